@@ -0,0 +1,827 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 自助权限申请 ==========
+
+// 申请目标类型
+const (
+	accessTargetRole  = "role"
+	accessTargetGroup = "group"
+)
+
+var errNotAccessApprover = errors.New("你不是该申请的审批人")
+
+// accessRevokePayload 到期收回阶段参数
+type accessRevokePayload struct {
+	RequestID uint `json:"requestId"`
+}
+
+// loadAccessRequestConfig 读取自助权限申请配置，默认直属上级可审批
+func loadAccessRequestConfig() models.AccessRequestConfig {
+	cfg := models.AccessRequestConfig{ManagerApprove: true}
+	if raw, err := storage.GetConfig("access_request"); err == nil {
+		json.Unmarshal([]byte(raw), &cfg)
+	}
+	if cfg.ApproverIDs == nil {
+		cfg.ApproverIDs = []uint{}
+	}
+	if cfg.RequestableGroupIDs == nil {
+		cfg.RequestableGroupIDs = []uint{}
+	}
+	if cfg.MaxDurationDays < 0 {
+		cfg.MaxDurationDays = 0
+	}
+	return cfg
+}
+
+// accessTargetLabel 申请目标的展示名称，如 角色「财务审批」
+func accessTargetLabel(req models.AccessRequest) string {
+	if req.TargetType == accessTargetGroup {
+		return "分组「" + req.TargetName + "」"
+	}
+	return "角色「" + req.TargetName + "」"
+}
+
+// accessDurationLabel 授权时长的展示文本
+func accessDurationLabel(days int) string {
+	if days <= 0 {
+		return "长期"
+	}
+	return fmt.Sprintf("%d 天", days)
+}
+
+// resolveAccessTarget 校验申请目标允许自助申请，返回名称和角色负责人
+func resolveAccessTarget(cfg models.AccessRequestConfig, targetType string, targetID uint) (string, uint, error) {
+	switch targetType {
+	case accessTargetRole:
+		var role models.Role
+		if err := storage.DB.First(&role, targetID).Error; err != nil {
+			return "", 0, fmt.Errorf("角色不存在")
+		}
+		if !role.Requestable || role.Status != 1 {
+			return "", 0, fmt.Errorf("该角色不允许自助申请")
+		}
+		return role.Name, role.OwnerID, nil
+	case accessTargetGroup:
+		var group models.UserGroup
+		if err := storage.DB.First(&group, targetID).Error; err != nil {
+			return "", 0, fmt.Errorf("分组不存在")
+		}
+		allowed := false
+		for _, id := range storage.ExpandGroupIDs(cfg.RequestableGroupIDs) {
+			if id == targetID {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", 0, fmt.Errorf("该分组不允许自助申请")
+		}
+		return group.Name, 0, nil
+	}
+	return "", 0, fmt.Errorf("申请类型错误")
+}
+
+// userHoldsAccess 用户是否已拥有该角色 / 已在该分组中
+func userHoldsAccess(userID uint, targetType string, targetID uint) bool {
+	var count int64
+	if targetType == accessTargetGroup {
+		storage.DB.Model(&models.UserGroupMember{}).Where("user_id = ? AND group_id = ?", userID, targetID).Count(&count)
+	} else {
+		storage.DB.Model(&models.UserRole{}).Where("user_id = ? AND role_id = ?", userID, targetID).Count(&count)
+	}
+	return count > 0
+}
+
+// accessApprovers 申请的审批人：角色负责人、直属上级（配置允许时）和配置的固定审批人
+// 申请人本人及已禁用 / 删除的账号不作为审批人
+func accessApprovers(cfg models.AccessRequestConfig, user models.User, ownerID uint) []models.User {
+	ids := []uint{}
+	if ownerID > 0 {
+		ids = append(ids, ownerID)
+	}
+	if cfg.ManagerApprove && user.ManagerID > 0 {
+		ids = append(ids, user.ManagerID)
+	}
+	ids = append(ids, cfg.ApproverIDs...)
+
+	var approvers []models.User
+	if len(ids) > 0 {
+		storage.DB.Where("id IN ? AND id <> ? AND is_deleted = 0 AND status = 1", uniqueIDs(ids), user.ID).Find(&approvers)
+	}
+	return approvers
+}
+
+// checkAccessDecider 校验当前用户能否处理该申请：申请的审批人，
+// 或拥有角色分配权限且申请人和目标都在其管理范围内的管理员；不能处理自己的申请
+func checkAccessDecider(c *gin.Context, req models.AccessRequest) error {
+	userID := middleware.GetUserID(c)
+	if req.UserID == userID {
+		return fmt.Errorf("不能审批自己的申请")
+	}
+	var count int64
+	storage.DB.Model(&models.AccessRequestApprover{}).Where("request_id = ? AND user_id = ?", req.ID, userID).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	if !middleware.CheckPermission(c, "user:assign_role") {
+		return errNotAccessApprover
+	}
+	scope := middleware.GetDataScope(c, "user:assign_role")
+	if err := scope.CheckUserVisible(req.UserID); err != nil {
+		return err
+	}
+	if req.TargetType == accessTargetGroup {
+		return scope.CheckGroupChange(nil, []uint{req.TargetID})
+	}
+	return scope.CheckRoleChange(nil, []uint{req.TargetID})
+}
+
+// validateAccessDuration 授权时长须在配置的上限内，配置了上限时不允许长期授权
+func validateAccessDuration(cfg models.AccessRequestConfig, days int) error {
+	if days < 0 {
+		return fmt.Errorf("授权时长不能为负数")
+	}
+	if cfg.MaxDurationDays > 0 && (days == 0 || days > cfg.MaxDurationDays) {
+		return fmt.Errorf("授权时长须在 1 到 %d 天之间", cfg.MaxDurationDays)
+	}
+	return nil
+}
+
+// enqueueAccessChange 角色 / 分组变化后向下游投递对应事件
+func enqueueAccessChange(tx *gorm.DB, targetType string, userID uint) error {
+	var user models.User
+	if err := tx.Preload("Roles").First(&user, userID).Error; err != nil {
+		return err
+	}
+	event := models.SyncEventRoleChange
+	if targetType == accessTargetGroup {
+		event = models.SyncEventGroupChange
+	}
+	return syncer.EnqueueSyncEvent(tx, event, user, "")
+}
+
+// grantAccess 审批通过：授予角色 / 加入分组，限定时长的安排到期收回
+func grantAccess(req models.AccessRequest, days int, operator, comment string) error {
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, req.UserID).Error; err != nil {
+		return fmt.Errorf("申请人不存在")
+	}
+	if req.TargetType == accessTargetGroup {
+		if err := storage.DB.First(&models.UserGroup{}, req.TargetID).Error; err != nil {
+			return fmt.Errorf("分组不存在")
+		}
+	} else if err := storage.DB.First(&models.Role{}, req.TargetID).Error; err != nil {
+		return fmt.Errorf("角色不存在")
+	}
+
+	held := userHoldsAccess(user.ID, req.TargetType, req.TargetID)
+	groups := storage.GetUserGroupIDs(user.ID)
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":        models.AccessRequestApproved,
+		"decided_by":    operator,
+		"decided_at":    &now,
+		"comment":       comment,
+		"duration_days": days,
+	}
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		// 审批前已通过其他途径拥有的权限不安排到期收回
+		if days > 0 && !held {
+			expiresAt := now.AddDate(0, 0, days)
+			updates["expires_at"] = &expiresAt
+			if _, err := scheduleLifecycleTask(tx, user, lifecycleFlowAccess, lifecycleStageRevokeAccess, expiresAt,
+				accessRevokePayload{RequestID: req.ID}, operator); err != nil {
+				return err
+			}
+		}
+		// 以状态为条件更新，避免多个审批人同时处理
+		res := tx.Model(&models.AccessRequest{}).Where("id = ? AND status = ?", req.ID, models.AccessRequestPending).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("该申请已被处理")
+		}
+
+		if held {
+			return nil
+		}
+		if req.TargetType == accessTargetGroup {
+			if err := storage.SetUserGroups(tx, user.ID, user.GroupID, append(groups, req.TargetID)); err != nil {
+				return err
+			}
+		} else if err := tx.Create(&models.UserRole{UserID: user.ID, RoleID: req.TargetID}).Error; err != nil {
+			return err
+		}
+		return enqueueAccessChange(tx, req.TargetType, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+	syncer.WakeSyncQueue()
+	return nil
+}
+
+// accessHolding 收回前在事务外读取的成员关系
+type accessHolding struct {
+	user   models.User
+	held   bool
+	groups []uint // 移除目标分组后剩余的分组
+}
+
+func loadAccessHolding(user models.User, targetType string, targetID uint) accessHolding {
+	h := accessHolding{user: user, held: userHoldsAccess(user.ID, targetType, targetID)}
+	if targetType == accessTargetGroup {
+		for _, id := range storage.GetUserGroupIDs(user.ID) {
+			if id != targetID {
+				h.groups = append(h.groups, id)
+			}
+		}
+	}
+	return h
+}
+
+// detachAccess 在事务中移除角色 / 分组并向下游投递事件，未持有时不处理
+func detachAccess(tx *gorm.DB, h accessHolding, targetType string, targetID uint) error {
+	if !h.held {
+		return nil
+	}
+	if targetType == accessTargetGroup {
+		primary := h.user.GroupID
+		if primary == targetID {
+			primary = 0
+		}
+		if err := storage.SetUserGroups(tx, h.user.ID, primary, h.groups); err != nil {
+			return err
+		}
+	} else if err := tx.Where("user_id = ? AND role_id = ?", h.user.ID, targetID).Delete(&models.UserRole{}).Error; err != nil {
+		return err
+	}
+	return enqueueAccessChange(tx, targetType, h.user.ID)
+}
+
+// cancelAccessRevokeTask 取消申请尚未执行的到期收回阶段
+func cancelAccessRevokeTask(tx *gorm.DB, userID, requestID uint, operator string) error {
+	payload, _ := json.Marshal(accessRevokePayload{RequestID: requestID})
+	return tx.Model(&models.LifecycleTask{}).
+		Where("user_id = ? AND flow = ? AND status = ? AND payload = ?", userID, lifecycleFlowAccess, models.LifecycleTaskPending, string(payload)).
+		Updates(map[string]interface{}{
+			"status":       models.LifecycleTaskCancelled,
+			"cancelled_by": operator,
+			"message":      "授权已收回",
+		}).Error
+}
+
+// closeApprovedAccessRequests 成员关系被其他途径（如权限复核）收回时，关闭对应的已授权申请
+func closeApprovedAccessRequests(tx *gorm.DB, userID uint, targetType string, targetID uint, operator, comment string) error {
+	var ids []uint
+	tx.Model(&models.AccessRequest{}).Where("user_id = ? AND target_type = ? AND target_id = ? AND status = ?",
+		userID, targetType, targetID, models.AccessRequestApproved).Pluck("id", &ids)
+	for _, id := range ids {
+		if err := cancelAccessRevokeTask(tx, userID, id, operator); err != nil {
+			return err
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Model(&models.AccessRequest{}).Where("id IN ?", ids).
+		Updates(map[string]interface{}{"status": models.AccessRequestRevoked, "comment": comment}).Error
+}
+
+// revokeAccess 收回已授予的角色 / 分组，status 为 revoked（手动收回）或 expired（到期收回）
+func revokeAccess(req models.AccessRequest, status, operator, comment string) error {
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, req.UserID).Error; err != nil {
+		return fmt.Errorf("申请人不存在")
+	}
+	holding := loadAccessHolding(user, req.TargetType, req.TargetID)
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.AccessRequest{}).Where("id = ? AND status = ?", req.ID, models.AccessRequestApproved).
+			Updates(map[string]interface{}{"status": status, "comment": comment})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("该申请不处于已授权状态")
+		}
+		// 手动收回时取消尚未执行的到期收回阶段
+		if err := cancelAccessRevokeTask(tx, user.ID, req.ID, operator); err != nil {
+			return err
+		}
+		return detachAccess(tx, holding, req.TargetType, req.TargetID)
+	})
+	if err != nil {
+		return err
+	}
+	syncer.WakeSyncQueue()
+	return nil
+}
+
+// recordAccessChanged 角色授予 / 收回记录安全事件
+func recordAccessChanged(req models.AccessRequest, sourceIP, description string) {
+	recordRoleChanged(req.TargetType, req.UserID, req.Username, req.TargetID, sourceIP, description,
+		map[string]interface{}{"requestId": req.ID, "role": req.TargetName})
+}
+
+// recordRoleChanged 角色变更记录安全事件，分组变更不记录
+func recordRoleChanged(targetType string, userID uint, username string, roleID uint, sourceIP, description string, details map[string]interface{}) {
+	if targetType != accessTargetRole {
+		return
+	}
+	securityService.RecordSecurityEvent(models.EventRoleChanged, models.SeverityMedium, sourceIP,
+		&userID, username, "role", strconv.FormatUint(uint64(roleID), 10), description, details)
+}
+
+// runAccessRevokeStage 生命周期阶段：授权到期收回
+func runAccessRevokeStage(task models.LifecycleTask) (string, error) {
+	var payload accessRevokePayload
+	if err := json.Unmarshal([]byte(task.Payload), &payload); err != nil {
+		return "", fmt.Errorf("收回参数错误: %v", err)
+	}
+	var req models.AccessRequest
+	if err := storage.DB.First(&req, payload.RequestID).Error; err != nil {
+		return "", fmt.Errorf("权限申请不存在")
+	}
+	if req.Status != models.AccessRequestApproved {
+		return "申请不处于已授权状态，跳过", nil
+	}
+	if err := revokeAccess(req, models.AccessRequestExpired, "system", "授权已到期"); err != nil {
+		return "", err
+	}
+	recordAccessChanged(req, "", "授权到期，已收回"+accessTargetLabel(req))
+	go sendAccessResultNotification(req, "已到期收回", "system", "")
+	return "授权已到期，已收回" + accessTargetLabel(req), nil
+}
+
+// ---------- 通知 ----------
+
+// sendAccessNotification 按消息策略向收件人发送权限申请相关通知
+func sendAccessNotification(scene, title string, requester models.User, recipients []models.User, vars map[string]string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[权限申请] 通知 panic: %v", r)
+		}
+	}()
+
+	channelTypes := ResolveAllowedChannelTypes(scene, requester.GroupID)
+	if len(channelTypes) == 0 {
+		return // 消息策略中未配置该场景的渠道，不发送
+	}
+	var tpl models.MessageTemplate
+	if storage.DB.Where("scene = ?", scene).First(&tpl).Error != nil {
+		log.Printf("[权限申请] 消息模板 %s 不存在，请在消息模板管理中创建，跳过通知", scene)
+		return
+	}
+
+	for _, to := range recipients {
+		content := tpl.Content
+		for k, v := range vars {
+			content = strings.ReplaceAll(content, "{{"+k+"}}", v)
+		}
+		content = strings.ReplaceAll(content, "{{username}}", requester.Username)
+		content = strings.ReplaceAll(content, "{{nickname}}", requester.Nickname)
+		content = strings.ReplaceAll(content, "{{name}}", to.Nickname)
+		content = strings.ReplaceAll(content, "{{time}}", time.Now().Format("2006-01-02 15:04:05"))
+		content = strings.ReplaceAll(content, "{{app_name}}", "统一身份认证平台")
+
+		results := services.SendNotificationByChannels(to, title, content, channelTypes)
+		for _, r := range results {
+			if r.Success {
+				log.Printf("[权限申请] %s 发送成功 -> %s（申请人 %s）", r.Channel, to.Username, requester.Username)
+			} else {
+				log.Printf("[权限申请] %s 发送失败 %s（申请人 %s）: %s", r.Channel, to.Username, requester.Username, r.Message)
+			}
+		}
+	}
+}
+
+// sendAccessResultNotification 通知申请人处理结果
+func sendAccessResultNotification(req models.AccessRequest, result, approver, comment string) {
+	var requester models.User
+	if storage.DB.Where("is_deleted = 0").First(&requester, req.UserID).Error != nil {
+		return
+	}
+	sendAccessNotification("access_request_result", "权限申请结果", requester, []models.User{requester}, map[string]string{
+		"target":   accessTargetLabel(req),
+		"result":   result,
+		"approver": approver,
+		"comment":  comment,
+	})
+}
+
+// ---------- 个人中心接口 ----------
+
+// accessOption 可申请的角色 / 分组
+type accessOption struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	ParentID    uint   `json:"parentId,omitempty"`
+	Held        bool   `json:"held"` // 已拥有，无需申请
+}
+
+// GetAccessRequestOptions 当前用户可申请的角色和分组
+func GetAccessRequestOptions(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	cfg := loadAccessRequestConfig()
+
+	held := make(map[uint]bool)
+	var heldRoles []uint
+	storage.DB.Model(&models.UserRole{}).Where("user_id = ?", userID).Pluck("role_id", &heldRoles)
+	for _, id := range heldRoles {
+		held[id] = true
+	}
+	var roles []models.Role
+	storage.DB.Where("requestable = ? AND status = 1", true).Order("id").Find(&roles)
+	roleOptions := make([]accessOption, 0, len(roles))
+	for _, r := range roles {
+		roleOptions = append(roleOptions, accessOption{ID: r.ID, Name: r.Name, Description: r.Description, Held: held[r.ID]})
+	}
+
+	groupOptions := []accessOption{}
+	if ids := storage.ExpandGroupIDs(cfg.RequestableGroupIDs); len(ids) > 0 {
+		member := make(map[uint]bool)
+		for _, id := range storage.GetUserGroupIDs(userID) {
+			member[id] = true
+		}
+		var groups []models.UserGroup
+		storage.DB.Where("id IN ?", ids).Order("`order` asc, id asc").Find(&groups)
+		for _, g := range groups {
+			groupOptions = append(groupOptions, accessOption{ID: g.ID, Name: g.Name, ParentID: g.ParentID, Held: member[g.ID]})
+		}
+	}
+
+	respondOK(c, gin.H{
+		"roles":           roleOptions,
+		"groups":          groupOptions,
+		"maxDurationDays": cfg.MaxDurationDays,
+	})
+}
+
+// ListMyAccessRequests 我的权限申请
+func ListMyAccessRequests(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if page < 1 {
+		page = 1
+	}
+
+	query := storage.DB.Model(&models.AccessRequest{}).Where("user_id = ?", middleware.GetUserID(c))
+	var total int64
+	query.Count(&total)
+	var list []models.AccessRequest
+	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list)
+	respondList(c, list, total)
+}
+
+// CreateAccessRequest 提交权限申请并通知审批人
+func CreateAccessRequest(c *gin.Context) {
+	var req struct {
+		TargetType   string `json:"targetType"`
+		TargetID     uint   `json:"targetId"`
+		Reason       string `json:"reason"`
+		DurationDays int    `json:"durationDays"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		respondError(c, http.StatusBadRequest, "请填写申请理由")
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > 500 {
+		respondError(c, http.StatusBadRequest, "申请理由不能超过 500 字")
+		return
+	}
+
+	cfg := loadAccessRequestConfig()
+	if err := validateAccessDuration(cfg, req.DurationDays); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	name, ownerID, err := resolveAccessTarget(cfg, req.TargetType, req.TargetID)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, middleware.GetUserID(c)).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if userHoldsAccess(user.ID, req.TargetType, req.TargetID) {
+		respondError(c, http.StatusBadRequest, "你已拥有该权限，无需申请")
+		return
+	}
+	var pending int64
+	storage.DB.Model(&models.AccessRequest{}).
+		Where("user_id = ? AND target_type = ? AND target_id = ? AND status = ?", user.ID, req.TargetType, req.TargetID, models.AccessRequestPending).
+		Count(&pending)
+	if pending > 0 {
+		respondError(c, http.StatusBadRequest, "已有待审批的相同申请")
+		return
+	}
+
+	approvers := accessApprovers(cfg, user, ownerID)
+	record := models.AccessRequest{
+		UserID:       user.ID,
+		Username:     user.Username,
+		TargetType:   req.TargetType,
+		TargetID:     req.TargetID,
+		TargetName:   name,
+		Reason:       req.Reason,
+		DurationDays: req.DurationDays,
+		Status:       models.AccessRequestPending,
+	}
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		for _, a := range approvers {
+			if err := tx.Create(&models.AccessRequestApprover{RequestID: record.ID, UserID: a.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "提交失败")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "权限申请", "提交申请", user.Username,
+		fmt.Sprintf("%s，时长：%s，理由：%s", accessTargetLabel(record), accessDurationLabel(record.DurationDays), record.Reason))
+	if len(approvers) > 0 {
+		go sendAccessNotification("access_request", "权限申请待审批", user, approvers, map[string]string{
+			"target":   accessTargetLabel(record),
+			"duration": accessDurationLabel(record.DurationDays),
+			"reason":   record.Reason,
+		})
+	}
+	respondOK(c, record)
+}
+
+// CancelAccessRequest 撤回自己待审批的申请
+func CancelAccessRequest(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	res := storage.DB.Model(&models.AccessRequest{}).
+		Where("id = ? AND user_id = ? AND status = ?", id, middleware.GetUserID(c), models.AccessRequestPending).
+		Update("status", models.AccessRequestCancelled)
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusBadRequest, "申请不存在或已被处理")
+		return
+	}
+	middleware.RecordOperationLog(c, "权限申请", "撤回申请", middleware.GetUsername(c), fmt.Sprintf("申请 %d", id))
+	respondOK(c, nil)
+}
+
+// ---------- 审批接口 ----------
+
+// accessRequestItem 审批列表项，附带申请人姓名和审批人
+type accessRequestItem struct {
+	models.AccessRequest
+	Nickname  string   `json:"nickname"`
+	Approvers []string `json:"approvers"`
+}
+
+// ListAccessApprovals 待我审批的申请；拥有角色分配权限且不受范围限制时 all=1 可查看全部
+func ListAccessApprovals(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if page < 1 {
+		page = 1
+	}
+
+	userID := middleware.GetUserID(c)
+	query := storage.DB.Model(&models.AccessRequest{})
+	if c.Query("all") != "1" || !middleware.CheckPermission(c, "user:assign_role") ||
+		!middleware.GetDataScope(c, "user:assign_role").Unrestricted() {
+		query = query.Where("id IN (?)", storage.DB.Model(&models.AccessRequestApprover{}).Select("request_id").Where("user_id = ?", userID))
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	query.Count(&total)
+	var list []models.AccessRequest
+	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list)
+
+	userIDs := make([]uint, 0, len(list))
+	requestIDs := make([]uint, 0, len(list))
+	for _, r := range list {
+		userIDs = append(userIDs, r.UserID)
+		requestIDs = append(requestIDs, r.ID)
+	}
+	var approverRows []models.AccessRequestApprover
+	if len(requestIDs) > 0 {
+		storage.DB.Where("request_id IN ?", requestIDs).Order("id").Find(&approverRows)
+	}
+	for _, a := range approverRows {
+		userIDs = append(userIDs, a.UserID)
+	}
+	names := make(map[uint]models.User)
+	if len(userIDs) > 0 {
+		var users []models.User
+		storage.DB.Select("id, username, nickname").Where("id IN ?", uniqueIDs(userIDs)).Find(&users)
+		for _, u := range users {
+			names[u.ID] = u
+		}
+	}
+	approvers := make(map[uint][]string)
+	for _, a := range approverRows {
+		if u, ok := names[a.UserID]; ok {
+			approvers[a.RequestID] = append(approvers[a.RequestID], u.Nickname+"（"+u.Username+"）")
+		}
+	}
+
+	items := make([]accessRequestItem, 0, len(list))
+	for _, r := range list {
+		item := accessRequestItem{AccessRequest: r, Nickname: names[r.UserID].Nickname, Approvers: approvers[r.ID]}
+		if item.Approvers == nil {
+			item.Approvers = []string{}
+		}
+		items = append(items, item)
+	}
+	respondList(c, items, total)
+}
+
+// loadDecidableAccessRequest 读取申请并校验当前用户可处理，失败时已写入响应
+func loadDecidableAccessRequest(c *gin.Context, status string) (models.AccessRequest, bool) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var req models.AccessRequest
+	if err := storage.DB.First(&req, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "申请不存在")
+		return req, false
+	}
+	if err := checkAccessDecider(c, req); err != nil {
+		respondError(c, http.StatusForbidden, err.Error())
+		return req, false
+	}
+	if req.Status != status {
+		respondError(c, http.StatusBadRequest, "该申请已被处理")
+		return req, false
+	}
+	return req, true
+}
+
+// ApproveAccessRequest 审批通过，审批人可调整授权时长
+func ApproveAccessRequest(c *gin.Context) {
+	var body struct {
+		Comment      string `json:"comment"`
+		DurationDays *int   `json:"durationDays"`
+	}
+	c.ShouldBindJSON(&body)
+	req, ok := loadDecidableAccessRequest(c, models.AccessRequestPending)
+	if !ok {
+		return
+	}
+
+	days := req.DurationDays
+	if body.DurationDays != nil {
+		days = *body.DurationDays
+		if err := validateAccessDuration(loadAccessRequestConfig(), days); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	operator := middleware.GetUsername(c)
+	comment := strings.TrimSpace(body.Comment)
+	if err := grantAccess(req, days, operator, comment); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recordAccessChanged(req, c.ClientIP(), fmt.Sprintf("%s 审批通过，授予%s（%s）", operator, accessTargetLabel(req), accessDurationLabel(days)))
+	middleware.RecordOperationLog(c, "权限申请", "审批通过", req.Username,
+		fmt.Sprintf("%s，时长：%s", accessTargetLabel(req), accessDurationLabel(days)))
+	go sendAccessResultNotification(req, "已通过（授权时长："+accessDurationLabel(days)+"）", operator, comment)
+	respondOK(c, nil)
+}
+
+// DenyAccessRequest 驳回申请
+func DenyAccessRequest(c *gin.Context) {
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	c.ShouldBindJSON(&body)
+	req, ok := loadDecidableAccessRequest(c, models.AccessRequestPending)
+	if !ok {
+		return
+	}
+
+	operator := middleware.GetUsername(c)
+	comment := strings.TrimSpace(body.Comment)
+	now := time.Now()
+	res := storage.DB.Model(&models.AccessRequest{}).Where("id = ? AND status = ?", req.ID, models.AccessRequestPending).
+		Updates(map[string]interface{}{
+			"status":     models.AccessRequestDenied,
+			"decided_by": operator,
+			"decided_at": &now,
+			"comment":    comment,
+		})
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusBadRequest, "该申请已被处理")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "权限申请", "驳回申请", req.Username, accessTargetLabel(req)+"，意见："+comment)
+	go sendAccessResultNotification(req, "未通过", operator, comment)
+	respondOK(c, nil)
+}
+
+// RevokeAccessRequest 提前收回已授予的权限
+func RevokeAccessRequest(c *gin.Context) {
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	c.ShouldBindJSON(&body)
+	req, ok := loadDecidableAccessRequest(c, models.AccessRequestApproved)
+	if !ok {
+		return
+	}
+
+	operator := middleware.GetUsername(c)
+	comment := strings.TrimSpace(body.Comment)
+	if err := revokeAccess(req, models.AccessRequestRevoked, operator, comment); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recordAccessChanged(req, c.ClientIP(), fmt.Sprintf("%s 收回%s", operator, accessTargetLabel(req)))
+	middleware.RecordOperationLog(c, "权限申请", "收回权限", req.Username, accessTargetLabel(req)+"，原因："+comment)
+	go sendAccessResultNotification(req, "已被收回", operator, comment)
+	respondOK(c, nil)
+}
+
+// ---------- 配置接口 ----------
+
+// GetAccessRequestConfig 获取自助权限申请配置
+func GetAccessRequestConfig(c *gin.Context) {
+	respondOK(c, loadAccessRequestConfig())
+}
+
+// UpdateAccessRequestConfig 更新自助权限申请配置
+func UpdateAccessRequestConfig(c *gin.Context) {
+	var cfg models.AccessRequestConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	if cfg.MaxDurationDays < 0 {
+		respondError(c, http.StatusBadRequest, "授权时长上限不能为负数")
+		return
+	}
+	cfg.ApproverIDs = uniqueIDs(cfg.ApproverIDs)
+	cfg.RequestableGroupIDs = uniqueIDs(cfg.RequestableGroupIDs)
+	if len(cfg.ApproverIDs) > 0 {
+		var count int64
+		storage.DB.Model(&models.User{}).Where("id IN ? AND is_deleted = 0", cfg.ApproverIDs).Count(&count)
+		if int(count) != len(cfg.ApproverIDs) {
+			respondError(c, http.StatusBadRequest, "审批人中包含不存在的用户")
+			return
+		}
+	}
+	if len(cfg.RequestableGroupIDs) > 0 {
+		var count int64
+		storage.DB.Model(&models.UserGroup{}).Where("id IN ?", cfg.RequestableGroupIDs).Count(&count)
+		if int(count) != len(cfg.RequestableGroupIDs) {
+			respondError(c, http.StatusBadRequest, "可申请分组中包含不存在的分组")
+			return
+		}
+	}
+
+	data, _ := json.Marshal(cfg)
+	if err := storage.SetConfig("access_request", string(data)); err != nil {
+		respondError(c, http.StatusInternalServerError, "保存失败")
+		return
+	}
+	middleware.RecordOperationLog(c, "权限申请", "更新配置", "access_request", string(data))
+	respondOK(c, nil)
+}
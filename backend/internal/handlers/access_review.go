@@ -0,0 +1,853 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 权限复核活动 ==========
+
+// 复核人规则
+const (
+	reviewerManager = "manager" // 成员的直属上级
+	reviewerOwner   = "owner"   // 角色负责人（分组无负责人，使用备选复核人）
+)
+
+var reviewDecisionLabels = map[string]string{
+	models.ReviewDecisionPending:     "待复核",
+	models.ReviewDecisionConfirmed:   "确认保留",
+	models.ReviewDecisionRevoked:     "收回",
+	models.ReviewDecisionAutoRevoked: "逾期自动收回",
+	models.ReviewDecisionUnreviewed:  "逾期未复核",
+}
+
+var accessReviewStatusLabels = map[string]string{
+	models.AccessReviewActive:    "进行中",
+	models.AccessReviewCompleted: "已完成",
+	models.AccessReviewCancelled: "已取消",
+}
+
+// accessReviewStats 复核项按结论统计
+type accessReviewStats struct {
+	Total       int64 `json:"total"`
+	Pending     int64 `json:"pending"`
+	Confirmed   int64 `json:"confirmed"`
+	Revoked     int64 `json:"revoked"`
+	AutoRevoked int64 `json:"autoRevoked"`
+	Unreviewed  int64 `json:"unreviewed"`
+}
+
+func loadAccessReviewStats(reviewIDs []uint) map[uint]*accessReviewStats {
+	stats := make(map[uint]*accessReviewStats, len(reviewIDs))
+	for _, id := range reviewIDs {
+		stats[id] = &accessReviewStats{}
+	}
+	if len(reviewIDs) == 0 {
+		return stats
+	}
+	var rows []struct {
+		ReviewID uint
+		Decision string
+		Count    int64
+	}
+	storage.DB.Model(&models.AccessReviewItem{}).Select("review_id, decision, COUNT(*) AS count").
+		Where("review_id IN ?", reviewIDs).Group("review_id, decision").Scan(&rows)
+	for _, r := range rows {
+		s := stats[r.ReviewID]
+		if s == nil {
+			continue
+		}
+		s.Total += r.Count
+		switch r.Decision {
+		case models.ReviewDecisionPending:
+			s.Pending = r.Count
+		case models.ReviewDecisionConfirmed:
+			s.Confirmed = r.Count
+		case models.ReviewDecisionRevoked:
+			s.Revoked = r.Count
+		case models.ReviewDecisionAutoRevoked:
+			s.AutoRevoked = r.Count
+		case models.ReviewDecisionUnreviewed:
+			s.Unreviewed = r.Count
+		}
+	}
+	return stats
+}
+
+// accessReviewView 复核活动及其进度
+type accessReviewView struct {
+	models.AccessReview
+	TargetIDs   []uint             `json:"targetIds"`
+	TargetNames []string           `json:"targetNames"`
+	ReviewerIDs []uint             `json:"reviewerIds"`
+	Stats       *accessReviewStats `json:"stats"`
+}
+
+// accessReviewTargetNames 复核对象名称
+func accessReviewTargetNames(targetType string, ids []uint) []string {
+	names := []string{}
+	if len(ids) == 0 {
+		return names
+	}
+	if targetType == accessTargetGroup {
+		var groups []models.UserGroup
+		storage.DB.Where("id IN ?", ids).Order("id").Find(&groups)
+		for _, g := range groups {
+			names = append(names, g.Name)
+		}
+	} else {
+		var roles []models.Role
+		storage.DB.Where("id IN ?", ids).Order("id").Find(&roles)
+		for _, r := range roles {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+func newAccessReviewView(review models.AccessReview, stats *accessReviewStats) accessReviewView {
+	v := accessReviewView{
+		AccessReview: review,
+		TargetIDs:    middleware.ParseIDList(review.TargetIDs),
+		ReviewerIDs:  middleware.ParseIDList(review.ReviewerIDs),
+		Stats:        stats,
+	}
+	v.TargetNames = accessReviewTargetNames(review.TargetType, v.TargetIDs)
+	if v.TargetIDs == nil {
+		v.TargetIDs = []uint{}
+	}
+	if v.ReviewerIDs == nil {
+		v.ReviewerIDs = []uint{}
+	}
+	return v
+}
+
+// buildAccessReviewItems 为复核对象的每个成员生成复核项并确定复核人
+// 复核人按规则取直属上级或角色负责人，不可用（不存在、已禁用或为成员本人）时依次取备选复核人
+func buildAccessReviewItems(c *gin.Context, targetType string, targetIDs []uint, reviewerType string, fallback []uint) []models.AccessReviewItem {
+	type membership struct {
+		UserID   uint
+		TargetID uint
+	}
+	var members []membership
+	if targetType == accessTargetGroup {
+		storage.DB.Model(&models.UserGroupMember{}).Select("user_id, group_id AS target_id").
+			Where("group_id IN ?", targetIDs).Order("group_id, user_id").Scan(&members)
+	} else {
+		storage.DB.Model(&models.UserRole{}).Select("user_id, role_id AS target_id").
+			Where("role_id IN ?", targetIDs).Order("role_id, user_id").Scan(&members)
+	}
+
+	if len(members) == 0 {
+		return nil
+	}
+	memberIDs := make([]uint, 0, len(members))
+	for _, m := range members {
+		memberIDs = append(memberIDs, m.UserID)
+	}
+
+	// 只复核操作人管理范围内的未删除用户
+	userQuery := middleware.GetDataScope(c, "role:review").FilterUsers(storage.DB.Model(&models.User{}))
+	var users []models.User
+	userQuery.Where("id IN ? AND is_deleted = 0", uniqueIDs(memberIDs)).Find(&users)
+	userMap := make(map[uint]models.User, len(users))
+	for _, u := range users {
+		userMap[u.ID] = u
+	}
+
+	targetNames := make(map[uint]string)
+	owners := make(map[uint]uint)
+	if targetType == accessTargetGroup {
+		var groups []models.UserGroup
+		storage.DB.Where("id IN ?", targetIDs).Find(&groups)
+		for _, g := range groups {
+			targetNames[g.ID] = g.Name
+		}
+	} else {
+		var roles []models.Role
+		storage.DB.Where("id IN ?", targetIDs).Find(&roles)
+		for _, r := range roles {
+			targetNames[r.ID] = r.Name
+			owners[r.ID] = r.OwnerID
+		}
+	}
+
+	// 可用的复核人：未删除且启用
+	candidateIDs := append([]uint{}, fallback...)
+	for _, u := range users {
+		if u.ManagerID > 0 {
+			candidateIDs = append(candidateIDs, u.ManagerID)
+		}
+	}
+	for _, id := range owners {
+		if id > 0 {
+			candidateIDs = append(candidateIDs, id)
+		}
+	}
+	reviewers := make(map[uint]models.User)
+	if len(candidateIDs) > 0 {
+		var list []models.User
+		storage.DB.Where("id IN ? AND is_deleted = 0 AND status = 1", uniqueIDs(candidateIDs)).Find(&list)
+		for _, u := range list {
+			reviewers[u.ID] = u
+		}
+	}
+
+	items := make([]models.AccessReviewItem, 0, len(members))
+	for _, m := range members {
+		user, ok := userMap[m.UserID]
+		if !ok {
+			continue
+		}
+		preferred := owners[m.TargetID]
+		if reviewerType == reviewerManager {
+			preferred = user.ManagerID
+		}
+		var reviewer models.User
+		for _, id := range append([]uint{preferred}, fallback...) {
+			if r, ok := reviewers[id]; ok && id != user.ID {
+				reviewer = r
+				break
+			}
+		}
+		items = append(items, models.AccessReviewItem{
+			UserID:       user.ID,
+			Username:     user.Username,
+			Nickname:     user.Nickname,
+			TargetType:   targetType,
+			TargetID:     m.TargetID,
+			TargetName:   targetNames[m.TargetID],
+			ReviewerID:   reviewer.ID,
+			ReviewerName: reviewer.Username,
+			Decision:     models.ReviewDecisionPending,
+		})
+	}
+	return items
+}
+
+// decideReviewItem 处理单个复核项：确认保留，或收回角色 / 分组
+// 收回时对应的自助申请一并关闭
+func decideReviewItem(item models.AccessReviewItem, decision, operator, comment, sourceIP string) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"decision":   decision,
+		"comment":    comment,
+		"decided_by": operator,
+		"decided_at": &now,
+	}
+	revoke := decision == models.ReviewDecisionRevoked || decision == models.ReviewDecisionAutoRevoked
+
+	var holding accessHolding
+	if revoke {
+		var user models.User
+		if storage.DB.Where("is_deleted = 0").First(&user, item.UserID).Error == nil {
+			holding = loadAccessHolding(user, item.TargetType, item.TargetID)
+		}
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		// 以结论为条件更新，避免复核人与截止处理同时处理同一项
+		res := tx.Model(&models.AccessReviewItem{}).Where("id = ? AND decision = ?", item.ID, models.ReviewDecisionPending).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("该复核项已处理")
+		}
+		if !revoke || holding.user.ID == 0 {
+			return nil
+		}
+		if err := closeApprovedAccessRequests(tx, item.UserID, item.TargetType, item.TargetID, operator, "权限复核收回"); err != nil {
+			return err
+		}
+		return detachAccess(tx, holding, item.TargetType, item.TargetID)
+	})
+	if err != nil {
+		return err
+	}
+	if revoke && holding.held {
+		syncer.WakeSyncQueue()
+		recordRoleChanged(item.TargetType, item.UserID, item.Username, item.TargetID, sourceIP,
+			fmt.Sprintf("权限复核%s角色「%s」", reviewDecisionLabels[decision], item.TargetName),
+			map[string]interface{}{"reviewId": item.ReviewID, "itemId": item.ID, "role": item.TargetName})
+	}
+	return nil
+}
+
+// finalizeAccessReview 结束复核活动：未复核的项按配置自动收回或标记为未复核
+func finalizeAccessReview(review models.AccessReview, operator string) (int, error) {
+	res := storage.DB.Model(&models.AccessReview{}).Where("id = ? AND status = ?", review.ID, models.AccessReviewActive).
+		Updates(map[string]interface{}{"status": models.AccessReviewCompleted, "completed_at": time.Now()})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, fmt.Errorf("复核活动不处于进行中")
+	}
+
+	var items []models.AccessReviewItem
+	storage.DB.Where("review_id = ? AND decision = ?", review.ID, models.ReviewDecisionPending).Find(&items)
+	decision := models.ReviewDecisionUnreviewed
+	if review.AutoRevoke {
+		decision = models.ReviewDecisionAutoRevoked
+	}
+	handled := 0
+	for _, item := range items {
+		if err := decideReviewItem(item, decision, operator, "截止时未复核", ""); err != nil {
+			log.Printf("[权限复核] 复核项 %d 处理失败: %v", item.ID, err)
+			continue
+		}
+		handled++
+	}
+	log.Printf("[权限复核] 活动「%s」已结束，未复核 %d 项（%s）", review.Name, handled, reviewDecisionLabels[decision])
+	return handled, nil
+}
+
+// processAccessReviews 调度器：截止前提醒未完成的复核人，到达截止时间结束活动
+func processAccessReviews() {
+	now := time.Now()
+	var reviews []models.AccessReview
+	storage.DB.Where("status = ?", models.AccessReviewActive).Find(&reviews)
+	for _, review := range reviews {
+		if !review.Deadline.After(now) {
+			if _, err := finalizeAccessReview(review, "system"); err != nil {
+				log.Printf("[权限复核] 结束活动「%s」失败: %v", review.Name, err)
+			}
+			continue
+		}
+		if review.RemindDays > 0 && review.RemindedAt == nil && !review.Deadline.After(now.AddDate(0, 0, review.RemindDays)) {
+			// 先标记再发送，避免发送较慢时下一轮重复提醒
+			res := storage.DB.Model(&models.AccessReview{}).Where("id = ? AND reminded_at IS NULL", review.ID).Update("reminded_at", now)
+			if res.RowsAffected > 0 {
+				go notifyAccessReviewers(review, "权限复核即将截止")
+			}
+		}
+	}
+}
+
+// notifyAccessReviewers 按消息策略通知尚有待复核项的复核人
+func notifyAccessReviewers(review models.AccessReview, title string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[权限复核] 通知 panic: %v", r)
+		}
+	}()
+
+	var tpl models.MessageTemplate
+	if storage.DB.Where("scene = ?", "access_review").First(&tpl).Error != nil {
+		log.Printf("[权限复核] 消息模板 access_review 不存在，请在消息模板管理中创建，跳过通知: %s", review.Name)
+		return
+	}
+	var rows []struct {
+		ReviewerID uint
+		Count      int
+	}
+	storage.DB.Model(&models.AccessReviewItem{}).Select("reviewer_id, COUNT(*) AS count").
+		Where("review_id = ? AND decision = ? AND reviewer_id > 0", review.ID, models.ReviewDecisionPending).
+		Group("reviewer_id").Scan(&rows)
+
+	overdue := "将保留但标记为逾期未复核"
+	if review.AutoRevoke {
+		overdue = "将被自动收回"
+	}
+	for _, row := range rows {
+		var reviewer models.User
+		if storage.DB.Where("is_deleted = 0 AND status = 1").First(&reviewer, row.ReviewerID).Error != nil {
+			continue
+		}
+		channelTypes := ResolveAllowedChannelTypes("access_review", reviewer.GroupID)
+		if len(channelTypes) == 0 {
+			continue // 消息策略中未配置权限复核渠道，不发送
+		}
+		content := tpl.Content
+		content = strings.ReplaceAll(content, "{{name}}", reviewer.Nickname)
+		content = strings.ReplaceAll(content, "{{username}}", reviewer.Username)
+		content = strings.ReplaceAll(content, "{{review_name}}", review.Name)
+		content = strings.ReplaceAll(content, "{{count}}", strconv.Itoa(row.Count))
+		content = strings.ReplaceAll(content, "{{deadline}}", review.Deadline.Format("2006-01-02 15:04:05"))
+		content = strings.ReplaceAll(content, "{{overdue}}", overdue)
+		content = strings.ReplaceAll(content, "{{time}}", time.Now().Format("2006-01-02 15:04:05"))
+		content = strings.ReplaceAll(content, "{{app_name}}", "统一身份认证平台")
+
+		results := services.SendNotificationByChannels(reviewer, title, content, channelTypes)
+		for _, r := range results {
+			if r.Success {
+				log.Printf("[权限复核] %s 发送成功 -> %s（%s）", r.Channel, reviewer.Username, review.Name)
+			} else {
+				log.Printf("[权限复核] %s 发送失败 %s（%s）: %s", r.Channel, reviewer.Username, review.Name, r.Message)
+			}
+		}
+	}
+}
+
+// ---------- 管理接口 ----------
+
+// ListAccessReviews 复核活动列表
+func ListAccessReviews(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+
+	query := storage.DB.Model(&models.AccessReview{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	query.Count(&total)
+	var reviews []models.AccessReview
+	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&reviews)
+
+	ids := make([]uint, 0, len(reviews))
+	for _, r := range reviews {
+		ids = append(ids, r.ID)
+	}
+	stats := loadAccessReviewStats(ids)
+	list := make([]accessReviewView, 0, len(reviews))
+	for _, r := range reviews {
+		list = append(list, newAccessReviewView(r, stats[r.ID]))
+	}
+	respondList(c, list, total)
+}
+
+// accessReviewOption 可复核的角色或分组，附带成员数
+type accessReviewOption struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	ParentID    uint   `json:"parentId,omitempty"`
+	MemberCount int64  `json:"memberCount"`
+}
+
+// GetAccessReviewOptions 发起复核时可选的角色和分组
+func GetAccessReviewOptions(c *gin.Context) {
+	type countRow struct {
+		TargetID uint
+		Count    int64
+	}
+	var roleCounts, groupCounts []countRow
+	storage.DB.Model(&models.UserRole{}).Select("role_id AS target_id, COUNT(*) AS count").Group("role_id").Scan(&roleCounts)
+	storage.DB.Model(&models.UserGroupMember{}).Select("group_id AS target_id, COUNT(*) AS count").Group("group_id").Scan(&groupCounts)
+	toMap := func(rows []countRow) map[uint]int64 {
+		m := make(map[uint]int64, len(rows))
+		for _, r := range rows {
+			m[r.TargetID] = r.Count
+		}
+		return m
+	}
+	roleCount, groupCount := toMap(roleCounts), toMap(groupCounts)
+
+	var roles []models.Role
+	storage.DB.Order("id").Find(&roles)
+	roleOptions := make([]accessReviewOption, 0, len(roles))
+	for _, r := range roles {
+		roleOptions = append(roleOptions, accessReviewOption{ID: r.ID, Name: r.Name, MemberCount: roleCount[r.ID]})
+	}
+	var groups []models.UserGroup
+	storage.DB.Order("`order` asc, id asc").Find(&groups)
+	groupOptions := make([]accessReviewOption, 0, len(groups))
+	for _, g := range groups {
+		groupOptions = append(groupOptions, accessReviewOption{ID: g.ID, Name: g.Name, ParentID: g.ParentID, MemberCount: groupCount[g.ID]})
+	}
+	respondOK(c, gin.H{"roles": roleOptions, "groups": groupOptions})
+}
+
+// SearchAccessReviewers 搜索可作为备选复核人的用户
+func SearchAccessReviewers(c *gin.Context) {
+	query := storage.DB.Model(&models.User{}).Select("id, username, nickname").Where("is_deleted = 0 AND status = 1")
+	if ids := c.Query("ids"); ids != "" {
+		query = query.Where("id IN ?", strings.Split(ids, ","))
+	} else if keyword := strings.TrimSpace(c.Query("keyword")); keyword != "" {
+		query = query.Where("username LIKE ? OR nickname LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+	}
+	var users []struct {
+		ID       uint   `json:"id"`
+		Username string `json:"username"`
+		Nickname string `json:"nickname"`
+	}
+	query.Order("id").Limit(20).Scan(&users)
+	respondOK(c, users)
+}
+
+// CreateAccessReview 发起复核活动，生成复核项并通知复核人
+func CreateAccessReview(c *gin.Context) {
+	var req struct {
+		Name         string `json:"name"`
+		Description  string `json:"description"`
+		TargetType   string `json:"targetType"`
+		TargetIDs    []uint `json:"targetIds"`
+		ReviewerType string `json:"reviewerType"`
+		ReviewerIDs  []uint `json:"reviewerIds"`
+		Deadline     string `json:"deadline"`
+		AutoRevoke   bool   `json:"autoRevoke"`
+		RemindDays   int    `json:"remindDays"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondError(c, http.StatusBadRequest, "请填写活动名称")
+		return
+	}
+	if req.TargetType != accessTargetRole && req.TargetType != accessTargetGroup {
+		respondError(c, http.StatusBadRequest, "复核对象类型错误")
+		return
+	}
+	if req.ReviewerType != reviewerManager && req.ReviewerType != reviewerOwner {
+		respondError(c, http.StatusBadRequest, "复核人规则错误")
+		return
+	}
+	req.TargetIDs = uniqueIDs(req.TargetIDs)
+	if len(req.TargetIDs) == 0 {
+		respondError(c, http.StatusBadRequest, "请选择要复核的角色或分组")
+		return
+	}
+	if len(accessReviewTargetNames(req.TargetType, req.TargetIDs)) != len(req.TargetIDs) {
+		respondError(c, http.StatusBadRequest, "复核对象中包含不存在的角色或分组")
+		return
+	}
+	req.ReviewerIDs = uniqueIDs(req.ReviewerIDs)
+	if len(req.ReviewerIDs) > 0 {
+		var count int64
+		storage.DB.Model(&models.User{}).Where("id IN ? AND is_deleted = 0", req.ReviewerIDs).Count(&count)
+		if int(count) != len(req.ReviewerIDs) {
+			respondError(c, http.StatusBadRequest, "备选复核人中包含不存在的用户")
+			return
+		}
+	}
+	deadline, err := parseValidityTime(req.Deadline, true)
+	if err != nil || deadline == nil {
+		respondError(c, http.StatusBadRequest, "请填写正确的截止时间")
+		return
+	}
+	if !deadline.After(time.Now()) {
+		respondError(c, http.StatusBadRequest, "截止时间必须晚于当前时间")
+		return
+	}
+	if req.RemindDays < 0 {
+		respondError(c, http.StatusBadRequest, "提醒天数不能为负数")
+		return
+	}
+
+	items := buildAccessReviewItems(c, req.TargetType, req.TargetIDs, req.ReviewerType, req.ReviewerIDs)
+	if len(items) == 0 {
+		respondError(c, http.StatusBadRequest, "所选角色或分组下没有需要复核的成员")
+		return
+	}
+
+	targets, _ := json.Marshal(req.TargetIDs)
+	reviewers, _ := json.Marshal(req.ReviewerIDs)
+	review := models.AccessReview{
+		Name:         req.Name,
+		Description:  strings.TrimSpace(req.Description),
+		TargetType:   req.TargetType,
+		TargetIDs:    string(targets),
+		ReviewerType: req.ReviewerType,
+		ReviewerIDs:  string(reviewers),
+		Deadline:     *deadline,
+		AutoRevoke:   req.AutoRevoke,
+		RemindDays:   req.RemindDays,
+		Status:       models.AccessReviewActive,
+		CreatedBy:    middleware.GetUsername(c),
+	}
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&review).Error; err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].ReviewID = review.ID
+		}
+		return tx.CreateInBatches(&items, 200).Error
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "创建失败")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "权限复核", "发起复核", review.Name,
+		fmt.Sprintf("复核对象：%s，共 %d 项，截止：%s", strings.Join(accessReviewTargetNames(review.TargetType, req.TargetIDs), "、"),
+			len(items), review.Deadline.Format("2006-01-02 15:04:05")))
+	go notifyAccessReviewers(review, "权限复核待处理")
+	respondOK(c, newAccessReviewView(review, loadAccessReviewStats([]uint{review.ID})[review.ID]))
+}
+
+// ListAccessReviewItems 复核活动的复核项
+func ListAccessReviewItems(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+
+	query := storage.DB.Model(&models.AccessReviewItem{}).Where("review_id = ?", id)
+	if decision := c.Query("decision"); decision != "" {
+		query = query.Where("decision = ?", decision)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		query = query.Where("username LIKE ? OR nickname LIKE ? OR reviewer_name LIKE ?", "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%")
+	}
+	var total int64
+	query.Count(&total)
+	var items []models.AccessReviewItem
+	query.Order("id").Offset((page - 1) * pageSize).Limit(pageSize).Find(&items)
+	respondList(c, items, total)
+}
+
+// CloseAccessReview 提前结束复核活动，未复核的项按活动配置处理
+func CloseAccessReview(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var review models.AccessReview
+	if err := storage.DB.First(&review, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "复核活动不存在")
+		return
+	}
+	handled, err := finalizeAccessReview(review, middleware.GetUsername(c))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	middleware.RecordOperationLog(c, "权限复核", "结束复核", review.Name, fmt.Sprintf("未复核 %d 项", handled))
+	respondOK(c, gin.H{"handled": handled})
+}
+
+// CancelAccessReview 取消复核活动，未复核的项保持原状
+func CancelAccessReview(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var review models.AccessReview
+	if err := storage.DB.First(&review, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "复核活动不存在")
+		return
+	}
+	res := storage.DB.Model(&models.AccessReview{}).Where("id = ? AND status = ?", review.ID, models.AccessReviewActive).
+		Updates(map[string]interface{}{"status": models.AccessReviewCancelled, "completed_at": time.Now()})
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusBadRequest, "复核活动不处于进行中")
+		return
+	}
+	middleware.RecordOperationLog(c, "权限复核", "取消复核", review.Name, "")
+	respondOK(c, nil)
+}
+
+// ExportAccessReview 导出复核结果作为审计证据
+func ExportAccessReview(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var review models.AccessReview
+	if err := storage.DB.First(&review, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "复核活动不存在")
+		return
+	}
+	var items []models.AccessReviewItem
+	storage.DB.Where("review_id = ?", review.ID).Order("id").Find(&items)
+	view := newAccessReviewView(review, loadAccessReviewStats([]uint{review.ID})[review.ID])
+
+	f := excelize.NewFile()
+	headerStyle, _ := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#E0E0E0"}},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+
+	// 活动信息
+	summary := "活动信息"
+	f.SetSheetName("Sheet1", summary)
+	targetLabel := "角色"
+	if review.TargetType == accessTargetGroup {
+		targetLabel = "分组"
+	}
+	reviewerRule := "直属上级"
+	if review.ReviewerType == reviewerOwner {
+		reviewerRule = "角色负责人"
+	}
+	overdue := "保留并标记为逾期未复核"
+	if review.AutoRevoke {
+		overdue = "自动收回"
+	}
+	completedAt := ""
+	if review.CompletedAt != nil {
+		completedAt = review.CompletedAt.Format("2006-01-02 15:04:05")
+	}
+	rows := [][2]interface{}{
+		{"活动名称", review.Name},
+		{"说明", review.Description},
+		{"复核对象", targetLabel + "：" + strings.Join(view.TargetNames, "、")},
+		{"复核人规则", reviewerRule},
+		{"截止时间", review.Deadline.Format("2006-01-02 15:04:05")},
+		{"截止时未复核", overdue},
+		{"状态", accessReviewStatusLabels[review.Status]},
+		{"发起人", review.CreatedBy},
+		{"发起时间", review.CreatedAt.Format("2006-01-02 15:04:05")},
+		{"完成时间", completedAt},
+		{"复核项总数", view.Stats.Total},
+		{"确认保留", view.Stats.Confirmed},
+		{"收回", view.Stats.Revoked},
+		{"逾期自动收回", view.Stats.AutoRevoked},
+		{"逾期未复核", view.Stats.Unreviewed},
+		{"待复核", view.Stats.Pending},
+		{"导出人", middleware.GetUsername(c)},
+		{"导出时间", time.Now().Format("2006-01-02 15:04:05")},
+	}
+	for i, r := range rows {
+		f.SetCellValue(summary, cellName(1, i+1), r[0])
+		f.SetCellValue(summary, cellName(2, i+1), r[1])
+	}
+	f.SetCellStyle(summary, "A1", cellName(1, len(rows)), headerStyle)
+	f.SetColWidth(summary, "A", "A", 16)
+	f.SetColWidth(summary, "B", "B", 60)
+
+	// 复核明细
+	sheet := "复核明细"
+	f.NewSheet(sheet)
+	headers := []string{"序号", "用户名", "姓名", "类型", "角色/分组", "复核人", "结论", "意见", "处理人", "处理时间"}
+	for i, h := range headers {
+		f.SetCellValue(sheet, cellName(i+1, 1), h)
+	}
+	f.SetCellStyle(sheet, "A1", cellName(len(headers), 1), headerStyle)
+	for i, item := range items {
+		row := i + 2
+		typeLabel := "角色"
+		if item.TargetType == accessTargetGroup {
+			typeLabel = "分组"
+		}
+		decidedAt := ""
+		if item.DecidedAt != nil {
+			decidedAt = item.DecidedAt.Format("2006-01-02 15:04:05")
+		}
+		f.SetCellValue(sheet, cellName(1, row), i+1)
+		f.SetCellValue(sheet, cellName(2, row), item.Username)
+		f.SetCellValue(sheet, cellName(3, row), item.Nickname)
+		f.SetCellValue(sheet, cellName(4, row), typeLabel)
+		f.SetCellValue(sheet, cellName(5, row), item.TargetName)
+		f.SetCellValue(sheet, cellName(6, row), item.ReviewerName)
+		f.SetCellValue(sheet, cellName(7, row), reviewDecisionLabels[item.Decision])
+		f.SetCellValue(sheet, cellName(8, row), item.Comment)
+		f.SetCellValue(sheet, cellName(9, row), item.DecidedBy)
+		f.SetCellValue(sheet, cellName(10, row), decidedAt)
+	}
+	widths := map[string]float64{"A": 8, "B": 18, "C": 12, "D": 8, "E": 20, "F": 16, "G": 14, "H": 30, "I": 16, "J": 20}
+	for col, w := range widths {
+		f.SetColWidth(sheet, col, col, w)
+	}
+
+	middleware.RecordOperationLog(c, "权限复核", "导出结果", review.Name, fmt.Sprintf("共 %d 项", len(items)))
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=access_review_%d_%s.xlsx", review.ID, time.Now().Format("20060102_150405")))
+	if err := f.Write(c.Writer); err != nil {
+		log.Printf("[导出] 权限复核结果导出失败: %v", err)
+	}
+}
+
+// ---------- 复核人接口 ----------
+
+// myReviewItem 待我复核的项，附带活动名称和截止时间
+type myReviewItem struct {
+	models.AccessReviewItem
+	ReviewName string    `json:"reviewName"`
+	Deadline   time.Time `json:"deadline"`
+	AutoRevoke bool      `json:"autoRevoke"`
+}
+
+// ListMyReviewItems 进行中活动里分配给我的复核项
+func ListMyReviewItems(c *gin.Context) {
+	var items []myReviewItem
+	query := storage.DB.Model(&models.AccessReviewItem{}).
+		Select("access_review_items.*, access_reviews.name AS review_name, access_reviews.deadline, access_reviews.auto_revoke").
+		Joins("JOIN access_reviews ON access_reviews.id = access_review_items.review_id").
+		Where("access_review_items.reviewer_id = ? AND access_reviews.status = ?", middleware.GetUserID(c), models.AccessReviewActive)
+	if decision := c.DefaultQuery("decision", models.ReviewDecisionPending); decision != "all" {
+		query = query.Where("access_review_items.decision = ?", decision)
+	}
+	query.Order("access_reviews.deadline, access_review_items.id").Limit(500).Scan(&items)
+	if items == nil {
+		items = []myReviewItem{}
+	}
+	respondOK(c, items)
+}
+
+// DecideReviewItems 批量复核：confirm 确认保留 / revoke 收回
+// 复核人只能处理分配给自己的项；拥有权限复核权限的管理员可处理任意项；不能复核自己的成员关系
+func DecideReviewItems(c *gin.Context) {
+	var req struct {
+		ItemIDs  []uint `json:"itemIds"`
+		Decision string `json:"decision"`
+		Comment  string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.ItemIDs) == 0 {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	decision := models.ReviewDecisionConfirmed
+	switch req.Decision {
+	case "confirm":
+	case "revoke":
+		decision = models.ReviewDecisionRevoked
+	default:
+		respondError(c, http.StatusBadRequest, "复核结论错误")
+		return
+	}
+	comment := strings.TrimSpace(req.Comment)
+	if decision == models.ReviewDecisionRevoked && comment == "" {
+		respondError(c, http.StatusBadRequest, "收回时请填写原因")
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+	operator := middleware.GetUsername(c)
+	isAdmin := middleware.CheckPermission(c, "role:review")
+
+	var items []models.AccessReviewItem
+	storage.DB.Where("id IN ?", uniqueIDs(req.ItemIDs)).Find(&items)
+	reviewStatus := make(map[uint]string)
+	type failure struct {
+		ID      uint   `json:"id"`
+		Message string `json:"message"`
+	}
+	failed := []failure{}
+	success := 0
+	for _, item := range items {
+		if _, ok := reviewStatus[item.ReviewID]; !ok {
+			var review models.AccessReview
+			storage.DB.Select("id, status").First(&review, item.ReviewID)
+			reviewStatus[item.ReviewID] = review.Status
+		}
+		switch {
+		case reviewStatus[item.ReviewID] != models.AccessReviewActive:
+			failed = append(failed, failure{item.ID, "复核活动已结束"})
+			continue
+		case item.UserID == userID:
+			failed = append(failed, failure{item.ID, "不能复核自己的权限"})
+			continue
+		case item.ReviewerID != userID && !isAdmin:
+			failed = append(failed, failure{item.ID, "你不是该项的复核人"})
+			continue
+		}
+		if err := decideReviewItem(item, decision, operator, comment, c.ClientIP()); err != nil {
+			failed = append(failed, failure{item.ID, err.Error()})
+			continue
+		}
+		success++
+	}
+
+	if success > 0 {
+		middleware.RecordOperationLog(c, "权限复核", reviewDecisionLabels[decision], operator,
+			fmt.Sprintf("处理 %d 项，意见：%s", success, comment))
+	}
+	respondOK(c, gin.H{"success": success, "failed": failed})
+}
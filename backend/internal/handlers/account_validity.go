@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 账号有效期（生效 / 失效时间） ==========
+
+// parseValidityTime 解析有效期时间，为空表示不限制
+// 只填日期时：生效时间取当天零点，失效时间取当天 23:59:59
+func parseValidityTime(s string, endOfDay bool) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if endOfDay {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return &t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("时间格式错误: %s", s)
+	}
+	return &t, nil
+}
+
+// validateValidity 校验生效时间早于失效时间
+func validateValidity(from, until *time.Time) error {
+	if from != nil && until != nil && !from.Before(*until) {
+		return fmt.Errorf("生效时间必须早于失效时间")
+	}
+	return nil
+}
+
+// sameTime 两个可空时间是否相同
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// outsideValidity 当前是否不在账号有效期内
+func outsideValidity(user models.User, now time.Time) bool {
+	if user.ValidFrom != nil && user.ValidFrom.After(now) {
+		return true
+	}
+	return user.ValidUntil != nil && !user.ValidUntil.After(now)
+}
+
+// scheduleAccountValidity 按用户当前的有效期重新安排生效 / 失效阶段（取消原有未执行的有效期阶段）
+func scheduleAccountValidity(tx *gorm.DB, user models.User, operator string) error {
+	if err := cancelLifecycleTasks(tx, user.ID, lifecycleFlowValidity, operator, "有效期已修改"); err != nil {
+		return err
+	}
+	now := time.Now()
+	if user.ValidFrom != nil && user.ValidFrom.After(now) {
+		if _, err := scheduleLifecycleTask(tx, user, lifecycleFlowValidity, lifecycleStageValidFrom, *user.ValidFrom, nil, operator); err != nil {
+			return err
+		}
+	}
+	if user.ValidUntil != nil && user.ValidUntil.After(now) {
+		if _, err := scheduleLifecycleTask(tx, user, lifecycleFlowValidity, lifecycleStageValidUntil, *user.ValidUntil, nil, operator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runValidityStage 执行有效期阶段：到达生效时间启用、到达失效时间禁用
+func runValidityStage(user models.User, stage string) (string, error) {
+	if stage == lifecycleStageValidFrom {
+		// 入职 / 离职流程中的账号由生命周期流程控制启用
+		if user.LifecycleState != "" {
+			return "用户处于入职或离职流程中，跳过", nil
+		}
+		if outsideValidity(user, time.Now()) {
+			return "当前不在账号有效期内，跳过", nil
+		}
+		if user.Status == 1 {
+			return "账号已是启用状态", nil
+		}
+		user.Status = 1
+		if err := updateUserWithSyncEvent(user, map[string]interface{}{"status": 1}, models.SyncEventUserEnable, ""); err != nil {
+			return "", err
+		}
+		return "已到生效时间，账号已启用", nil
+	}
+
+	if user.Username == "admin" {
+		return "", fmt.Errorf("不能禁用管理员账户")
+	}
+	if user.Status == 0 {
+		return "账号已是禁用状态", nil
+	}
+	user.Status = 0
+	if err := updateUserWithSyncEvent(user, map[string]interface{}{"status": 0}, models.SyncEventUserDisable, ""); err != nil {
+		return "", err
+	}
+	return "已到失效时间，账号已禁用", nil
+}
+
+// notifyExpiringAccounts 账号失效前 N 天通知本人及直属上级（每个失效时间只通知一次）
+func notifyExpiringAccounts() {
+	cfg := syncer.GetLifecycleConfig()
+	if cfg.ExpiryNotifyDays <= 0 {
+		return
+	}
+	now := time.Now()
+	var users []models.User
+	storage.DB.Where("is_deleted = 0 AND status = 1 AND expiry_notified_at IS NULL").
+		Where("valid_until > ? AND valid_until <= ?", now, now.AddDate(0, 0, cfg.ExpiryNotifyDays)).
+		Limit(200).Find(&users)
+	for _, user := range users {
+		// 先标记再发送，避免发送较慢时下一轮重复通知
+		res := storage.DB.Model(&models.User{}).Where("id = ? AND expiry_notified_at IS NULL", user.ID).Update("expiry_notified_at", now)
+		if res.RowsAffected == 0 {
+			continue
+		}
+		go sendAccountExpiringNotification(user)
+	}
+}
+
+// sendAccountExpiringNotification 按消息策略发送账号到期提醒
+func sendAccountExpiringNotification(user models.User) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[到期提醒] panic: %v", r)
+		}
+	}()
+
+	channelTypes := ResolveAllowedChannelTypes("account_expiring", user.GroupID)
+	if len(channelTypes) == 0 {
+		return // 消息策略中未配置账号到期提醒渠道，不发送
+	}
+	var tpl models.MessageTemplate
+	if storage.DB.Where("scene = ?", "account_expiring").First(&tpl).Error != nil {
+		log.Printf("[到期提醒] 消息模板 account_expiring 不存在，请在消息模板管理中创建，跳过通知: %s", user.Username)
+		return
+	}
+
+	recipients := []models.User{user}
+	if user.ManagerID > 0 {
+		var manager models.User
+		if storage.DB.Where("is_deleted = 0 AND status = 1").First(&manager, user.ManagerID).Error == nil {
+			recipients = append(recipients, manager)
+		}
+	}
+
+	days := int(time.Until(*user.ValidUntil).Hours()/24) + 1
+	for _, to := range recipients {
+		content := tpl.Content
+		content = strings.ReplaceAll(content, "{{username}}", user.Username)
+		content = strings.ReplaceAll(content, "{{nickname}}", user.Nickname)
+		content = strings.ReplaceAll(content, "{{name}}", to.Nickname)
+		content = strings.ReplaceAll(content, "{{valid_until}}", user.ValidUntil.Format("2006-01-02 15:04:05"))
+		content = strings.ReplaceAll(content, "{{days}}", strconv.Itoa(days))
+		content = strings.ReplaceAll(content, "{{time}}", time.Now().Format("2006-01-02 15:04:05"))
+		content = strings.ReplaceAll(content, "{{app_name}}", "统一身份认证平台")
+
+		results := services.SendNotificationByChannels(to, "账号到期提醒", content, channelTypes)
+		for _, r := range results {
+			if r.Success {
+				log.Printf("[到期提醒] %s 发送成功 -> %s（账号 %s）", r.Channel, to.Username, user.Username)
+			} else {
+				log.Printf("[到期提醒] %s 发送失败 %s（账号 %s）: %s", r.Channel, to.Username, user.Username, r.Message)
+			}
+		}
+	}
+}
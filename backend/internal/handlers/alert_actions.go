@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 告警自动响应动作 ==========
+
+func init() {
+	services.SetAlertActionRunner(runAlertActions)
+}
+
+// alertActionResult 单个动作的执行结果
+type alertActionResult struct {
+	status     string
+	message    string
+	target     string
+	userID     *uint
+	revertData map[string]interface{}
+	expiresAt  *time.Time
+}
+
+func actionSkipped(target, msg string) alertActionResult {
+	return alertActionResult{status: models.AlertActionSkipped, target: target, message: msg}
+}
+
+// runAlertActions 执行告警规则的自动响应动作，每个动作都记录一条 AlertActionLog
+func runAlertActions(rule *models.AlertRule, incident *models.AlertIncident, event *models.SecurityEvent, actions []services.AlertAction) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[告警响应] panic recovered: %v", r)
+		}
+	}()
+
+	reason := fmt.Sprintf("告警规则「%s」自动响应", rule.Name)
+	for _, action := range actions {
+		var res alertActionResult
+		switch action.Type {
+		case models.AlertActionBlockIP:
+			res = alertBlockIP(event, action, reason)
+		case models.AlertActionLockAccount:
+			res = alertLockAccount(event, reason)
+		case models.AlertActionTerminateSessions:
+			res = alertTerminateSessions(event)
+		case models.AlertActionForcePasswordChange:
+			res = alertForcePasswordChange(event)
+		case models.AlertActionDisableUser:
+			res = alertDisableUser(event, rule)
+		default:
+			continue
+		}
+
+		revertJSON := ""
+		if res.revertData != nil {
+			data, _ := json.Marshal(res.revertData)
+			revertJSON = string(data)
+		}
+		eventID := event.ID
+		entry := models.AlertActionLog{
+			RuleID:     rule.ID,
+			RuleName:   rule.Name,
+			IncidentID: &incident.ID,
+			EventID:    &eventID,
+			ActionType: action.Type,
+			Target:     res.target,
+			UserID:     res.userID,
+			Status:     res.status,
+			Message:    res.message,
+			RevertData: revertJSON,
+			ExpiresAt:  res.expiresAt,
+		}
+		storage.DB.Create(&entry)
+		log.Printf("[告警响应] 规则 %s 动作 %s 目标 %s: %s %s", rule.Name, action.Type, res.target, res.status, res.message)
+	}
+}
+
+// alertEventUser 查找安全事件关联的用户
+func alertEventUser(event *models.SecurityEvent) (models.User, bool) {
+	var user models.User
+	if event.UserID != nil {
+		if storage.DB.Where("is_deleted = 0").First(&user, *event.UserID).Error == nil {
+			return user, true
+		}
+	}
+	if event.Username != "" && storage.DB.Where("username = ? AND is_deleted = 0", event.Username).First(&user).Error == nil {
+		return user, true
+	}
+	return user, false
+}
+
+// alertBlockIP 将事件来源 IP 加入黑名单（带过期时间）
+func alertBlockIP(event *models.SecurityEvent, action services.AlertAction, reason string) alertActionResult {
+	ip := event.SourceIP
+	if ip == "" {
+		return actionSkipped("", "事件没有来源IP")
+	}
+	ss := services.GetSecurityService()
+	if ok, _ := ss.CheckIPWhitelist(ip); ok {
+		return actionSkipped(ip, "IP在白名单中，不自动封禁")
+	}
+
+	// ip_address 唯一：仍生效的黑名单跳过，已过期或停用的旧记录先清理
+	var existing models.IPBlacklist
+	if storage.DB.Where("ip_address = ?", ip).First(&existing).Error == nil {
+		if existing.IsActive && (existing.ExpiresAt == nil || existing.ExpiresAt.After(time.Now())) {
+			return actionSkipped(ip, "IP已在黑名单中")
+		}
+		storage.DB.Delete(&existing)
+	}
+
+	expiresAt := time.Now().Add(time.Duration(action.DurationMinutes) * time.Minute)
+	if err := ss.AddToBlacklist(ip, reason, "auto", &expiresAt, nil); err != nil {
+		return alertActionResult{status: models.AlertActionFailed, target: ip, message: err.Error()}
+	}
+	var entry models.IPBlacklist
+	storage.DB.Where("ip_address = ?", ip).First(&entry)
+	return alertActionResult{
+		status:     models.AlertActionSuccess,
+		target:     ip,
+		message:    fmt.Sprintf("IP已加入黑名单 %d 分钟", action.DurationMinutes),
+		revertData: map[string]interface{}{"blacklistId": entry.ID},
+		expiresAt:  &expiresAt,
+	}
+}
+
+// alertLockAccount 锁定事件关联账号
+func alertLockAccount(event *models.SecurityEvent, reason string) alertActionResult {
+	user, ok := alertEventUser(event)
+	if !ok {
+		return actionSkipped(event.Username, "未找到事件关联的用户")
+	}
+	if user.Username == "admin" {
+		return actionSkipped(user.Username, "不自动锁定管理员账户")
+	}
+	ss := services.GetSecurityService()
+	if locked, _, _ := ss.CheckAccountLockout(user.Username); locked {
+		return actionSkipped(user.Username, "账户已处于锁定状态")
+	}
+
+	if err := ss.LockAccount(user.Username, event.SourceIP, reason, user.FailedAttempts, user.LockCount+1); err != nil {
+		return alertActionResult{status: models.AlertActionFailed, target: user.Username, userID: &user.ID, message: err.Error()}
+	}
+	var lockout models.Lockout
+	storage.DB.Where("lock_type = ? AND target = ? AND is_active = ?", models.LockTypeAccount, user.Username, true).
+		Order("id DESC").First(&lockout)
+	return alertActionResult{
+		status:     models.AlertActionSuccess,
+		target:     user.Username,
+		userID:     &user.ID,
+		message:    "账户已锁定至 " + lockout.ExpiresAt.Format("2006-01-02 15:04:05"),
+		revertData: map[string]interface{}{"lockoutId": lockout.ID},
+		expiresAt:  &lockout.ExpiresAt,
+	}
+}
+
+// alertTerminateSessions 终止事件关联用户的全部活跃会话
+func alertTerminateSessions(event *models.SecurityEvent) alertActionResult {
+	user, ok := alertEventUser(event)
+	if !ok {
+		return actionSkipped(event.Username, "未找到事件关联的用户")
+	}
+	var sessions []models.Session
+	storage.DB.Where("user_id = ? AND is_active = ?", user.ID, true).Find(&sessions)
+	if len(sessions) == 0 {
+		return actionSkipped(user.Username, "用户没有活跃会话")
+	}
+
+	ss := services.GetSecurityService()
+	var ids []string
+	for _, s := range sessions {
+		if err := ss.TerminateSession(s.ID, nil); err == nil {
+			ids = append(ids, s.ID)
+		}
+	}
+	return alertActionResult{
+		status:     models.AlertActionSuccess,
+		target:     user.Username,
+		userID:     &user.ID,
+		message:    fmt.Sprintf("已终止 %d 个会话", len(ids)),
+		revertData: map[string]interface{}{"sessionIds": ids},
+	}
+}
+
+// alertForcePasswordChange 要求事件关联用户下次登录修改密码
+func alertForcePasswordChange(event *models.SecurityEvent) alertActionResult {
+	user, ok := alertEventUser(event)
+	if !ok {
+		return actionSkipped(event.Username, "未找到事件关联的用户")
+	}
+	if user.ForcePasswordChange {
+		return actionSkipped(user.Username, "用户已被要求修改密码")
+	}
+	if err := storage.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("force_password_change", true).Error; err != nil {
+		return alertActionResult{status: models.AlertActionFailed, target: user.Username, userID: &user.ID, message: err.Error()}
+	}
+	return alertActionResult{
+		status:  models.AlertActionSuccess,
+		target:  user.Username,
+		userID:  &user.ID,
+		message: "已要求用户下次登录修改密码",
+	}
+}
+
+// alertDisableUser 禁用事件关联用户并下发 user_disable
+func alertDisableUser(event *models.SecurityEvent, rule *models.AlertRule) alertActionResult {
+	user, ok := alertEventUser(event)
+	if !ok {
+		return actionSkipped(event.Username, "未找到事件关联的用户")
+	}
+	if user.Username == "admin" {
+		return actionSkipped(user.Username, "不能禁用管理员账户")
+	}
+	if user.Status == 0 {
+		return actionSkipped(user.Username, "用户已是禁用状态")
+	}
+	if err := applyUserStatus(user, 0, "alert:"+rule.Name); err != nil {
+		return alertActionResult{status: models.AlertActionFailed, target: user.Username, userID: &user.ID, message: err.Error()}
+	}
+	return alertActionResult{
+		status:  models.AlertActionSuccess,
+		target:  user.Username,
+		userID:  &user.ID,
+		message: "用户已禁用并下发 user_disable",
+	}
+}
+
+// GetAlertActionLogs 获取告警自动响应记录
+func GetAlertActionLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	query := storage.DB.Model(&models.AlertActionLog{})
+	if v := c.Query("status"); v != "" {
+		query = query.Where("status = ?", v)
+	}
+	if v := c.Query("actionType"); v != "" {
+		query = query.Where("action_type = ?", v)
+	}
+	if v := c.Query("incidentId"); v != "" {
+		query = query.Where("incident_id = ?", v)
+	}
+	if v := c.Query("target"); v != "" {
+		query = query.Where("target LIKE ?", "%"+v+"%")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var logs []models.AlertActionLog
+	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs)
+
+	respondOK(c, gin.H{
+		"list":  logs,
+		"total": total,
+	})
+}
+
+// RevertAlertAction 一键撤销自动响应动作
+func RevertAlertAction(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var entry models.AlertActionLog
+	if err := storage.DB.First(&entry, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "响应记录不存在")
+		return
+	}
+	if entry.Status != models.AlertActionSuccess {
+		respondError(c, http.StatusBadRequest, "只有执行成功的动作可以撤销")
+		return
+	}
+
+	operator := middleware.GetUsername(c)
+	msg, err := revertAlertAction(entry, middleware.GetUserID(c), operator)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now()
+	storage.DB.Model(&entry).Updates(map[string]interface{}{
+		"status":      models.AlertActionReverted,
+		"reverted_by": operator,
+		"reverted_at": now,
+	})
+
+	middleware.RecordOperationLog(c, "安全中心", "撤销告警响应",
+		fmt.Sprintf("%s %s", entry.ActionType, entry.Target), fmt.Sprintf("规则: %s，%s", entry.RuleName, msg))
+	respondOK(c, gin.H{"message": msg})
+}
+
+// revertAlertAction 按动作类型恢复原状态
+func revertAlertAction(entry models.AlertActionLog, operatorID uint, operator string) (string, error) {
+	var data struct {
+		BlacklistID uint     `json:"blacklistId"`
+		LockoutID   uint     `json:"lockoutId"`
+		SessionIDs  []string `json:"sessionIds"`
+	}
+	json.Unmarshal([]byte(entry.RevertData), &data)
+	ss := services.GetSecurityService()
+
+	switch entry.ActionType {
+	case models.AlertActionBlockIP:
+		var item models.IPBlacklist
+		if data.BlacklistID == 0 || storage.DB.First(&item, data.BlacklistID).Error != nil {
+			return "黑名单记录已不存在", nil
+		}
+		if err := ss.RemoveFromBlacklist(item.ID, operatorID); err != nil {
+			return "", fmt.Errorf("移出黑名单失败: %v", err)
+		}
+		return "IP已移出黑名单", nil
+
+	case models.AlertActionLockAccount:
+		var lockout models.Lockout
+		if data.LockoutID == 0 || storage.DB.First(&lockout, data.LockoutID).Error != nil ||
+			!lockout.IsActive || !lockout.ExpiresAt.After(time.Now()) {
+			return "锁定已解除或已过期", nil
+		}
+		if err := ss.UnlockAccount(entry.Target, operatorID); err != nil {
+			return "", fmt.Errorf("解锁失败: %v", err)
+		}
+		return "账户已解锁", nil
+
+	case models.AlertActionTerminateSessions:
+		if len(data.SessionIDs) == 0 {
+			return "没有可恢复的会话", nil
+		}
+		res := storage.DB.Model(&models.Session{}).
+			Where("id IN ? AND is_active = ? AND expires_at > ?", data.SessionIDs, false, time.Now()).
+			Updates(map[string]interface{}{"is_active": true, "revoked_at": nil, "revoke_reason": ""})
+		return fmt.Sprintf("已恢复 %d 个未过期的会话", res.RowsAffected), nil
+
+	case models.AlertActionForcePasswordChange:
+		if entry.UserID == nil {
+			return "", fmt.Errorf("响应记录缺少用户信息")
+		}
+		storage.DB.Model(&models.User{}).Where("id = ?", *entry.UserID).Update("force_password_change", false)
+		return "已取消强制修改密码", nil
+
+	case models.AlertActionDisableUser:
+		var user models.User
+		if entry.UserID == nil || storage.DB.Where("is_deleted = 0").First(&user, *entry.UserID).Error != nil {
+			return "", fmt.Errorf("用户不存在")
+		}
+		if user.Status == 1 {
+			return "用户已是启用状态", nil
+		}
+		if err := validateUserStatusChange(user, 1); err != nil {
+			return "", err
+		}
+		if err := applyUserStatus(user, 1, operator); err != nil {
+			return "", fmt.Errorf("启用用户失败: %v", err)
+		}
+		return "用户已重新启用并下发 user_enable", nil
+	}
+	return "", fmt.Errorf("不支持撤销的动作: %s", entry.ActionType)
+}
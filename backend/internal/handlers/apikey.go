@@ -83,6 +83,7 @@ func ListAPIKeys(c *gin.Context) {
 		PermissionList []string `json:"permissionList"`
 		IPWhiteList    []string `json:"ipWhiteList"`
 		IPBlackList    []string `json:"ipBlackList"`
+		ScopeGroupList []uint   `json:"scopeGroupList"`
 		IsExpired      bool     `json:"isExpired"`
 	}
 
@@ -101,6 +102,7 @@ func ListAPIKeys(c *gin.Context) {
 		if k.IPBlacklist != "" && k.IPBlacklist != "[]" {
 			json.Unmarshal([]byte(k.IPBlacklist), &item.IPBlackList)
 		}
+		item.ScopeGroupList = middleware.ParseIDList(k.ScopeGroupIDs)
 
 		// 检查是否过期
 		if k.ExpiresAt != nil && k.ExpiresAt.Before(now) {
@@ -125,6 +127,8 @@ func CreateAPIKey(c *gin.Context) {
 		IPBlacklist []string `json:"ipBlacklist"`
 		RateLimit   int      `json:"rateLimit"`
 		ExpiresAt   string   `json:"expiresAt"` // ISO8601 格式
+		// 限定可操作的分组（含子分组），为空时与创建者的管理范围一致
+		ScopeGroupIDs []uint `json:"scopeGroupIds"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "参数错误: "+err.Error())
@@ -170,19 +174,21 @@ func CreateAPIKey(c *gin.Context) {
 	permsJSON, _ := json.Marshal(req.Permissions)
 	wlJSON, _ := json.Marshal(req.IPWhitelist)
 	blJSON, _ := json.Marshal(req.IPBlacklist)
+	scopeJSON, _ := json.Marshal(req.ScopeGroupIDs)
 
 	apiKey := models.APIKey{
-		AppID:       appID,
-		AppKey:      hashAppKey(rawAppKey),
-		AppKeyHint:  getAppKeyHint(rawAppKey),
-		Name:        req.Name,
-		Description: req.Description,
-		Permissions: string(permsJSON),
-		IPWhitelist: string(wlJSON),
-		IPBlacklist: string(blJSON),
-		RateLimit:   rateLimit,
-		IsActive:    true,
-		CreatedBy:   middleware.GetUserID(c),
+		AppID:         appID,
+		AppKey:        hashAppKey(rawAppKey),
+		AppKeyHint:    getAppKeyHint(rawAppKey),
+		Name:          req.Name,
+		Description:   req.Description,
+		Permissions:   string(permsJSON),
+		IPWhitelist:   string(wlJSON),
+		IPBlacklist:   string(blJSON),
+		RateLimit:     rateLimit,
+		ScopeGroupIDs: string(scopeJSON),
+		IsActive:      true,
+		CreatedBy:     middleware.GetUserID(c),
 	}
 
 	// 过期时间
@@ -227,14 +233,15 @@ func UpdateAPIKey(c *gin.Context) {
 	}
 
 	var req struct {
-		Name        string   `json:"name"`
-		Description string   `json:"description"`
-		Permissions []string `json:"permissions"`
-		IPWhitelist []string `json:"ipWhitelist"`
-		IPBlacklist []string `json:"ipBlacklist"`
-		RateLimit   int      `json:"rateLimit"`
-		IsActive    *bool    `json:"isActive"`
-		ExpiresAt   *string  `json:"expiresAt"`
+		Name          string   `json:"name"`
+		Description   string   `json:"description"`
+		Permissions   []string `json:"permissions"`
+		IPWhitelist   []string `json:"ipWhitelist"`
+		IPBlacklist   []string `json:"ipBlacklist"`
+		RateLimit     int      `json:"rateLimit"`
+		IsActive      *bool    `json:"isActive"`
+		ExpiresAt     *string  `json:"expiresAt"`
+		ScopeGroupIDs []uint   `json:"scopeGroupIds"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "参数错误")
@@ -274,6 +281,12 @@ func UpdateAPIKey(c *gin.Context) {
 		updates["ip_blacklist"] = string(blJSON)
 	}
 
+	// 分组范围
+	if req.ScopeGroupIDs != nil {
+		scopeJSON, _ := json.Marshal(req.ScopeGroupIDs)
+		updates["scope_group_ids"] = string(scopeJSON)
+	}
+
 	// 过期时间
 	if req.ExpiresAt != nil {
 		if *req.ExpiresAt == "" {
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 字段权威来源 ==========
+
+// localAttributeSource 优先级列表中表示“本地编辑”的来源
+const localAttributeSource uint = 0
+
+// precedenceFields 可配置权威来源的本地字段（另支持 ext.<扩展属性>）
+var precedenceFields = map[string]string{
+	"nickname":        "姓名",
+	"phone":           "手机号",
+	"email":           "邮箱",
+	"avatar":          "头像",
+	"job_title":       "职位",
+	"department_name": "部门名称",
+	"group_id":        "所属分组",
+	"manager_id":      "直属上级",
+}
+
+// precedenceFieldOptions 可配置字段列表（内置字段在前，扩展属性在后）
+func precedenceFieldOptions() []gin.H {
+	keys := make([]string, 0, len(precedenceFields))
+	for key := range precedenceFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	options := make([]gin.H, 0, len(keys))
+	for _, key := range keys {
+		options = append(options, gin.H{"key": key, "label": precedenceFields[key]})
+	}
+	for _, d := range storage.ListUserAttributeDefs() {
+		options = append(options, gin.H{"key": "ext." + d.Key, "label": d.Label})
+	}
+	return options
+}
+
+// isPrecedenceField 字段是否可配置权威来源/锁定
+func isPrecedenceField(field string, defs map[string]bool) bool {
+	if key := strings.TrimPrefix(field, "ext."); key != field {
+		return defs[key]
+	}
+	_, ok := precedenceFields[field]
+	return ok
+}
+
+// normalizeAttributePrecedence 校验字段优先级配置：字段受支持、来源为本地或已存在的连接器且不重复；空列表直接移除
+func normalizeAttributePrecedence(precedence map[string][]uint, defs map[string]bool) error {
+	for field, order := range precedence {
+		if !isPrecedenceField(field, defs) {
+			return fmt.Errorf("不支持配置权威来源的字段: %s", field)
+		}
+		if len(order) == 0 {
+			delete(precedence, field)
+			continue
+		}
+		seen := make(map[uint]bool, len(order))
+		for _, src := range order {
+			if seen[src] {
+				return fmt.Errorf("字段 %s 的权威来源重复", field)
+			}
+			seen[src] = true
+			if src == localAttributeSource {
+				continue
+			}
+			var count int64
+			storage.DB.Model(&models.Connector{}).Where("id = ?", src).Count(&count)
+			if count == 0 {
+				return fmt.Errorf("字段 %s 的权威连接器 %d 不存在", field, src)
+			}
+		}
+	}
+	return nil
+}
+
+// fieldPrecedence 一次上游写入中某个用户的字段来源判定
+type fieldPrecedence struct {
+	precedence  map[string][]uint
+	connectorID uint
+	operator    string
+	userID      uint
+	linked      map[uint]bool
+	sources     map[string]models.UserFieldSource
+}
+
+func newFieldPrecedence(cfg models.IdentityConfig, conn models.Connector, userID uint) *fieldPrecedence {
+	return &fieldPrecedence{
+		precedence:  cfg.AttributePrecedence,
+		connectorID: conn.ID,
+		operator:    conn.Name,
+		userID:      userID,
+		linked:      storage.UserLinkedConnectors(userID),
+		sources:     storage.GetUserFieldSources(userID),
+	}
+}
+
+// available 来源对该用户是否可用：连接器需已关联该用户；本地需该字段最近一次由本地编辑
+func (p *fieldPrecedence) available(src uint, field string) bool {
+	if src == localAttributeSource {
+		rec, ok := p.sources[field]
+		return ok && rec.ConnectorID == localAttributeSource
+	}
+	return p.linked[src]
+}
+
+// allows 当前来源能否写入该字段：字段未锁定，且排在当前来源之前的来源对该用户均不可用
+// 未列入优先级的来源排在最后，仅在列出的来源都不可用时写入
+func (p *fieldPrecedence) allows(field string) bool {
+	if rec, ok := p.sources[field]; ok && rec.Locked {
+		return false
+	}
+	for _, src := range p.precedence[field] {
+		if src == p.connectorID {
+			return true
+		}
+		if p.available(src, field) {
+			return false
+		}
+	}
+	return true
+}
+
+// owns 当前来源被明确列为该字段的权威来源且可写入，此时可覆盖本地已有的非空值
+func (p *fieldPrecedence) owns(field string) bool {
+	for _, src := range p.precedence[field] {
+		if src == p.connectorID {
+			return p.allows(field)
+		}
+	}
+	return false
+}
+
+// filter 移除当前来源无权写入的字段
+func (p *fieldPrecedence) filter(updates map[string]interface{}) {
+	for field := range updates {
+		if !p.allows(field) {
+			delete(updates, field)
+		}
+	}
+}
+
+// claim 上游写入后更新字段来源记录：原先由本地编辑的字段改记为当前连接器
+// 在写入字段的同一事务内调用，失败时由调用方回滚
+func (p *fieldPrecedence) claim(tx *gorm.DB, fields ...string) error {
+	var claimed []string
+	for _, field := range fields {
+		if rec, ok := p.sources[field]; ok && !rec.Locked && rec.ConnectorID != p.connectorID {
+			claimed = append(claimed, field)
+		}
+	}
+	if err := storage.ClaimFieldSources(tx, p.userID, p.connectorID, claimed, p.operator); err != nil {
+		return fmt.Errorf("更新字段来源失败: %v", err)
+	}
+	return nil
+}
+
+// localEditedFields 本地编辑实际改动的字段（用于记录字段来源）
+func localEditedFields(user models.User, updates map[string]interface{}, attrs map[string]string) []string {
+	current := map[string]interface{}{
+		"nickname":   user.Nickname,
+		"phone":      user.Phone,
+		"email":      user.Email,
+		"manager_id": user.ManagerID,
+		"job_title":  user.JobTitle,
+	}
+	var fields []string
+	for field, old := range current {
+		if v, ok := updates[field]; ok && v != old {
+			fields = append(fields, field)
+		}
+	}
+	if len(attrs) > 0 {
+		oldAttrs := storage.GetUserAttributes(user.ID)
+		for key, v := range attrs {
+			if oldAttrs[key] != v {
+				fields = append(fields, "ext."+key)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// validateLockFields 校验待锁定字段
+func validateLockFields(fields []string) error {
+	defs := make(map[string]bool)
+	for _, d := range storage.ListUserAttributeDefs() {
+		defs[d.Key] = true
+	}
+	for _, field := range fields {
+		if !isPrecedenceField(field, defs) {
+			return fmt.Errorf("字段 %s 不支持锁定", field)
+		}
+	}
+	return nil
+}
+
+// ---------- 接口 ----------
+
+// GetUserFieldSources 获取用户的字段来源与锁定状态
+func GetUserFieldSources(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	sources := storage.GetUserFieldSources(uint(id))
+	result := make([]models.UserFieldSource, 0, len(sources))
+	for _, rec := range sources {
+		result = append(result, rec)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Field < result[j].Field })
+	respondOK(c, result)
+}
+
+// LockUserFields 锁定用户字段，防止上游覆盖当前值
+func LockUserFields(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var req struct {
+		Fields []string `json:"fields" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if err := validateLockFields(req.Fields); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := storage.RecordLocalEdits(storage.DB, user.ID, req.Fields, middleware.GetUsername(c), true); err != nil {
+		respondError(c, http.StatusInternalServerError, "锁定失败")
+		return
+	}
+	middleware.RecordOperationLog(c, "用户管理", "锁定字段", user.Username, strings.Join(req.Fields, ","))
+	respondOK(c, nil)
+}
+
+// UnlockUserField 解除字段锁定，之后按权威来源优先级同步
+func UnlockUserField(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	field := c.Param("field")
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if err := storage.UnlockUserField(user.ID, field); err != nil {
+		respondError(c, http.StatusInternalServerError, "解锁失败")
+		return
+	}
+	middleware.RecordOperationLog(c, "用户管理", "解锁字段", user.Username, field)
+	respondOK(c, nil)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"go-syncflow/internal/models"
+)
+
+func TestFieldPrecedence(t *testing.T) {
+	// 连接器 1（钉钉）、2（企业微信）、3（未列入优先级）；0 表示本地编辑
+	precedence := map[string][]uint{
+		"email":    {2, 1},
+		"phone":    {localAttributeSource, 1},
+		"nickname": {1},
+	}
+	tests := []struct {
+		name       string
+		connector  uint
+		linked     map[uint]bool
+		sources    map[string]models.UserFieldSource
+		field      string
+		wantAllows bool
+		wantOwns   bool
+	}{
+		{"未配置优先级的字段任意来源可写", 1, nil, nil, "job_title", true, false},
+		{"首位来源可写并拥有字段", 2, map[uint]bool{1: true, 2: true}, nil, "email", true, true},
+		{"更高优先级来源已关联时不可写", 1, map[uint]bool{1: true, 2: true}, nil, "email", false, false},
+		{"更高优先级来源未关联时可写", 1, map[uint]bool{1: true}, nil, "email", true, true},
+		{"未列入的来源在列出来源可用时不可写", 3, map[uint]bool{1: true, 3: true}, nil, "email", false, false},
+		{"未列入的来源在列出来源均不可用时可写但不拥有", 3, map[uint]bool{3: true}, nil, "email", true, false},
+		{
+			"本地最近编辑时本地优先", 1, map[uint]bool{1: true},
+			map[string]models.UserFieldSource{"phone": {Field: "phone", ConnectorID: localAttributeSource}},
+			"phone", false, false,
+		},
+		{
+			"本地编辑已被上游接管时上游可写", 1, map[uint]bool{1: true},
+			map[string]models.UserFieldSource{"phone": {Field: "phone", ConnectorID: 1}},
+			"phone", true, true,
+		},
+		{"本地无编辑记录时上游可写", 1, map[uint]bool{1: true}, nil, "phone", true, true},
+		{
+			"锁定字段任何来源不可写", 1, map[uint]bool{1: true},
+			map[string]models.UserFieldSource{"nickname": {Field: "nickname", ConnectorID: 1, Locked: true}},
+			"nickname", false, false,
+		},
+		{
+			"锁定未配置优先级的字段", 2, nil,
+			map[string]models.UserFieldSource{"job_title": {Field: "job_title", Locked: true}},
+			"job_title", false, false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fieldPrecedence{precedence: precedence, connectorID: tt.connector, linked: tt.linked, sources: tt.sources}
+			if got := p.allows(tt.field); got != tt.wantAllows {
+				t.Errorf("allows(%s) = %v, want %v", tt.field, got, tt.wantAllows)
+			}
+			if got := p.owns(tt.field); got != tt.wantOwns {
+				t.Errorf("owns(%s) = %v, want %v", tt.field, got, tt.wantOwns)
+			}
+		})
+	}
+}
+
+func TestFieldPrecedenceFilter(t *testing.T) {
+	p := &fieldPrecedence{
+		precedence:  map[string][]uint{"email": {2, 1}, "phone": {1}},
+		connectorID: 1,
+		linked:      map[uint]bool{1: true, 2: true},
+		sources:     map[string]models.UserFieldSource{"job_title": {Field: "job_title", Locked: true}},
+	}
+	updates := map[string]interface{}{"email": "a@example.com", "phone": "13800000000", "job_title": "工程师", "nickname": "张三"}
+	p.filter(updates)
+
+	var got []string
+	for field := range updates {
+		got = append(got, field)
+	}
+	sort.Strings(got)
+	if want := []string{"nickname", "phone"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filter 保留字段 = %v, want %v", got, want)
+	}
+}
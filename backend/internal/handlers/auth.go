@@ -20,7 +20,6 @@ import (
 	"go-syncflow/internal/models"
 	"go-syncflow/internal/services"
 	"go-syncflow/internal/storage"
-	syncer "go-syncflow/internal/sync"
 )
 
 // decodeRawPassword 解密前端 RSA 加密的明文密码（用于 AD 同步）
@@ -36,6 +35,22 @@ func decodeRawPassword(encrypted string) string {
 	return plain
 }
 
+// policyPassword 返回用于密码策略检查的明文：原文提交时即为新密码；
+// 前端已 SHA256 哈希时使用 RSA 加密传输的原文，且须与哈希一致，否则返回空（策略已在前端校验）
+func policyPassword(newPassword string, encrypted bool, rawPwd string) string {
+	if !encrypted {
+		return newPassword
+	}
+	if rawPwd == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(rawPwd))
+	if !strings.EqualFold(hex.EncodeToString(h[:]), newPassword) {
+		return ""
+	}
+	return rawPwd
+}
+
 // GetRSAPublicKey 返回 RSA 公钥（前端用于加密明文密码）
 func GetRSAPublicKey(c *gin.Context) {
 	respondOK(c, gin.H{
@@ -144,65 +159,111 @@ type loginRequest struct {
 	CSRFToken string `json:"_csrf"`       // 一次性 CSRF 令牌
 }
 
-func Login(c *gin.Context) {
-	var req loginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		respondError(c, http.StatusBadRequest, "参数错误")
-		return
+// clientLocationHeaders 由前置代理 / CDN 写入的客户端国家或地区请求头（按顺序取第一个非空值）
+var clientLocationHeaders = []string{"CF-IPCountry", "X-Country-Code", "X-Geo-Country"}
+
+// clientLocation 获取客户端登录地点：优先查询离线 GeoIP 数据库（国家/省份/城市），
+// 未配置数据库或未命中时取可信代理写入的地区头
+func clientLocation(c *gin.Context) string {
+	if loc := services.GetSecurityService().LookupLocation(c.ClientIP()); loc != nil {
+		return loc.String()
 	}
+	for _, h := range clientLocationHeaders {
+		if v := strings.TrimSpace(c.GetHeader(h)); v != "" && v != "XX" {
+			return strings.ToUpper(v)
+		}
+	}
+	return ""
+}
 
+// rejectBlockedLogin 登录前置检查（IP黑名单、IP锁定、账户锁定），被拒绝时已写入响应并返回 true
+func rejectBlockedLogin(c *gin.Context, username string) bool {
 	clientIP := c.ClientIP()
 	userAgent := c.GetHeader("User-Agent")
 	ss := services.GetSecurityService()
 
-	// 1. 检查IP黑名单
+	// IP黑名单
 	inBlacklist, reason, _ := ss.CheckIPBlacklist(clientIP)
 	if inBlacklist {
-		ss.RecordLoginAttempt(req.Username, nil, clientIP, userAgent, false, "IP在黑名单中")
-		ss.RecordSecurityEvent(models.EventLoginBlocked, models.SeverityHigh, clientIP, nil, req.Username,
+		ss.RecordLoginAttempt(username, nil, clientIP, userAgent, false, "IP在黑名单中")
+		ss.RecordSecurityEvent(models.EventLoginBlocked, models.SeverityHigh, clientIP, nil, username,
 			"login", "", "登录被阻止: IP在黑名单中", map[string]interface{}{"reason": reason})
 		respondError(c, http.StatusForbidden, "访问被拒绝")
-		return
+		return true
 	}
 
-	// 2. 检查IP锁定
+	// IP锁定
 	ipLocked, ipExpiresAt, _ := ss.CheckIPLockout(clientIP)
 	if ipLocked {
-		ss.RecordLoginAttempt(req.Username, nil, clientIP, userAgent, false, "IP被锁定")
+		ss.RecordLoginAttempt(username, nil, clientIP, userAgent, false, "IP被锁定")
 		respondError(c, http.StatusForbidden, fmt.Sprintf("IP已被锁定，请在 %s 后重试", ipExpiresAt.Format("15:04:05")))
-		return
+		return true
 	}
 
-	// 3. 检查账户锁定
-	accountLocked, accountExpiresAt, _ := ss.CheckAccountLockout(req.Username)
+	// 账户锁定
+	accountLocked, accountExpiresAt, _ := ss.CheckAccountLockout(username)
 	if accountLocked {
-		ss.RecordLoginAttempt(req.Username, nil, clientIP, userAgent, false, "账户被锁定")
+		ss.RecordLoginAttempt(username, nil, clientIP, userAgent, false, "账户被锁定")
 		respondError(c, http.StatusForbidden, fmt.Sprintf("账户已被锁定，请在 %s 后重试", accountExpiresAt.Format("15:04:05")))
+		return true
+	}
+	return false
+}
+
+// rejectUnavailableUser 检查用户状态与用户级别锁定，被拒绝时已写入响应并返回 true
+func rejectUnavailableUser(c *gin.Context, user models.User) bool {
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	ss := services.GetSecurityService()
+
+	// 用户状态
+	if user.Status == 0 {
+		ss.RecordLoginAttempt(user.Username, &user.ID, clientIP, userAgent, false, "用户已禁用")
+		middleware.RecordLoginLog(user.ID, user.Username, clientIP, userAgent, false, "用户已禁用")
+		respondError(c, http.StatusForbidden, "用户已被禁用")
+		return true
+	}
+
+	// 用户级别锁定
+	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		ss.RecordLoginAttempt(user.Username, &user.ID, clientIP, userAgent, false, "账户被锁定")
+		respondError(c, http.StatusForbidden, fmt.Sprintf("账户已被锁定，请在 %s 后重试", user.LockedUntil.Format("15:04:05")))
+		return true
+	}
+	return false
+}
+
+func Login(c *gin.Context) {
+	var req loginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	ss := services.GetSecurityService()
+
+	// 1-3. 检查IP黑名单、IP锁定、账户锁定
+	if rejectBlockedLogin(c, req.Username) {
 		return
 	}
 
 	// 4. 查找用户
 	var user models.User
 	if err := storage.DB.Where("username = ? AND is_deleted = 0", req.Username).First(&user).Error; err != nil {
-		ss.RecordLoginAttempt(req.Username, nil, clientIP, userAgent, false, "用户不存在")
+		location := clientLocation(c)
+		ss.RecordLoginAttemptDetail(req.Username, nil, clientIP, userAgent, location, deviceFingerprint(c), false, "用户不存在")
+		ss.RecordClientSecurityEvent(models.EventLoginFailed, models.SeverityMedium, clientIP, userAgent, location,
+			nil, req.Username, "login", "", "登录失败: 用户不存在", nil)
 		ss.HandleFailedLogin(req.Username, clientIP)
 		middleware.RecordLoginLog(0, req.Username, clientIP, userAgent, false, "用户不存在")
 		respondError(c, http.StatusUnauthorized, "用户名或密码错误")
 		return
 	}
 
-	// 5. 检查用户状态
-	if user.Status == 0 {
-		ss.RecordLoginAttempt(req.Username, &user.ID, clientIP, userAgent, false, "用户已禁用")
-		middleware.RecordLoginLog(user.ID, req.Username, clientIP, userAgent, false, "用户已禁用")
-		respondError(c, http.StatusForbidden, "用户已被禁用")
-		return
-	}
-
-	// 6. 检查用户级别锁定
-	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
-		ss.RecordLoginAttempt(req.Username, &user.ID, clientIP, userAgent, false, "账户被锁定")
-		respondError(c, http.StatusForbidden, fmt.Sprintf("账户已被锁定，请在 %s 后重试", user.LockedUntil.Format("15:04:05")))
+	// 5-6. 检查用户状态与用户级别锁定
+	if rejectUnavailableUser(c, user) {
 		return
 	}
 
@@ -229,54 +290,41 @@ func Login(c *gin.Context) {
 	}
 	
 	if !passwordValid {
-		ss.RecordLoginAttempt(req.Username, &user.ID, clientIP, userAgent, false, "密码错误")
+		location := clientLocation(c)
+		ss.RecordLoginAttemptDetail(req.Username, &user.ID, clientIP, userAgent, location, deviceFingerprint(c), false, "密码错误")
+		ss.RecordClientSecurityEvent(models.EventLoginFailed, models.SeverityMedium, clientIP, userAgent, location,
+			&user.ID, req.Username, "login", "", "登录失败: 密码错误", nil)
 		ss.HandleFailedLogin(req.Username, clientIP)
 		middleware.RecordLoginLog(user.ID, req.Username, clientIP, userAgent, false, "密码错误")
 		respondError(c, http.StatusUnauthorized, "用户名或密码错误")
 		return
 	}
 
-	// 8. 生成Token
-	token, err := middleware.GenerateToken(user.ID, user.Username)
-	if err != nil {
-		respondError(c, http.StatusInternalServerError, "生成Token失败")
+	// 9. 登录风险评估：高风险直接阻止
+	location := clientLocation(c)
+	fingerprint := deviceFingerprint(c)
+	risk := ss.AssessLoginRisk(user, clientIP, location, fingerprint)
+	if risk.Decision == services.LoginRiskBlock {
+		rejectRiskyLogin(c, user, location, fingerprint, risk)
 		return
 	}
 
-	// 9. 记录成功登录
-	ss.RecordLoginAttempt(req.Username, &user.ID, clientIP, userAgent, true, "")
-	ss.HandleSuccessfulLogin(user.ID, clientIP)
-	ss.RecordSecurityEvent(models.EventLoginSuccess, models.SeverityLow, clientIP, &user.ID, req.Username,
-		"login", "", "用户登录成功", nil)
-	middleware.RecordLoginLog(user.ID, req.Username, clientIP, userAgent, true, "登录成功")
-
-	// 会话记录由 Auth 中间件在首次请求时自动创建
+	// 10. 二次验证：开启通行密钥二次验证的用户验证通行密钥（同时满足风险验证），否则中风险需输入验证码
+	if startPasskeySecondFactor(c, user, location, fingerprint, risk) {
+		return
+	}
+	if risk.Decision == services.LoginRiskChallenge && startLoginChallenge(c, user, location, fingerprint, risk) {
+		return
+	}
 
-	respondOK(c, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":                  user.ID,
-			"username":            user.Username,
-			"nickname":            user.Nickname,
-			"avatar":              user.Avatar,
-			"forcePasswordChange": user.ForcePasswordChange,
-		},
-	})
+	// 11. 生成Token并记录成功登录
+	completeLogin(c, user, location, fingerprint, risk, "登录成功")
 }
 
 func Logout(c *gin.Context) {
-	// 登出时终止当前会话
-	userID := middleware.GetUserID(c)
-	if userID > 0 {
-		token := c.GetHeader("Authorization")
-		if len(token) > 7 && token[:7] == "Bearer " {
-			token = token[7:]
-		}
-		// 使用 token hash 查找并终止匹配的会话
-		tokenHash := middleware.HashToken(token)
-		storage.DB.Model(&models.Session{}).
-			Where("user_id = ? AND access_token = ? AND is_active = ?", userID, tokenHash, true).
-			Update("is_active", false)
+	// 登出时撤销当前会话，访问令牌和刷新令牌同时失效
+	if sessionID := middleware.GetSessionID(c); sessionID != "" {
+		services.GetSecurityService().RevokeSession(sessionID, services.SessionRevokeLogout)
 	}
 	respondOK(c, nil)
 }
@@ -431,9 +479,11 @@ func ChangePassword(c *gin.Context) {
 
 	ss := services.GetSecurityService()
 
-	// 如果前端未加密（原文密码），才在后端执行密码策略校验
-	if !req.Encrypted {
-		valid, errors := ss.ValidatePassword(req.NewPassword)
+	// 有明文（原文提交或随 RSA 加密原文一并提交）时在后端执行密码策略校验
+	rawPwd := decodeRawPassword(req.RawPwd)
+	plain := policyPassword(req.NewPassword, req.Encrypted, rawPwd)
+	if plain != "" {
+		valid, errors := ss.ValidatePasswordFor(services.PasswordCheckInput{Password: plain, User: &user})
 		if !valid {
 			respondError(c, http.StatusBadRequest, errors[0])
 			return
@@ -449,7 +499,6 @@ func ChangePassword(c *gin.Context) {
 
 	hashed, _ := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	now := time.Now()
-	rawPwd := decodeRawPassword(req.RawPwd)
 	updates := map[string]interface{}{
 		"password":              string(hashed),
 		"force_password_change": false,
@@ -458,20 +507,25 @@ func ChangePassword(c *gin.Context) {
 	if rawPwd != "" {
 		updates["samba_nt_password"] = ldapserver.ComputeNTHash(rawPwd)
 	}
-	storage.DB.Model(&user).Updates(updates)
+	if err := updateUserWithSyncEvent(user, updates, models.SyncEventPasswordChange, rawPwd); err != nil {
+		respondError(c, http.StatusInternalServerError, "密码修改失败")
+		return
+	}
 
 	// 更新密码历史
 	ss.UpdatePasswordHistory(userID, string(hashed))
+	if plain != "" {
+		ss.RecordPasswordSkeleton(userID, plain)
+	}
 
-	// 使该用户所有已签发的 token 失效
-	middleware.InvalidateUserTokens(userID)
+	// 使该用户其他会话失效，当前会话保留
+	ss.RevokeUserSessions(userID, middleware.GetSessionID(c), services.SessionRevokeCredential)
 
 	// 记录安全事件
 	ss.RecordSecurityEvent(models.EventPasswordChanged, models.SeverityMedium, c.ClientIP(), &userID, user.Username,
 		"user", fmt.Sprintf("%d", userID), "用户修改了密码", nil)
 
 	middleware.RecordOperationLog(c, "用户", "修改密码", "", "")
-	syncer.DispatchSyncEvent(models.SyncEventPasswordChange, userID, rawPwd)
 	respondOK(c, nil)
 }
 
@@ -637,10 +691,12 @@ func ChangePasswordMultiMethod(c *gin.Context) {
 
 	ss := services.GetSecurityService()
 
-	// 如果前端未加密（原文密码），才在后端执行密码策略校验
-	// 前端已 SHA256 哈希时，策略校验在前端完成，后端跳过
-	if !req.Encrypted {
-		valid, policyErrors := ss.ValidatePassword(req.NewPassword)
+	// 有明文（原文提交或随 RSA 加密原文一并提交）时在后端执行密码策略校验
+	// 前端已 SHA256 哈希且无原文时，策略校验在前端完成，后端跳过
+	rawPwd := decodeRawPassword(req.RawPwd)
+	plain := policyPassword(req.NewPassword, req.Encrypted, rawPwd)
+	if plain != "" {
+		valid, policyErrors := ss.ValidatePasswordFor(services.PasswordCheckInput{Password: plain, User: &user})
 		if !valid {
 			respondError(c, http.StatusBadRequest, policyErrors[0])
 			return
@@ -656,7 +712,6 @@ func ChangePasswordMultiMethod(c *gin.Context) {
 
 	hashed, _ := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	now := time.Now()
-	rawPwd := decodeRawPassword(req.RawPwd)
 	updates := map[string]interface{}{
 		"password":              string(hashed),
 		"force_password_change": false,
@@ -665,18 +720,23 @@ func ChangePasswordMultiMethod(c *gin.Context) {
 	if rawPwd != "" {
 		updates["samba_nt_password"] = ldapserver.ComputeNTHash(rawPwd)
 	}
-	storage.DB.Model(&user).Updates(updates)
+	if err := updateUserWithSyncEvent(user, updates, models.SyncEventPasswordChange, rawPwd); err != nil {
+		respondError(c, http.StatusInternalServerError, "密码修改失败")
+		return
+	}
 
 	ss.UpdatePasswordHistory(userID, string(hashed))
+	if plain != "" {
+		ss.RecordPasswordSkeleton(userID, plain)
+	}
 
-	// 使该用户所有已签发的 token 失效，强制所有会话重新登录
-	middleware.InvalidateUserTokens(userID)
+	// 使该用户其他会话失效，强制其他设备重新登录
+	ss.RevokeUserSessions(userID, middleware.GetSessionID(c), services.SessionRevokeCredential)
 
 	ss.RecordSecurityEvent(models.EventPasswordChanged, models.SeverityMedium, c.ClientIP(), &userID, user.Username,
 		"user", fmt.Sprintf("%d", userID), fmt.Sprintf("用户通过 %s 方式修改了密码", req.Method), nil)
 
 	middleware.RecordOperationLog(c, "个人中心", "修改密码", fmt.Sprintf("方式: %s", req.Method), "")
-	syncer.DispatchSyncEvent(models.SyncEventPasswordChange, userID, rawPwd)
 	respondOK(c, nil)
 }
 
@@ -1106,10 +1166,12 @@ func ForgotPasswordReset(c *gin.Context) {
 	// 验证通过，重置错误计数
 	resetForgotFail(req.Username)
 
-	// 密码策略（仅原文时校验）
-	if !req.Encrypted {
+	// 密码策略（有明文时校验）
+	rawPwd := decodeRawPassword(req.RawPwd)
+	plain := policyPassword(req.NewPassword, req.Encrypted, rawPwd)
+	if plain != "" {
 		ss := services.GetSecurityService()
-		valid, policyErrors := ss.ValidatePassword(req.NewPassword)
+		valid, policyErrors := ss.ValidatePasswordFor(services.PasswordCheckInput{Password: plain, User: &user})
 		if !valid {
 			respondError(c, http.StatusBadRequest, policyErrors[0])
 			return
@@ -1118,7 +1180,6 @@ func ForgotPasswordReset(c *gin.Context) {
 
 	hashed, _ := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	now := time.Now()
-	rawPwd := decodeRawPassword(req.RawPwd)
 	updates := map[string]interface{}{
 		"password":              string(hashed),
 		"force_password_change": false,
@@ -1127,16 +1188,20 @@ func ForgotPasswordReset(c *gin.Context) {
 	if rawPwd != "" {
 		updates["samba_nt_password"] = ldapserver.ComputeNTHash(rawPwd)
 	}
-	storage.DB.Model(&user).Updates(updates)
-
-	middleware.InvalidateUserTokens(user.ID)
+	if err := updateUserWithSyncEvent(user, updates, models.SyncEventPasswordChange, rawPwd); err != nil {
+		respondError(c, http.StatusInternalServerError, "密码重置失败")
+		return
+	}
 
 	ss := services.GetSecurityService()
+	ss.RevokeUserSessions(user.ID, "", services.SessionRevokeCredential)
 	ss.UpdatePasswordHistory(user.ID, string(hashed))
-	ss.RecordSecurityEvent(models.EventPasswordChanged, models.SeverityMedium, c.ClientIP(), &user.ID, user.Username,
-		"user", fmt.Sprintf("%d", user.ID), fmt.Sprintf("用户通过忘记密码（%s）重置了密码", req.Method), nil)
+	if plain != "" {
+		ss.RecordPasswordSkeleton(user.ID, plain)
+	}
+	ss.RecordClientSecurityEvent(models.EventPasswordReset, models.SeverityMedium, c.ClientIP(), c.GetHeader("User-Agent"), clientLocation(c),
+		&user.ID, user.Username, "user", fmt.Sprintf("%d", user.ID), fmt.Sprintf("用户通过忘记密码（%s）重置了密码", req.Method), nil)
 
-	syncer.DispatchSyncEvent(models.SyncEventPasswordChange, user.ID, rawPwd)
 	respondOK(c, gin.H{"message": "密码重置成功，请使用新密码登录"})
 }
 
@@ -1352,33 +1417,30 @@ func DingTalkLogin(c *gin.Context) {
 		return
 	}
 
-	// 7. 生成Token
-	token, err := middleware.GenerateToken(user.ID, user.Username)
+	// 7. 创建会话并签发令牌
+	location := clientLocation(c)
+	tokens, err := issueSessionTokens(c, user, location)
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "生成Token失败")
 		return
 	}
 
 	// 8. 记录登录成功
-	ss.RecordLoginAttempt(user.Username, &user.ID, clientIP, userAgent, true, "")
+	ss.RecordLoginAttemptDetail(user.Username, &user.ID, clientIP, userAgent, location, deviceFingerprint(c), true, "")
 	ss.HandleSuccessfulLogin(user.ID, clientIP)
-	ss.RecordSecurityEvent(models.EventLoginSuccess, models.SeverityLow, clientIP, &user.ID, user.Username,
-		"dingtalk", "", "钉钉免登成功", map[string]interface{}{
+	ss.RecordClientSecurityEvent(models.EventLoginSuccess, models.SeverityLow, clientIP, userAgent, location,
+		&user.ID, user.Username, "dingtalk", "", "钉钉免登成功", map[string]interface{}{
 			"ddUserId": ddUser.UserID,
 			"ddName":   ddUser.Name,
 		})
 	middleware.RecordLoginLog(user.ID, user.Username, clientIP, userAgent, true, "钉钉免登")
 
-	// 会话记录由 Auth 中间件在首次请求时自动创建
-
-	respondOK(c, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":                  user.ID,
-			"username":            user.Username,
-			"nickname":            user.Nickname,
-			"avatar":              user.Avatar,
-			"forcePasswordChange": user.ForcePasswordChange,
-		},
-	})
+	tokens["user"] = gin.H{
+		"id":                  user.ID,
+		"username":            user.Username,
+		"nickname":            user.Nickname,
+		"avatar":              user.Avatar,
+		"forcePasswordChange": user.ForcePasswordChange,
+	}
+	respondOK(c, tokens)
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"go-syncflow/internal/dingtalk"
 	"go-syncflow/internal/ldapserver"
@@ -233,6 +234,13 @@ func ExecuteDingTalkSync(triggerType string) (*SyncResult, error) {
 			if gid, ok := deptToGroupID[primaryDeptID]; ok {
 				userGroupID = gid
 			}
+			// 所属全部部门对应的本地分组（多部门）
+			var userGroupIDs []uint
+			for _, did := range u.DeptIDList {
+				if gid, ok := deptToGroupID[did]; ok {
+					userGroupIDs = append(userGroupIDs, gid)
+				}
+			}
 
 			// ===== 写入钉钉源用户表（dingtalk_users），与本地用户完全隔离 =====
 			var dtUser models.DingTalkUser
@@ -267,22 +275,38 @@ func ExecuteDingTalkSync(triggerType string) (*SyncResult, error) {
 			// ===== 同步到本地用户表（users），本地修改不影响钉钉源 =====
 			var existingUser models.User
 			if storage.DB.Where("ding_talk_uid = ? AND is_deleted = 0", u.UserID).First(&existingUser).Error == nil {
-				// 更新已有本地用户的钉钉信息（不覆盖 status、username、password 等本地属性）
-				storage.DB.Model(&existingUser).Updates(map[string]interface{}{
-					"nickname":        u.Name,
-					"phone":           u.Mobile,
-					"email":           u.Email,
-					"avatar":          u.Avatar,
-					"department_id":   primaryDeptID,
-					"department_name": deptName,
-					"job_title":       u.JobTitle,
-					"group_id":        userGroupID,
+				// 更新已有本地用户的钉钉信息（不覆盖 status、username、password 等本地属性），
+				// 同一事务内触发下游同步器（同步用户信息变更到 AD / 数据库等）
+				err := storage.DB.Transaction(func(tx *gorm.DB) error {
+					if err := tx.Model(&existingUser).Updates(map[string]interface{}{
+						"nickname":        u.Name,
+						"phone":           u.Mobile,
+						"email":           u.Email,
+						"avatar":          u.Avatar,
+						"department_id":   primaryDeptID,
+						"department_name": deptName,
+						"job_title":       u.JobTitle,
+						"group_id":        userGroupID,
+					}).Error; err != nil {
+						return err
+					}
+					if err := storage.SetUserGroups(tx, existingUser.ID, userGroupID, userGroupIDs); err != nil {
+						return err
+					}
+					// 关联钉钉源记录
+					if err := tx.Model(&dtUser).Update("local_user_id", existingUser.ID).Error; err != nil {
+						return err
+					}
+					return syncer.EnqueueUserSyncEvent(tx, models.SyncEventUserUpdate, existingUser.ID, "")
 				})
-				// 关联钉钉源记录
-				storage.DB.Model(&dtUser).Update("local_user_id", existingUser.ID)
-
-				// 触发下游同步器（同步用户信息变更到 AD / 数据库等）
-				syncer.DispatchSyncEvent(models.SyncEventUserUpdate, existingUser.ID, "")
+				if err != nil {
+					log.Printf("更新钉钉用户 %s 失败: %v", u.Name, err)
+					userDetails = append(userDetails, SyncUserDetail{
+						DingTalkUID: u.UserID, DingName: u.Name, LocalUser: existingUser.Username,
+						Department: deptName, Action: "failed", Message: fmt.Sprintf("更新失败: %v", err),
+					})
+					continue
+				}
 
 				result.UsersUpdated++
 				userDetails = append(userDetails, SyncUserDetail{
@@ -314,7 +338,23 @@ func ExecuteDingTalkSync(triggerType string) (*SyncResult, error) {
 				GroupID:         userGroupID,
 			}
 
-				if err := storage.DB.Create(&newUser).Error; err != nil {
+				// 创建用户、关联钉钉源记录、分配默认角色并触发下游同步器（同步到 AD / 数据库等），同一事务内完成
+				err := storage.DB.Transaction(func(tx *gorm.DB) error {
+					if err := tx.Create(&newUser).Error; err != nil {
+						return err
+					}
+					if err := storage.SetUserGroups(tx, newUser.ID, userGroupID, userGroupIDs); err != nil {
+						return err
+					}
+					if err := tx.Model(&dtUser).Update("local_user_id", newUser.ID).Error; err != nil {
+						return err
+					}
+					if err := tx.Create(&models.UserRole{UserID: newUser.ID, RoleID: defaultRoleID}).Error; err != nil {
+						return err
+					}
+					return syncer.EnqueueUserSyncEvent(tx, models.SyncEventUserCreate, newUser.ID, randomPwd)
+				})
+				if err != nil {
 					log.Printf("创建钉钉用户 %s 失败: %v", u.Name, err)
 					userDetails = append(userDetails, SyncUserDetail{
 						DingTalkUID: u.UserID, DingName: u.Name, LocalUser: username,
@@ -323,15 +363,6 @@ func ExecuteDingTalkSync(triggerType string) (*SyncResult, error) {
 					continue
 				}
 
-				// 关联钉钉源记录
-				storage.DB.Model(&dtUser).Update("local_user_id", newUser.ID)
-
-				// 分配默认角色
-				storage.DB.Create(&models.UserRole{UserID: newUser.ID, RoleID: defaultRoleID})
-
-				// 触发下游同步器（同步到 AD / 数据库等）
-				syncer.DispatchSyncEvent(models.SyncEventUserCreate, newUser.ID, randomPwd)
-
 				// 根据消息策略发送"账号开通通知"
 				go sendAccountCreatedNotification(newUser, randomPwd)
 
@@ -350,11 +381,17 @@ func ExecuteDingTalkSync(triggerType string) (*SyncResult, error) {
 	storage.DB.Where("source = ? AND status = 1 AND is_deleted = 0", "dingtalk").Find(&activeDingtalkUsers)
 	for _, u := range activeDingtalkUsers {
 		if u.DingTalkUID != "" && !processedUsers[u.DingTalkUID] {
-			// 该用户在本次同步中未出现（已从钉钉删除），禁用
-			storage.DB.Model(&u).Update("status", 0)
-
-			// 触发下游同步器（同步禁用状态到 AD / 数据库等）
-			syncer.DispatchSyncEvent(models.SyncEventUserDisable, u.ID, "")
+			// 该用户在本次同步中未出现（已从钉钉删除），禁用并触发下游同步器（同步禁用状态到 AD / 数据库等）
+			err := storage.DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&u).Update("status", 0).Error; err != nil {
+					return err
+				}
+				return syncer.EnqueueUserSyncEvent(tx, models.SyncEventUserDisable, u.ID, "")
+			})
+			if err != nil {
+				log.Printf("禁用钉钉用户 %s 失败: %v", u.Username, err)
+				continue
+			}
 
 			result.UsersDisabled++
 			userDetails = append(userDetails, SyncUserDetail{
@@ -365,6 +402,7 @@ func ExecuteDingTalkSync(triggerType string) (*SyncResult, error) {
 			storage.DB.Model(&models.DingTalkUser{}).Where("ding_talk_uid = ?", u.DingTalkUID).Update("active", false)
 		}
 	}
+	syncer.WakeSyncQueue()
 
 	// 6. 同步本地群组名称和架构（处理钉钉部门重命名或调整层级的情况）
 	// 已在 1.5 步骤中通过 deptToGroupID 处理了名称更新和父级关系更新
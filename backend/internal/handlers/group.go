@@ -5,10 +5,12 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
 	"go-syncflow/internal/middleware"
 	"go-syncflow/internal/models"
 	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
 )
 
 // ListUserGroups 获取所有分组
@@ -24,11 +26,12 @@ func ListUserGroups(c *gin.Context) {
 		GroupID uint `gorm:"column:group_id"`
 		Count   int  `gorm:"column:cnt"`
 	}
+	// 按成员关系统计（含兼职部门）
 	var counts []GroupCount
-	storage.DB.Model(&models.User{}).
-		Select("group_id, count(*) as cnt").
-		Where("is_deleted = 0 AND group_id > 0").
-		Group("group_id").
+	storage.DB.Model(&models.UserGroupMember{}).
+		Select("user_group_members.group_id, count(*) as cnt").
+		Joins("JOIN users ON users.id = user_group_members.user_id AND users.is_deleted = 0").
+		Group("user_group_members.group_id").
 		Find(&counts)
 
 	countMap := make(map[uint]int)
@@ -171,10 +174,33 @@ func DeleteUserGroup(c *gin.Context) {
 		return
 	}
 
-	// 将该分组下的用户设为未分组
-	storage.DB.Model(&models.User{}).Where("group_id = ?", id).Update("group_id", 0)
-
-	storage.DB.Delete(&group)
+	// 移除成员关系，以该分组为主部门的用户改用其余分组（没有则未分组）
+	var memberIDs []uint
+	storage.DB.Model(&models.UserGroupMember{}).Where("group_id = ?", id).Pluck("user_id", &memberIDs)
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := storage.RemoveGroupMembers(tx, group.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&group).Error; err != nil {
+			return err
+		}
+		if len(memberIDs) == 0 {
+			return nil
+		}
+		var members []models.User
+		tx.Preload("Roles").Where("id IN ?", memberIDs).Find(&members)
+		for _, member := range members {
+			if err := syncer.EnqueueSyncEvent(tx, models.SyncEventUserUpdate, member, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "删除失败")
+		return
+	}
+	syncer.WakeSyncQueue()
 
 	middleware.RecordOperationLog(c, "用户分组", "删除分组", group.Name, "")
 	respondOK(c, nil)
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/imclient"
+	"go-syncflow/internal/ldapserver"
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 多来源身份关联 ==========
+
+// identityMatchFields 匹配规则可用的本地字段（另支持 ext.<扩展属性>）
+var identityMatchFields = map[string]string{
+	"phone":    "phone",
+	"email":    "email",
+	"username": "username",
+}
+
+// loadIdentityConfig 读取身份关联配置
+func loadIdentityConfig() models.IdentityConfig {
+	var cfg models.IdentityConfig
+	if raw, err := storage.GetConfig("identity"); err == nil {
+		json.Unmarshal([]byte(raw), &cfg)
+	}
+	if cfg.MatchRules == nil {
+		cfg.MatchRules = []models.IdentityMatchRule{}
+	}
+	if cfg.AttributePrecedence == nil {
+		cfg.AttributePrecedence = map[string][]uint{}
+	}
+	return cfg
+}
+
+// findUsersByField 按本地字段精确查找未删除用户（最多返回 2 个，用于判断是否唯一）
+func findUsersByField(field, value string) []models.User {
+	var users []models.User
+	if key := strings.TrimPrefix(field, "ext."); key != field {
+		storage.DB.Where("is_deleted = 0 AND id IN (?)",
+			storage.DB.Model(&models.UserAttributeValue{}).Select("user_id").Where("attr_key = ? AND value = ?", key, value)).
+			Limit(2).Find(&users)
+		return users
+	}
+	col, ok := identityMatchFields[field]
+	if !ok {
+		return nil
+	}
+	if col == "email" {
+		storage.DB.Where("LOWER(email) = ? AND is_deleted = 0", strings.ToLower(value)).Limit(2).Find(&users)
+	} else {
+		storage.DB.Where(col+" = ? AND is_deleted = 0", value).Limit(2).Find(&users)
+	}
+	return users
+}
+
+// matchUserByIdentityRules 按配置的顺序规则匹配本地用户
+// 只接受唯一命中，且命中用户在该连接器下尚未关联其他身份；返回命中规则的本地字段
+func matchUserByIdentityRules(connectorID uint, rules []models.IdentityMatchRule, source func(attr string) string) (models.User, string, bool) {
+	for _, r := range rules {
+		value := strings.TrimSpace(source(r.Source))
+		if value == "" {
+			continue
+		}
+		users := findUsersByField(r.Field, value)
+		if len(users) != 1 {
+			continue // 未命中或命中多个（有歧义），尝试下一条规则
+		}
+		var linked int64
+		storage.DB.Model(&models.UserIdentity{}).Where("user_id = ? AND connector_id = ?", users[0].ID, connectorID).Count(&linked)
+		if linked > 0 {
+			continue
+		}
+		return users[0], r.Field, true
+	}
+	return models.User{}, "", false
+}
+
+// linkUpstreamIdentity 记录上游身份关联（失败仅记录日志，不影响同步）
+func linkUpstreamIdentity(conn models.Connector, userID uint, externalID, displayName, linkedBy string) {
+	err := storage.LinkIdentity(storage.DB, models.UserIdentity{
+		UserID:      userID,
+		ConnectorID: conn.ID,
+		ExternalID:  externalID,
+		Source:      conn.Type,
+		DisplayName: displayName,
+		LinkedBy:    linkedBy,
+	})
+	if err != nil {
+		log.Printf("[身份关联] 用户 %d 关联 %s/%s 失败: %v", userID, conn.Name, externalID, err)
+	}
+}
+
+// ---------- 配置 ----------
+
+// GetIdentityConfig 获取身份关联配置
+func GetIdentityConfig(c *gin.Context) {
+	respondOK(c, gin.H{"config": loadIdentityConfig(), "precedenceFields": precedenceFieldOptions()})
+}
+
+// UpdateIdentityConfig 更新身份关联配置
+func UpdateIdentityConfig(c *gin.Context) {
+	var cfg models.IdentityConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	defs := make(map[string]bool)
+	for _, d := range storage.ListUserAttributeDefs() {
+		defs[d.Key] = true
+	}
+	for i, r := range cfg.MatchRules {
+		cfg.MatchRules[i].Source = strings.TrimSpace(r.Source)
+		if cfg.MatchRules[i].Source == "" {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("第 %d 条匹配规则缺少上游属性", i+1))
+			return
+		}
+		_, known := identityMatchFields[r.Field]
+		if key := strings.TrimPrefix(r.Field, "ext."); key != r.Field {
+			known = defs[key]
+		}
+		if !known {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("第 %d 条匹配规则的本地字段 %s 不支持", i+1, r.Field))
+			return
+		}
+	}
+	if err := normalizeAttributePrecedence(cfg.AttributePrecedence, defs); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, _ := json.Marshal(cfg)
+	if err := storage.SetConfig("identity", string(data)); err != nil {
+		respondError(c, http.StatusInternalServerError, "保存失败")
+		return
+	}
+	middleware.RecordOperationLog(c, "身份关联", "更新配置", "identity", string(data))
+	respondOK(c, nil)
+}
+
+// ---------- 用户身份 ----------
+
+// ListUserIdentities 获取用户的关联身份
+func ListUserIdentities(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	identities := storage.ListUserIdentities(uint(id))
+
+	connNames := make(map[uint]string)
+	var conns []models.Connector
+	storage.DB.Select("id, name").Find(&conns)
+	for _, conn := range conns {
+		connNames[conn.ID] = conn.Name
+	}
+	result := make([]gin.H, 0, len(identities))
+	for _, identity := range identities {
+		result = append(result, gin.H{
+			"identity":      identity,
+			"connectorName": connNames[identity.ConnectorID],
+		})
+	}
+	respondOK(c, result)
+}
+
+// MergeUsers 将源用户合并到当前用户：身份、角色、分组、下属转移到当前用户，空字段用源用户补齐，随后删除源用户
+func MergeUsers(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var req struct {
+		SourceUserID uint `json:"sourceUserId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	if req.SourceUserID == uint(id) {
+		respondError(c, http.StatusBadRequest, "不能与自己合并")
+		return
+	}
+
+	var target, source models.User
+	if err := storage.DB.Preload("Roles").Where("is_deleted = 0").First(&target, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if err := storage.DB.Preload("Roles").Where("is_deleted = 0").First(&source, req.SourceUserID).Error; err != nil {
+		respondError(c, http.StatusNotFound, "被合并的用户不存在")
+		return
+	}
+	if source.Username == "admin" {
+		respondError(c, http.StatusForbidden, "不能合并管理员账户")
+		return
+	}
+	if err := middleware.GetDataScope(c, "user:update").CheckUser(source.ID); err != nil {
+		respondError(c, http.StatusForbidden, "被合并的用户："+err.Error())
+		return
+	}
+
+	// 同一连接器下两人各有身份时无法合并（每个连接器只能关联一个身份）
+	targetConns := storage.UserLinkedConnectors(target.ID)
+	sourceIdentities := storage.ListUserIdentities(source.ID)
+	for _, identity := range sourceIdentities {
+		if targetConns[identity.ConnectorID] {
+			respondError(c, http.StatusBadRequest, "两个用户在同一连接器下均有关联身份，请先拆分")
+			return
+		}
+	}
+
+	source.Attributes = storage.GetUserAttributes(source.ID)
+	source.GroupIDs = storage.GetUserGroupIDs(source.ID)
+	targetAttrs := storage.GetUserAttributes(target.ID)
+
+	// 当前用户为空的字段用源用户补齐
+	fill := make(map[string]interface{})
+	for col, pair := range map[string][2]string{
+		"nickname":        {target.Nickname, source.Nickname},
+		"phone":           {target.Phone, source.Phone},
+		"email":           {target.Email, source.Email},
+		"avatar":          {target.Avatar, source.Avatar},
+		"job_title":       {target.JobTitle, source.JobTitle},
+		"department_name": {target.DepartmentName, source.DepartmentName},
+		"ding_talk_uid":   {target.DingTalkUID, source.DingTalkUID},
+	} {
+		if pair[0] == "" && pair[1] != "" {
+			fill[col] = pair[1]
+		}
+	}
+	if target.ManagerID == 0 && source.ManagerID != target.ID {
+		fill["manager_id"] = source.ManagerID
+	}
+	attrs := make(map[string]string)
+	for k, v := range source.Attributes {
+		if targetAttrs[k] == "" {
+			attrs[k] = v
+		}
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserIdentity{}).Where("user_id = ?", source.ID).
+			Updates(map[string]interface{}{"user_id": target.ID, "linked_by": "merge"}).Error; err != nil {
+			return err
+		}
+		tx.Model(&models.IMUser{}).Where("local_user_id = ?", source.ID).Update("local_user_id", target.ID)
+		tx.Model(&models.User{}).Where("manager_id = ?", source.ID).Update("manager_id", target.ID)
+
+		hasRole := make(map[uint]bool)
+		for _, r := range target.Roles {
+			hasRole[r.ID] = true
+		}
+		for _, r := range source.Roles {
+			if !hasRole[r.ID] {
+				tx.Create(&models.UserRole{UserID: target.ID, RoleID: r.ID})
+			}
+		}
+		if err := storage.SetUserGroups(tx, target.ID, target.GroupID, append(storage.GetUserGroupIDs(target.ID), source.GroupIDs...)); err != nil {
+			return err
+		}
+		if err := storage.SaveUserAttributes(tx, target.ID, attrs); err != nil {
+			return err
+		}
+		if len(fill) > 0 {
+			if err := tx.Model(&models.User{}).Where("id = ?", target.ID).Updates(fill).Error; err != nil {
+				return err
+			}
+		}
+
+		// 删除源用户（下游同步删除重复账号）
+		if err := syncer.EnqueueSyncEvent(tx, models.SyncEventUserDelete, source, ""); err != nil {
+			return err
+		}
+		tx.Where("user_id = ?", source.ID).Delete(&models.UserRole{})
+		if err := storage.DeleteUserAttributes(tx, source.ID); err != nil {
+			return err
+		}
+		if err := storage.DeleteUserGroups(tx, source.ID); err != nil {
+			return err
+		}
+		if err := storage.DeleteUserFieldSources(tx, source.ID); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&models.User{}, source.ID).Error; err != nil {
+			return err
+		}
+		return syncer.EnqueueSyncEvent(tx, models.SyncEventUserUpdate, target, "")
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "合并失败: "+err.Error())
+		return
+	}
+	syncer.WakeSyncQueue()
+
+	middleware.RecordOperationLog(c, "用户管理", "合并用户", target.Username,
+		fmt.Sprintf("合并 %s，转移身份 %d 个", source.Username, len(sourceIdentities)))
+	respondOK(c, gin.H{"identities": storage.ListUserIdentities(target.ID)})
+}
+
+// SplitIdentity 将关联身份从当前用户拆分出去，为其新建本地用户
+func SplitIdentity(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	identityID, _ := strconv.ParseUint(c.Param("identityId"), 10, 32)
+
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	var identity models.UserIdentity
+	if err := storage.DB.Where("id = ? AND user_id = ?", identityID, user.ID).First(&identity).Error; err != nil {
+		respondError(c, http.StatusNotFound, "关联身份不存在")
+		return
+	}
+	var conn models.Connector
+	storage.DB.First(&conn, identity.ConnectorID)
+
+	// 以 IM 缓存中的资料创建新用户；没有缓存时仅使用身份中的姓名
+	info := imclient.IMUserInfo{UserID: identity.ExternalID, Name: identity.DisplayName}
+	var cached models.IMUser
+	if storage.DB.Where("connector_id = ? AND remote_user_id = ?", identity.ConnectorID, identity.ExternalID).First(&cached).Error == nil {
+		info = imclient.IMUserInfo{
+			UserID:   cached.RemoteUserID,
+			Name:     cached.Name,
+			Mobile:   cached.Mobile,
+			Email:    cached.Email,
+			Avatar:   cached.Avatar,
+			JobTitle: cached.JobTitle,
+		}
+	}
+	// 新用户不沿用原用户已占用的手机号/邮箱，避免下次同步又按规则匹配回原用户
+	if info.Mobile == user.Phone {
+		info.Mobile = ""
+	}
+	if strings.EqualFold(info.Email, user.Email) {
+		info.Email = ""
+	}
+
+	rawPassword := generateRandomPassword()
+	hashed, err := hashPasswordForUpstream(rawPassword)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "拆分失败")
+		return
+	}
+	newUser := models.User{
+		Username:        imclient.GenerateUsername(conn.IMUsernameRule, &info),
+		Password:        hashed,
+		SambaNTPassword: ldapserver.ComputeNTHash(rawPassword),
+		Nickname:        info.Name,
+		Phone:           info.Mobile,
+		Email:           info.Email,
+		Avatar:          info.Avatar,
+		JobTitle:        info.JobTitle,
+		Status:          1,
+		Source:          identity.Source,
+	}
+	if conn.IsIM() {
+		newUser.DingTalkUID = identity.ExternalID
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newUser).Error; err != nil {
+			return err
+		}
+		var defaultRole models.Role
+		if tx.Where("code = ?", "user").First(&defaultRole).Error == nil {
+			tx.Create(&models.UserRole{UserID: newUser.ID, RoleID: defaultRole.ID})
+		}
+		if err := tx.Model(&identity).Updates(map[string]interface{}{"user_id": newUser.ID, "linked_by": "split"}).Error; err != nil {
+			return err
+		}
+		tx.Model(&models.IMUser{}).Where("connector_id = ? AND remote_user_id = ?", identity.ConnectorID, identity.ExternalID).
+			Update("local_user_id", newUser.ID)
+		if user.DingTalkUID == identity.ExternalID {
+			if err := tx.Model(&user).Update("ding_talk_uid", "").Error; err != nil {
+				return err
+			}
+		}
+		return syncer.EnqueueSyncEvent(tx, models.SyncEventUserCreate, newUser, rawPassword)
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "拆分失败: "+err.Error())
+		return
+	}
+	syncer.WakeSyncQueue()
+
+	middleware.RecordOperationLog(c, "用户管理", "拆分身份", user.Username,
+		fmt.Sprintf("%s/%s 拆分为新用户 %s", conn.Name, identity.ExternalID, newUser.Username))
+	respondOK(c, newUser)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 访问令牌签名密钥 ==========
+
+// GetJWKS 公开验签公钥（/.well-known/jwks.json），供其他服务离线校验访问令牌
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, middleware.JWKS())
+}
+
+// ListJWTSigningKeys 签名密钥列表（不含私钥）
+func ListJWTSigningKeys(c *gin.Context) {
+	var keys []models.JWTSigningKey
+	storage.DB.Order("id DESC").Limit(50).Find(&keys)
+	respondOK(c, gin.H{
+		"list":   keys,
+		"config": middleware.GetJWTSigningConfig(),
+	})
+}
+
+// RotateJWTSigningKey 立即轮换签名密钥，旧密钥在重叠期内继续验签
+func RotateJWTSigningKey(c *gin.Context) {
+	if middleware.GetJWTSigningConfig().Algorithm == "HS256" {
+		respondError(c, http.StatusBadRequest, "当前使用 HS256 签名，请先切换为 RS256 或 ES256")
+		return
+	}
+	if _, err := middleware.RotateJWTKeys(true); err != nil {
+		respondError(c, http.StatusInternalServerError, "轮换失败: "+err.Error())
+		return
+	}
+	middleware.RecordOperationLog(c, "安全中心", "轮换令牌签名密钥", "", "")
+	respondOK(c, nil)
+}
+
+// RevokeJWTSigningKey 吊销签名密钥（密钥泄露时使用），由其签发的令牌立即失效
+func RevokeJWTSigningKey(c *gin.Context) {
+	kid := c.Param("kid")
+	if err := middleware.RevokeJWTKey(kid); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	middleware.RecordOperationLog(c, "安全中心", "吊销令牌签名密钥", kid, "")
+	respondOK(c, nil)
+}
+
+// StartJWTKeyRotationScheduler 启动时加载签名密钥，之后每小时检查是否需要轮换
+func StartJWTKeyRotationScheduler() {
+	if _, err := middleware.RotateJWTKeys(false); err != nil {
+		log.Printf("[令牌签名] 初始化签名密钥失败，暂时使用 HS256: %v", err)
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[令牌签名] 轮换调度器 panic: %v", r)
+			}
+		}()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := middleware.RotateJWTKeys(false); err != nil {
+				log.Printf("[令牌签名] 密钥轮换失败: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,580 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/ldapserver"
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 用户生命周期（入职 / 调岗 / 离职） ==========
+
+// 生命周期流程
+const (
+	lifecycleFlowJoin  = "join"
+	lifecycleFlowMove  = "move"
+	lifecycleFlowLeave = "leave"
+	// 账号有效期：生效时启用、失效时禁用
+	lifecycleFlowValidity = "validity"
+	// 自助权限申请：限定时长的授权到期收回
+	lifecycleFlowAccess = "access"
+)
+
+// 生命周期阶段
+const (
+	lifecycleStagePreCreate    = "pre_create"    // 入职：预创建下游账号（禁用）
+	lifecycleStageActivate     = "activate"      // 入职：启用账号
+	lifecycleStageMove         = "move"          // 调岗：调整分组（AD 随之移动 OU）与角色
+	lifecycleStageDisable      = "disable"       // 离职：禁用账号，AD 移入离职用户 OU
+	lifecycleStageStripGroups  = "strip_groups"  // 离职：移除角色与分组
+	lifecycleStageDelete       = "delete"        // 离职：删除用户
+	lifecycleStageValidFrom    = "valid_from"    // 有效期：到达生效时间，启用账号
+	lifecycleStageValidUntil   = "valid_until"   // 有效期：到达失效时间，禁用账号
+	lifecycleStageRevokeAccess = "revoke_access" // 权限申请：授权到期，收回角色 / 分组
+)
+
+// lifecycleMovePayload 调岗阶段参数
+type lifecycleMovePayload struct {
+	GroupID   uint   `json:"groupId"`             // 新主部门
+	GroupIDs  []uint `json:"groupIds"`            // 全部所属分组，为空时只保留主部门
+	RoleIDs   []uint `json:"roleIds,omitempty"`   // 新角色，为空表示不调整
+	ManagerID *uint  `json:"managerId,omitempty"` // 新直属上级，不传表示不调整
+}
+
+// parseLifecycleDate 解析生效日期（2006-01-02 或 RFC3339），为空表示立即
+func parseLifecycleDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Now(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("日期格式错误: %s", s)
+	}
+	return t, nil
+}
+
+// scheduleLifecycleTask 写入一个待执行的生命周期阶段
+func scheduleLifecycleTask(tx *gorm.DB, user models.User, flow, stage string, at time.Time, payload interface{}, operator string) (models.LifecycleTask, error) {
+	task := models.LifecycleTask{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Flow:        flow,
+		Stage:       stage,
+		Status:      models.LifecycleTaskPending,
+		ScheduledAt: at,
+		CreatedBy:   operator,
+	}
+	if payload != nil {
+		data, _ := json.Marshal(payload)
+		task.Payload = string(data)
+	}
+	err := tx.Create(&task).Error
+	return task, err
+}
+
+// cancelLifecycleTasks 取消用户指定流程（为空表示全部）中未执行的阶段
+func cancelLifecycleTasks(tx *gorm.DB, userID uint, flow, operator, reason string) error {
+	q := tx.Model(&models.LifecycleTask{}).Where("user_id = ? AND status = ?", userID, models.LifecycleTaskPending)
+	if flow != "" {
+		q = q.Where("flow = ?", flow)
+	}
+	return q.Updates(map[string]interface{}{
+		"status":       models.LifecycleTaskCancelled,
+		"cancelled_by": operator,
+		"message":      reason,
+	}).Error
+}
+
+// logLifecycle 生命周期阶段审计，写入同步日志
+func logLifecycle(task models.LifecycleTask, triggerType, status, message string) {
+	storage.DB.Create(&models.SyncLog{
+		Direction:     "lifecycle",
+		TriggerType:   triggerType,
+		TriggerEvent:  task.Flow + "." + task.Stage,
+		UserID:        task.UserID,
+		Username:      task.Username,
+		Status:        status,
+		Message:       message,
+		Detail:        task.Payload,
+		AffectedCount: 1,
+	})
+}
+
+// startJoin 入职流程：用户先以禁用状态创建，入职日前 N 天预创建下游账号，入职日启用
+// 需在创建用户的事务内调用；预创建时间未到时用户处于待入职状态，不投递任何下游事件
+func startJoin(tx *gorm.DB, user *models.User, startDate time.Time, operator string) error {
+	cfg := syncer.GetLifecycleConfig()
+	preCreateAt := startDate.AddDate(0, 0, -cfg.PreCreateDays)
+
+	user.Status = 0
+	user.LifecycleState = models.LifecyclePreCreated
+	if preCreateAt.After(time.Now()) {
+		user.LifecycleState = models.LifecyclePendingJoin
+		if _, err := scheduleLifecycleTask(tx, *user, lifecycleFlowJoin, lifecycleStagePreCreate, preCreateAt, nil, operator); err != nil {
+			return err
+		}
+	}
+	// status 字段带默认值，创建时写入 0 会被忽略，这里显式更新
+	if err := tx.Model(user).Updates(map[string]interface{}{"status": 0, "lifecycle_state": user.LifecycleState}).Error; err != nil {
+		return err
+	}
+	_, err := scheduleLifecycleTask(tx, *user, lifecycleFlowJoin, lifecycleStageActivate, startDate, nil, operator)
+	return err
+}
+
+// startLeave 离职流程：生效时禁用（AD 移入离职用户 OU），按配置在 N 天后移除分组、M 天后删除
+// 生效时间已到时立即执行禁用阶段
+func startLeave(user models.User, effective time.Time, operator, reason string) error {
+	cfg := syncer.GetLifecycleConfig()
+	var disableTask models.LifecycleTask
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		// 离职覆盖尚未执行的入职 / 调岗 / 离职阶段
+		if err := cancelLifecycleTasks(tx, user.ID, "", operator, "已重新发起离职"); err != nil {
+			return err
+		}
+		var err error
+		if disableTask, err = scheduleLifecycleTask(tx, user, lifecycleFlowLeave, lifecycleStageDisable, effective, nil, operator); err != nil {
+			return err
+		}
+		if cfg.StripGroupsAfterDays > 0 {
+			if _, err := scheduleLifecycleTask(tx, user, lifecycleFlowLeave, lifecycleStageStripGroups,
+				effective.AddDate(0, 0, cfg.StripGroupsAfterDays), nil, operator); err != nil {
+				return err
+			}
+		}
+		if cfg.DeleteAfterDays > 0 {
+			if _, err := scheduleLifecycleTask(tx, user, lifecycleFlowLeave, lifecycleStageDelete,
+				effective.AddDate(0, 0, cfg.DeleteAfterDays), nil, operator); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logLifecycle(disableTask, "manual", "pending", "发起离职: "+reason)
+	if !effective.After(time.Now()) {
+		executeLifecycleTask(disableTask, "manual")
+	}
+	return nil
+}
+
+// runLifecycleStage 执行单个阶段，返回结果说明
+func runLifecycleStage(task models.LifecycleTask) (string, error) {
+	var user models.User
+	if err := storage.DB.Preload("Roles").Where("is_deleted = 0").First(&user, task.UserID).Error; err != nil {
+		return "", fmt.Errorf("用户不存在")
+	}
+
+	switch task.Stage {
+	case lifecycleStagePreCreate:
+		if user.LifecycleState != models.LifecyclePendingJoin {
+			return "用户不处于待入职状态，跳过", nil
+		}
+		// 待入职期间未保存明文密码，预创建时生成随机初始密码并按账号开通策略通知
+		rawPassword := generateRandomPassword()
+		hashed, err := hashPasswordForUpstream(rawPassword)
+		if err != nil {
+			return "", err
+		}
+		user.Password = hashed
+		user.SambaNTPassword = ldapserver.ComputeNTHash(rawPassword)
+		user.LifecycleState = models.LifecyclePreCreated
+		err = storage.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"password":          user.Password,
+				"samba_nt_password": user.SambaNTPassword,
+				"lifecycle_state":   user.LifecycleState,
+			}).Error; err != nil {
+				return err
+			}
+			return syncer.EnqueueSyncEvent(tx, models.SyncEventUserCreate, user, rawPassword)
+		})
+		if err != nil {
+			return "", err
+		}
+		syncer.WakeSyncQueue()
+		go sendAccountCreatedNotification(user, rawPassword)
+		return "已预创建下游账号（禁用）", nil
+
+	case lifecycleStageActivate:
+		if user.LifecycleState == models.LifecyclePendingJoin {
+			// 预创建未执行（如已取消）时在入职日一并创建
+			if _, err := runLifecycleStage(models.LifecycleTask{UserID: user.ID, Stage: lifecycleStagePreCreate}); err != nil {
+				return "", err
+			}
+		}
+		user.Status = 1
+		user.LifecycleState = ""
+		if err := updateUserWithSyncEvent(user, map[string]interface{}{"status": 1, "lifecycle_state": ""}, models.SyncEventUserEnable, ""); err != nil {
+			return "", err
+		}
+		return "账号已启用", nil
+
+	case lifecycleStageMove:
+		var payload lifecycleMovePayload
+		if err := json.Unmarshal([]byte(task.Payload), &payload); err != nil {
+			return "", fmt.Errorf("调岗参数错误: %v", err)
+		}
+		if payload.ManagerID != nil {
+			if err := validateManager(user.ID, *payload.ManagerID); err != nil {
+				return "", err
+			}
+		}
+		err := storage.DB.Transaction(func(tx *gorm.DB) error {
+			if err := storage.SetUserGroups(tx, user.ID, payload.GroupID, payload.GroupIDs); err != nil {
+				return err
+			}
+			if payload.ManagerID != nil {
+				if err := tx.Model(&user).Update("manager_id", *payload.ManagerID).Error; err != nil {
+					return err
+				}
+			}
+			if len(payload.RoleIDs) > 0 {
+				if err := replaceUserRoles(tx, user.ID, payload.RoleIDs); err != nil {
+					return err
+				}
+			}
+			// 分组变化后下游按新主部门移动 OU、调整部门安全组
+			return syncer.EnqueueSyncEvent(tx, models.SyncEventUserUpdate, user, "")
+		})
+		if err != nil {
+			return "", err
+		}
+		syncer.WakeSyncQueue()
+		return fmt.Sprintf("已调整到分组 %d", payload.GroupID), nil
+
+	case lifecycleStageDisable:
+		if user.Username == "admin" {
+			return "", fmt.Errorf("不能禁用管理员账户")
+		}
+		user.Status = 0
+		user.LifecycleState = models.LifecycleLeft
+		if err := updateUserWithSyncEvent(user, map[string]interface{}{"status": 0, "lifecycle_state": models.LifecycleLeft}, models.SyncEventUserDisable, ""); err != nil {
+			return "", err
+		}
+		return "账号已禁用", nil
+
+	case lifecycleStageStripGroups:
+		if user.LifecycleState != models.LifecycleLeft {
+			return "用户不处于离职状态，跳过", nil
+		}
+		err := storage.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserRole{}).Error; err != nil {
+				return err
+			}
+			if err := storage.SetUserGroups(tx, user.ID, 0, nil); err != nil {
+				return err
+			}
+			user.Roles = nil
+			user.GroupID = 0
+			// 下游同步时移出用户的角色安全组与部门安全组
+			return syncer.EnqueueSyncEvent(tx, models.SyncEventUserUpdate, user, "")
+		})
+		if err != nil {
+			return "", err
+		}
+		syncer.WakeSyncQueue()
+		return "已移除全部角色与分组", nil
+
+	case lifecycleStageDelete:
+		if user.LifecycleState != models.LifecycleLeft {
+			return "用户不处于离职状态，跳过", nil
+		}
+		if user.Username == "admin" {
+			return "", fmt.Errorf("不能删除管理员账户")
+		}
+		if err := deleteUserCascade(user); err != nil {
+			return "", err
+		}
+		return "用户已删除", nil
+
+	case lifecycleStageValidFrom, lifecycleStageValidUntil:
+		return runValidityStage(user, task.Stage)
+
+	case lifecycleStageRevokeAccess:
+		return runAccessRevokeStage(task)
+	}
+	return "", fmt.Errorf("未知阶段: %s", task.Stage)
+}
+
+// executeLifecycleTask 执行阶段并记录结果
+func executeLifecycleTask(task models.LifecycleTask, triggerType string) {
+	// 先抢占任务，避免调度器与手动执行重复处理
+	res := storage.DB.Model(&models.LifecycleTask{}).Where("id = ? AND status = ?", task.ID, models.LifecycleTaskPending).
+		Update("status", models.LifecycleTaskRunning)
+	if res.RowsAffected == 0 {
+		return
+	}
+
+	message, err := runLifecycleStage(task)
+	status := models.LifecycleTaskDone
+	if err != nil {
+		status = models.LifecycleTaskFailed
+		message = err.Error()
+	}
+	now := time.Now()
+	storage.DB.Model(&models.LifecycleTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+		"status":      status,
+		"message":     message,
+		"executed_at": &now,
+	})
+
+	logStatus := "success"
+	if err != nil {
+		logStatus = "failed"
+	}
+	logLifecycle(task, triggerType, logStatus, message)
+	log.Printf("[生命周期] %s %s.%s: %s", task.Username, task.Flow, task.Stage, message)
+}
+
+// processDueLifecycleTasks 执行所有到期的阶段
+func processDueLifecycleTasks() {
+	var tasks []models.LifecycleTask
+	storage.DB.Where("status = ? AND scheduled_at <= ?", models.LifecycleTaskPending, time.Now()).
+		Order("scheduled_at, id").Limit(200).Find(&tasks)
+	for _, task := range tasks {
+		executeLifecycleTask(task, "schedule")
+	}
+}
+
+// StartLifecycleScheduler 启动生命周期调度器，每分钟检查到期阶段
+func StartLifecycleScheduler() {
+	// 上次运行中断遗留的任务重新执行
+	storage.DB.Model(&models.LifecycleTask{}).Where("status = ?", models.LifecycleTaskRunning).Update("status", models.LifecycleTaskPending)
+
+	go func() {
+		runLifecycleJobs()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			runLifecycleJobs()
+		}
+	}()
+	log.Println("[生命周期] 调度器已启动")
+}
+
+// runLifecycleJobs 执行一轮定时任务；每个任务单独 recover，panic 不影响其他任务和后续轮次
+func runLifecycleJobs() {
+	jobs := []struct {
+		name string
+		run  func()
+	}{
+		{"生命周期任务", processDueLifecycleTasks},
+		{"账号到期提醒", notifyExpiringAccounts},
+		{"权限复核", processAccessReviews},
+	}
+	for _, job := range jobs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[生命周期] %s panic: %v", job.name, r)
+				}
+			}()
+			job.run()
+		}()
+	}
+}
+
+// ---------- 接口 ----------
+
+// GetLifecycleConfig 获取生命周期配置
+func GetLifecycleConfig(c *gin.Context) {
+	respondOK(c, syncer.GetLifecycleConfig())
+}
+
+// UpdateLifecycleConfig 更新生命周期配置
+func UpdateLifecycleConfig(c *gin.Context) {
+	var cfg models.LifecycleConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	if cfg.StripGroupsAfterDays < 0 || cfg.DeleteAfterDays < 0 || cfg.PreCreateDays < 0 || cfg.ExpiryNotifyDays < 0 {
+		respondError(c, http.StatusBadRequest, "天数不能为负数")
+		return
+	}
+	if cfg.DeleteAfterDays > 0 && cfg.StripGroupsAfterDays > cfg.DeleteAfterDays {
+		respondError(c, http.StatusBadRequest, "移除分组的天数不能晚于删除天数")
+		return
+	}
+
+	data, _ := json.Marshal(cfg)
+	if err := storage.SetConfig("lifecycle", string(data)); err != nil {
+		respondError(c, http.StatusInternalServerError, "保存失败")
+		return
+	}
+	middleware.RecordOperationLog(c, "用户生命周期", "更新配置", "lifecycle", string(data))
+	respondOK(c, nil)
+}
+
+// ListLifecycleTasks 生命周期阶段列表
+func ListLifecycleTasks(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+
+	scoped := middleware.GetDataScope(c, "user:list").FilterUsers(storage.DB.Model(&models.User{}).Select("id"))
+	query := storage.DB.Model(&models.LifecycleTask{}).Where("user_id IN (?)", scoped)
+	if userID := c.Query("userId"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if flow := c.Query("flow"); flow != "" {
+		query = query.Where("flow = ?", flow)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		query = query.Where("username LIKE ?", "%"+keyword+"%")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var tasks []models.LifecycleTask
+	query.Order("scheduled_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&tasks)
+	respondList(c, tasks, total)
+}
+
+// CancelLifecycleTask 取消未执行的阶段
+func CancelLifecycleTask(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var task models.LifecycleTask
+	if err := storage.DB.First(&task, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "任务不存在")
+		return
+	}
+	if err := middleware.GetDataScope(c, "user:update").CheckUser(task.UserID); err != nil {
+		respondError(c, http.StatusForbidden, err.Error())
+		return
+	}
+
+	operator := middleware.GetUsername(c)
+	res := storage.DB.Model(&models.LifecycleTask{}).Where("id = ? AND status = ?", task.ID, models.LifecycleTaskPending).
+		Updates(map[string]interface{}{
+			"status":       models.LifecycleTaskCancelled,
+			"cancelled_by": operator,
+			"message":      "管理员取消",
+		})
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusBadRequest, "只能取消未执行的阶段")
+		return
+	}
+
+	logLifecycle(task, "manual", "cancelled", "由 "+operator+" 取消")
+	middleware.RecordOperationLog(c, "用户生命周期", "取消阶段", task.Username, task.Flow+"."+task.Stage)
+	respondOK(c, nil)
+}
+
+// StartUserLeave 发起离职
+func StartUserLeave(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var req struct {
+		EffectiveDate string `json:"effectiveDate"` // 为空表示立即
+		Reason        string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	effective, err := parseLifecycleDate(req.EffectiveDate)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if user.Username == "admin" || user.ID == middleware.GetUserID(c) {
+		respondError(c, http.StatusForbidden, "不能对管理员或自己发起离职")
+		return
+	}
+
+	if err := startLeave(user, effective, middleware.GetUsername(c), req.Reason); err != nil {
+		respondError(c, http.StatusInternalServerError, "发起离职失败: "+err.Error())
+		return
+	}
+	middleware.RecordOperationLog(c, "用户生命周期", "发起离职", user.Username, effective.Format("2006-01-02"))
+	respondOK(c, nil)
+}
+
+// ScheduleUserMove 安排调岗：生效日调整分组（AD 移动 OU）与角色
+func ScheduleUserMove(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var req struct {
+		EffectiveDate string `json:"effectiveDate"` // 为空表示立即
+		lifecycleMovePayload
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	if req.GroupID == 0 && len(req.GroupIDs) == 0 {
+		respondError(c, http.StatusBadRequest, "请选择调入的分组")
+		return
+	}
+	effective, err := parseLifecycleDate(req.EffectiveDate)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var user models.User
+	if err := storage.DB.Where("is_deleted = 0").First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if user.LifecycleState == models.LifecycleLeft {
+		respondError(c, http.StatusBadRequest, "离职用户不能调岗")
+		return
+	}
+	if req.ManagerID != nil {
+		if err := validateManager(user.ID, *req.ManagerID); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if len(req.RoleIDs) > 0 && !middleware.CheckPermission(c, "user:assign_role") {
+		respondError(c, http.StatusForbidden, "没有分配角色的权限")
+		return
+	}
+	// 调入的分组与调整的角色须在操作人的管理范围内
+	moveReq := updateUserRequest{GroupID: &req.GroupID, GroupIDs: append([]uint{}, req.GroupIDs...), RoleIDs: req.RoleIDs}
+	if err := currentUserScopes(c, "user:update").checkUpdate(user, moveReq, len(req.RoleIDs) > 0); err != nil {
+		respondError(c, http.StatusForbidden, err.Error())
+		return
+	}
+
+	task, err := scheduleLifecycleTask(storage.DB, user, lifecycleFlowMove, lifecycleStageMove, effective, req.lifecycleMovePayload, middleware.GetUsername(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "安排调岗失败")
+		return
+	}
+	logLifecycle(task, "manual", "pending", "安排调岗")
+	if !effective.After(time.Now()) {
+		executeLifecycleTask(task, "manual")
+	}
+	middleware.RecordOperationLog(c, "用户生命周期", "安排调岗", user.Username, task.Payload)
+	respondOK(c, task)
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 风险登录二次验证 ==========
+
+const (
+	loginChallengeTTL         = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+)
+
+// loginChallenge 待完成的风险登录（密码已校验通过，等待验证码）
+type loginChallenge struct {
+	UserID      uint
+	Username    string
+	IP          string
+	UserAgent   string
+	Location    string
+	Fingerprint string
+	Code        string
+	Risk        services.LoginRiskResult
+	Attempts    int
+	ExpiresAt   time.Time
+}
+
+var (
+	loginChallengeStore     = make(map[string]*loginChallenge)
+	loginChallengeStoreLock = &sync.Mutex{}
+)
+
+// deviceFingerprint 设备指纹：前端持久化的 X-Device-Id 与 User-Agent 的哈希
+// 未携带设备 ID（如脚本调用）时退化为 User-Agent + Accept-Language
+func deviceFingerprint(c *gin.Context) string {
+	deviceID := strings.TrimSpace(c.GetHeader("X-Device-Id"))
+	if len(deviceID) > 128 {
+		deviceID = deviceID[:128]
+	}
+	if deviceID == "" {
+		deviceID = "lang:" + c.GetHeader("Accept-Language")
+	}
+	sum := sha256.Sum256([]byte(deviceID + "|" + c.GetHeader("User-Agent")))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// rejectRiskyLogin 风险评分超过阻止阈值，拒绝登录并记录安全事件
+func rejectRiskyLogin(c *gin.Context, user models.User, location, fingerprint string, risk services.LoginRiskResult) {
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	ss := services.GetSecurityService()
+
+	ss.RecordLoginAttemptDetail(user.Username, &user.ID, clientIP, userAgent, location, fingerprint, false, "登录风险过高")
+	ss.RecordClientSecurityEvent(models.EventLoginBlocked, models.SeverityHigh, clientIP, userAgent, location,
+		&user.ID, user.Username, "login", "", "登录被阻止: 风险评分 "+fmt.Sprint(risk.Score)+"（"+strings.Join(risk.FactorNames(), "、")+"）",
+		risk.Details())
+	middleware.RecordLoginLog(user.ID, user.Username, clientIP, userAgent, false, "登录风险过高")
+	respondError(c, http.StatusForbidden, "检测到异常登录，已阻止本次登录，请联系管理员")
+}
+
+// startLoginChallenge 风险登录：通过消息策略配置的渠道发送验证码，返回挑战令牌
+// 没有可用渠道时按 challenge_fallback 放行或阻止，返回 false 表示调用方应继续处理
+func startLoginChallenge(c *gin.Context, user models.User, location, fingerprint string, risk services.LoginRiskResult) bool {
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	ss := services.GetSecurityService()
+
+	channelTypes := ResolveAllowedChannelTypes("login_verify", user.GroupID)
+	if len(channelTypes) == 0 {
+		channelTypes = ResolveAllowedChannelTypes("verify_code", user.GroupID)
+	}
+
+	code := generateVerifyCode()
+	var sent []string
+	if len(channelTypes) > 0 {
+		content := buildLoginVerifyContent(user, code, clientIP, location)
+		for _, r := range services.SendNotificationByChannels(user, "登录验证码", content, channelTypes) {
+			if r.Success {
+				sent = append(sent, r.Channel)
+			} else {
+				log.Printf("[风险登录] %s 验证码发送失败 -> %s: %s", r.Channel, user.Username, r.Message)
+			}
+		}
+	}
+
+	if len(sent) == 0 {
+		if ss.GetLoginRiskConfig().ChallengeFallback == services.LoginRiskBlock {
+			rejectRiskyLogin(c, user, location, fingerprint, risk)
+			return true
+		}
+		log.Printf("[风险登录] 用户 %s 无可用验证渠道，按配置放行（风险评分 %d）", user.Username, risk.Score)
+		return false
+	}
+
+	token := newImportToken()
+	loginChallengeStoreLock.Lock()
+	now := time.Now()
+	for k, v := range loginChallengeStore {
+		if now.After(v.ExpiresAt) {
+			delete(loginChallengeStore, k)
+		}
+	}
+	loginChallengeStore[token] = &loginChallenge{
+		UserID:      user.ID,
+		Username:    user.Username,
+		IP:          clientIP,
+		UserAgent:   userAgent,
+		Location:    location,
+		Fingerprint: fingerprint,
+		Code:        code,
+		Risk:        risk,
+		ExpiresAt:   now.Add(loginChallengeTTL),
+	}
+	loginChallengeStoreLock.Unlock()
+
+	ss.RecordClientSecurityEvent(models.EventLoginChallenged, models.SeverityMedium, clientIP, userAgent, location,
+		&user.ID, user.Username, "login", "", "风险登录需二次验证（"+strings.Join(risk.FactorNames(), "、")+"）", risk.Details())
+
+	respondOK(c, gin.H{
+		"challenge":      true,
+		"challengeToken": token,
+		"channels":       sent,
+		"expiresIn":      int(loginChallengeTTL.Seconds()),
+		"factors":        risk.FactorNames(),
+	})
+	return true
+}
+
+// buildLoginVerifyContent 按 login_verify 模板生成验证码消息
+func buildLoginVerifyContent(user models.User, code, ip, location string) string {
+	if location == "" {
+		location = "未知地点"
+	}
+	content := "【{{app_name}}】检测到账号 {{username}} 的异常登录，验证码：{{code}}，5分钟内有效。如非本人操作，请立即修改密码。"
+	var tpl models.MessageTemplate
+	if storage.DB.Where("scene = ? AND is_active = ?", "login_verify", true).First(&tpl).Error == nil {
+		content = tpl.Content
+	}
+	content = strings.ReplaceAll(content, "{{code}}", code)
+	content = strings.ReplaceAll(content, "{{username}}", user.Username)
+	content = strings.ReplaceAll(content, "{{name}}", user.Nickname)
+	content = strings.ReplaceAll(content, "{{ip}}", ip)
+	content = strings.ReplaceAll(content, "{{location}}", location)
+	content = strings.ReplaceAll(content, "{{time}}", time.Now().Format("2006-01-02 15:04:05"))
+	content = strings.ReplaceAll(content, "{{app_name}}", "统一身份认证平台")
+	return content
+}
+
+// VerifyLoginChallenge 风险登录 - 提交验证码完成登录
+func VerifyLoginChallenge(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	ss := services.GetSecurityService()
+
+	loginChallengeStoreLock.Lock()
+	ch, ok := loginChallengeStore[req.ChallengeToken]
+	if ok && (time.Now().After(ch.ExpiresAt) || ch.IP != clientIP) {
+		delete(loginChallengeStore, req.ChallengeToken)
+		ok = false
+	}
+	if !ok {
+		loginChallengeStoreLock.Unlock()
+		respondError(c, http.StatusUnauthorized, "验证已过期，请重新登录")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(ch.Code), []byte(strings.TrimSpace(req.Code))) != 1 {
+		ch.Attempts++
+		exhausted := ch.Attempts >= loginChallengeMaxAttempts
+		if exhausted {
+			delete(loginChallengeStore, req.ChallengeToken)
+		}
+		snapshot := *ch
+		loginChallengeStoreLock.Unlock()
+
+		ss.RecordLoginAttemptDetail(snapshot.Username, &snapshot.UserID, clientIP, userAgent, snapshot.Location, snapshot.Fingerprint, false, "登录验证码错误")
+		middleware.RecordLoginLog(snapshot.UserID, snapshot.Username, clientIP, userAgent, false, "登录验证码错误")
+		if exhausted {
+			ss.HandleFailedLogin(snapshot.Username, clientIP)
+			respondError(c, http.StatusUnauthorized, "验证码错误次数过多，请重新登录")
+			return
+		}
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("验证码错误，还可尝试 %d 次", loginChallengeMaxAttempts-snapshot.Attempts))
+		return
+	}
+	delete(loginChallengeStore, req.ChallengeToken)
+	loginChallengeStoreLock.Unlock()
+
+	// 验证期间账号可能已被禁用或锁定，重新检查
+	var user models.User
+	if err := storage.DB.Where("id = ? AND is_deleted = 0", ch.UserID).First(&user).Error; err != nil || user.Status == 0 {
+		respondError(c, http.StatusForbidden, "用户不存在或已被禁用")
+		return
+	}
+	if locked, expiresAt, _ := ss.CheckAccountLockout(user.Username); locked {
+		respondError(c, http.StatusForbidden, fmt.Sprintf("账户已被锁定，请在 %s 后重试", expiresAt.Format("15:04:05")))
+		return
+	}
+
+	completeLogin(c, user, ch.Location, ch.Fingerprint, ch.Risk, "风险登录验证通过")
+}
+
+// completeLogin 创建会话、签发令牌并记录成功登录
+func completeLogin(c *gin.Context, user models.User, location, fingerprint string, risk services.LoginRiskResult, remark string) {
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	ss := services.GetSecurityService()
+
+	tokens, err := issueSessionTokens(c, user, location)
+	if err != nil {
+		log.Printf("[登录] 创建会话失败, 用户 %s: %v", user.Username, err)
+		respondError(c, http.StatusInternalServerError, "生成Token失败")
+		return
+	}
+
+	var details map[string]interface{}
+	if len(risk.Factors) > 0 {
+		details = risk.Details()
+	}
+	ss.RecordLoginAttemptDetail(user.Username, &user.ID, clientIP, userAgent, location, fingerprint, true, "")
+	ss.HandleSuccessfulLogin(user.ID, clientIP)
+	ss.RecordClientSecurityEvent(models.EventLoginSuccess, models.SeverityLow, clientIP, userAgent, location,
+		&user.ID, user.Username, "login", "", "用户登录成功", details)
+	middleware.RecordLoginLog(user.ID, user.Username, clientIP, userAgent, true, remark)
+
+	tokens["user"] = gin.H{
+		"id":                  user.ID,
+		"username":            user.Username,
+		"nickname":            user.Nickname,
+		"avatar":              user.Avatar,
+		"forcePasswordChange": user.ForcePasswordChange,
+	}
+	respondOK(c, tokens)
+}
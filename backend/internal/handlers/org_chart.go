@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 汇报关系 / 组织架构 ==========
+
+// maxReportingDepth 汇报链最大层级，防止脏数据形成环时无限遍历
+const maxReportingDepth = 32
+
+// orgNode 组织架构节点
+type orgNode struct {
+	ID             uint       `json:"id"`
+	Username       string     `json:"username"`
+	Nickname       string     `json:"nickname"`
+	Avatar         string     `json:"avatar"`
+	JobTitle       string     `json:"jobTitle"`
+	DepartmentName string     `json:"departmentName"`
+	Status         int8       `json:"status"`
+	ManagerID      uint       `json:"managerId"`
+	ReportCount    int        `json:"reportCount"`
+	Children       []*orgNode `json:"children,omitempty"`
+}
+
+const orgNodeColumns = "id, username, nickname, avatar, job_title, department_name, status, manager_id"
+
+func newOrgNode(u models.User) *orgNode {
+	return &orgNode{
+		ID:             u.ID,
+		Username:       u.Username,
+		Nickname:       u.Nickname,
+		Avatar:         u.Avatar,
+		JobTitle:       u.JobTitle,
+		DepartmentName: u.DepartmentName,
+		Status:         u.Status,
+		ManagerID:      u.ManagerID,
+	}
+}
+
+// validateManager 校验上级设置：上级必须存在，且不能是自己或自己的下属
+func validateManager(userID, managerID uint) error {
+	if managerID == 0 {
+		return nil
+	}
+	if managerID == userID {
+		return fmt.Errorf("不能将自己设为上级")
+	}
+	current := managerID
+	for depth := 0; current > 0 && depth < maxReportingDepth; depth++ {
+		var u models.User
+		if err := storage.DB.Select("id, manager_id").Where("is_deleted = 0").First(&u, current).Error; err != nil {
+			if current == managerID {
+				return fmt.Errorf("上级用户不存在")
+			}
+			return nil
+		}
+		if userID > 0 && u.ManagerID == userID {
+			return fmt.Errorf("上级设置形成循环汇报关系")
+		}
+		current = u.ManagerID
+	}
+	return nil
+}
+
+// reportCounts 统计每个用户的直接下属人数
+func reportCounts(managerIDs []uint) map[uint]int {
+	counts := make(map[uint]int)
+	if len(managerIDs) == 0 {
+		return counts
+	}
+	var rows []struct {
+		ManagerID uint
+		Total     int
+	}
+	storage.DB.Model(&models.User{}).Select("manager_id, COUNT(*) AS total").
+		Where("manager_id IN ? AND is_deleted = 0", managerIDs).Group("manager_id").Scan(&rows)
+	for _, r := range rows {
+		counts[r.ManagerID] = r.Total
+	}
+	return counts
+}
+
+// GetUserReportingLine 获取用户的汇报链（由近及远）与直接下属
+func GetUserReportingLine(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var user models.User
+	if err := storage.DB.Select(orgNodeColumns).Where("is_deleted = 0").First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	managers := make([]*orgNode, 0)
+	seen := map[uint]bool{user.ID: true}
+	for current, depth := user.ManagerID, 0; current > 0 && !seen[current] && depth < maxReportingDepth; depth++ {
+		var m models.User
+		if storage.DB.Select(orgNodeColumns).Where("is_deleted = 0").First(&m, current).Error != nil {
+			break
+		}
+		seen[current] = true
+		managers = append(managers, newOrgNode(m))
+		current = m.ManagerID
+	}
+
+	var reports []models.User
+	storage.DB.Select(orgNodeColumns).Where("manager_id = ? AND is_deleted = 0", user.ID).Order("id").Find(&reports)
+	ids := make([]uint, 0, len(reports))
+	for _, r := range reports {
+		ids = append(ids, r.ID)
+	}
+	counts := reportCounts(ids)
+	reportNodes := make([]*orgNode, 0, len(reports))
+	for _, r := range reports {
+		n := newOrgNode(r)
+		n.ReportCount = counts[r.ID]
+		reportNodes = append(reportNodes, n)
+	}
+
+	self := newOrgNode(user)
+	self.ReportCount = len(reportNodes)
+	respondOK(c, gin.H{
+		"user":          self,
+		"managers":      managers,
+		"directReports": reportNodes,
+	})
+}
+
+// GetOrgChart 组织架构树
+// rootId 指定根节点，不指定时以所有无上级且有下属的用户为根；depth 为展开层级（默认 3，最大 10）
+func GetOrgChart(c *gin.Context) {
+	depth, _ := strconv.Atoi(c.DefaultQuery("depth", "3"))
+	if depth <= 0 {
+		depth = 3
+	}
+	if depth > 10 {
+		depth = 10
+	}
+
+	var users []models.User
+	query := storage.DB.Model(&models.User{}).Select(orgNodeColumns).Where("is_deleted = 0")
+	middleware.GetDataScope(c, "user:list").FilterUsers(query).Order("id").Find(&users)
+
+	nodes := make(map[uint]*orgNode, len(users))
+	children := make(map[uint][]uint)
+	for _, u := range users {
+		nodes[u.ID] = newOrgNode(u)
+		if u.ManagerID > 0 {
+			children[u.ManagerID] = append(children[u.ManagerID], u.ID)
+		}
+	}
+	for id, n := range nodes {
+		n.ReportCount = len(children[id])
+	}
+
+	var rootIDs []uint
+	if rootID, _ := strconv.ParseUint(c.Query("rootId"), 10, 32); rootID > 0 {
+		if _, ok := nodes[uint(rootID)]; !ok {
+			respondError(c, http.StatusNotFound, "用户不存在")
+			return
+		}
+		rootIDs = []uint{uint(rootID)}
+	} else {
+		for _, u := range users {
+			// 上级不存在（已删除或不在管理范围内）的用户同样视为根
+			if _, ok := nodes[u.ManagerID]; (u.ManagerID == 0 || !ok) && len(children[u.ID]) > 0 {
+				rootIDs = append(rootIDs, u.ID)
+			}
+		}
+	}
+
+	visited := make(map[uint]bool)
+	var build func(id uint, level int) *orgNode
+	build = func(id uint, level int) *orgNode {
+		visited[id] = true
+		n := nodes[id]
+		if level >= depth {
+			return n
+		}
+		for _, childID := range children[id] {
+			if !visited[childID] {
+				n.Children = append(n.Children, build(childID, level+1))
+			}
+		}
+		return n
+	}
+
+	roots := make([]*orgNode, 0, len(rootIDs))
+	for _, id := range rootIDs {
+		roots = append(roots, build(id, 1))
+	}
+	respondOK(c, roots)
+}
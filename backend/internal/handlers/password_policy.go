@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 密码检查：字典与泄露库 ==========
+
+// 自定义禁用字典上传大小上限
+const passwordDictionaryMaxSize = 20 << 20
+
+// GetPasswordCheckStatus 各检查器及字典、离线泄露库的状态
+func GetPasswordCheckStatus(c *gin.Context) {
+	policy, _ := securityService.GetPasswordPolicy()
+
+	checkers := []gin.H{}
+	for _, checker := range services.RegisteredPasswordCheckers() {
+		checkers = append(checkers, gin.H{"code": checker.Code, "name": checker.Name})
+	}
+	count, updatedAt := services.PasswordDictionaryStatus()
+	available, detail := services.HIBPDataStatus(policy.BreachDataPath)
+
+	respondOK(c, gin.H{
+		"checkers":    checkers,
+		"commonCount": services.CommonPasswordCount(),
+		"dictionary":  gin.H{"count": count, "updatedAt": updatedAt},
+		"breach":      gin.H{"path": policy.BreachDataPath, "available": available, "detail": detail},
+	})
+}
+
+// UploadPasswordDictionary 上传自定义禁用字典（文本文件，每行一个），替换原有字典
+func UploadPasswordDictionary(c *gin.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "请上传 .txt 字典文件")
+		return
+	}
+	if fh.Size > passwordDictionaryMaxSize {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("字典文件不能超过 %d MB", passwordDictionaryMaxSize>>20))
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "读取文件失败")
+		return
+	}
+	defer f.Close()
+
+	count, err := services.SavePasswordDictionary(io.LimitReader(f, passwordDictionaryMaxSize))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+	securityService.RecordSecurityEvent(models.EventConfigChanged, models.SeverityMedium, c.ClientIP(),
+		&userID, middleware.GetUsername(c), "security_config", "password_dictionary",
+		fmt.Sprintf("更新了自定义禁用密码字典（%s，%d 个词）", fh.Filename, count), nil)
+	respondOK(c, gin.H{"count": count})
+}
+
+// DeletePasswordDictionary 清空自定义禁用字典
+func DeletePasswordDictionary(c *gin.Context) {
+	if err := services.ClearPasswordDictionary(); err != nil {
+		respondError(c, http.StatusInternalServerError, "删除失败")
+		return
+	}
+	userID := middleware.GetUserID(c)
+	securityService.RecordSecurityEvent(models.EventConfigChanged, models.SeverityMedium, c.ClientIP(),
+		&userID, middleware.GetUsername(c), "security_config", "password_dictionary", "清空了自定义禁用密码字典", nil)
+	respondOK(c, nil)
+}
+
+// TestPasswordPolicy 按当前策略检查密码，可指定用户名以包含个人信息和相似度检查；密码不会被记录
+func TestPasswordPolicy(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" {
+		respondError(c, http.StatusBadRequest, "请输入要检查的密码")
+		return
+	}
+	in := services.PasswordCheckInput{Password: req.Password}
+	if username := strings.TrimSpace(req.Username); username != "" {
+		var user models.User
+		if err := storage.DB.Where("username = ? AND is_deleted = 0", username).First(&user).Error; err != nil {
+			respondError(c, http.StatusNotFound, "用户不存在")
+			return
+		}
+		in.User = &user
+	}
+	valid, errors := securityService.ValidatePasswordFor(in)
+	if errors == nil {
+		errors = []string{}
+	}
+	respondOK(c, gin.H{"valid": valid, "errors": errors})
+}
@@ -22,6 +22,21 @@ type createRoleRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Code        string `json:"code" binding:"required"`
 	Description string `json:"description"`
+	OwnerID     uint   `json:"ownerId"`
+	Requestable bool   `json:"requestable"`
+}
+
+// validateRoleOwner 角色负责人须为存在的用户，0 表示不设置
+func validateRoleOwner(ownerID uint) error {
+	if ownerID == 0 {
+		return nil
+	}
+	var count int64
+	storage.DB.Model(&models.User{}).Where("id = ? AND is_deleted = 0", ownerID).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("角色负责人不存在")
+	}
+	return nil
 }
 
 func CreateRole(c *gin.Context) {
@@ -37,12 +52,18 @@ func CreateRole(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "角色编码已存在")
 		return
 	}
+	if err := validateRoleOwner(req.OwnerID); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	role := models.Role{
 		Name:        req.Name,
 		Code:        req.Code,
 		Description: req.Description,
 		Status:      1,
+		OwnerID:     req.OwnerID,
+		Requestable: req.Requestable,
 	}
 
 	if err := storage.DB.Create(&role).Error; err != nil {
@@ -74,6 +95,8 @@ func UpdateRole(c *gin.Context) {
 		Description string `json:"description"`
 		SidebarMode string `json:"sidebarMode"`
 		LandingPage string `json:"landingPage"`
+		OwnerID     *uint  `json:"ownerId"`
+		Requestable *bool  `json:"requestable"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "参数错误")
@@ -95,6 +118,17 @@ func UpdateRole(c *gin.Context) {
 		updates["sidebar_mode"] = req.SidebarMode
 	}
 	updates["landing_page"] = req.LandingPage
+	// 自助申请设置（仅当传了值时更新）
+	if req.OwnerID != nil {
+		if err := validateRoleOwner(*req.OwnerID); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		updates["owner_id"] = *req.OwnerID
+	}
+	if req.Requestable != nil {
+		updates["requestable"] = *req.Requestable
+	}
 
 	storage.DB.Model(&role).Updates(updates)
 
@@ -29,6 +29,9 @@ func RegisterRoutes(r *gin.Engine) {
 		})
 	}
 
+	// 访问令牌验签公钥
+	r.GET("/.well-known/jwks.json", GetJWKS)
+
 	api := r.Group("/api")
 	api.Use(middleware.IPWhitelistMiddleware())
 	api.Use(middleware.RateLimitMiddleware())
@@ -36,6 +39,11 @@ func RegisterRoutes(r *gin.Engine) {
 		// ========== 公开接口 ==========
 		api.GET("/auth/csrf", GetLoginCSRFToken)
 		api.POST("/auth/login", middleware.LoginRateLimitMiddleware(), Login)
+		api.POST("/auth/login/verify", middleware.LoginRateLimitMiddleware(), VerifyLoginChallenge)
+		api.POST("/auth/refresh", RefreshToken)
+		api.GET("/auth/webauthn/status", GetWebAuthnStatus)
+		api.POST("/auth/webauthn/login/begin", middleware.LoginRateLimitMiddleware(), WebAuthnLoginBegin)
+		api.POST("/auth/webauthn/login/finish", middleware.LoginRateLimitMiddleware(), WebAuthnLoginFinish)
 		api.POST("/auth/dingtalk", DingTalkLogin) // 保留旧钉钉登录兼容
 		api.POST("/auth/forgot-password/check", middleware.SensitiveRateLimitMiddleware(), ForgotPasswordCheck)
 		api.POST("/auth/forgot-password/send-code", middleware.SensitiveRateLimitMiddleware(), ForgotPasswordSendCode)
@@ -66,6 +74,34 @@ func RegisterRoutes(r *gin.Engine) {
 			auth.PUT("/profile", UpdateProfile)
 			auth.PUT("/profile/password", ChangePasswordMultiMethod)
 			auth.POST("/profile/verify-code", SendVerifyCode)
+			auth.GET("/profile/webauthn/credentials", ListMyWebAuthnCredentials)
+			auth.POST("/profile/webauthn/register/begin", WebAuthnRegisterBegin)
+			auth.POST("/profile/webauthn/register/finish", WebAuthnRegisterFinish)
+			auth.PUT("/profile/webauthn/credentials/:id", RenameMyWebAuthnCredential)
+			auth.DELETE("/profile/webauthn/credentials/:id", DeleteMyWebAuthnCredential)
+			// 自助权限申请
+			auth.GET("/profile/access-requests", ListMyAccessRequests)
+			auth.GET("/profile/access-requests/options", GetAccessRequestOptions)
+			auth.POST("/profile/access-requests", CreateAccessRequest)
+			auth.POST("/profile/access-requests/:id/cancel", CancelAccessRequest)
+			// 权限申请审批：审批人或有角色分配权限的管理员，在处理函数中校验
+			auth.GET("/access-requests", ListAccessApprovals)
+			auth.POST("/access-requests/:id/approve", ApproveAccessRequest)
+			auth.POST("/access-requests/:id/deny", DenyAccessRequest)
+			auth.POST("/access-requests/:id/revoke", RevokeAccessRequest)
+			auth.GET("/access-requests/config", middleware.PermissionMiddleware("settings:system"), GetAccessRequestConfig)
+			auth.PUT("/access-requests/config", middleware.PermissionMiddleware("settings:system"), UpdateAccessRequestConfig)
+			// 权限复核：复核人处理分配给自己的复核项，活动管理需权限复核权限
+			auth.GET("/profile/access-reviews", ListMyReviewItems)
+			auth.POST("/access-reviews/items/decide", DecideReviewItems)
+			auth.GET("/access-reviews", middleware.PermissionMiddleware("role:review"), ListAccessReviews)
+			auth.POST("/access-reviews", middleware.PermissionMiddleware("role:review"), CreateAccessReview)
+			auth.GET("/access-reviews/options", middleware.PermissionMiddleware("role:review"), GetAccessReviewOptions)
+			auth.GET("/access-reviews/reviewers", middleware.PermissionMiddleware("role:review"), SearchAccessReviewers)
+			auth.GET("/access-reviews/:id/items", middleware.PermissionMiddleware("role:review"), ListAccessReviewItems)
+			auth.POST("/access-reviews/:id/close", middleware.PermissionMiddleware("role:review"), CloseAccessReview)
+			auth.POST("/access-reviews/:id/cancel", middleware.PermissionMiddleware("role:review"), CancelAccessReview)
+			auth.GET("/access-reviews/:id/export", middleware.PermissionMiddleware("role:review"), ExportAccessReview)
 
 			// 权限树
 			auth.GET("/permissions/tree", GetPermissionTree)
@@ -77,12 +113,44 @@ func RegisterRoutes(r *gin.Engine) {
 			auth.GET("/users/export", middleware.PermissionAnyMiddleware("user:export", "settings:system"), ExportUsers)
 			auth.GET("/users", middleware.PermissionMiddleware("user:list"), ListUsers)
 			auth.POST("/users", middleware.PermissionMiddleware("user:create"), CreateUser)
-			auth.GET("/users/:id", middleware.PermissionMiddleware("user:list"), GetUser)
-			auth.PUT("/users/:id", middleware.PermissionMiddleware("user:update"), UpdateUser)
-			auth.DELETE("/users/:id", middleware.PermissionMiddleware("user:delete"), DeleteUser)
-			auth.PUT("/users/:id/status", middleware.PermissionAnyMiddleware("user:toggle_status", "user:update"), UpdateUserStatus)
-			auth.PUT("/users/:id/reset-password", middleware.PermissionAnyMiddleware("user:reset_password", "user:update"), ResetUserPassword)
+			auth.POST("/users/import/preview", middleware.PermissionMiddleware("user:create"), PreviewUserImport)
+			auth.POST("/users/import/:token/commit", middleware.PermissionMiddleware("user:create"), CommitUserImport)
+			auth.GET("/users/import/template", middleware.PermissionAnyMiddleware("user:create", "user:update"), DownloadUserTemplate)
+			auth.POST("/users/bulk-update/preview", middleware.PermissionMiddleware("user:update"), PreviewUserBulkUpdate)
+			auth.POST("/users/bulk-update/:token/commit", middleware.PermissionMiddleware("user:update"), CommitUserBulkUpdate)
+			auth.GET("/users/:id", middleware.PermissionMiddleware("user:list"), middleware.UserScopeMiddleware("user:list"), GetUser)
+			auth.PUT("/users/:id", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), UpdateUser)
+			auth.DELETE("/users/:id", middleware.PermissionMiddleware("user:delete"), middleware.UserScopeMiddleware("user:delete"), DeleteUser)
+			auth.PUT("/users/:id/status", middleware.PermissionAnyMiddleware("user:toggle_status", "user:update"), middleware.UserScopeMiddleware("user:toggle_status", "user:update"), UpdateUserStatus)
+			auth.PUT("/users/:id/reset-password", middleware.PermissionAnyMiddleware("user:reset_password", "user:update"), middleware.UserScopeMiddleware("user:reset_password", "user:update"), ResetUserPassword)
 			auth.POST("/users/batch-reset-password", middleware.PermissionAnyMiddleware("user:reset_password", "user:update"), BatchResetPassword)
+			auth.GET("/users/org-chart", middleware.PermissionMiddleware("user:list"), GetOrgChart)
+			auth.GET("/users/:id/reporting-line", middleware.PermissionMiddleware("user:list"), middleware.UserScopeMiddleware("user:list"), GetUserReportingLine)
+			auth.GET("/users/:id/role-scopes", middleware.PermissionMiddleware("user:list"), middleware.UserScopeMiddleware("user:list"), GetUserRoleScopes)
+			auth.PUT("/users/:id/role-scopes", middleware.PermissionMiddleware("user:assign_role"), middleware.UserScopeMiddleware("user:assign_role"), UpdateUserRoleScopes)
+			// 多来源身份关联
+			auth.GET("/users/:id/identities", middleware.PermissionMiddleware("user:list"), middleware.UserScopeMiddleware("user:list"), ListUserIdentities)
+			auth.GET("/users/:id/webauthn", middleware.PermissionMiddleware("user:list"), middleware.UserScopeMiddleware("user:list"), ListUserWebAuthnCredentials)
+			auth.DELETE("/users/:id/webauthn/:credId", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), DeleteUserWebAuthnCredential)
+			auth.POST("/users/:id/merge", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), MergeUsers)
+			auth.POST("/users/:id/identities/:identityId/split", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), SplitIdentity)
+			auth.GET("/users/:id/field-sources", middleware.PermissionMiddleware("user:list"), middleware.UserScopeMiddleware("user:list"), GetUserFieldSources)
+			auth.PUT("/users/:id/field-locks", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), LockUserFields)
+			auth.DELETE("/users/:id/field-locks/:field", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), UnlockUserField)
+			// 用户生命周期
+			auth.POST("/users/:id/lifecycle/leave", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), StartUserLeave)
+			auth.POST("/users/:id/lifecycle/move", middleware.PermissionMiddleware("user:update"), middleware.UserScopeMiddleware("user:update"), ScheduleUserMove)
+			auth.GET("/lifecycle/tasks", middleware.PermissionMiddleware("user:list"), ListLifecycleTasks)
+			auth.POST("/lifecycle/tasks/:id/cancel", middleware.PermissionMiddleware("user:update"), CancelLifecycleTask)
+			auth.GET("/lifecycle/config", middleware.PermissionMiddleware("settings:system"), GetLifecycleConfig)
+			auth.PUT("/lifecycle/config", middleware.PermissionMiddleware("settings:system"), UpdateLifecycleConfig)
+			auth.GET("/identity/config", middleware.PermissionMiddleware("settings:system"), GetIdentityConfig)
+			auth.PUT("/identity/config", middleware.PermissionMiddleware("settings:system"), UpdateIdentityConfig)
+			// 用户扩展属性定义
+			auth.GET("/user-attributes", middleware.PermissionMiddleware("user:list"), ListUserAttributeDefs)
+			auth.POST("/user-attributes", middleware.PermissionMiddleware("settings:system"), CreateUserAttributeDef)
+			auth.PUT("/user-attributes/:id", middleware.PermissionMiddleware("settings:system"), UpdateUserAttributeDef)
+			auth.DELETE("/user-attributes/:id", middleware.PermissionMiddleware("settings:system"), DeleteUserAttributeDef)
 
 			// 用户分组
 			auth.GET("/groups", middleware.PermissionMiddleware("user:list"), ListUserGroups)
@@ -165,9 +233,16 @@ func RegisterRoutes(r *gin.Engine) {
 			auth.GET("/security/sessions/my", GetMySessions)
 			auth.DELETE("/security/sessions/:id", middleware.PermissionMiddleware("settings:system"), TerminateSession)
 			auth.DELETE("/security/sessions/user/:userId", middleware.PermissionMiddleware("settings:system"), TerminateUserSessions)
+			auth.GET("/security/jwt-keys", middleware.PermissionMiddleware("settings:system"), ListJWTSigningKeys)
+			auth.POST("/security/jwt-keys/rotate", middleware.PermissionMiddleware("settings:system"), RotateJWTSigningKey)
+			auth.DELETE("/security/jwt-keys/:kid", middleware.PermissionMiddleware("settings:system"), RevokeJWTSigningKey)
 			auth.GET("/security/config", middleware.PermissionMiddleware("settings:system"), GetSecurityConfigs)
 			auth.GET("/security/config/:key", middleware.PermissionMiddleware("settings:system"), GetSecurityConfig)
 			auth.PUT("/security/config/:key", middleware.PermissionMiddleware("settings:system"), UpdateSecurityConfig)
+			auth.GET("/security/password-checks", middleware.PermissionMiddleware("settings:system"), GetPasswordCheckStatus)
+			auth.POST("/security/password-checks/test", middleware.PermissionMiddleware("settings:system"), TestPasswordPolicy)
+			auth.POST("/security/password-dictionary", middleware.PermissionMiddleware("settings:system"), UploadPasswordDictionary)
+			auth.DELETE("/security/password-dictionary", middleware.PermissionMiddleware("settings:system"), DeletePasswordDictionary)
 			auth.GET("/security/alerts/channels", middleware.PermissionMiddleware("settings:system"), GetNotifyChannels)
 			auth.POST("/security/alerts/channels", middleware.PermissionMiddleware("settings:system"), CreateNotifyChannel)
 			auth.PUT("/security/alerts/channels/:id", middleware.PermissionMiddleware("settings:system"), UpdateNotifyChannel)
@@ -178,6 +253,11 @@ func RegisterRoutes(r *gin.Engine) {
 			auth.PUT("/security/alerts/rules/:id", middleware.PermissionMiddleware("settings:system"), UpdateAlertRule)
 			auth.DELETE("/security/alerts/rules/:id", middleware.PermissionMiddleware("settings:system"), DeleteAlertRule)
 			auth.GET("/security/alerts/logs", middleware.PermissionMiddleware("settings:system"), GetAlertLogs)
+			auth.GET("/security/alerts/incidents", middleware.PermissionMiddleware("settings:system"), GetAlertIncidents)
+			auth.GET("/security/alerts/incidents/:id/events", middleware.PermissionMiddleware("settings:system"), GetAlertIncidentEvents)
+			auth.POST("/security/alerts/incidents/:id/resolve", middleware.PermissionMiddleware("settings:system"), ResolveAlertIncident)
+			auth.GET("/security/alerts/actions", middleware.PermissionMiddleware("settings:system"), GetAlertActionLogs)
+			auth.POST("/security/alerts/actions/:id/revert", middleware.PermissionMiddleware("settings:system"), RevertAlertAction)
 
 			// ========== 消息模板管理 ==========
 			auth.GET("/notify/templates", middleware.PermissionMiddleware("settings:system"), GetMessageTemplates)
@@ -262,6 +342,20 @@ func RegisterRoutes(r *gin.Engine) {
 			auth.POST("/sync/downstream/rules/:id/trigger", syncPerm, TriggerDownstreamSync)
 			auth.GET("/sync/downstream/rules/:id/mappings", syncPerm, ListDownstreamRuleMappings)
 			auth.PUT("/sync/downstream/rules/:id/mappings", syncPerm, BatchUpdateDownstreamRuleMappings)
+			auth.POST("/sync/mappings/test", syncPerm, TestMappingRule)
+
+			// 下游同步队列
+			auth.GET("/sync/queue/jobs", syncPerm, ListSyncJobs)
+			auth.GET("/sync/queue/stats", syncPerm, GetSyncQueueStats)
+			auth.POST("/sync/queue/jobs/:id/retry", syncPerm, RetrySyncJob)
+			auth.POST("/sync/queue/jobs/:id/discard", syncPerm, DiscardSyncJob)
+			auth.POST("/sync/queue/jobs/batch", syncPerm, BatchSyncJobs)
+			auth.GET("/sync/queue/config", syncPerm, GetSyncQueueConfig)
+			auth.PUT("/sync/queue/config", syncPerm, UpdateSyncQueueConfig)
+			// 下游规则对账
+			auth.POST("/sync/downstream/rules/:id/reconcile", syncPerm, RunDownstreamReconcile)
+			auth.GET("/sync/downstream/rules/:id/reconcile", syncPerm, GetDownstreamReconcile)
+			auth.POST("/sync/downstream/rules/:id/reconcile/:reportId/items/:index", syncPerm, ResolveReconcileItem)
 
 			// 连接器类型列表
 			auth.GET("/sync/connector-types", syncPerm, GetConnectorTypes)
@@ -295,11 +389,14 @@ func RegisterRoutes(r *gin.Engine) {
 		{
 			openAPI.GET("/users", ListUsers)
 			openAPI.POST("/users", CreateUser)
-			openAPI.GET("/users/:id", GetUser)
-			openAPI.PUT("/users/:id", UpdateUser)
-			openAPI.DELETE("/users/:id", DeleteUser)
-			openAPI.PUT("/users/:id/status", UpdateUserStatus)
-			openAPI.PUT("/users/:id/reset-password", ResetUserPassword)
+			openAPI.GET("/users/:id", middleware.UserScopeMiddleware("user:list"), GetUser)
+			openAPI.PUT("/users/:id", middleware.UserScopeMiddleware("user:update"), UpdateUser)
+			openAPI.DELETE("/users/:id", middleware.UserScopeMiddleware("user:delete"), DeleteUser)
+			openAPI.PUT("/users/:id/status", middleware.UserScopeMiddleware("user:toggle_status", "user:update"), UpdateUserStatus)
+			openAPI.PUT("/users/:id/reset-password", middleware.UserScopeMiddleware("user:reset_password", "user:update"), ResetUserPassword)
+			openAPI.GET("/users/org-chart", GetOrgChart)
+			openAPI.GET("/users/:id/reporting-line", middleware.UserScopeMiddleware("user:list"), GetUserReportingLine)
+			openAPI.GET("/user-attributes", ListUserAttributeDefs)
 			openAPI.GET("/groups", ListUserGroups)
 			openAPI.POST("/groups", CreateUserGroup)
 			openAPI.PUT("/groups/:id", UpdateUserGroup)
@@ -337,6 +434,7 @@ func corsMiddleware() gin.HandlerFunc {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type,Authorization")
+		c.Header("Access-Control-Expose-Headers", "X-Token-Expired")
 		c.Header("Access-Control-Max-Age", "86400")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
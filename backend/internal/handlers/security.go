@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -488,15 +489,25 @@ func GetAllSessions(c *gin.Context) {
 
 // GetMySessions 获取当前用户会话
 func GetMySessions(c *gin.Context) {
-	userID := c.GetUint("userID")
+	userID := middleware.GetUserID(c)
 	sessions, _ := securityService.GetUserSessions(userID)
-	respondOK(c, sessions)
+
+	type mySession struct {
+		models.Session
+		Current bool `json:"current"`
+	}
+	currentID := middleware.GetSessionID(c)
+	result := make([]mySession, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, mySession{Session: s, Current: s.ID == currentID})
+	}
+	respondOK(c, result)
 }
 
 // TerminateSession 终止会话
 func TerminateSession(c *gin.Context) {
 	sessionID := c.Param("id")
-	userID := c.GetUint("userID")
+	userID := middleware.GetUserID(c)
 
 	if err := securityService.TerminateSession(sessionID, &userID); err != nil {
 		respondError(c, http.StatusInternalServerError, "终止失败")
@@ -509,10 +520,9 @@ func TerminateSession(c *gin.Context) {
 // TerminateUserSessions 终止用户所有会话
 func TerminateUserSessions(c *gin.Context) {
 	targetUserID, _ := strconv.ParseUint(c.Param("userId"), 10, 32)
-	userID := c.GetUint("userID")
+	userID := middleware.GetUserID(c)
 
-	storage.DB.Model(&models.Session{}).Where("user_id = ? AND is_active = ?", targetUserID, true).
-		Update("is_active", false)
+	securityService.RevokeUserSessions(uint(targetUserID), "", services.SessionRevokeTerminated)
 
 	securityService.RecordSecurityEvent(models.EventSessionTerminated, models.SeverityMedium, "",
 		&userID, "", "user", strconv.FormatUint(targetUserID, 10), "用户所有会话被终止", nil)
@@ -568,6 +578,13 @@ func UpdateSecurityConfig(c *gin.Context) {
 
 	securityService.ClearConfigCache()
 
+	// 签名算法或轮换周期变化时立即生效
+	if key == "jwt_signing" {
+		if _, err := middleware.RotateJWTKeys(false); err != nil {
+			log.Printf("[令牌签名] 应用签名配置失败: %v", err)
+		}
+	}
+
 	// 记录配置修改事件
 	securityService.RecordSecurityEvent(models.EventConfigChanged, models.SeverityMedium, c.ClientIP(),
 		&userID, "", "security_config", key, "安全配置已修改: "+key, nil)
@@ -920,10 +937,11 @@ func GetAlertRules(c *gin.Context) {
 	// 解析 JSON 字段方便前端使用
 	type RuleView struct {
 		models.AlertRule
-		EventTypeList  []string `json:"eventTypes"`
-		ChannelIDList  []uint   `json:"channelIds"`
-		RoleIDList     []uint   `json:"notifyRoleIds"`
-		UserIDList     []uint   `json:"notifyUserIds"`
+		EventTypeList []string               `json:"eventTypes"`
+		ChannelIDList []uint                 `json:"channelIds"`
+		RoleIDList    []uint                 `json:"notifyRoleIds"`
+		UserIDList    []uint                 `json:"notifyUserIds"`
+		ActionList    []services.AlertAction `json:"actions"`
 	}
 	var views []RuleView
 	for _, r := range rules {
@@ -932,6 +950,7 @@ func GetAlertRules(c *gin.Context) {
 		json.Unmarshal([]byte(r.NotifyChannels), &v.ChannelIDList)
 		json.Unmarshal([]byte(r.NotifyRoleIDs), &v.RoleIDList)
 		json.Unmarshal([]byte(r.NotifyUserIDs), &v.UserIDList)
+		v.ActionList, _ = services.ParseAlertActions(r.Actions)
 		views = append(views, v)
 	}
 	respondOK(c, views)
@@ -940,21 +959,33 @@ func GetAlertRules(c *gin.Context) {
 // CreateAlertRule 创建告警规则
 func CreateAlertRule(c *gin.Context) {
 	var req struct {
-		AlertType         string   `json:"alertType"`
-		Name              string   `json:"name" binding:"required"`
-		EventTypes        []string `json:"eventTypes" binding:"required"`
-		SeverityThreshold string   `json:"severityThreshold"`
-		NotifyChannels    []uint   `json:"notifyChannels" binding:"required"`
-		NotifyTarget      string   `json:"notifyTarget"`
-		NotifyRoleIDs     []uint   `json:"notifyRoleIds"`
-		NotifyUserIDs     []uint   `json:"notifyUserIds"`
-		TemplateID        uint     `json:"templateId"`
-		CooldownMinutes   int      `json:"cooldownMinutes"`
+		AlertType         string                 `json:"alertType"`
+		Name              string                 `json:"name" binding:"required"`
+		EventTypes        []string               `json:"eventTypes" binding:"required"`
+		SeverityThreshold string                 `json:"severityThreshold"`
+		NotifyChannels    []uint                 `json:"notifyChannels" binding:"required"`
+		NotifyTarget      string                 `json:"notifyTarget"`
+		NotifyRoleIDs     []uint                 `json:"notifyRoleIds"`
+		NotifyUserIDs     []uint                 `json:"notifyUserIds"`
+		TemplateID        uint                   `json:"templateId"`
+		CooldownMinutes   int                    `json:"cooldownMinutes"`
+		Conditions        string                 `json:"conditions"`
+		Actions           []services.AlertAction `json:"actions"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "参数错误")
 		return
 	}
+	conditions, err := normalizeAlertConditions(req.Conditions)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actions, err := normalizeAlertActions(req.Actions)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	eventTypesJSON, _ := json.Marshal(req.EventTypes)
 	channelsJSON, _ := json.Marshal(req.NotifyChannels)
@@ -985,6 +1016,8 @@ func CreateAlertRule(c *gin.Context) {
 		NotifyUserIDs:     string(userIDsJSON),
 		TemplateID:        req.TemplateID,
 		CooldownMinutes:   req.CooldownMinutes,
+		Conditions:        conditions,
+		Actions:           actions,
 		IsActive:          true,
 	}
 
@@ -1001,17 +1034,19 @@ func UpdateAlertRule(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 
 	var req struct {
-		AlertType         *string  `json:"alertType"`
-		Name              string   `json:"name"`
-		EventTypes        []string `json:"eventTypes"`
-		SeverityThreshold string   `json:"severityThreshold"`
-		NotifyChannels    []uint   `json:"notifyChannels"`
-		NotifyTarget      *string  `json:"notifyTarget"`
-		NotifyRoleIDs     []uint   `json:"notifyRoleIds"`
-		NotifyUserIDs     []uint   `json:"notifyUserIds"`
-		TemplateID        *uint    `json:"templateId"`
-		CooldownMinutes   *int     `json:"cooldownMinutes"`
-		IsActive          *bool    `json:"isActive"`
+		AlertType         *string                 `json:"alertType"`
+		Name              string                  `json:"name"`
+		EventTypes        []string                `json:"eventTypes"`
+		SeverityThreshold string                  `json:"severityThreshold"`
+		NotifyChannels    []uint                  `json:"notifyChannels"`
+		NotifyTarget      *string                 `json:"notifyTarget"`
+		NotifyRoleIDs     []uint                  `json:"notifyRoleIds"`
+		NotifyUserIDs     []uint                  `json:"notifyUserIds"`
+		TemplateID        *uint                   `json:"templateId"`
+		CooldownMinutes   *int                    `json:"cooldownMinutes"`
+		Conditions        *string                 `json:"conditions"`
+		Actions           *[]services.AlertAction `json:"actions"`
+		IsActive          *bool                   `json:"isActive"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "参数错误")
@@ -1053,6 +1088,22 @@ func UpdateAlertRule(c *gin.Context) {
 	if req.CooldownMinutes != nil {
 		updates["cooldown_minutes"] = *req.CooldownMinutes
 	}
+	if req.Conditions != nil {
+		conditions, err := normalizeAlertConditions(*req.Conditions)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		updates["conditions"] = conditions
+	}
+	if req.Actions != nil {
+		actions, err := normalizeAlertActions(*req.Actions)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		updates["actions"] = actions
+	}
 	if req.IsActive != nil {
 		updates["is_active"] = *req.IsActive
 	}
@@ -1065,6 +1116,33 @@ func UpdateAlertRule(c *gin.Context) {
 	respondOK(c, nil)
 }
 
+// normalizeAlertConditions 校验告警规则条件并压缩为紧凑 JSON，空条件存为空字符串
+func normalizeAlertConditions(raw string) (string, error) {
+	cond, err := services.ParseAlertConditions(raw)
+	if err != nil {
+		return "", err
+	}
+	if len(cond.Filters) == 0 && cond.Threshold == nil && cond.Sequence == nil {
+		return "", nil
+	}
+	data, _ := json.Marshal(cond)
+	return string(data), nil
+}
+
+// normalizeAlertActions 校验自动响应动作并序列化，未配置动作存为空字符串
+func normalizeAlertActions(list []services.AlertAction) (string, error) {
+	if len(list) == 0 {
+		return "", nil
+	}
+	data, _ := json.Marshal(list)
+	actions, err := services.ParseAlertActions(string(data))
+	if err != nil {
+		return "", err
+	}
+	data, _ = json.Marshal(actions)
+	return string(data), nil
+}
+
 // DeleteAlertRule 删除告警规则
 func DeleteAlertRule(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -1094,6 +1172,75 @@ func GetAlertLogs(c *gin.Context) {
 	})
 }
 
+// GetAlertIncidents 获取告警聚合列表
+func GetAlertIncidents(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	query := storage.DB.Model(&models.AlertIncident{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if ruleID := c.Query("ruleId"); ruleID != "" {
+		query = query.Where("rule_id = ?", ruleID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var incidents []models.AlertIncident
+	query.Order("last_seen_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&incidents)
+
+	respondOK(c, gin.H{
+		"list":  incidents,
+		"total": total,
+	})
+}
+
+// GetAlertIncidentEvents 获取告警聚合关联的安全事件
+func GetAlertIncidentEvents(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var incident models.AlertIncident
+	if err := storage.DB.First(&incident, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "告警聚合不存在")
+		return
+	}
+	var ids []uint
+	json.Unmarshal([]byte(incident.EventIDs), &ids)
+
+	events := []models.SecurityEvent{}
+	if len(ids) > 0 {
+		storage.DB.Where("id IN ?", ids).Order("id ASC").Find(&events)
+	}
+	respondOK(c, events)
+}
+
+// ResolveAlertIncident 处理告警聚合，之后的匹配会新建聚合并重新通知
+func ResolveAlertIncident(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	now := time.Now()
+	res := storage.DB.Model(&models.AlertIncident{}).
+		Where("id = ? AND status = ?", id, models.IncidentStatusOpen).
+		Updates(map[string]interface{}{
+			"status":      models.IncidentStatusResolved,
+			"resolved_by": middleware.GetUsername(c),
+			"resolved_at": now,
+		})
+	if res.Error != nil {
+		respondError(c, http.StatusInternalServerError, "操作失败")
+		return
+	}
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusBadRequest, "告警聚合不存在或已处理")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "安全中心", "处理告警聚合", strconv.FormatUint(id, 10), "")
+	respondOK(c, nil)
+}
+
 // ========== 消息策略管理 ==========
 
 // ResolveAllowedChannelTypes 根据消息策略解析指定场景允许的通知渠道类型
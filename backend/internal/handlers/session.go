@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 会话令牌 ==========
+
+func init() {
+	middleware.SetSessionValidator(services.GetSecurityService().ValidateSession)
+}
+
+// issueSessionTokens 登录成功后创建会话，签发短期访问令牌和刷新令牌
+func issueSessionTokens(c *gin.Context, user models.User, location string) (gin.H, error) {
+	ss := services.GetSecurityService()
+	session, refreshToken, err := ss.CreateSession(user.ID, c.ClientIP(), c.GetHeader("User-Agent"), location)
+	if err != nil {
+		return nil, err
+	}
+	return signAccessToken(user, session, refreshToken)
+}
+
+// signAccessToken 为会话签发访问令牌，会话中只保存令牌摘要
+func signAccessToken(user models.User, session *models.Session, refreshToken string) (gin.H, error) {
+	expiresAt := services.GetSecurityService().GetSessionConfig().AccessTokenExpiry(session)
+	token, err := middleware.GenerateToken(user.ID, user.Username, session.ID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	storage.DB.Model(&models.Session{}).Where("id = ?", session.ID).Update("access_token", middleware.HashToken(token))
+	return gin.H{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(time.Until(expiresAt).Seconds()),
+	}, nil
+}
+
+// RefreshToken 使用刷新令牌换取新的访问令牌和刷新令牌（旧刷新令牌随即作废）
+func RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	ss := services.GetSecurityService()
+	session, refreshToken, err := ss.RefreshSession(req.RefreshToken, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		// 并发刷新时旧令牌已被其他请求轮换，前端改用最新令牌重试
+		if errors.Is(err, services.ErrRefreshTokenRotated) {
+			respondError(c, http.StatusConflict, err.Error())
+			return
+		}
+		respondError(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var user models.User
+	if err := storage.DB.Where("id = ? AND is_deleted = 0", session.UserID).First(&user).Error; err != nil || user.Status == 0 {
+		ss.RevokeSession(session.ID, services.SessionRevokeUserDisabled)
+		respondError(c, http.StatusUnauthorized, "用户不存在或已被禁用")
+		return
+	}
+
+	tokens, err := signAccessToken(user, session, refreshToken)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "生成Token失败")
+		return
+	}
+	respondOK(c, tokens)
+}
+
+// StartSessionCleanupScheduler 定时将过期会话置为失效并清理历史记录
+func StartSessionCleanupScheduler() {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[会话] 清理调度器 panic: %v", r)
+			}
+		}()
+		ss := services.GetSecurityService()
+		ss.CleanupSessions()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			ss.CleanupSessions()
+		}
+	}()
+}
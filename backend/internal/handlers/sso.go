@@ -9,7 +9,6 @@ import (
 
 	"go-syncflow/internal/imclient"
 	"go-syncflow/internal/ldapserver"
-	"go-syncflow/internal/middleware"
 	"go-syncflow/internal/models"
 	"go-syncflow/internal/storage"
 )
@@ -97,26 +96,33 @@ func SSOLogin(c *gin.Context) {
 		return
 	}
 
-	// 匹配本地用户
+	// 匹配本地用户：先按关联身份，再按身份匹配规则（未配置时沿用连接器的匹配字段）
 	var localUser models.User
 	found := false
+	linkedBy := "legacy"
 
-	matchField := conn.IMMatchField
-	if matchField == "" {
-		matchField = "mobile"
+	if userID, ok := storage.FindIdentityUser(conn.ID, imUser.UserID); ok {
+		found = storage.DB.Where("id = ? AND is_deleted = 0", userID).First(&localUser).Error == nil
 	}
-
-	switch matchField {
-	case "mobile":
-		if imUser.Mobile != "" {
-			found = storage.DB.Where("phone = ? AND is_deleted = 0 AND status = 1", imUser.Mobile).First(&localUser).Error == nil
+	if !found {
+		rules := loadIdentityConfig().MatchRules
+		if len(rules) == 0 {
+			switch conn.IMMatchField {
+			case "", "mobile":
+				rules = []models.IdentityMatchRule{{Source: "mobile", Field: "phone"}}
+			case "email":
+				rules = []models.IdentityMatchRule{{Source: "email", Field: "email"}}
+			case "userid":
+				found = storage.DB.Where("ding_talk_uid = ? AND is_deleted = 0", imUser.UserID).First(&localUser).Error == nil
+			}
 		}
-	case "email":
-		if imUser.Email != "" {
-			found = storage.DB.Where("email = ? AND is_deleted = 0 AND status = 1", imUser.Email).First(&localUser).Error == nil
+		if !found {
+			var field string
+			localUser, field, found = matchUserByIdentityRules(conn.ID, rules, func(attr string) string {
+				return imUserField(*imUser, attr)
+			})
+			linkedBy = "match:" + field
 		}
-	case "userid":
-		found = storage.DB.Where("dingtalk_uid = ? AND is_deleted = 0 AND status = 1", imUser.UserID).First(&localUser).Error == nil
 	}
 
 	// 如果未找到，尝试自动注册
@@ -153,8 +159,12 @@ func SSOLogin(c *gin.Context) {
 		}
 
 		found = true
+		linkedBy = "created"
 		log.Printf("[SSO] 自动创建用户: %s (来源: %s)", username, conn.Type)
 	}
+	if found {
+		linkUpstreamIdentity(conn, localUser.ID, imUser.UserID, imUser.Name, linkedBy)
+	}
 
 	if !found {
 		respondError(c, http.StatusNotFound, "未找到匹配的本地用户，请联系管理员")
@@ -167,8 +177,8 @@ func SSOLogin(c *gin.Context) {
 		return
 	}
 
-	// 生成 JWT Token
-	token, err := middleware.GenerateToken(localUser.ID, localUser.Username)
+	// 创建会话并签发令牌
+	tokens, err := issueSessionTokens(c, localUser, clientLocation(c))
 	if err != nil {
 		respondError(c, http.StatusInternalServerError, "生成令牌失败")
 		return
@@ -193,13 +203,11 @@ func SSOLogin(c *gin.Context) {
 
 	log.Printf("[SSO] %s 登录成功: %s (%s)", conn.IMPlatformName(), localUser.Username, imUser.Name)
 
-	respondOK(c, gin.H{
-		"token": token,
-		"user": gin.H{
-			"id":       localUser.ID,
-			"username": localUser.Username,
-			"nickname": localUser.Nickname,
-			"avatar":   localUser.Avatar,
-		},
-	})
+	tokens["user"] = gin.H{
+		"id":       localUser.ID,
+		"username": localUser.Username,
+		"nickname": localUser.Nickname,
+		"avatar":   localUser.Avatar,
+	}
+	respondOK(c, tokens)
 }
@@ -29,26 +29,28 @@ func ListDownstreamConnectors(c *gin.Context) {
 func CreateDownstreamConnector(c *gin.Context) {
 	// 使用自定义 struct 接收（因为 models.Connector 的密码字段是 json:"-"，直接 Bind 会丢失密码）
 	var req struct {
-		Name         string `json:"name" binding:"required"`
-		Type         string `json:"type" binding:"required"`
-		Host         string `json:"host"`
-		Port         int    `json:"port"`
-		UseTLS       bool   `json:"useTls"`
-		BaseDN       string `json:"baseDn"`
-		BindDN       string `json:"bindDn"`
-		BindPassword string `json:"bindPassword"`
-		UPNSuffix    string `json:"upnSuffix"`
-		DBType       string `json:"dbType"`
-		Database     string `json:"database"`
-		DBUser       string `json:"dbUser"`
-		DBPassword   string `json:"dbPassword"`
-		Charset      string `json:"charset"`
-		ServiceName  string `json:"serviceName"`
-		UserTable    string `json:"userTable"`
-		GroupTable   string `json:"groupTable"`
-		RoleTable    string `json:"roleTable"`
-		PwdFormat    string `json:"pwdFormat"`
-		Timeout      int    `json:"timeout"`
+		Name           string `json:"name" binding:"required"`
+		Type           string `json:"type" binding:"required"`
+		Host           string `json:"host"`
+		Port           int    `json:"port"`
+		UseTLS         bool   `json:"useTls"`
+		BaseDN         string `json:"baseDn"`
+		BindDN         string `json:"bindDn"`
+		BindPassword   string `json:"bindPassword"`
+		UPNSuffix      string `json:"upnSuffix"`
+		DBType         string `json:"dbType"`
+		Database       string `json:"database"`
+		DBUser         string `json:"dbUser"`
+		DBPassword     string `json:"dbPassword"`
+		Charset        string `json:"charset"`
+		ServiceName    string `json:"serviceName"`
+		UserTable      string `json:"userTable"`
+		GroupTable     string `json:"groupTable"`
+		RoleTable      string `json:"roleTable"`
+		PwdFormat      string `json:"pwdFormat"`
+		Timeout        int    `json:"timeout"`
+		Config         string `json:"config"`
+		MaxConcurrency int    `json:"maxConcurrency"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "参数错误: "+err.Error())
@@ -63,11 +65,18 @@ func CreateDownstreamConnector(c *gin.Context) {
 		DBType: req.DBType, Database: req.Database, DBUser: req.DBUser, DBPassword: req.DBPassword,
 		Charset: req.Charset, ServiceName: req.ServiceName,
 		UserTable: req.UserTable, GroupTable: req.GroupTable, RoleTable: req.RoleTable,
-		PwdFormat: req.PwdFormat, Timeout: req.Timeout,
+		PwdFormat: req.PwdFormat, Timeout: req.Timeout, Config: req.Config,
+		MaxConcurrency: req.MaxConcurrency,
 	}
 	if conn.Timeout == 0 {
 		conn.Timeout = 5
 	}
+	if conn.IsHTTP() {
+		if _, err := syncer.ParseHTTPRestConfig(conn); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	if err := storage.DB.Create(&conn).Error; err != nil {
 		respondError(c, http.StatusInternalServerError, "创建失败")
@@ -123,7 +132,7 @@ func UpdateDownstreamConnector(c *gin.Context) {
 		"dbType": "db_type", "database": "database", "dbUser": "db_user",
 		"dbPassword": "db_password", "charset": "charset", "serviceName": "service_name",
 		"userTable": "user_table", "groupTable": "group_table", "roleTable": "role_table",
-		"pwdFormat": "pwd_format", "config": "config",
+		"pwdFormat": "pwd_format", "config": "config", "maxConcurrency": "max_concurrency",
 	}
 	updates := make(map[string]interface{})
 	for k, v := range req {
@@ -132,6 +141,21 @@ func UpdateDownstreamConnector(c *gin.Context) {
 		}
 	}
 
+	// HTTP/REST 连接器的请求配置与创建时同样校验
+	if v, ok := updates["config"]; ok && conn.IsHTTP() {
+		raw, isStr := v.(string)
+		if !isStr {
+			respondError(c, http.StatusBadRequest, "配置格式错误")
+			return
+		}
+		check := conn
+		check.Config = raw
+		if _, err := syncer.ParseHTTPRestConfig(check); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	if len(updates) > 0 {
 		storage.DB.Model(&conn).Updates(updates)
 	}
@@ -163,8 +187,25 @@ func TestDownstreamConnector(c *gin.Context) {
 		respondError(c, http.StatusNotFound, "连接器不存在")
 		return
 	}
+	if !conn.IsHTTP() {
+		TestUpstreamConnector(c) // 复用测试逻辑
+		return
+	}
 
-	TestUpstreamConnector(c) // 复用测试逻辑
+	// HTTP/REST 仅作为下游连接器
+	testOK := false
+	testMsg, err := syncer.TestHTTPRestConnection(conn)
+	if err != nil {
+		testMsg = err.Error()
+	} else {
+		testOK = true
+	}
+	storage.DB.Model(&conn).Updates(map[string]interface{}{
+		"last_test_at":  time.Now(),
+		"last_test_ok":  testOK,
+		"last_test_msg": testMsg,
+	})
+	respondOK(c, gin.H{"ok": testOK, "message": testMsg})
 }
 
 // ========== 下游同步规则 CRUD ==========
@@ -192,6 +233,9 @@ func CreateDownstreamRule(c *gin.Context) {
 		SyncGroups       bool     `json:"syncGroups"`
 		SyncRoles        bool     `json:"syncRoles"`
 		PreventPwdChange bool     `json:"preventPwdChange"`
+		DeltaSync        *bool    `json:"deltaSync"`
+		// 定期对账间隔(小时)
+		ReconcileInterval int `json:"reconcileInterval"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		respondError(c, http.StatusBadRequest, "参数错误: "+err.Error())
@@ -236,13 +280,19 @@ func CreateDownstreamRule(c *gin.Context) {
 		SyncGroups:       req.SyncGroups,
 		SyncRoles:        req.SyncRoles,
 		PreventPwdChange: req.PreventPwdChange,
+		DeltaSync:        req.DeltaSync == nil || *req.DeltaSync,
 		Status:           1,
 	}
+	rule.ReconcileInterval = req.ReconcileInterval
 
 	if err := storage.DB.Create(&rule).Error; err != nil {
 		respondError(c, http.StatusInternalServerError, "创建失败")
 		return
 	}
+	if !rule.DeltaSync {
+		// gorm 对零值使用字段默认值，需显式关闭
+		storage.DB.Model(&rule).Update("delta_sync", false)
+	}
 
 	// 创建默认映射
 	var conn models.Connector
@@ -294,6 +344,7 @@ func UpdateDownstreamRule(c *gin.Context) {
 		"cronExpr": "cron_expr", "enableEvent": "enable_event",
 		"syncUsers": "sync_users", "syncGroups": "sync_groups", "syncRoles": "sync_roles",
 		"preventPwdChange": "prevent_pwd_change", "status": "status",
+		"deltaSync": "delta_sync", "reconcileInterval": "reconcile_interval",
 	}
 	updates := make(map[string]interface{})
 	for k, v := range req {
@@ -353,6 +404,14 @@ func TriggerDownstreamSync(c *gin.Context) {
 		return
 	}
 
+	// mode=full 强制全量推送（忽略属性指纹）
+	if c.Query("mode") == "full" {
+		go syncer.ExecuteForcedFullSyncRule(rule, "manual")
+		middleware.RecordOperationLog(c, "下游同步", "强制全量同步", rule.Name, "")
+		respondOK(c, gin.H{"message": "强制全量同步已触发"})
+		return
+	}
+
 	go syncer.ExecuteFullSyncRule(rule, "manual")
 
 	middleware.RecordOperationLog(c, "下游同步", "手动触发", rule.Name, "")
@@ -378,6 +437,10 @@ func BatchUpdateDownstreamRuleMappings(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "参数错误")
 		return
 	}
+	if err := validateMappingRules(req.Mappings); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	storage.DB.Where("sync_rule_id = ?", ruleID).Delete(&models.SyncAttributeMapping{})
 
@@ -395,6 +458,55 @@ func BatchUpdateDownstreamRuleMappings(c *gin.Context) {
 	respondOK(c, gin.H{"count": len(req.Mappings)})
 }
 
+// validateMappingRules 保存前校验转换规则/表达式
+func validateMappingRules(mappings []models.SyncAttributeMapping) error {
+	for _, m := range mappings {
+		if err := syncer.ValidateMappingRule(m); err != nil {
+			return fmt.Errorf("映射 %s → %s 规则错误: %v", m.SourceAttribute, m.TargetAttribute, err)
+		}
+	}
+	return nil
+}
+
+// TestMappingRule 对指定用户试算映射规则
+func TestMappingRule(c *gin.Context) {
+	var req struct {
+		UserID          uint   `json:"userId" binding:"required"`
+		SourceAttribute string `json:"sourceAttribute"`
+		MappingType     string `json:"mappingType"`
+		TransformRule   string `json:"transformRule"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	var user models.User
+	if err := storage.DB.Preload("Roles").Where("is_deleted = 0").First(&user, req.UserID).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	m := models.SyncAttributeMapping{
+		SourceAttribute: req.SourceAttribute,
+		MappingType:     req.MappingType,
+		TransformRule:   req.TransformRule,
+	}
+	if err := syncer.ValidateMappingRule(m); err != nil {
+		respondOK(c, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+	values, err := syncer.PreviewMappingValues(m, user)
+	if err != nil {
+		respondOK(c, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+	if values == nil {
+		values = []string{}
+	}
+	respondOK(c, gin.H{"valid": true, "values": values})
+}
+
 // createDefaultSyncRuleMappings 为新下游同步规则创建默认映射
 func createDefaultSyncRuleMappings(ruleID uint, connType string) {
 	var mappings []models.SyncAttributeMapping
@@ -426,6 +538,14 @@ func createDefaultSyncRuleMappings(ruleID uint, connType string) {
 			{SyncRuleID: ruleID, ObjectType: "user", SourceAttribute: "password_hash", TargetAttribute: "userPassword", MappingType: "mapping", Priority: 7, IsEnabled: true},
 			{SyncRuleID: ruleID, ObjectType: "group", SourceAttribute: "name", TargetAttribute: "ou", MappingType: "mapping", Priority: 1, IsEnabled: true},
 		}
+	case "http_rest":
+		mappings = []models.SyncAttributeMapping{
+			{SyncRuleID: ruleID, ObjectType: "user", SourceAttribute: "username", TargetAttribute: "username", MappingType: "mapping", Priority: 1, IsEnabled: true},
+			{SyncRuleID: ruleID, ObjectType: "user", SourceAttribute: "nickname", TargetAttribute: "name", MappingType: "mapping", Priority: 2, IsEnabled: true},
+			{SyncRuleID: ruleID, ObjectType: "user", SourceAttribute: "email", TargetAttribute: "email", MappingType: "mapping", Priority: 3, IsEnabled: true},
+			{SyncRuleID: ruleID, ObjectType: "user", SourceAttribute: "phone", TargetAttribute: "mobile", MappingType: "mapping", Priority: 4, IsEnabled: true},
+			{SyncRuleID: ruleID, ObjectType: "user", SourceAttribute: "status", TargetAttribute: "status", MappingType: "mapping", Priority: 5, IsEnabled: true},
+		}
 	default:
 		// 数据库类型
 		mappings = []models.SyncAttributeMapping{
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 下游同步队列管理 ==========
+
+// StartSyncQueue 启动下游同步队列
+func StartSyncQueue() {
+	syncer.StartSyncQueue()
+}
+
+// ListSyncJobs 同步任务列表
+func ListSyncJobs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+
+	query := storage.DB.Model(&models.SyncJob{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if connID := c.Query("connectorId"); connID != "" {
+		query = query.Where("connector_id = ?", connID)
+	}
+	if ruleID := c.Query("ruleId"); ruleID != "" {
+		query = query.Where("sync_rule_id = ?", ruleID)
+	}
+	if event := c.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		query = query.Where("username LIKE ?", "%"+keyword+"%")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var jobs []models.SyncJob
+	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&jobs)
+	respondList(c, jobs, total)
+}
+
+// GetSyncQueueStats 各状态任务数
+func GetSyncQueueStats(c *gin.Context) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	storage.DB.Model(&models.SyncJob{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows)
+
+	stats := gin.H{
+		models.SyncJobPending:   int64(0),
+		models.SyncJobRunning:   int64(0),
+		models.SyncJobSuccess:   int64(0),
+		models.SyncJobDead:      int64(0),
+		models.SyncJobDiscarded: int64(0),
+	}
+	for _, r := range rows {
+		stats[r.Status] = r.Count
+	}
+	respondOK(c, stats)
+}
+
+// RetrySyncJob 重试单个死信/已丢弃任务
+func RetrySyncJob(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	if syncer.RetrySyncJobs([]uint{uint(id)}) == 0 {
+		respondError(c, http.StatusBadRequest, "任务不存在或当前状态不可重试")
+		return
+	}
+	middleware.RecordOperationLog(c, "同步队列", "重试任务", fmt.Sprintf("任务ID:%d", id), "")
+	respondOK(c, nil)
+}
+
+// DiscardSyncJob 丢弃单个待执行/死信任务
+func DiscardSyncJob(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	if syncer.DiscardSyncJobs([]uint{uint(id)}) == 0 {
+		respondError(c, http.StatusBadRequest, "任务不存在或当前状态不可丢弃")
+		return
+	}
+	middleware.RecordOperationLog(c, "同步队列", "丢弃任务", fmt.Sprintf("任务ID:%d", id), "")
+	respondOK(c, nil)
+}
+
+// BatchSyncJobs 批量重试/丢弃任务
+func BatchSyncJobs(c *gin.Context) {
+	var req struct {
+		IDs    []uint `json:"ids" binding:"required"`
+		Action string `json:"action" binding:"required"` // retry / discard
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	var affected int64
+	switch req.Action {
+	case "retry":
+		affected = syncer.RetrySyncJobs(req.IDs)
+	case "discard":
+		affected = syncer.DiscardSyncJobs(req.IDs)
+	default:
+		respondError(c, http.StatusBadRequest, "不支持的操作: "+req.Action)
+		return
+	}
+
+	middleware.RecordOperationLog(c, "同步队列", "批量"+req.Action, fmt.Sprintf("%d 个任务", len(req.IDs)), fmt.Sprintf("生效 %d 个", affected))
+	respondOK(c, gin.H{"affected": affected})
+}
+
+// GetSyncQueueConfig 获取同步队列配置
+func GetSyncQueueConfig(c *gin.Context) {
+	respondOK(c, syncer.GetSyncQueueConfig())
+}
+
+// UpdateSyncQueueConfig 更新同步队列配置
+func UpdateSyncQueueConfig(c *gin.Context) {
+	var cfg models.SyncQueueConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	if cfg.MaxAttempts < 1 || cfg.BackoffBaseSeconds < 1 || cfg.DefaultConcurrency < 1 {
+		respondError(c, http.StatusBadRequest, "最大尝试次数、重试间隔和并发数必须大于 0")
+		return
+	}
+
+	data, _ := json.Marshal(cfg)
+	if err := storage.SetConfig("sync_queue", string(data)); err != nil {
+		respondError(c, http.StatusInternalServerError, "保存失败")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "同步队列", "更新配置", "sync_queue", string(data))
+	respondOK(c, nil)
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	gosync "sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/ldapserver"
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 下游规则对账 ==========
+
+// reconcileActions 各类条目允许的处理动作
+var reconcileActions = map[string]map[string]bool{
+	"orphan":  {"adopt": true, "delete": true},    // 认领为本地用户 / 删除目标对象
+	"missing": {"overwrite": true},                // 推送本地用户到目标端
+	"drift":   {"adopt": true, "overwrite": true}, // 以目标值回写本地 / 以本地值覆盖目标
+}
+
+// reconcileReportResponse 报告详情（含条目）
+func reconcileReportResponse(report models.SyncReconcileReport) gin.H {
+	var items []syncer.ReconcileItem
+	json.Unmarshal([]byte(report.Items), &items)
+	if items == nil {
+		items = []syncer.ReconcileItem{}
+	}
+	return gin.H{"report": report, "items": items}
+}
+
+// reconcileKeepReports 每个规则保留的对账报告数（定期对账会持续生成新报告）
+const reconcileKeepReports = 20
+
+var (
+	reconcileMu      gosync.Mutex
+	reconcileRunning = make(map[uint]bool) // ruleID -> 正在对账
+)
+
+// runRuleReconcile 生成并保存规则的对账报告；同一规则同时只允许一个对账在执行
+func runRuleReconcile(rule models.SyncRule, createdBy string) (*models.SyncReconcileReport, error) {
+	reconcileMu.Lock()
+	if reconcileRunning[rule.ID] {
+		reconcileMu.Unlock()
+		return nil, fmt.Errorf("该规则正在对账，请稍后查看结果")
+	}
+	reconcileRunning[rule.ID] = true
+	reconcileMu.Unlock()
+	defer func() {
+		reconcileMu.Lock()
+		delete(reconcileRunning, rule.ID)
+		reconcileMu.Unlock()
+	}()
+
+	now := time.Now()
+	storage.DB.Model(&models.SyncRule{}).Where("id = ?", rule.ID).Update("last_reconcile_at", now)
+
+	result, err := syncer.BuildReconcileReport(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	itemsJSON, _ := json.Marshal(result.Items)
+	report := models.SyncReconcileReport{
+		SyncRuleID:  rule.ID,
+		ConnectorID: rule.ConnectorID,
+		LocalTotal:  result.LocalTotal,
+		TargetTotal: result.TargetTotal,
+		Orphans:     result.Orphans,
+		Missing:     result.Missing,
+		Drifted:     result.Drifted,
+		Items:       string(itemsJSON),
+		Duration:    result.Duration,
+		CreatedBy:   createdBy,
+	}
+	if err := storage.DB.Create(&report).Error; err != nil {
+		return nil, fmt.Errorf("保存对账报告失败")
+	}
+
+	// 清理超出保留数量的旧报告
+	var keepIDs []uint
+	storage.DB.Model(&models.SyncReconcileReport{}).Where("sync_rule_id = ?", rule.ID).
+		Order("id DESC").Limit(reconcileKeepReports).Pluck("id", &keepIDs)
+	if len(keepIDs) == reconcileKeepReports {
+		storage.DB.Where("sync_rule_id = ? AND id NOT IN ?", rule.ID, keepIDs).Delete(&models.SyncReconcileReport{})
+	}
+	return &report, nil
+}
+
+// RunDownstreamReconcile 生成对账报告
+func RunDownstreamReconcile(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var rule models.SyncRule
+	if err := storage.DB.First(&rule, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "同步规则不存在")
+		return
+	}
+
+	report, err := runRuleReconcile(rule, c.GetString("username"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "对账失败: "+err.Error())
+		return
+	}
+
+	middleware.RecordOperationLog(c, "下游同步", "生成对账报告", rule.Name,
+		fmt.Sprintf("孤儿%d, 缺失%d, 漂移%d", report.Orphans, report.Missing, report.Drifted))
+	respondOK(c, reconcileReportResponse(*report))
+}
+
+// StartReconcileScheduler 定期对账调度：按规则配置的间隔在后台生成对账报告
+func StartReconcileScheduler() {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			processScheduledReconciles()
+		}
+	}()
+}
+
+// processScheduledReconciles 执行到期的定期对账（逐个执行，避免同时扫描多个目标端）
+func processScheduledReconciles() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[对账] 定期对账panic: %v", r)
+		}
+	}()
+
+	var rules []models.SyncRule
+	storage.DB.Where("direction = ? AND status = 1 AND reconcile_interval > 0", "downstream").Find(&rules)
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.LastReconcileAt != nil && now.Sub(*rule.LastReconcileAt) < time.Duration(rule.ReconcileInterval)*time.Hour {
+			continue
+		}
+		report, err := runRuleReconcile(rule, "system")
+		if err != nil {
+			log.Printf("[对账] 规则 %s 定期对账失败: %v", rule.Name, err)
+			continue
+		}
+		log.Printf("[对账] 规则 %s 定期对账完成: 孤儿%d, 缺失%d, 漂移%d", rule.Name, report.Orphans, report.Missing, report.Drifted)
+	}
+}
+
+// GetDownstreamReconcile 获取规则最新对账报告
+func GetDownstreamReconcile(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var report models.SyncReconcileReport
+	if err := storage.DB.Where("sync_rule_id = ?", id).Order("id DESC").First(&report).Error; err != nil {
+		respondOK(c, nil)
+		return
+	}
+	respondOK(c, reconcileReportResponse(report))
+}
+
+// ResolveReconcileItem 处理单个对账条目
+func ResolveReconcileItem(c *gin.Context) {
+	ruleID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	reportID, _ := strconv.ParseUint(c.Param("reportId"), 10, 32)
+	index, _ := strconv.Atoi(c.Param("index"))
+
+	var req struct {
+		Action string `json:"action" binding:"required"` // adopt / overwrite / delete
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	var rule models.SyncRule
+	if err := storage.DB.First(&rule, ruleID).Error; err != nil {
+		respondError(c, http.StatusNotFound, "同步规则不存在")
+		return
+	}
+	// 先将条目标记为处理中，并发请求不会重复执行同一条目的动作
+	var item syncer.ReconcileItem
+	err := updateReconcileItems(uint(reportID), uint(ruleID), func(items []syncer.ReconcileItem) error {
+		if index < 0 || index >= len(items) {
+			return fmt.Errorf("对账条目不存在")
+		}
+		item = items[index]
+		if item.Resolved != "" {
+			return fmt.Errorf("该条目已处理")
+		}
+		if !reconcileActions[item.Kind][req.Action] {
+			return fmt.Errorf("该条目不支持此操作")
+		}
+		items[index].Resolved = reconcileProcessing
+		return nil
+	})
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch item.Kind + ":" + req.Action {
+	case "orphan:adopt":
+		err = adoptReconcileOrphan(rule, item)
+	case "orphan:delete":
+		err = syncer.DeleteTargetObject(rule, item)
+	case "missing:overwrite", "drift:overwrite":
+		var user models.User
+		if err = storage.DB.Preload("Roles").Where("is_deleted = 0").First(&user, item.UserID).Error; err != nil {
+			err = fmt.Errorf("本地用户不存在")
+			break
+		}
+		err = syncer.PushUserForRule(rule, user)
+	case "drift:adopt":
+		err = adoptReconcileDrift(rule, item)
+	}
+
+	resolved := req.Action
+	if err != nil {
+		resolved = "" // 处理失败，恢复为未处理
+	}
+	if uerr := updateReconcileItems(uint(reportID), uint(ruleID), func(items []syncer.ReconcileItem) error {
+		items[index].Resolved = resolved
+		return nil
+	}); uerr != nil {
+		log.Printf("[对账] 更新条目状态失败: report=%d index=%d err=%v", reportID, index, uerr)
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "处理失败: "+err.Error())
+		return
+	}
+
+	item.Resolved = resolved
+	middleware.RecordOperationLog(c, "下游同步", "处理对账条目", rule.Name,
+		fmt.Sprintf("%s %s: %s", item.Kind, item.Key, req.Action))
+	respondOK(c, item)
+}
+
+// reconcileProcessing 条目正在处理中
+const reconcileProcessing = "processing"
+
+// updateReconcileItems 读取-修改-写回报告条目，按版本号乐观锁重试，避免并发处理丢失更新
+func updateReconcileItems(reportID, ruleID uint, modify func(items []syncer.ReconcileItem) error) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		var report models.SyncReconcileReport
+		if err := storage.DB.Where("id = ? AND sync_rule_id = ?", reportID, ruleID).First(&report).Error; err != nil {
+			return fmt.Errorf("对账报告不存在")
+		}
+		var items []syncer.ReconcileItem
+		json.Unmarshal([]byte(report.Items), &items)
+		if err := modify(items); err != nil {
+			return err
+		}
+		itemsJSON, _ := json.Marshal(items)
+		res := storage.DB.Model(&models.SyncReconcileReport{}).
+			Where("id = ? AND version = ?", report.ID, report.Version).
+			Updates(map[string]interface{}{"items": string(itemsJSON), "version": report.Version + 1})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			return nil
+		}
+	}
+	return fmt.Errorf("对账报告正被其他操作修改，请重试")
+}
+
+// adoptReconcileOrphan 将目标端孤儿对象认领为本地用户
+// 不生成下游同步事件，避免以随机密码覆盖目标端已有账号；下次全量同步时按映射对齐
+func adoptReconcileOrphan(rule models.SyncRule, item syncer.ReconcileItem) error {
+	if item.Key == "" {
+		return fmt.Errorf("目标对象缺少用户名")
+	}
+	var count int64
+	storage.DB.Model(&models.User{}).Where("username = ?", item.Key).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("用户名已存在")
+	}
+
+	rawPwd := generateRandomPassword()
+	hashed, err := hashPasswordForStorage(rawPwd, false)
+	if err != nil {
+		return err
+	}
+	user := models.User{
+		Username:        item.Key,
+		Password:        hashed,
+		SambaNTPassword: ldapserver.ComputeNTHash(rawPwd),
+		Status:          1,
+		Source:          "local",
+	}
+	fields := make(map[string]interface{})
+	for attr, col := range syncer.ReverseMappedFields(rule) {
+		if v := item.TargetAttrs[attr]; v != "" {
+			fields[col] = v
+		}
+	}
+
+	return storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if len(fields) > 0 {
+			if err := tx.Model(&user).Updates(fields).Error; err != nil {
+				return err
+			}
+		}
+		var defaultRole models.Role
+		if tx.Where("code = ?", "user").First(&defaultRole).Error == nil {
+			tx.Create(&models.UserRole{UserID: user.ID, RoleID: defaultRole.ID})
+		}
+		return nil
+	})
+}
+
+// adoptReconcileDrift 以目标端的值回写本地用户（仅直接映射的可回写字段）
+func adoptReconcileDrift(rule models.SyncRule, item syncer.ReconcileItem) error {
+	var user models.User
+	if err := storage.DB.Preload("Roles").Where("is_deleted = 0").First(&user, item.UserID).Error; err != nil {
+		return fmt.Errorf("本地用户不存在")
+	}
+
+	reverse := syncer.ReverseMappedFields(rule)
+	updates := make(map[string]interface{})
+	for _, d := range item.Drifts {
+		if col, ok := reverse[d.Attribute]; ok {
+			updates[col] = d.Target
+		}
+	}
+	if len(updates) == 0 {
+		return fmt.Errorf("漂移属性均不可回写到本地")
+	}
+	return updateUserWithSyncEvent(user, updates, models.SyncEventUserUpdate, "")
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"go-syncflow/internal/imclient"
 	"go-syncflow/internal/ldapserver"
@@ -554,14 +555,27 @@ func executeIMUpstreamSync(conn models.Connector, rule models.SyncRule) syncer.U
 	// 2. 拉取用户
 	depts, _ := client.GetAllDepartments()
 	allUsers := make(map[string]imclient.IMUserInfo) // 按 UserID 去重
+	deptParents := make(map[string]string)           // 部门ID -> 父部门ID
+	deptLeaders := make(map[string][]string)         // 部门ID -> 负责人用户ID
 	for _, dept := range depts {
+		deptParents[dept.DeptID] = dept.ParentID
 		users, err := client.GetDepartmentUsers(dept.DeptID)
 		if err != nil {
 			log.Printf("[上游同步] 获取部门 %s 用户失败: %v", dept.Name, err)
 			continue
 		}
 		for _, u := range users {
+			if u.DeptLeader {
+				deptLeaders[dept.DeptID] = append(deptLeaders[dept.DeptID], u.UserID)
+			}
+			// 同一用户出现在多个部门：以首次出现的部门为主部门，其余追加到 DeptIDs
+			if existing, ok := allUsers[u.UserID]; ok {
+				existing.DeptIDs = append(existing.DeptIDs, dept.DeptID)
+				allUsers[u.UserID] = existing
+				continue
+			}
 			u.DeptName = dept.Name
+			u.DeptIDs = []string{dept.DeptID}
 			allUsers[u.UserID] = u
 		}
 	}
@@ -586,7 +600,10 @@ func executeIMUpstreamSync(conn models.Connector, rule models.SyncRule) syncer.U
 		}
 	}
 
-	// 5. 检查本地用户是否在 IM 端已删除（自动禁用）
+	// 5. 同步汇报关系（需在所有用户落库之后，上级可能是本次新建的用户）
+	syncIMManagers(conn, resolveIMManagers(allUsers, deptParents, deptLeaders))
+
+	// 6. 检查本地用户是否在 IM 端已删除（自动禁用）
 	if rule.AutoDisableUser {
 		result.UsersDisabled = disableRemovedIMUsers(conn, processedUIDs)
 	}
@@ -724,13 +741,22 @@ func syncIMUserToLocal(conn models.Connector, rule models.SyncRule, imUser imcli
 		Department: imUser.DeptName,
 	}
 
-	// 查找本地用户：优先已关联，再按前端配置的匹配字段
+	// 查找本地用户：优先已关联身份，再按身份匹配规则
 	var localUser models.User
 	found := false
+	linkedBy := ""
+	identityCfg := loadIdentityConfig()
+
+	// 第0层：按关联身份（连接器 + 平台用户ID）匹配
+	if userID, ok := storage.FindIdentityUser(conn.ID, imUser.UserID); ok {
+		found = storage.DB.Where("id = ? AND is_deleted = 0", userID).First(&localUser).Error == nil
+	}
 
-	// 第1层：按 IM 平台用户ID 匹配（已关联的用户一定能匹配到）
-	if imUser.UserID != "" {
-		found = storage.DB.Where("ding_talk_uid = ? AND is_deleted = 0", imUser.UserID).First(&localUser).Error == nil
+	// 第1层：按 IM 平台用户ID 匹配（兼容未建立关联身份的历史数据；已在本连接器关联其他身份的用户除外）
+	if !found && imUser.UserID != "" {
+		found = storage.DB.Where("ding_talk_uid = ? AND is_deleted = 0", imUser.UserID).First(&localUser).Error == nil &&
+			!storage.UserLinkedConnectors(localUser.ID)[conn.ID]
+		linkedBy = "legacy"
 	}
 
 	// 第2层：按 IM 缓存表中的 local_user_id 匹配
@@ -741,24 +767,26 @@ func syncIMUserToLocal(conn models.Connector, rule models.SyncRule, imUser imcli
 		}
 	}
 
-	// 第3层：严格按前端配置的匹配字段查找（唯一匹配规则）
+	// 第3层：按身份匹配规则依次尝试（唯一匹配）；未配置规则时沿用连接器的匹配字段
 	if !found {
-		matchField := conn.IMMatchField
-		if matchField == "" {
-			matchField = "mobile"
-		}
-		switch matchField {
-		case "mobile":
-			if imUser.Mobile != "" {
-				found = storage.DB.Where("phone = ? AND is_deleted = 0", imUser.Mobile).First(&localUser).Error == nil
-			}
-		case "email":
-			if imUser.Email != "" && strings.Contains(imUser.Email, "@") && strings.Index(imUser.Email, "@") > 0 {
-				found = storage.DB.Where("email = ? AND is_deleted = 0", imUser.Email).First(&localUser).Error == nil
+		rules := identityCfg.MatchRules
+		if len(rules) == 0 {
+			switch conn.IMMatchField {
+			case "", "mobile":
+				rules = []models.IdentityMatchRule{{Source: "mobile", Field: "phone"}}
+			case "email":
+				rules = []models.IdentityMatchRule{{Source: "email", Field: "email"}}
 			}
-		case "userid":
-			// 已在第1层处理
 		}
+		var field string
+		localUser, field, found = matchUserByIdentityRules(conn.ID, rules, func(attr string) string {
+			v := imUserField(imUser, attr)
+			if attr == "email" && strings.Index(v, "@") <= 0 {
+				return "" // 无效邮箱不参与匹配（@前必须有内容）
+			}
+			return v
+		})
+		linkedBy = "match:" + field
 	}
 
 	if !found && rule.AutoCreateUser {
@@ -768,24 +796,11 @@ func syncIMUserToLocal(conn models.Connector, rule models.SyncRule, imUser imcli
 
 		username := imclient.GenerateUsername(conn.IMUsernameRule, &imUser)
 
-		// 查找部门对应的群组：优先按 IM 部门 ID 匹配，避免同名部门冲突
+		// 所属部门对应的本地群组，第一个为主部门
+		groupIDs := resolveIMUserGroups(imUser)
 		var groupID uint
-		if imUser.DeptID != "" {
-			// 通过 IM 部门缓存表获取远程部门 ID -> 本地群组
-			remoteDeptID, _ := strconv.ParseInt(imUser.DeptID, 10, 64)
-			if remoteDeptID > 0 {
-				var g models.UserGroup
-				if storage.DB.Where("ding_talk_dept_id = ?", remoteDeptID).First(&g).Error == nil {
-					groupID = g.ID
-				}
-			}
-		}
-		// 降级：按部门名称匹配（兼容旧数据）
-		if groupID == 0 && imUser.DeptName != "" {
-			var g models.UserGroup
-			if storage.DB.Where("name = ?", imUser.DeptName).First(&g).Error == nil {
-				groupID = g.ID
-			}
+		if len(groupIDs) > 0 {
+			groupID = groupIDs[0]
 		}
 
 		// 无效邮箱不存入本地用户（@前必须有内容）
@@ -808,49 +823,76 @@ func syncIMUserToLocal(conn models.Connector, rule models.SyncRule, imUser imcli
 			Source:          conn.Type,
 			DingTalkUID:     imUser.UserID,
 		}
-		if err := storage.DB.Create(&newUser).Error; err != nil {
+		// 用户、角色、分组、IM 关联与下游同步任务在同一事务内写入
+		err := storage.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newUser).Error; err != nil {
+				return err
+			}
+
+			// 分配默认角色
+			if conn.IMDefaultRoleID > 0 {
+				if err := tx.Create(&models.UserRole{UserID: newUser.ID, RoleID: conn.IMDefaultRoleID}).Error; err != nil {
+					return err
+				}
+			}
+			// 默认分配"普通用户"角色
+			var normalRole models.Role
+			if tx.Where("code = ?", "user").First(&normalRole).Error == nil {
+				var existUR models.UserRole
+				if tx.Where("user_id = ? AND role_id = ?", newUser.ID, normalRole.ID).First(&existUR).Error != nil {
+					if err := tx.Create(&models.UserRole{UserID: newUser.ID, RoleID: normalRole.ID}).Error; err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := storage.SetUserGroups(tx, newUser.ID, groupID, groupIDs); err != nil {
+				return err
+			}
+
+			// 更新 IM 用户关联
+			if err := tx.Model(&models.IMUser{}).Where("connector_id = ? AND remote_user_id = ?", conn.ID, imUser.UserID).Update("local_user_id", newUser.ID).Error; err != nil {
+				return err
+			}
+
+			if err := applyUpstreamAttributeMappings(tx, rule, imUser, newFieldPrecedence(identityCfg, conn, newUser.ID)); err != nil {
+				return err
+			}
+
+			// 触发下游同步
+			return syncer.EnqueueUserSyncEvent(tx, models.SyncEventUserCreate, newUser.ID, rawPassword)
+		})
+		if err != nil {
 			detail.Action = "failed"
 			detail.Message = "创建用户失败: " + err.Error()
 			return detail
 		}
+		syncer.WakeSyncQueue()
 
 		detail.LocalUser = username
 		detail.Action = "created"
 
-		// 分配默认角色
-		if conn.IMDefaultRoleID > 0 {
-			storage.DB.Create(&models.UserRole{UserID: newUser.ID, RoleID: conn.IMDefaultRoleID})
-		}
-		// 默认分配"普通用户"角色
-		var normalRole models.Role
-		if storage.DB.Where("code = ?", "user").First(&normalRole).Error == nil {
-			var existUR models.UserRole
-			if storage.DB.Where("user_id = ? AND role_id = ?", newUser.ID, normalRole.ID).First(&existUR).Error != nil {
-				storage.DB.Create(&models.UserRole{UserID: newUser.ID, RoleID: normalRole.ID})
-			}
-		}
-
-		// 更新 IM 用户关联
-		storage.DB.Model(&models.IMUser{}).Where("connector_id = ? AND remote_user_id = ?", conn.ID, imUser.UserID).Update("local_user_id", newUser.ID)
-
-		// 触发下游同步
-		syncer.DispatchSyncEvent("user_create", newUser.ID, rawPassword)
+		linkUpstreamIdentity(conn, newUser.ID, imUser.UserID, imUser.Name, "created")
 
 		// 发送账号通知
 		go sendAccountCreatedNotification(newUser, rawPassword)
 
 	} else if found {
-		// 更新已有用户（保留密码）
+		// 先建立关联身份，字段权威来源判定依赖用户已关联的连接器
+		linkUpstreamIdentity(conn, localUser.ID, imUser.UserID, imUser.Name, linkedBy)
+		precedence := newFieldPrecedence(identityCfg, conn, localUser.ID)
+
+		// 更新已有用户（保留密码）；手机号、邮箱默认只补空值，当前来源是其权威来源时才覆盖
 		updates := map[string]interface{}{
 			"nickname":        imUser.Name,
 			"avatar":          imUser.Avatar,
 			"job_title":       imUser.JobTitle,
 			"department_name": imUser.DeptName,
 		}
-		if imUser.Mobile != "" && localUser.Phone == "" {
+		if imUser.Mobile != "" && (localUser.Phone == "" || precedence.owns("phone")) {
 			updates["phone"] = imUser.Mobile
 		}
-		if imUser.Email != "" && localUser.Email == "" {
+		if imUser.Email != "" && (localUser.Email == "" || precedence.owns("email")) {
 			updates["email"] = imUser.Email
 		}
 		if localUser.Source == "" || localUser.Source == "local" {
@@ -860,24 +902,71 @@ func syncIMUserToLocal(conn models.Connector, rule models.SyncRule, imUser imcli
 			updates["ding_talk_uid"] = imUser.UserID
 		}
 
-		// 更新群组
-		if imUser.DeptName != "" {
-			var g models.UserGroup
-			if storage.DB.Where("name = ?", imUser.DeptName).First(&g).Error == nil {
-				updates["group_id"] = g.ID
-			}
+		// 按字段权威来源过滤：锁定字段及由优先级更高的来源负责的字段不覆盖
+		precedence.filter(updates)
+		written := make([]string, 0, len(updates)+1)
+		for field := range updates {
+			written = append(written, field)
 		}
 
-		storage.DB.Model(&localUser).Updates(updates)
+		// 更新群组：保留仍在平台部门中的主部门，避免多部门用户每次同步来回切换；
+		// 本地创建的群组（未关联 IM 部门）中的成员关系不受上游影响
+		groupIDs := resolveIMUserGroups(imUser)
+		updateGroups := len(groupIDs) > 0 && precedence.allows("group_id")
+
+		// 用户字段、分组、IM 关联与下游同步任务在同一事务内写入
+		err := storage.DB.Transaction(func(tx *gorm.DB) error {
+			if len(updates) > 0 {
+				if err := tx.Model(&localUser).Updates(updates).Error; err != nil {
+					return err
+				}
+			}
+			if updateGroups {
+				primary := groupIDs[0]
+				for _, id := range groupIDs {
+					if id == localUser.GroupID {
+						primary = id
+						break
+					}
+				}
+				var localOnly []uint
+				tx.Model(&models.UserGroupMember{}).
+					Joins("JOIN user_groups ON user_groups.id = user_group_members.group_id AND user_groups.ding_talk_dept_id = 0").
+					Where("user_group_members.user_id = ?", localUser.ID).
+					Pluck("user_group_members.group_id", &localOnly)
+				if err := storage.SetUserGroups(tx, localUser.ID, primary, append(groupIDs, localOnly...)); err != nil {
+					return err
+				}
+			}
+
+			// 更新 IM 用户关联
+			if err := tx.Model(&models.IMUser{}).Where("connector_id = ? AND remote_user_id = ?", conn.ID, imUser.UserID).Update("local_user_id", localUser.ID).Error; err != nil {
+				return err
+			}
+
+			if updateGroups {
+				written = append(written, "group_id")
+			}
+			if err := precedence.claim(tx, written...); err != nil {
+				return err
+			}
+			if err := applyUpstreamAttributeMappings(tx, rule, imUser, precedence); err != nil {
+				return err
+			}
 
-		// 更新 IM 用户关联
-		storage.DB.Model(&models.IMUser{}).Where("connector_id = ? AND remote_user_id = ?", conn.ID, imUser.UserID).Update("local_user_id", localUser.ID)
+			// 触发下游更新
+			return syncer.EnqueueUserSyncEvent(tx, models.SyncEventUserUpdate, localUser.ID, "")
+		})
+		if err != nil {
+			detail.LocalUser = localUser.Username
+			detail.Action = "failed"
+			detail.Message = "更新用户失败: " + err.Error()
+			return detail
+		}
+		syncer.WakeSyncQueue()
 
 		detail.LocalUser = localUser.Username
 		detail.Action = "updated"
-
-		// 触发下游更新
-		syncer.DispatchSyncEvent("user_update", localUser.ID, "")
 	} else {
 		detail.Action = "skipped"
 		detail.Message = "未找到匹配用户且未启用自动创建"
@@ -886,20 +975,250 @@ func syncIMUserToLocal(conn models.Connector, rule models.SyncRule, imUser imcli
 	return detail
 }
 
+// applyUpstreamAttributeMappings 按上游规则中目标为 ext.<key> 的映射写入扩展属性
+// 上游未返回的字段保留本地原值；校验失败或无权覆盖（锁定/非权威来源）的值跳过；
+// 在用户写入事务内调用，扩展属性与用户字段一并提交或回滚
+func applyUpstreamAttributeMappings(tx *gorm.DB, rule models.SyncRule, imUser imclient.IMUserInfo, precedence *fieldPrecedence) error {
+	userID := precedence.userID
+	var mappings []models.SyncAttributeMapping
+	tx.Where("sync_rule_id = ? AND object_type = ? AND is_enabled = ? AND target_attribute LIKE ?",
+		rule.ID, "user", true, "ext.%").Order("priority").Find(&mappings)
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	defs := make(map[string]models.UserAttributeDef)
+	for _, d := range storage.ListUserAttributeDefs() {
+		defs[d.Key] = d
+	}
+
+	// 转换/表达式映射与下游同步使用同一套规则：.value 为上游字段值，其他字段读取本地用户
+	var localUser models.User
+	if err := tx.Preload("Roles").First(&localUser, userID).Error; err != nil {
+		return err
+	}
+
+	values := make(map[string]string)
+	for _, m := range mappings {
+		key := strings.TrimPrefix(m.TargetAttribute, "ext.")
+		d, ok := defs[key]
+		if !ok || !precedence.allows(m.TargetAttribute) {
+			continue
+		}
+		v, err := syncer.TransformMappingValue(m, localUser, imUserField(imUser, m.SourceAttribute))
+		if err != nil {
+			log.Printf("[上游同步] 用户 %s 扩展属性 %s 转换失败: %v", imUser.Name, key, err)
+			continue
+		}
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		nv, err := normalizeAttributeValue(d, v)
+		if err == nil {
+			err = checkAttributeUnique(d, userID, nv)
+		}
+		if err != nil {
+			log.Printf("[上游同步] 用户 %s 扩展属性 %s 跳过: %v", imUser.Name, key, err)
+			continue
+		}
+		values[key] = nv
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if err := storage.SaveUserAttributes(tx, userID, values); err != nil {
+		return err
+	}
+	written := make([]string, 0, len(values))
+	for key := range values {
+		written = append(written, "ext."+key)
+	}
+	return precedence.claim(tx, written...)
+}
+
+// imUserField 读取 IM 用户字段：标准字段或平台原始字段
+func imUserField(u imclient.IMUserInfo, attr string) string {
+	switch attr {
+	case "userid", "userId", "user_id":
+		return u.UserID
+	case "name":
+		return u.Name
+	case "mobile":
+		return u.Mobile
+	case "email":
+		return u.Email
+	case "avatar":
+		return u.Avatar
+	case "title", "position", "job_title":
+		return u.JobTitle
+	case "deptId":
+		return u.DeptID
+	case "deptName", "department":
+		return u.DeptName
+	}
+	return u.Extra[attr]
+}
+
+// resolveIMUserGroups 将 IM 用户所属部门解析为本地群组ID（主部门在前）
+// 优先按 IM 部门 ID 匹配，避免同名部门冲突；均未匹配时按主部门名称降级匹配（兼容旧数据）
+func resolveIMUserGroups(imUser imclient.IMUserInfo) []uint {
+	deptIDs := imUser.DeptIDs
+	if len(deptIDs) == 0 && imUser.DeptID != "" {
+		deptIDs = []string{imUser.DeptID}
+	}
+	var groupIDs []uint
+	seen := make(map[uint]bool)
+	for _, did := range deptIDs {
+		remoteDeptID, _ := strconv.ParseInt(did, 10, 64)
+		if remoteDeptID <= 0 {
+			continue
+		}
+		var g models.UserGroup
+		if storage.DB.Where("ding_talk_dept_id = ?", remoteDeptID).First(&g).Error == nil && !seen[g.ID] {
+			seen[g.ID] = true
+			groupIDs = append(groupIDs, g.ID)
+		}
+	}
+	if len(groupIDs) == 0 && imUser.DeptName != "" {
+		var g models.UserGroup
+		if storage.DB.Where("name = ?", imUser.DeptName).First(&g).Error == nil {
+			groupIDs = append(groupIDs, g.ID)
+		}
+	}
+	return groupIDs
+}
+
+// resolveIMManagers 解析每个 IM 用户的直属上级（平台用户ID）
+// 优先使用平台返回的直属上级；缺失时取所在部门负责人，本人即负责人时逐级取上级部门负责人
+func resolveIMManagers(allUsers map[string]imclient.IMUserInfo, deptParents map[string]string, deptLeaders map[string][]string) map[string]string {
+	// 飞书 leader_user_id 默认为 open_id，用户ID 可能取的是 user_id，建立索引转换
+	openIDs := make(map[string]string)
+	for uid, u := range allUsers {
+		if oid := u.Extra["open_id"]; oid != "" {
+			openIDs[oid] = uid
+		}
+	}
+
+	managers := make(map[string]string, len(allUsers))
+	for uid, u := range allUsers {
+		mgr := u.ManagerID
+		if _, ok := allUsers[mgr]; !ok {
+			mgr = openIDs[mgr]
+		}
+		for dept, depth := u.DeptID, 0; mgr == "" && dept != "" && depth < maxReportingDepth; depth++ {
+			leaders := deptLeaders[dept]
+			isLeader := false
+			for _, l := range leaders {
+				if l == uid {
+					isLeader = true
+					break
+				}
+			}
+			if !isLeader && len(leaders) > 0 {
+				mgr = leaders[0]
+			}
+			dept = deptParents[dept]
+		}
+		if mgr == uid {
+			mgr = ""
+		}
+		managers[uid] = mgr
+	}
+	return managers
+}
+
+// syncIMManagers 将平台汇报关系写入本地用户的 ManagerID，变更时触发下游更新
+func syncIMManagers(conn models.Connector, managers map[string]string) {
+	var records []models.IMUser
+	storage.DB.Where("connector_id = ? AND local_user_id > 0", conn.ID).Find(&records)
+	localIDs := make(map[string]uint, len(records))
+	for _, r := range records {
+		localIDs[r.RemoteUserID] = r.LocalUserID
+	}
+
+	identityCfg := loadIdentityConfig()
+	changed := 0
+	for uid, mgr := range managers {
+		storage.DB.Model(&models.IMUser{}).Where("connector_id = ? AND remote_user_id = ?", conn.ID, uid).Update("manager_id", mgr)
+
+		userID := localIDs[uid]
+		if userID == 0 {
+			continue
+		}
+		managerID := localIDs[mgr]
+		var user models.User
+		if storage.DB.Select("id, manager_id").Where("is_deleted = 0").First(&user, userID).Error != nil || user.ManagerID == managerID {
+			continue
+		}
+		precedence := newFieldPrecedence(identityCfg, conn, userID)
+		if !precedence.allows("manager_id") {
+			continue
+		}
+		if err := validateManager(userID, managerID); err != nil {
+			log.Printf("[上游同步] 用户 %s 上级未更新: %v", uid, err)
+			continue
+		}
+		err := storage.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("manager_id", managerID).Error; err != nil {
+				return err
+			}
+			if err := precedence.claim(tx, "manager_id"); err != nil {
+				return err
+			}
+			return syncer.EnqueueUserSyncEvent(tx, models.SyncEventUserUpdate, userID, "")
+		})
+		if err != nil {
+			log.Printf("[上游同步] 用户 %s 上级更新失败: %v", uid, err)
+			continue
+		}
+		changed++
+	}
+	if changed > 0 {
+		syncer.WakeSyncQueue()
+		log.Printf("[上游同步] 汇报关系更新 %d 人", changed)
+	}
+}
+
 func disableRemovedIMUsers(conn models.Connector, activeUIDs map[string]bool) int {
 	// 查找所有已关联本地用户的 IM 用户
 	var imUsers []models.IMUser
 	storage.DB.Where("connector_id = ? AND local_user_id > 0", conn.ID).Find(&imUsers)
 
+	lifecycle := syncer.GetLifecycleConfig()
 	disabled := 0
 	for _, imu := range imUsers {
 		if !activeUIDs[imu.RemoteUserID] {
+			// 启用生命周期时走离职流程（禁用、移入离职 OU、到期移除分组与删除），已在离职流程中的跳过
+			if lifecycle.Enabled {
+				var user models.User
+				if storage.DB.Where("id = ? AND is_deleted = 0", imu.LocalUserID).First(&user).Error != nil ||
+					user.LifecycleState == models.LifecycleLeft || user.Username == "admin" {
+					continue
+				}
+				if err := startLeave(user, time.Now(), conn.Name, "上游已移除"); err != nil {
+					log.Printf("[上游同步] 用户 %s 发起离职失败: %v", user.Username, err)
+					continue
+				}
+				disabled++
+				continue
+			}
 			// IM 端已删除，禁用本地用户
-			storage.DB.Model(&models.User{}).Where("id = ? AND status = 1", imu.LocalUserID).Update("status", 0)
-			syncer.DispatchSyncEvent("user_disable", imu.LocalUserID, "")
+			err := storage.DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Model(&models.User{}).Where("id = ? AND status = 1", imu.LocalUserID).Update("status", 0).Error; err != nil {
+					return err
+				}
+				return syncer.EnqueueUserSyncEvent(tx, models.SyncEventUserDisable, imu.LocalUserID, "")
+			})
+			if err != nil {
+				log.Printf("[上游同步] 禁用用户 %d 失败: %v", imu.LocalUserID, err)
+				continue
+			}
 			disabled++
 		}
 	}
+	if disabled > 0 {
+		syncer.WakeSyncQueue()
+	}
 	return disabled
 }
 
@@ -247,6 +247,10 @@ func CreateMapping(c *gin.Context) {
 	if req.MappingType == "" {
 		req.MappingType = "mapping"
 	}
+	if err := syncer.ValidateMappingRule(req); err != nil {
+		respondError(c, http.StatusBadRequest, "规则错误: "+err.Error())
+		return
+	}
 	req.IsEnabled = true
 
 	storage.DB.Create(&req)
@@ -281,6 +285,18 @@ func UpdateMapping(c *gin.Context) {
 		}
 	}
 
+	check := m
+	if v, ok := req["mappingType"].(string); ok {
+		check.MappingType = v
+	}
+	if v, ok := req["transformRule"].(string); ok {
+		check.TransformRule = v
+	}
+	if err := syncer.ValidateMappingRule(check); err != nil {
+		respondError(c, http.StatusBadRequest, "规则错误: "+err.Error())
+		return
+	}
+
 	storage.DB.Model(&m).Updates(updates)
 	respondOK(c, nil)
 }
@@ -301,6 +317,10 @@ func BatchUpdateMappings(c *gin.Context) {
 		respondError(c, http.StatusBadRequest, "参数错误")
 		return
 	}
+	if err := validateMappingRules(req.Mappings); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// 删除旧映射
 	storage.DB.Where("synchronizer_id = ?", syncID).Delete(&models.SyncAttributeMapping{})
@@ -357,7 +377,7 @@ func GetSourceFields(c *gin.Context) {
 
 	switch objectType {
 	case "user":
-		respondOK(c, []fieldDef{
+		fields := []fieldDef{
 			{"id", "用户ID", "uint"},
 			{"username", "用户名", "string"},
 			{"password_raw", "密码(原文)", "string"},
@@ -371,18 +391,29 @@ func GetSourceFields(c *gin.Context) {
 			{"source", "用户来源", "string"},
 			{"group_id", "分组ID", "uint"},
 			{"group_name", "分组名称", "string"},
+			{"group_ids", "全部分组ID(逗号分隔)", "string"},
+			{"group_names", "全部分组名称(逗号分隔)", "string"},
 			{"department_name", "部门名称", "string"},
 			{"job_title", "职位", "string"},
+			{"manager_username", "直属上级用户名", "string"},
+			{"manager_nickname", "直属上级姓名", "string"},
 			{"roles", "角色代码(逗号分隔)", "string"},
 			{"role_names", "角色名称(逗号分隔)", "string"},
 			{"dingtalk_uid", "钉钉UserID", "string"},
 			{"created_at", "创建时间", "time"},
 			{"updated_at", "更新时间", "time"},
 			{"password_changed_at", "密码修改时间", "time"},
+			{"valid_from", "账号生效时间", "time"},
+			{"valid_until", "账号失效时间", "time"},
 			{"last_login_at", "最后登录时间", "time"},
 			{"last_login_ip", "最后登录IP", "string"},
 			{"mfa_enabled", "MFA状态", "bool"},
-		})
+		}
+		// 自定义扩展属性
+		for _, d := range storage.ListUserAttributeDefs() {
+			fields = append(fields, fieldDef{"ext." + d.Key, d.Label + "(扩展)", d.Type})
+		}
+		respondOK(c, fields)
 	case "group":
 		respondOK(c, []fieldDef{
 			{"id", "分组ID", "uint"},
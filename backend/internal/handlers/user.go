@@ -14,6 +14,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/xuri/excelize/v2"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 
 	"go-syncflow/internal/ldapserver"
 	"go-syncflow/internal/middleware"
@@ -41,6 +42,20 @@ func hashPasswordForStorage(password string, isEncrypted bool) (string, error) {
 	return string(hashed), nil
 }
 
+// updateUserWithSyncEvent 在同一事务内更新用户并写入下游同步任务
+func updateUserWithSyncEvent(user models.User, updates map[string]interface{}, event string, rawPassword string) error {
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		return syncer.EnqueueSyncEvent(tx, event, user, rawPassword)
+	})
+	if err == nil {
+		syncer.WakeSyncQueue()
+	}
+	return err
+}
+
 func ListUsers(c *gin.Context) {
 	pageIndex, _ := strconv.Atoi(c.DefaultQuery("pageIndex", "0"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
@@ -52,6 +67,7 @@ func ListUsers(c *gin.Context) {
 	groupID := c.Query("groupId")
 
 	query := storage.DB.Model(&models.User{}).Where("is_deleted = 0")
+	query = middleware.GetDataScope(c, "user:list").FilterUsers(query)
 	if keyword != "" {
 		query = query.Where("username LIKE ? OR nickname LIKE ? OR phone LIKE ?", "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%")
 	}
@@ -60,7 +76,8 @@ func ListUsers(c *gin.Context) {
 			if gid == 0 {
 				query = query.Where("group_id = 0")
 			} else {
-				query = query.Where("group_id = ?", gid)
+				// 按成员关系过滤：兼职部门的成员同样列出
+				query = query.Where("id IN (?)", storage.GroupMemberUserIDs([]uint{uint(gid)}))
 			}
 		}
 	}
@@ -68,6 +85,13 @@ func ListUsers(c *gin.Context) {
 	query.Count(&total)
 	query.Preload("Roles").Offset(pageIndex * pageSize).Limit(pageSize).Order("id desc").Find(&users)
 
+	storage.LoadUserAttributes(users)
+	storage.LoadUserGroupIDs(users)
+	defs := storage.ListUserAttributeDefs()
+	for i := range users {
+		users[i].Attributes = visibleUserAttributes(c, users[i].Attributes, defs)
+	}
+
 	respondList(c, users, total)
 }
 
@@ -80,7 +104,17 @@ type createUserRequest struct {
 	Email       string `json:"email"`
 	Status      int8   `json:"status"`
 	RoleIDs     []uint `json:"roleIds"`
-	GroupID     uint   `json:"groupId"`
+	GroupID     uint   `json:"groupId"`  // 主部门
+	GroupIDs    []uint `json:"groupIds"` // 全部所属分组（可包含主部门）
+	ManagerID   uint   `json:"managerId"`
+	JobTitle    string `json:"jobTitle"`
+	// 扩展属性 key -> value
+	Attributes map[string]string `json:"attributes"`
+	// 入职日期（2006-01-02），晚于当前时间时走入职流程：先禁用，到期预创建下游账号并启用
+	StartDate string `json:"startDate"`
+	// 账号有效期（2006-01-02 或带时间），不在有效期内时创建为禁用，到期自动启用 / 禁用
+	ValidFrom  string `json:"validFrom"`
+	ValidUntil string `json:"validUntil"`
 }
 
 func CreateUser(c *gin.Context) {
@@ -90,54 +124,171 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	p, err := prepareCreateUser(req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 用户、角色与下游同步任务在同一事务内写入
+	hasAssignPerm := middleware.CheckPermission(c, "user:assign_role")
+	if err := currentUserScopes(c, "user:create").checkCreate(req, hasAssignPerm); err != nil {
+		respondError(c, http.StatusForbidden, err.Error())
+		return
+	}
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		return createPreparedUser(tx, p, hasAssignPerm, middleware.GetUsername(c))
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "创建失败")
+		return
+	}
+	syncer.WakeSyncQueue()
+
+	middleware.RecordOperationLog(c, "用户管理", "新增用户", req.Username, "")
+	respondOK(c, p.user)
+}
+
+// preparedUser 已通过校验、待写入的新用户
+type preparedUser struct {
+	req       createUserRequest
+	user      models.User
+	attrs     map[string]string
+	startDate time.Time
+}
+
+// prepareCreateUser 校验新建用户请求并构造用户（不写库），单个新增与批量导入共用
+func prepareCreateUser(req createUserRequest) (*preparedUser, error) {
 	// 检查用户名是否存在
 	var count int64
 	storage.DB.Model(&models.User{}).Where("username = ?", req.Username).Count(&count)
 	if count > 0 {
-		respondError(c, http.StatusBadRequest, "用户名已存在")
-		return
+		return nil, fmt.Errorf("用户名已存在")
 	}
 
-	hashed, _ := hashPasswordForStorage(req.Password, false)
+	attrs, err := normalizeUserAttributes(0, req.Attributes, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateManager(0, req.ManagerID); err != nil {
+		return nil, err
+	}
+	var startDate time.Time
+	if req.StartDate != "" {
+		if startDate, err = parseLifecycleDate(req.StartDate); err != nil {
+			return nil, err
+		}
+	}
+	validFrom, err := parseValidityTime(req.ValidFrom, false)
+	if err != nil {
+		return nil, err
+	}
+	validUntil, err := parseValidityTime(req.ValidUntil, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateValidity(validFrom, validUntil); err != nil {
+		return nil, err
+	}
+
+	// 批量导入预览只做校验，不带密码，跳过耗时的哈希
+	var hashed string
+	if req.Password != "" {
+		hashed, _ = hashPasswordForStorage(req.Password, false)
+	}
 	user := models.User{
-		Username: req.Username,
-		Password: hashed,
-		Nickname: req.Nickname,
-		Phone:    req.Phone,
-		Email:    req.Email,
-		Status:   req.Status,
-		Source:   "local",
-		GroupID:  req.GroupID,
+		Username:   req.Username,
+		Password:   hashed,
+		Nickname:   req.Nickname,
+		Phone:      req.Phone,
+		Email:      req.Email,
+		Status:     req.Status,
+		Source:     "local",
+		GroupID:    req.GroupID,
+		ManagerID:  req.ManagerID,
+		JobTitle:   req.JobTitle,
+		ValidFrom:  validFrom,
+		ValidUntil: validUntil,
 	}
 	// 生成 Samba NT Hash（需要明文密码）
 	if req.RawPassword != "" {
 		user.SambaNTPassword = ldapserver.ComputeNTHash(req.RawPassword)
-	} else {
+	} else if req.Password != "" {
 		user.SambaNTPassword = ldapserver.ComputeNTHash(req.Password)
 	}
+	return &preparedUser{req: req, user: user, attrs: attrs, startDate: startDate}, nil
+}
 
-	if err := storage.DB.Create(&user).Error; err != nil {
-		respondError(c, http.StatusInternalServerError, "创建失败")
-		return
+// createPreparedUser 在事务内写入用户及其扩展属性、分组、角色，并投递 user_create 同步任务
+// assignRoles 为 false（无 user:assign_role 权限）时忽略请求中的角色，分配默认角色
+func createPreparedUser(tx *gorm.DB, p *preparedUser, assignRoles bool, operator string) error {
+	req, user := p.req, &p.user
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	if err := storage.SaveUserAttributes(tx, user.ID, p.attrs); err != nil {
+		return err
+	}
+	user.Attributes = p.attrs
+	if err := storage.SetUserGroups(tx, user.ID, req.GroupID, req.GroupIDs); err != nil {
+		return err
+	}
+	user.GroupID = resolvePrimaryGroup(req.GroupID, req.GroupIDs)
+
+	// 记录本地填写的字段，字段权威来源中的“本地”据此生效
+	localFields := localEditedFields(models.User{}, map[string]interface{}{
+		"nickname":   user.Nickname,
+		"phone":      user.Phone,
+		"email":      user.Email,
+		"manager_id": user.ManagerID,
+		"job_title":  user.JobTitle,
+	}, p.attrs)
+	if user.GroupID > 0 {
+		localFields = append(localFields, "group_id")
+	}
+	if err := storage.RecordLocalEdits(tx, user.ID, localFields, operator, false); err != nil {
+		return err
+	}
+	if p.startDate.After(time.Now()) {
+		if err := startJoin(tx, user, p.startDate, operator); err != nil {
+			return err
+		}
+	}
+	if user.ValidFrom != nil || user.ValidUntil != nil {
+		if err := scheduleAccountValidity(tx, *user, operator); err != nil {
+			return err
+		}
+		// 不在有效期内的账号以禁用状态创建（status 带默认值，显式更新）
+		if outsideValidity(*user, time.Now()) {
+			user.Status = 0
+			if err := tx.Model(user).Update("status", 0).Error; err != nil {
+				return err
+			}
+		}
 	}
 
 	// 分配角色：只有拥有 user:assign_role 权限时才使用前端传入的角色列表
-	hasAssignPerm := middleware.CheckPermission(c, "user:assign_role")
-	if hasAssignPerm && len(req.RoleIDs) > 0 {
+	if assignRoles && len(req.RoleIDs) > 0 {
 		for _, roleID := range req.RoleIDs {
-			storage.DB.Create(&models.UserRole{UserID: user.ID, RoleID: roleID})
+			tx.Create(&models.UserRole{UserID: user.ID, RoleID: roleID})
 		}
 	} else {
 		// 无角色分配权限或未指定角色时，自动分配"普通用户"角色（ID=2）
 		var defaultRole models.Role
-		if storage.DB.Where("code = ?", "user").First(&defaultRole).Error == nil {
-			storage.DB.Create(&models.UserRole{UserID: user.ID, RoleID: defaultRole.ID})
+		if tx.Where("code = ?", "user").First(&defaultRole).Error == nil {
+			tx.Create(&models.UserRole{UserID: user.ID, RoleID: defaultRole.ID})
 		}
 	}
 
-	middleware.RecordOperationLog(c, "用户管理", "新增用户", req.Username, "")
-	syncer.DispatchSyncEvent(models.SyncEventUserCreate, user.ID, req.Password)
-	respondOK(c, user)
+	return syncer.EnqueueSyncEvent(tx, models.SyncEventUserCreate, *user, req.Password)
+}
+
+// resolvePrimaryGroup 未指定主部门时取所属分组的第一个
+func resolvePrimaryGroup(primaryID uint, groupIDs []uint) uint {
+	if primaryID == 0 && len(groupIDs) > 0 {
+		return groupIDs[0]
+	}
+	return primaryID
 }
 
 func GetUser(c *gin.Context) {
@@ -148,6 +299,8 @@ func GetUser(c *gin.Context) {
 		respondError(c, http.StatusNotFound, "用户不存在")
 		return
 	}
+	user.Attributes = visibleUserAttributes(c, storage.GetUserAttributes(user.ID), storage.ListUserAttributeDefs())
+	user.GroupIDs = storage.GetUserGroupIDs(user.ID)
 
 	respondOK(c, user)
 }
@@ -159,6 +312,19 @@ type updateUserRequest struct {
 	Status   int8   `json:"status"`
 	RoleIDs  []uint `json:"roleIds"`
 	GroupID  *uint  `json:"groupId"`
+	// 全部所属分组；不传则只调整主部门，保留其余分组
+	GroupIDs []uint `json:"groupIds"`
+	// 直属上级用户ID，0 表示清除；不传则不修改
+	ManagerID *uint `json:"managerId"`
+	// 扩展属性：只更新提交的键，值为空表示清除
+	Attributes map[string]string `json:"attributes"`
+	// 职位，不传则不修改
+	JobTitle *string `json:"jobTitle"`
+	// 同时锁定的字段（如 nickname、ext.<key>），锁定后上游同步不再覆盖
+	LockFields []string `json:"lockFields"`
+	// 账号有效期：不传则不修改，空字符串表示清除
+	ValidFrom  *string `json:"validFrom"`
+	ValidUntil *string `json:"validUntil"`
 }
 
 func UpdateUser(c *gin.Context) {
@@ -176,36 +342,198 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	assignRoles := middleware.CheckPermission(c, "user:assign_role")
+	if err := currentUserScopes(c, "user:update").checkUpdate(user, req, assignRoles); err != nil {
+		respondError(c, http.StatusForbidden, err.Error())
+		return
+	}
+	p, err := prepareUserUpdate(user, req)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyUserUpdate(p, assignRoles, middleware.GetUsername(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, "更新失败")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "用户管理", "编辑用户", user.Username, "")
+	respondOK(c, nil)
+}
+
+// preparedUserUpdate 已通过校验、待写入的用户修改
+type preparedUserUpdate struct {
+	user            models.User
+	req             updateUserRequest
+	updates         map[string]interface{}
+	attrs           map[string]string
+	localFields     []string
+	validityChanged bool
+	candidate       models.User // 应用有效期修改后的用户，用于重新安排有效期阶段
+}
+
+// prepareUserUpdate 校验用户修改请求（不写库），单个编辑与表格批量更新共用
+func prepareUserUpdate(user models.User, req updateUserRequest) (*preparedUserUpdate, error) {
 	// 钉钉同步用户：基本信息（姓名、手机号、邮箱、分组）不允许手动修改，只能通过同步更新
+	var updates map[string]interface{}
 	if user.Source == "dingtalk" {
-		updates := map[string]interface{}{
+		updates = map[string]interface{}{
 			"status": req.Status,
 		}
-		storage.DB.Model(&user).Updates(updates)
 	} else {
-		updates := map[string]interface{}{
+		updates = map[string]interface{}{
 			"nickname": req.Nickname,
 			"phone":    req.Phone,
 			"email":    req.Email,
 			"status":   req.Status,
 		}
-		if req.GroupID != nil {
-			updates["group_id"] = *req.GroupID
+		if req.ManagerID != nil {
+			if err := validateManager(user.ID, *req.ManagerID); err != nil {
+				return nil, err
+			}
+			updates["manager_id"] = *req.ManagerID
+		}
+		if req.JobTitle != nil {
+			updates["job_title"] = *req.JobTitle
 		}
-		storage.DB.Model(&user).Updates(updates)
 	}
 
-	// 更新角色：只有拥有 user:assign_role 权限时才允许修改角色
-	if middleware.CheckPermission(c, "user:assign_role") {
-		storage.DB.Where("user_id = ?", id).Delete(&models.UserRole{})
-		for _, roleID := range req.RoleIDs {
-			storage.DB.Create(&models.UserRole{UserID: uint(id), RoleID: roleID})
+	attrs, err := normalizeUserAttributes(user.ID, req.Attributes, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLockFields(req.LockFields); err != nil {
+		return nil, err
+	}
+
+	// 有效期不属于上游数据，各来源的用户均可修改
+	validityChanged := req.ValidFrom != nil || req.ValidUntil != nil
+	if validityChanged {
+		validFrom, validUntil := user.ValidFrom, user.ValidUntil
+		if req.ValidFrom != nil {
+			if validFrom, err = parseValidityTime(*req.ValidFrom, false); err != nil {
+				return nil, err
+			}
+		}
+		if req.ValidUntil != nil {
+			if validUntil, err = parseValidityTime(*req.ValidUntil, true); err != nil {
+				return nil, err
+			}
+		}
+		if err := validateValidity(validFrom, validUntil); err != nil {
+			return nil, err
+		}
+		updates["valid_from"] = validFrom
+		updates["valid_until"] = validUntil
+		// 失效时间变化后重新发送到期提醒
+		if !sameTime(user.ValidUntil, validUntil) {
+			updates["expiry_notified_at"] = nil
+		}
+	}
+	candidate := user
+	if v, ok := updates["valid_from"].(*time.Time); ok {
+		candidate.ValidFrom = v
+	}
+	if v, ok := updates["valid_until"].(*time.Time); ok {
+		candidate.ValidUntil = v
+	}
+	if outsideValidity(candidate, time.Now()) {
+		updates["status"] = int8(0)
+	}
+	localFields := localEditedFields(user, updates, attrs)
+	if user.Source != "dingtalk" && (req.GroupIDs != nil || (req.GroupID != nil && *req.GroupID != user.GroupID)) {
+		localFields = append(localFields, "group_id")
+	}
+
+	return &preparedUserUpdate{
+		user:            user,
+		req:             req,
+		updates:         updates,
+		attrs:           attrs,
+		localFields:     localFields,
+		validityChanged: validityChanged,
+		candidate:       candidate,
+	}, nil
+}
+
+// applyUserUpdate 在同一事务内写入用户修改并投递 user_update 同步任务
+// assignRoles 为 true（拥有 user:assign_role 权限）时以请求中的角色替换现有角色
+func applyUserUpdate(p *preparedUserUpdate, assignRoles bool, operator string) error {
+	user, req := p.user, p.req
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(p.updates).Error; err != nil {
+			return err
+		}
+		if err := storage.SaveUserAttributes(tx, user.ID, p.attrs); err != nil {
+			return err
+		}
+		if user.Source != "dingtalk" && (req.GroupID != nil || req.GroupIDs != nil) {
+			primary := user.GroupID
+			if req.GroupID != nil {
+				primary = *req.GroupID
+			}
+			groupIDs := req.GroupIDs
+			if groupIDs == nil {
+				// 未传 groupIds 时只调整主部门，保留现有全部分组关系（含原主部门）
+				groupIDs = storage.GetUserGroupIDs(user.ID)
+			}
+			if err := storage.SetUserGroups(tx, user.ID, primary, groupIDs); err != nil {
+				return err
+			}
+		}
+		if err := storage.RecordLocalEdits(tx, user.ID, p.localFields, operator, false); err != nil {
+			return err
 		}
+		if err := storage.RecordLocalEdits(tx, user.ID, req.LockFields, operator, true); err != nil {
+			return err
+		}
+		if p.validityChanged {
+			if err := scheduleAccountValidity(tx, p.candidate, operator); err != nil {
+				return err
+			}
+		}
+
+		// 更新角色：只有拥有 user:assign_role 权限时才允许修改角色
+		if assignRoles {
+			if err := replaceUserRoles(tx, user.ID, req.RoleIDs); err != nil {
+				return err
+			}
+		}
+
+		return syncer.EnqueueSyncEvent(tx, models.SyncEventUserUpdate, user, "")
+	})
+	if err != nil {
+		return err
 	}
+	syncer.WakeSyncQueue()
+	return nil
+}
 
-	middleware.RecordOperationLog(c, "用户管理", "编辑用户", user.Username, "")
-	syncer.DispatchSyncEvent(models.SyncEventUserUpdate, uint(id), "")
-	respondOK(c, nil)
+// replaceUserRoles 以 roleIDs 替换用户角色，保留仍在列表中的分配（及其管理范围）
+func replaceUserRoles(tx *gorm.DB, userID uint, roleIDs []uint) error {
+	q := tx.Where("user_id = ?", userID)
+	if len(roleIDs) > 0 {
+		q = q.Where("role_id NOT IN ?", roleIDs)
+	}
+	if err := q.Delete(&models.UserRole{}).Error; err != nil {
+		return err
+	}
+	var existing []uint
+	tx.Model(&models.UserRole{}).Where("user_id = ?", userID).Pluck("role_id", &existing)
+	have := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		have[id] = true
+	}
+	for _, roleID := range roleIDs {
+		if have[roleID] {
+			continue
+		}
+		have[roleID] = true
+		if err := tx.Create(&models.UserRole{UserID: userID, RoleID: roleID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func DeleteUser(c *gin.Context) {
@@ -228,18 +556,74 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	// 先加载角色（下游同步需要）再触发同步，等同步完成后再删除
-	storage.DB.Preload("Roles").First(&user, id)
-	syncer.DispatchSyncEventSync(models.SyncEventUserDelete, user, "")
-
-	// 硬删除：清理关联数据并物理删除记录
-	storage.DB.Where("user_id = ?", id).Delete(&models.UserRole{})
-	storage.DB.Unscoped().Delete(&user)
+	if err := deleteUserCascade(user); err != nil {
+		respondError(c, http.StatusInternalServerError, "删除失败")
+		return
+	}
 
 	middleware.RecordOperationLog(c, "用户管理", "删除用户", user.Username, "")
 	respondOK(c, nil)
 }
 
+// deleteUserCascade 硬删除用户及其关联数据，并投递下游删除任务
+func deleteUserCascade(user models.User) error {
+	// 先加载角色（下游同步需要），入队时保存用户快照，删除后仍可投递
+	storage.DB.Preload("Roles").First(&user, user.ID)
+	user.Attributes = storage.GetUserAttributes(user.ID)
+	user.GroupIDs = storage.GetUserGroupIDs(user.ID)
+
+	// 直接下属的上级随之清空，并同步到下游（清除 AD manager）
+	var reports []models.User
+	storage.DB.Preload("Roles").Where("manager_id = ? AND is_deleted = 0", user.ID).Find(&reports)
+
+	// 硬删除：清理关联数据并物理删除记录，与删除同步任务同一事务
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := syncer.EnqueueSyncEvent(tx, models.SyncEventUserDelete, user, ""); err != nil {
+			return err
+		}
+		tx.Where("user_id = ?", user.ID).Delete(&models.UserRole{})
+		if err := storage.DeleteUserAttributes(tx, user.ID); err != nil {
+			return err
+		}
+		if err := storage.DeleteUserGroups(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := storage.DeleteUserFieldSources(tx, user.ID); err != nil {
+			return err
+		}
+		// 未执行的生命周期任务随用户删除一并取消
+		if err := tx.Model(&models.LifecycleTask{}).Where("user_id = ? AND status = ?", user.ID, models.LifecycleTaskPending).
+			Updates(map[string]interface{}{"status": models.LifecycleTaskCancelled, "message": "用户已删除"}).Error; err != nil {
+			return err
+		}
+		// 未处理的权限申请随之取消，审批人关系一并移除
+		if err := tx.Model(&models.AccessRequest{}).Where("user_id = ? AND status = ?", user.ID, models.AccessRequestPending).
+			Updates(map[string]interface{}{"status": models.AccessRequestCancelled, "comment": "用户已删除"}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.AccessRequestApprover{}).Error; err != nil {
+			return err
+		}
+		for _, r := range reports {
+			if err := tx.Model(&r).Update("manager_id", 0).Error; err != nil {
+				return err
+			}
+			if err := syncer.EnqueueSyncEvent(tx, models.SyncEventUserUpdate, r, ""); err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+	syncer.WakeSyncQueue()
+	return nil
+}
+
 func UpdateUserStatus(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 
@@ -251,17 +635,78 @@ func UpdateUserStatus(c *gin.Context) {
 		return
 	}
 
-	storage.DB.Model(&models.User{}).Where("id = ?", id).Update("status", req.Status)
+	var user models.User
+	if err := storage.DB.First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
 
-	middleware.RecordOperationLog(c, "用户管理", "更新状态", strconv.FormatUint(id, 10), "")
-	if req.Status == 1 {
-		syncer.DispatchSyncEvent(models.SyncEventUserEnable, uint(id), "")
-	} else {
-		syncer.DispatchSyncEvent(models.SyncEventUserDisable, uint(id), "")
+	if err := validateUserStatusChange(user, req.Status); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyUserStatus(user, req.Status, middleware.GetUsername(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, "更新失败")
+		return
 	}
+
+	middleware.RecordOperationLog(c, "用户管理", "更新状态", strconv.FormatUint(id, 10), "")
 	respondOK(c, nil)
 }
 
+// validateUserStatusChange 校验能否手动启用 / 禁用用户
+func validateUserStatusChange(user models.User, status int8) error {
+	if status != 1 {
+		return nil
+	}
+	if outsideValidity(user, time.Now()) {
+		return fmt.Errorf("账号不在有效期内，请先修改生效 / 失效时间")
+	}
+	if user.LifecycleState == models.LifecyclePendingJoin {
+		return fmt.Errorf("用户待入职，请先取消入职阶段或等待预创建")
+	}
+	return nil
+}
+
+// applyUserStatus 启用 / 禁用用户并投递 user_enable / user_disable 同步任务
+func applyUserStatus(user models.User, status int8, operator string) error {
+	event := models.SyncEventUserDisable
+	updates := map[string]interface{}{"status": status}
+	// 手动启用视为复职 / 提前入职：取消未执行的生命周期阶段
+	rehire := status == 1 && user.LifecycleState != ""
+	if status == 1 {
+		event = models.SyncEventUserEnable
+		if rehire {
+			updates["lifecycle_state"] = ""
+			user.LifecycleState = ""
+		}
+	}
+	user.Status = status
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if rehire {
+			if err := cancelLifecycleTasks(tx, user.ID, "", operator, "用户已手动启用"); err != nil {
+				return err
+			}
+			// 有效期阶段不随复职取消
+			if err := scheduleAccountValidity(tx, user, operator); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		return syncer.EnqueueSyncEvent(tx, event, user, "")
+	})
+	if err != nil {
+		return err
+	}
+	syncer.WakeSyncQueue()
+	if status == 0 {
+		services.GetSecurityService().RevokeUserSessions(user.ID, "", services.SessionRevokeUserDisabled)
+	}
+	return nil
+}
+
 // UserExportItem 用户导出结构体（包含敏感字段）
 type UserExportItem struct {
 	ID              uint       `json:"id"`
@@ -317,10 +762,13 @@ func ResetUserPassword(c *gin.Context) {
 		"samba_nt_password": ldapserver.ComputeNTHash(rawPassword),
 		"password_changed_at": time.Now(),
 	}
-	storage.DB.Model(&models.User{}).Where("id = ?", id).Updates(updates)
+	if err := updateUserWithSyncEvent(user, updates, models.SyncEventPasswordChange, rawPassword); err != nil {
+		respondError(c, http.StatusInternalServerError, "重置失败")
+		return
+	}
 
-	// 使该用户 token 失效
-	middleware.InvalidateUserTokens(uint(id))
+	// 使该用户全部会话失效
+	services.GetSecurityService().RevokeUserSessions(uint(id), "", services.SessionRevokeCredential)
 
 	// 发送通知
 	notifyResult := sendPasswordResetNotification(user, rawPassword, req.NotifyChannels)
@@ -329,8 +777,6 @@ func ResetUserPassword(c *gin.Context) {
 		fmt.Sprintf("用户: %s(ID:%d)", user.Username, id),
 		fmt.Sprintf("自动生成密码, 通知: %v", req.NotifyChannels))
 
-	syncer.DispatchSyncEvent(models.SyncEventPasswordChange, uint(id), rawPassword)
-
 	respondOK(c, gin.H{
 		"message":      "密码重置成功",
 		"notifyResult": notifyResult,
@@ -371,8 +817,19 @@ func BatchResetPassword(c *gin.Context) {
 
 	results := make([]resetResult, 0, len(users))
 	successCount := 0
+	scope := middleware.GetDataScope(c, "user:reset_password", "user:update")
 
 	for _, user := range users {
+		if err := scope.CheckUser(user.ID); err != nil {
+			results = append(results, resetResult{
+				UserID:       user.ID,
+				Username:     user.Username,
+				Nickname:     user.Nickname,
+				Success:      false,
+				NotifyResult: err.Error(),
+			})
+			continue
+		}
 		rawPassword := generateSecurePassword()
 		hashed, err := hashPasswordForStorage(rawPassword, false)
 		if err != nil {
@@ -392,7 +849,7 @@ func BatchResetPassword(c *gin.Context) {
 			"password_changed_at": time.Now(),
 		}
 		storage.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates)
-		middleware.InvalidateUserTokens(user.ID)
+		services.GetSecurityService().RevokeUserSessions(user.ID, "", services.SessionRevokeCredential)
 
 		notifyResult := sendPasswordResetNotification(user, rawPassword, req.NotifyChannels)
 		successCount++
@@ -480,7 +937,8 @@ func sendPasswordResetNotification(user models.User, newPassword string, channel
 // ExportUsers 导出用户列表为 Excel
 func ExportUsers(c *gin.Context) {
 	var users []models.User
-	storage.DB.Where("is_deleted = 0").Order("id asc").Find(&users)
+	query := middleware.GetDataScope(c, "user:export", "settings:system").FilterUsers(storage.DB.Model(&models.User{}))
+	query.Where("is_deleted = 0").Order("id asc").Find(&users)
 
 	f := excelize.NewFile()
 	sheet := "用户列表"
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 用户扩展属性 ==========
+
+var (
+	attrKeyPattern   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
+	attrLDAPPattern  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{0,63}$`)
+	attrPhonePattern = regexp.MustCompile(`^\+?[0-9][0-9 -]{4,19}$`)
+)
+
+var attrTypes = map[string]bool{
+	"string": true, "number": true, "date": true, "bool": true, "enum": true, "email": true, "phone": true,
+}
+
+// 内置 LDAP 用户条目已使用的属性，扩展属性不能覆盖
+var reservedLDAPAttrs = map[string]bool{
+	"objectclass": true, "uid": true, "cn": true, "sn": true, "givenname": true, "displayname": true,
+	"uidnumber": true, "gidnumber": true, "userpassword": true, "mail": true, "telephonenumber": true,
+	"mobile": true, "title": true, "description": true, "o": true, "ou": true, "dc": true,
+	"member": true, "memberof": true, "memberuid": true, "manager": true, "directreports": true,
+	"homedirectory": true, "loginshell": true,
+	// 操作属性
+	"entrydn": true, "entryuuid": true, "entrycsn": true, "structuralobjectclass": true,
+	"subschemasubentry": true, "createtimestamp": true, "modifytimestamp": true,
+	"creatorsname": true, "modifiersname": true,
+}
+
+// reservedLDAPAttrPrefixes Samba / shadow 属性族由内置逻辑生成
+var reservedLDAPAttrPrefixes = []string{"samba", "shadow"}
+
+// isReservedLDAPAttr 属性名是否与内置 LDAP 属性冲突（不区分大小写）
+func isReservedLDAPAttr(name string) bool {
+	name = strings.ToLower(name)
+	if reservedLDAPAttrs[name] {
+		return true
+	}
+	for _, prefix := range reservedLDAPAttrPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpenAPIRequest 是否为开放 API（API Key）调用
+func isOpenAPIRequest(c *gin.Context) bool {
+	_, ok := c.Get("apiKeyId")
+	return ok
+}
+
+// visibleUserAttributes 按调用方过滤扩展属性：开放 API 不返回 private 属性
+func visibleUserAttributes(c *gin.Context, attrs map[string]string, defs []models.UserAttributeDef) map[string]string {
+	if !isOpenAPIRequest(c) {
+		return attrs
+	}
+	result := make(map[string]string, len(attrs))
+	for _, d := range defs {
+		if d.Visibility == models.AttrVisibilityPrivate {
+			continue
+		}
+		if v, ok := attrs[d.Key]; ok {
+			result[d.Key] = v
+		}
+	}
+	return result
+}
+
+func ListUserAttributeDefs(c *gin.Context) {
+	defs := storage.ListUserAttributeDefs()
+	if isOpenAPIRequest(c) {
+		visible := make([]models.UserAttributeDef, 0, len(defs))
+		for _, d := range defs {
+			if d.Visibility != models.AttrVisibilityPrivate {
+				visible = append(visible, d)
+			}
+		}
+		defs = visible
+	}
+	respondOK(c, defs)
+}
+
+// validateAttributeDef 校验属性定义
+func validateAttributeDef(d *models.UserAttributeDef) error {
+	d.Key = strings.TrimSpace(d.Key)
+	d.Label = strings.TrimSpace(d.Label)
+	if !attrKeyPattern.MatchString(d.Key) {
+		return fmt.Errorf("属性键只能包含字母、数字和下划线，且以字母开头")
+	}
+	if d.Label == "" {
+		return fmt.Errorf("显示名称不能为空")
+	}
+	if d.Type == "" {
+		d.Type = "string"
+	}
+	if !attrTypes[d.Type] {
+		return fmt.Errorf("不支持的属性类型: %s", d.Type)
+	}
+	if d.Type == "enum" && len(splitAttrOptions(d.Options)) == 0 {
+		return fmt.Errorf("枚举类型必须设置可选值")
+	}
+	if d.Pattern != "" {
+		if _, err := regexp.Compile(d.Pattern); err != nil {
+			return fmt.Errorf("校验正则错误: %v", err)
+		}
+	}
+	switch d.Visibility {
+	case "":
+		d.Visibility = models.AttrVisibilityInternal
+	case models.AttrVisibilityPublic, models.AttrVisibilityInternal, models.AttrVisibilityPrivate:
+	default:
+		return fmt.Errorf("不支持的可见性: %s", d.Visibility)
+	}
+	if d.LDAPAttribute != "" {
+		if !attrLDAPPattern.MatchString(d.LDAPAttribute) {
+			return fmt.Errorf("LDAP 属性名格式不正确")
+		}
+		if isReservedLDAPAttr(d.LDAPAttribute) {
+			return fmt.Errorf("LDAP 属性 %s 为内置属性，不能使用", d.LDAPAttribute)
+		}
+	} else if d.Visibility == models.AttrVisibilityPublic && isReservedLDAPAttr(d.Key) {
+		return fmt.Errorf("属性键 %s 与内置 LDAP 属性冲突，请指定 LDAP 属性名", d.Key)
+	}
+	return nil
+}
+
+func CreateUserAttributeDef(c *gin.Context) {
+	var def models.UserAttributeDef
+	if err := c.ShouldBindJSON(&def); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	def.ID = 0
+	if err := validateAttributeDef(&def); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var count int64
+	storage.DB.Model(&models.UserAttributeDef{}).Where(&models.UserAttributeDef{Key: def.Key}).Count(&count)
+	if count > 0 {
+		respondError(c, http.StatusBadRequest, "属性键已存在")
+		return
+	}
+
+	if err := storage.DB.Create(&def).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, "创建失败")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "用户管理", "新增扩展属性", def.Key, def.Label)
+	respondOK(c, def)
+}
+
+func UpdateUserAttributeDef(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var def models.UserAttributeDef
+	if err := storage.DB.First(&def, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "扩展属性不存在")
+		return
+	}
+
+	var req models.UserAttributeDef
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	// 属性键被映射和已有数据引用，不允许修改
+	req.ID = def.ID
+	req.Key = def.Key
+	req.CreatedAt = def.CreatedAt
+	if err := validateAttributeDef(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := storage.DB.Save(&req).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, "更新失败")
+		return
+	}
+
+	middleware.RecordOperationLog(c, "用户管理", "编辑扩展属性", def.Key, req.Label)
+	respondOK(c, req)
+}
+
+func DeleteUserAttributeDef(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var def models.UserAttributeDef
+	if err := storage.DB.First(&def, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "扩展属性不存在")
+		return
+	}
+
+	// 仍被同步映射引用时不允许删除
+	var refs int64
+	ref := "ext." + def.Key
+	storage.DB.Model(&models.SyncAttributeMapping{}).Where("source_attribute = ? OR target_attribute = ?", ref, ref).Count(&refs)
+	if refs > 0 {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("该属性被 %d 个同步映射引用，请先移除映射", refs))
+		return
+	}
+
+	storage.DB.Where("attr_key = ?", def.Key).Delete(&models.UserAttributeValue{})
+	storage.DB.Delete(&def)
+
+	middleware.RecordOperationLog(c, "用户管理", "删除扩展属性", def.Key, def.Label)
+	respondOK(c, nil)
+}
+
+func splitAttrOptions(options string) []string {
+	var result []string
+	for _, o := range strings.Split(options, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// maskAttributeValue 脱敏：保留前 3 位和后 4 位（短值保留首尾各 1 位）
+func maskAttributeValue(v string) string {
+	if strings.Contains(v, "*") {
+		return v // 已脱敏
+	}
+	runes := []rune(v)
+	switch {
+	case len(runes) >= 10:
+		return string(runes[:3]) + strings.Repeat("*", len(runes)-7) + string(runes[len(runes)-4:])
+	case len(runes) >= 3:
+		return string(runes[:1]) + strings.Repeat("*", len(runes)-2) + string(runes[len(runes)-1:])
+	}
+	return strings.Repeat("*", len(runes))
+}
+
+// normalizeAttributeValue 按类型校验并规范化单个属性值
+func normalizeAttributeValue(d models.UserAttributeDef, v string) (string, error) {
+	switch d.Type {
+	case "number":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "", fmt.Errorf("%s 必须是数字", d.Label)
+		}
+	case "date":
+		var t time.Time
+		var err error
+		for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339, "2006/01/02", "20060102"} {
+			if t, err = time.ParseInLocation(layout, v, time.Local); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			// IM 平台常以毫秒时间戳返回日期
+			ms, perr := strconv.ParseInt(v, 10, 64)
+			if perr != nil || ms <= 0 {
+				return "", fmt.Errorf("%s 日期格式不正确", d.Label)
+			}
+			t = time.UnixMilli(ms)
+		}
+		v = t.Format("2006-01-02")
+	case "bool":
+		switch strings.ToLower(v) {
+		case "1", "true", "yes", "是":
+			v = "true"
+		case "0", "false", "no", "否":
+			v = "false"
+		default:
+			return "", fmt.Errorf("%s 必须是布尔值", d.Label)
+		}
+	case "enum":
+		ok := false
+		for _, o := range splitAttrOptions(d.Options) {
+			if o == v {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return "", fmt.Errorf("%s 的值不在可选范围内", d.Label)
+		}
+	case "email":
+		if _, err := mail.ParseAddress(v); err != nil {
+			return "", fmt.Errorf("%s 邮箱格式不正确", d.Label)
+		}
+	case "phone":
+		if !attrPhonePattern.MatchString(v) {
+			return "", fmt.Errorf("%s 电话格式不正确", d.Label)
+		}
+	}
+
+	if d.Pattern != "" {
+		if re, err := regexp.Compile(d.Pattern); err == nil && !re.MatchString(v) {
+			return "", fmt.Errorf("%s 格式不正确", d.Label)
+		}
+	}
+	if d.Masked {
+		v = maskAttributeValue(v)
+	}
+	if d.MaxLength > 0 && len([]rune(v)) > d.MaxLength {
+		return "", fmt.Errorf("%s 长度不能超过 %d", d.Label, d.MaxLength)
+	}
+	if len(v) > 1024 {
+		return "", fmt.Errorf("%s 过长", d.Label)
+	}
+	return v, nil
+}
+
+// checkAttributeUnique 唯一属性的值不能被其他用户占用
+func checkAttributeUnique(d models.UserAttributeDef, userID uint, v string) error {
+	if !d.Unique {
+		return nil
+	}
+	var count int64
+	storage.DB.Model(&models.UserAttributeValue{}).
+		Where("attr_key = ? AND value = ? AND user_id <> ?", d.Key, v, userID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("%s「%s」已被其他用户使用", d.Label, v)
+	}
+	return nil
+}
+
+// normalizeUserAttributes 校验用户提交的扩展属性（类型、必填、唯一性），返回规范化后的值
+// creating=true 时检查所有必填属性；userID 为 0 表示新用户
+func normalizeUserAttributes(userID uint, input map[string]string, creating bool) (map[string]string, error) {
+	defs := storage.ListUserAttributeDefs()
+	defMap := make(map[string]models.UserAttributeDef, len(defs))
+	for _, d := range defs {
+		defMap[d.Key] = d
+	}
+
+	result := make(map[string]string, len(input))
+	for key, raw := range input {
+		d, ok := defMap[key]
+		if !ok {
+			return nil, fmt.Errorf("未知的扩展属性: %s", key)
+		}
+		v := strings.TrimSpace(raw)
+		if v == "" {
+			if d.Required {
+				return nil, fmt.Errorf("%s 不能为空", d.Label)
+			}
+			result[key] = ""
+			continue
+		}
+		v, err := normalizeAttributeValue(d, v)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAttributeUnique(d, userID, v); err != nil {
+			return nil, err
+		}
+		result[key] = v
+	}
+
+	if creating {
+		for _, d := range defs {
+			if d.Required && result[d.Key] == "" {
+				return nil, fmt.Errorf("%s 不能为空", d.Label)
+			}
+		}
+	}
+	return result, nil
+}
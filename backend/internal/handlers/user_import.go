@@ -0,0 +1,591 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+	syncer "go-syncflow/internal/sync"
+)
+
+// ========== 批量导入用户（xlsx / csv） ==========
+
+const (
+	userImportMaxRows  = 1000             // 单次导入最大行数
+	userImportBatchTTL = 30 * time.Minute // 预览结果保留时间，过期需重新上传
+)
+
+// userImportColumns 导入表头 -> 字段，同时接受中文表头与字段名；扩展属性列使用属性名称或 ext.<key>
+var userImportColumns = map[string]string{
+	"用户名": "username", "username": "username",
+	"姓名": "nickname", "nickname": "nickname",
+	"手机号": "phone", "phone": "phone",
+	"邮箱": "email", "email": "email",
+	"密码": "password", "password": "password",
+	"职位": "job_title", "jobtitle": "job_title",
+	"主部门": "group", "部门": "group", "group": "group",
+	"其他部门": "groups", "groups": "groups",
+	"角色": "roles", "roles": "roles",
+	"直属上级": "manager", "manager": "manager",
+	"入职日期": "start_date", "startdate": "start_date",
+	"生效时间": "valid_from", "validfrom": "valid_from",
+	"失效时间": "valid_until", "validuntil": "valid_until",
+	"操作": "action", "action": "action", // 仅批量更新使用
+}
+
+// userImportRow 导入预览中的一行
+type userImportRow struct {
+	Row              int               `json:"row"` // 表格中的行号（含表头）
+	Username         string            `json:"username"`
+	Nickname         string            `json:"nickname"`
+	Phone            string            `json:"phone"`
+	Email            string            `json:"email"`
+	JobTitle         string            `json:"jobTitle"`
+	Group            string            `json:"group"`
+	Groups           string            `json:"groups"`
+	Roles            string            `json:"roles"`
+	Manager          string            `json:"manager"`
+	StartDate        string            `json:"startDate"`
+	ValidFrom        string            `json:"validFrom"`
+	ValidUntil       string            `json:"validUntil"`
+	Attributes       map[string]string `json:"attributes,omitempty"`
+	GeneratePassword bool              `json:"generatePassword"` // 未填写密码，提交时生成随机密码并按账号开通策略通知
+	Errors           []string          `json:"errors"`
+	Warnings         []string          `json:"warnings"`
+
+	password string
+	request  createUserRequest
+}
+
+// userImportBatch 已上传、等待确认的导入批次（新增导入或表格批量更新）
+type userImportBatch struct {
+	Token      string
+	Kind       string // create / update
+	Operator   string
+	FileName   string
+	Rows       []*userImportRow
+	UpdateRows []*userUpdateRow
+	ExpiresAt  time.Time
+}
+
+var (
+	userImportBatches     = make(map[string]*userImportBatch)
+	userImportBatchesLock = &sync.Mutex{}
+)
+
+func storeUserImportBatch(batch *userImportBatch) {
+	userImportBatchesLock.Lock()
+	defer userImportBatchesLock.Unlock()
+	now := time.Now()
+	for token, b := range userImportBatches {
+		if now.After(b.ExpiresAt) {
+			delete(userImportBatches, token)
+		}
+	}
+	userImportBatches[batch.Token] = batch
+}
+
+// takeUserImportBatch 取出批次（取出后即移除，防止重复提交）
+func takeUserImportBatch(token, operator, kind string) *userImportBatch {
+	userImportBatchesLock.Lock()
+	defer userImportBatchesLock.Unlock()
+	batch, ok := userImportBatches[token]
+	if !ok || batch.Kind != kind || batch.Operator != operator || time.Now().After(batch.ExpiresAt) {
+		return nil
+	}
+	delete(userImportBatches, token)
+	return batch
+}
+
+func newImportToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// readSpreadsheet 读取上传的 xlsx / csv，返回第一个工作表的全部行
+func readSpreadsheet(fh *multipart.FileHeader) ([][]string, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(fh.Filename)) {
+	case ".xlsx":
+		f, err := excelize.OpenReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 Excel 文件: %v", err)
+		}
+		defer f.Close()
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, fmt.Errorf("Excel 文件中没有工作表")
+		}
+		return f.GetRows(sheets[0])
+	case ".csv":
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		// Excel 另存的 CSV 带 UTF-8 BOM
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		rows, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 CSV 文件: %v", err)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("仅支持 .xlsx 或 .csv 文件")
+}
+
+// importHeaderKey 规范化表头：去空格、去必填标记 *、小写
+func importHeaderKey(h string) string {
+	h = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(h), "*"))
+	return strings.ToLower(strings.ReplaceAll(h, "_", ""))
+}
+
+// mapImportHeader 解析表头，返回每列对应的字段（扩展属性为 ext.<key>，未识别的列为空）
+func mapImportHeader(header []string, defs []models.UserAttributeDef) ([]string, error) {
+	attrByName := make(map[string]string, len(defs)*3)
+	for _, d := range defs {
+		attrByName[importHeaderKey(d.Label)] = d.Key
+		attrByName[importHeaderKey(d.Key)] = d.Key
+		attrByName[importHeaderKey("ext."+d.Key)] = d.Key
+	}
+	fields := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, h := range header {
+		key := importHeaderKey(h)
+		field := userImportColumns[key]
+		if field == "" {
+			if attrKey, ok := attrByName[key]; ok {
+				field = "ext." + attrKey
+			}
+		}
+		if field == "" {
+			continue
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("表头「%s」重复", strings.TrimSpace(h))
+		}
+		seen[field] = true
+		fields[i] = field
+	}
+	if !seen["username"] {
+		return nil, fmt.Errorf("缺少「用户名」列")
+	}
+	return fields, nil
+}
+
+// parseImportRows 按表头将数据行转换为导入行（跳过空行）
+func parseImportRows(rows [][]string, fields []string) []*userImportRow {
+	var result []*userImportRow
+	for i, cells := range rows {
+		row := &userImportRow{Row: i + 2, Errors: []string{}, Warnings: []string{}}
+		empty := true
+		for col, field := range fields {
+			if field == "" || col >= len(cells) {
+				continue
+			}
+			v := strings.TrimSpace(cells[col])
+			if v != "" {
+				empty = false
+			}
+			if key := strings.TrimPrefix(field, "ext."); key != field {
+				if row.Attributes == nil {
+					row.Attributes = make(map[string]string)
+				}
+				row.Attributes[key] = v
+				continue
+			}
+			switch field {
+			case "username":
+				row.Username = v
+			case "nickname":
+				row.Nickname = v
+			case "phone":
+				row.Phone = v
+			case "email":
+				row.Email = v
+			case "password":
+				row.password = v
+			case "job_title":
+				row.JobTitle = v
+			case "group":
+				row.Group = v
+			case "groups":
+				row.Groups = v
+			case "roles":
+				row.Roles = v
+			case "manager":
+				row.Manager = v
+			case "start_date":
+				row.StartDate = v
+			case "valid_from":
+				row.ValidFrom = v
+			case "valid_until":
+				row.ValidUntil = v
+			}
+		}
+		if !empty {
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+// splitImportList 拆分逗号 / 顿号 / 分号分隔的列表
+func splitImportList(s string) []string {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '，' || r == '、' || r == ';' || r == '；'
+	})
+	result := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// importLookup 导入校验使用的分组、角色索引
+type importLookup struct {
+	groupsByName map[string][]uint
+	groupsByPath map[string]uint
+	groupPaths   map[uint]string
+	roles        map[string]uint // 角色代码或名称 -> ID
+}
+
+func newImportLookup() *importLookup {
+	l := &importLookup{
+		groupsByName: make(map[string][]uint),
+		groupsByPath: make(map[string]uint),
+		groupPaths:   make(map[uint]string),
+		roles:        make(map[string]uint),
+	}
+	var groups []models.UserGroup
+	storage.DB.Find(&groups)
+	byID := make(map[uint]models.UserGroup, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+	for _, g := range groups {
+		l.groupsByName[g.Name] = append(l.groupsByName[g.Name], g.ID)
+		path := []string{g.Name}
+		for p, depth := byID[g.ParentID], 0; p.ID > 0 && depth < 32; p, depth = byID[p.ParentID], depth+1 {
+			path = append([]string{p.Name}, path...)
+		}
+		l.groupsByPath[strings.Join(path, "/")] = g.ID
+		l.groupPaths[g.ID] = strings.Join(path, "/")
+	}
+	var roles []models.Role
+	storage.DB.Where("status = 1").Find(&roles)
+	for _, r := range roles {
+		l.roles[r.Name] = r.ID
+		l.roles[r.Code] = r.ID
+	}
+	return l
+}
+
+// group 按名称或完整路径（总部/技术部）查找分组
+func (l *importLookup) group(name string) (uint, error) {
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	if strings.Contains(name, "/") {
+		if id, ok := l.groupsByPath[name]; ok {
+			return id, nil
+		}
+		return 0, fmt.Errorf("部门「%s」不存在", name)
+	}
+	ids := l.groupsByName[name]
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("部门「%s」不存在", name)
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("部门名称「%s」不唯一，请填写完整路径（如 总部/%s）", name, name)
+}
+
+// validateImportRows 逐行校验并构造新建用户请求，错误记录在行上
+func validateImportRows(rows []*userImportRow, canAssignRole bool, scopes userScopes) {
+	lookup := newImportLookup()
+	ss := services.GetSecurityService()
+	defs := storage.ListUserAttributeDefs()
+	seenUsernames := make(map[string]int, len(rows))
+	seenUnique := make(map[string]int)
+
+	for _, row := range rows {
+		row.Errors = []string{}
+		row.Warnings = []string{}
+		addErr := func(format string, args ...interface{}) {
+			row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+		}
+
+		req := createUserRequest{
+			Username:   row.Username,
+			Nickname:   row.Nickname,
+			Phone:      row.Phone,
+			Email:      row.Email,
+			JobTitle:   row.JobTitle,
+			Status:     1,
+			Attributes: row.Attributes,
+			StartDate:  row.StartDate,
+			ValidFrom:  row.ValidFrom,
+			ValidUntil: row.ValidUntil,
+		}
+
+		if row.Username == "" {
+			addErr("用户名不能为空")
+		} else if first, ok := seenUsernames[strings.ToLower(row.Username)]; ok {
+			addErr("用户名与第 %d 行重复", first)
+		} else {
+			seenUsernames[strings.ToLower(row.Username)] = row.Row
+		}
+
+		// 密码：填写时按密码策略校验，未填写时提交时生成
+		row.GeneratePassword = row.password == ""
+		if !row.GeneratePassword {
+			owner := &models.User{Username: row.Username, Nickname: row.Nickname, Phone: row.Phone, Email: row.Email}
+			if valid, policyErrors := ss.ValidatePasswordFor(services.PasswordCheckInput{Password: row.password, User: owner}); !valid {
+				addErr("密码不符合策略: %s", strings.Join(policyErrors, "；"))
+			}
+		}
+
+		if row.Group != "" {
+			id, err := lookup.group(row.Group)
+			if err != nil {
+				addErr("%s", err.Error())
+			}
+			req.GroupID = id
+		}
+		for _, name := range splitImportList(row.Groups) {
+			id, err := lookup.group(name)
+			if err != nil {
+				addErr("%s", err.Error())
+				continue
+			}
+			req.GroupIDs = append(req.GroupIDs, id)
+		}
+
+		if roleNames := splitImportList(row.Roles); len(roleNames) > 0 {
+			if !canAssignRole {
+				addErr("没有分配角色的权限，请清空「角色」列")
+			}
+			for _, name := range roleNames {
+				id, ok := lookup.roles[name]
+				if !ok {
+					addErr("角色「%s」不存在或已禁用", name)
+					continue
+				}
+				req.RoleIDs = append(req.RoleIDs, id)
+			}
+		}
+
+		if row.Manager != "" {
+			var manager models.User
+			if storage.DB.Select("id").Where("username = ? AND is_deleted = 0", row.Manager).First(&manager).Error != nil {
+				addErr("直属上级「%s」不存在", row.Manager)
+			}
+			req.ManagerID = manager.ID
+		}
+
+		// 唯一扩展属性在文件内也不能重复
+		for _, d := range defs {
+			v := row.Attributes[d.Key]
+			if !d.Unique || v == "" {
+				continue
+			}
+			key := d.Key + "\x00" + v
+			if first, ok := seenUnique[key]; ok {
+				addErr("%s「%s」与第 %d 行重复", d.Label, v, first)
+			} else {
+				seenUnique[key] = row.Row
+			}
+		}
+
+		if err := scopes.checkCreate(req, canAssignRole); err != nil {
+			addErr("%s", err.Error())
+		}
+
+		// 与单个新增相同的校验：用户名唯一、扩展属性、日期
+		if row.Username != "" {
+			if _, err := prepareCreateUser(req); err != nil {
+				addErr("%s", err.Error())
+			}
+		}
+
+		if row.GeneratePassword && len(ResolveAllowedChannelTypes("account_created", resolvePrimaryGroup(req.GroupID, req.GroupIDs))) == 0 {
+			row.Warnings = append(row.Warnings, "未配置账号开通通知渠道，生成的初始密码将无法送达，需管理员另行重置")
+		}
+		row.request = req
+	}
+}
+
+// importSummary 导入预览统计
+func importSummary(rows []*userImportRow) (valid, invalid int) {
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			invalid++
+		} else {
+			valid++
+		}
+	}
+	return
+}
+
+// ---------- 接口 ----------
+
+// PreviewUserImport 上传 xlsx / csv，逐行校验并返回预览；校验结果保留 30 分钟供确认导入
+func PreviewUserImport(c *gin.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "请上传 .xlsx 或 .csv 文件")
+		return
+	}
+	records, err := readSpreadsheet(fh)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(records) < 2 {
+		respondError(c, http.StatusBadRequest, "文件中没有数据行")
+		return
+	}
+	fields, err := mapImportHeader(records[0], storage.ListUserAttributeDefs())
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows := parseImportRows(records[1:], fields)
+	if len(rows) == 0 {
+		respondError(c, http.StatusBadRequest, "文件中没有数据行")
+		return
+	}
+	if len(rows) > userImportMaxRows {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("单次最多导入 %d 行", userImportMaxRows))
+		return
+	}
+
+	validateImportRows(rows, middleware.CheckPermission(c, "user:assign_role"), currentUserScopes(c, "user:create"))
+	valid, invalid := importSummary(rows)
+
+	batch := &userImportBatch{
+		Token:     newImportToken(),
+		Kind:      "create",
+		Operator:  middleware.GetUsername(c),
+		FileName:  fh.Filename,
+		Rows:      rows,
+		ExpiresAt: time.Now().Add(userImportBatchTTL),
+	}
+	storeUserImportBatch(batch)
+
+	respondOK(c, gin.H{
+		"token":     batch.Token,
+		"fileName":  batch.FileName,
+		"total":     len(rows),
+		"valid":     valid,
+		"invalid":   invalid,
+		"rows":      rows,
+		"expiresAt": batch.ExpiresAt,
+	})
+}
+
+// CommitUserImport 确认导入：重新校验后在同一事务内创建全部用户，任一行失败则全部回滚
+func CommitUserImport(c *gin.Context) {
+	operator := middleware.GetUsername(c)
+	batch := takeUserImportBatch(c.Param("token"), operator, "create")
+	if batch == nil {
+		respondError(c, http.StatusNotFound, "导入批次不存在或已过期，请重新上传")
+		return
+	}
+
+	// 预览后数据可能已变化（如用户名被占用），提交前重新校验
+	canAssignRole := middleware.CheckPermission(c, "user:assign_role")
+	validateImportRows(batch.Rows, canAssignRole, currentUserScopes(c, "user:create"))
+	if valid, invalid := importSummary(batch.Rows); invalid > 0 {
+		// 放回批次，修正数据后可重新上传预览
+		batch.ExpiresAt = time.Now().Add(userImportBatchTTL)
+		storeUserImportBatch(batch)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("有 %d 行校验未通过，未导入任何用户", invalid),
+			"data":    gin.H{"token": batch.Token, "total": len(batch.Rows), "valid": valid, "invalid": invalid, "rows": batch.Rows},
+		})
+		return
+	}
+
+	type createdUser struct {
+		row      *userImportRow
+		user     models.User
+		password string
+	}
+	created := make([]createdUser, 0, len(batch.Rows))
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		for _, row := range batch.Rows {
+			password := row.password
+			if row.GeneratePassword {
+				password = generateRandomPassword()
+			}
+			req := row.request
+			req.Password = password
+			req.RawPassword = password
+			p, err := prepareCreateUser(req)
+			if err != nil {
+				return fmt.Errorf("第 %d 行: %v", row.Row, err)
+			}
+			if err := createPreparedUser(tx, p, canAssignRole, operator); err != nil {
+				return fmt.Errorf("第 %d 行: %v", row.Row, err)
+			}
+			created = append(created, createdUser{row: row, user: p.user, password: password})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[用户导入] %s 导入失败: %v", batch.FileName, err)
+		respondError(c, http.StatusInternalServerError, "导入失败，已全部回滚: "+err.Error())
+		return
+	}
+	syncer.WakeSyncQueue()
+
+	results := make([]gin.H, 0, len(created))
+	for _, cu := range created {
+		notified := false
+		// 待入职用户在预创建时重新生成密码并通知，这里不发送
+		if cu.row.GeneratePassword && cu.user.LifecycleState != models.LifecyclePendingJoin {
+			notified = len(cu.row.Warnings) == 0
+			go sendAccountCreatedNotification(cu.user, cu.password)
+		}
+		results = append(results, gin.H{
+			"row":              cu.row.Row,
+			"id":               cu.user.ID,
+			"username":         cu.user.Username,
+			"generatePassword": cu.row.GeneratePassword,
+			"notified":         notified,
+		})
+	}
+
+	middleware.RecordOperationLog(c, "用户管理", "批量导入用户", batch.FileName, fmt.Sprintf("共导入 %d 个用户", len(created)))
+	respondOK(c, gin.H{"created": len(created), "users": results})
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 委派管理范围 ==========
+
+// userScopes 用户管理操作的数据范围：可管理的用户 / 分组，以及可授予的角色
+type userScopes struct {
+	users *middleware.DataScope
+	roles *middleware.DataScope
+}
+
+// currentUserScopes codes 为当前操作对应的权限码
+func currentUserScopes(c *gin.Context, codes ...string) userScopes {
+	return userScopes{
+		users: middleware.GetDataScope(c, codes...),
+		roles: middleware.GetDataScope(c, "user:assign_role"),
+	}
+}
+
+// checkCreate 新建用户只能放入可管理的分组，并只能授予可授予的角色
+func (s userScopes) checkCreate(req createUserRequest, assignRoles bool) error {
+	groups := append([]uint{}, req.GroupIDs...)
+	if req.GroupID > 0 {
+		groups = append(groups, req.GroupID)
+	}
+	if s.users.GroupIDs() != nil && len(groups) == 0 {
+		return fmt.Errorf("请选择你管理范围内的分组")
+	}
+	if err := s.users.CheckGroupChange(nil, groups); err != nil {
+		return err
+	}
+	if assignRoles {
+		return s.roles.CheckRoleChange(nil, req.RoleIDs)
+	}
+	return nil
+}
+
+// checkUpdate 编辑用户时调整的分组和角色须在范围内，未变化的部分不校验
+func (s userScopes) checkUpdate(user models.User, req updateUserRequest, assignRoles bool) error {
+	if user.Source != "dingtalk" && (req.GroupID != nil || req.GroupIDs != nil) {
+		current := storage.GetUserGroupIDs(user.ID)
+		primary := user.GroupID
+		if req.GroupID != nil {
+			primary = *req.GroupID
+		}
+		desired := req.GroupIDs
+		if desired == nil {
+			desired = current
+			if len(desired) > 0 && desired[0] == user.GroupID {
+				desired = desired[1:]
+			}
+		}
+		if primary > 0 {
+			desired = append([]uint{primary}, desired...)
+		}
+		if err := s.users.CheckGroupChange(current, desired); err != nil {
+			return err
+		}
+	}
+	if assignRoles {
+		var current []uint
+		storage.DB.Model(&models.UserRole{}).Where("user_id = ?", user.ID).Pluck("role_id", &current)
+		return s.roles.CheckRoleChange(current, req.RoleIDs)
+	}
+	return nil
+}
+
+// userRoleScopeItem 用户某个角色分配的管理范围
+type userRoleScopeItem struct {
+	RoleID           uint   `json:"roleId"`
+	RoleName         string `json:"roleName,omitempty"`
+	RoleCode         string `json:"roleCode,omitempty"`
+	ScopeGroupIDs    []uint `json:"scopeGroupIds"`
+	GrantableRoleIDs []uint `json:"grantableRoleIds"`
+}
+
+// GetUserRoleScopes 查看用户各角色分配的管理范围
+func GetUserRoleScopes(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var userRoles []models.UserRole
+	storage.DB.Where("user_id = ?", id).Order("id").Find(&userRoles)
+	var roles []models.Role
+	storage.DB.Joins("JOIN user_roles ON user_roles.role_id = roles.id").Where("user_roles.user_id = ?", id).Find(&roles)
+	roleMap := make(map[uint]models.Role, len(roles))
+	for _, r := range roles {
+		roleMap[r.ID] = r
+	}
+
+	result := make([]userRoleScopeItem, 0, len(userRoles))
+	for _, ur := range userRoles {
+		item := userRoleScopeItem{
+			RoleID:           ur.RoleID,
+			RoleName:         roleMap[ur.RoleID].Name,
+			RoleCode:         roleMap[ur.RoleID].Code,
+			ScopeGroupIDs:    middleware.ParseIDList(ur.ScopeGroupIDs),
+			GrantableRoleIDs: middleware.ParseIDList(ur.GrantableRoleIDs),
+		}
+		if item.ScopeGroupIDs == nil {
+			item.ScopeGroupIDs = []uint{}
+		}
+		if item.GrantableRoleIDs == nil {
+			item.GrantableRoleIDs = []uint{}
+		}
+		result = append(result, item)
+	}
+	respondOK(c, result)
+}
+
+// UpdateUserRoleScopes 设置用户角色分配的管理范围（分组子树 + 可授予角色）
+// 受限的操作人只能在自身范围内再委派，不能设置不受限的范围，也不能修改自己的范围
+func UpdateUserRoleScopes(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var req struct {
+		Scopes []userRoleScopeItem `json:"scopes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	var user models.User
+	if err := storage.DB.First(&user, id).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	if user.ID == middleware.GetUserID(c) {
+		respondError(c, http.StatusBadRequest, "不能修改自己的管理范围")
+		return
+	}
+
+	scope := middleware.GetDataScope(c, "user:assign_role")
+	held := make(map[uint]bool)
+	var userRoles []models.UserRole
+	storage.DB.Where("user_id = ?", user.ID).Find(&userRoles)
+	for _, ur := range userRoles {
+		held[ur.RoleID] = true
+	}
+	for _, item := range req.Scopes {
+		if !held[item.RoleID] {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("用户未拥有角色 %d", item.RoleID))
+			return
+		}
+		if len(item.ScopeGroupIDs) > 0 {
+			var count int64
+			storage.DB.Model(&models.UserGroup{}).Where("id IN ?", item.ScopeGroupIDs).Count(&count)
+			if int(count) != len(uniqueIDs(item.ScopeGroupIDs)) {
+				respondError(c, http.StatusBadRequest, "管理范围中包含不存在的分组")
+				return
+			}
+		}
+		if !scope.Unrestricted() {
+			if len(item.ScopeGroupIDs) == 0 {
+				respondError(c, http.StatusForbidden, "你的管理范围受限，只能委派指定分组范围")
+				return
+			}
+			if err := scope.CheckGroupChange(nil, item.ScopeGroupIDs); err != nil {
+				respondError(c, http.StatusForbidden, "管理范围超出了你自己的范围")
+				return
+			}
+			if err := scope.CheckRoleChange(nil, item.GrantableRoleIDs); err != nil {
+				respondError(c, http.StatusForbidden, "可授予角色超出了你自己的范围")
+				return
+			}
+		}
+	}
+
+	for _, item := range req.Scopes {
+		groups, grantable := "", ""
+		if len(item.ScopeGroupIDs) > 0 {
+			b, _ := json.Marshal(uniqueIDs(item.ScopeGroupIDs))
+			groups = string(b)
+			b, _ = json.Marshal(uniqueIDs(item.GrantableRoleIDs))
+			grantable = string(b)
+		}
+		storage.DB.Model(&models.UserRole{}).Where("user_id = ? AND role_id = ?", user.ID, item.RoleID).
+			Updates(map[string]interface{}{"scope_group_ids": groups, "grantable_role_ids": grantable})
+	}
+
+	content, _ := json.Marshal(req.Scopes)
+	middleware.RecordOperationLog(c, "用户管理", "设置管理范围", user.Username, string(content))
+	respondOK(c, nil)
+}
+
+// uniqueIDs 去重并保持顺序
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
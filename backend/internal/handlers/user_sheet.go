@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 用户表格模板与批量更新 ==========
+
+// 批量更新的操作
+const (
+	sheetActionUpdate  = "update"
+	sheetActionDisable = "disable"
+	sheetActionEnable  = "enable"
+	sheetActionDelete  = "delete"
+)
+
+// sheetActions 操作列取值 -> 操作，为空表示更新
+var sheetActions = map[string]string{
+	"": sheetActionUpdate, "更新": sheetActionUpdate, "update": sheetActionUpdate,
+	"禁用": sheetActionDisable, "disable": sheetActionDisable,
+	"启用": sheetActionEnable, "enable": sheetActionEnable,
+	"删除": sheetActionDelete, "delete": sheetActionDelete,
+}
+
+// sheetColumn 模板中的一列
+type sheetColumn struct {
+	header string
+	field  string
+	width  float64
+}
+
+// userSheetColumns 模板列；create 为新增导入模板（含密码、入职日期），否则为批量更新模板（含操作列）
+func userSheetColumns(create bool, defs []models.UserAttributeDef) []sheetColumn {
+	var cols []sheetColumn
+	if !create {
+		cols = append(cols, sheetColumn{"操作", "action", 10})
+	}
+	cols = append(cols,
+		sheetColumn{"用户名*", "username", 18},
+		sheetColumn{"姓名", "nickname", 12},
+		sheetColumn{"手机号", "phone", 15},
+		sheetColumn{"邮箱", "email", 28},
+	)
+	if create {
+		cols = append(cols, sheetColumn{"密码", "password", 16})
+	}
+	cols = append(cols,
+		sheetColumn{"职位", "job_title", 14},
+		sheetColumn{"主部门", "group", 24},
+		sheetColumn{"其他部门", "groups", 30},
+		sheetColumn{"角色", "roles", 20},
+		sheetColumn{"直属上级", "manager", 14},
+	)
+	if create {
+		cols = append(cols, sheetColumn{"入职日期", "start_date", 14})
+	}
+	cols = append(cols,
+		sheetColumn{"生效时间", "valid_from", 20},
+		sheetColumn{"失效时间", "valid_until", 20},
+	)
+	for _, d := range defs {
+		cols = append(cols, sheetColumn{d.Label, "ext." + d.Key, 16})
+	}
+	return cols
+}
+
+// formatSheetTime 有效期在表格中的格式，与解析格式一致以便原样回传
+func formatSheetTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// userSheetValues 用户当前值（与模板列对应）
+func userSheetValues(user models.User, lookup *importLookup, managerNames map[uint]string) map[string]string {
+	var others []string
+	for _, gid := range storage.GetUserGroupIDs(user.ID) {
+		if gid != user.GroupID && lookup.groupPaths[gid] != "" {
+			others = append(others, lookup.groupPaths[gid])
+		}
+	}
+	roleNames := make([]string, 0, len(user.Roles))
+	for _, r := range user.Roles {
+		roleNames = append(roleNames, r.Name)
+	}
+	values := map[string]string{
+		"username":    user.Username,
+		"nickname":    user.Nickname,
+		"phone":       user.Phone,
+		"email":       user.Email,
+		"job_title":   user.JobTitle,
+		"group":       lookup.groupPaths[user.GroupID],
+		"groups":      strings.Join(others, ","),
+		"roles":       strings.Join(roleNames, ","),
+		"manager":     managerNames[user.ManagerID],
+		"valid_from":  formatSheetTime(user.ValidFrom),
+		"valid_until": formatSheetTime(user.ValidUntil),
+	}
+	for key, v := range storage.GetUserAttributes(user.ID) {
+		values["ext."+key] = v
+	}
+	return values
+}
+
+// sameSheetList 逗号分隔的列表是否相同（忽略顺序）
+func sameSheetList(a, b string) bool {
+	la, lb := splitImportList(a), splitImportList(b)
+	if len(la) != len(lb) {
+		return false
+	}
+	sort.Strings(la)
+	sort.Strings(lb)
+	for i := range la {
+		if la[i] != lb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// userFieldChange 批量更新预览中的字段变化
+type userFieldChange struct {
+	Field string `json:"field"`
+	Label string `json:"label"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// userUpdateRow 批量更新预览 / 结果中的一行
+type userUpdateRow struct {
+	Row      int               `json:"row"`
+	Action   string            `json:"action"`
+	Username string            `json:"username"`
+	UserID   uint              `json:"userId"`
+	Changes  []userFieldChange `json:"changes"`
+	Errors   []string          `json:"errors"`
+	Result   string            `json:"result,omitempty"` // 提交结果：success / failed / skipped / unchanged
+	Message  string            `json:"message,omitempty"`
+
+	values  map[string]string
+	user    models.User
+	prepped *preparedUserUpdate
+}
+
+// sheetOperator 批量更新的操作人及其权限
+type sheetOperator struct {
+	userID        uint
+	username      string
+	canAssignRole bool
+	canDelete     bool
+	scopes        userScopes
+}
+
+// parseUpdateRows 按表头将数据行转换为批量更新行（只保留文件中存在的列）
+func parseUpdateRows(rows [][]string, fields []string) []*userUpdateRow {
+	var result []*userUpdateRow
+	for i, cells := range rows {
+		values := make(map[string]string, len(fields))
+		empty := true
+		for col, field := range fields {
+			if field == "" {
+				continue
+			}
+			v := ""
+			if col < len(cells) {
+				v = strings.TrimSpace(cells[col])
+			}
+			if v != "" {
+				empty = false
+			}
+			values[field] = v
+		}
+		if empty {
+			continue
+		}
+		result = append(result, &userUpdateRow{
+			Row:      i + 2,
+			Username: values["username"],
+			values:   values,
+			Changes:  []userFieldChange{},
+			Errors:   []string{},
+		})
+	}
+	return result
+}
+
+// validateUpdateRows 逐行比对当前值生成差异，并按单个编辑 / 启停 / 删除的规则校验
+func validateUpdateRows(rows []*userUpdateRow, op sheetOperator) {
+	lookup := newImportLookup()
+	defs := storage.ListUserAttributeDefs()
+	labels := make(map[string]string)
+	for _, col := range userSheetColumns(false, defs) {
+		labels[col.field] = strings.TrimSuffix(col.header, "*")
+	}
+	managerNames := make(map[uint]string)
+	seen := make(map[string]int, len(rows))
+
+	for _, row := range rows {
+		row.Changes = []userFieldChange{}
+		row.Errors = []string{}
+		row.prepped = nil
+		addErr := func(format string, args ...interface{}) {
+			row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+		}
+
+		action, ok := sheetActions[strings.ToLower(row.values["action"])]
+		if !ok {
+			addErr("无法识别的操作「%s」，可选：更新、禁用、启用、删除", row.values["action"])
+			continue
+		}
+		row.Action = action
+		if row.Username == "" {
+			addErr("用户名不能为空")
+			continue
+		}
+		if first, dup := seen[strings.ToLower(row.Username)]; dup {
+			addErr("用户名与第 %d 行重复", first)
+			continue
+		}
+		seen[strings.ToLower(row.Username)] = row.Row
+
+		var user models.User
+		if err := storage.DB.Preload("Roles").Where("username = ? AND is_deleted = 0", row.Username).First(&user).Error; err != nil {
+			addErr("用户不存在，新增用户请使用批量导入")
+			continue
+		}
+		row.user = user
+		row.UserID = user.ID
+		if err := op.scopes.users.CheckUser(user.ID); err != nil {
+			addErr("%s", err.Error())
+			continue
+		}
+
+		switch action {
+		case sheetActionDelete:
+			if !op.canDelete {
+				addErr("没有删除用户的权限")
+			} else if user.Username == "admin" || user.ID == op.userID {
+				addErr("不能删除管理员账户或自己")
+			}
+			continue
+		case sheetActionDisable, sheetActionEnable:
+			status := int8(0)
+			if action == sheetActionEnable {
+				status = 1
+			}
+			if status == 0 && (user.Username == "admin" || user.ID == op.userID) {
+				addErr("不能禁用管理员账户或自己")
+				continue
+			}
+			if err := validateUserStatusChange(user, status); err != nil {
+				addErr("%s", err.Error())
+				continue
+			}
+			if user.Status != status {
+				row.Changes = append(row.Changes, userFieldChange{"status", "状态", statusLabel(user.Status), statusLabel(status)})
+			}
+			continue
+		}
+
+		// 更新：只比对文件中存在的列
+		if user.ManagerID > 0 && managerNames[user.ManagerID] == "" {
+			var manager models.User
+			if storage.DB.Select("id, username").First(&manager, user.ManagerID).Error == nil {
+				managerNames[manager.ID] = manager.Username
+			}
+		}
+		current := userSheetValues(user, lookup, managerNames)
+		req := updateUserRequest{
+			Nickname: user.Nickname,
+			Phone:    user.Phone,
+			Email:    user.Email,
+			Status:   user.Status,
+		}
+		for _, r := range user.Roles {
+			req.RoleIDs = append(req.RoleIDs, r.ID)
+		}
+
+		fields := make([]string, 0, len(row.values))
+		for field := range row.values {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			v := row.values[field]
+			switch field {
+			case "action", "username", "password", "start_date":
+				continue
+			case "groups", "roles":
+				if sameSheetList(v, current[field]) {
+					continue
+				}
+			default:
+				if v == current[field] {
+					continue
+				}
+			}
+			label := labels[field]
+			if label == "" {
+				label = field
+			}
+			row.Changes = append(row.Changes, userFieldChange{field, label, current[field], v})
+
+			switch field {
+			case "nickname":
+				req.Nickname = v
+			case "phone":
+				req.Phone = v
+			case "email":
+				req.Email = v
+			case "job_title":
+				req.JobTitle = &v
+			case "group", "groups":
+				// 主部门与其他部门一并提交，避免只改其一时丢失另一列
+				if req.GroupID != nil {
+					continue
+				}
+				primary, others := uint(0), []uint{}
+				if name := row.values["group"]; name != "" {
+					id, err := lookup.group(name)
+					if err != nil {
+						addErr("%s", err.Error())
+					}
+					primary = id
+				} else if _, ok := row.values["group"]; !ok {
+					primary = user.GroupID
+				}
+				groupsCell, hasGroups := row.values["groups"]
+				if !hasGroups {
+					groupsCell = current["groups"]
+				}
+				for _, name := range splitImportList(groupsCell) {
+					id, err := lookup.group(name)
+					if err != nil {
+						addErr("%s", err.Error())
+						continue
+					}
+					others = append(others, id)
+				}
+				req.GroupID = &primary
+				req.GroupIDs = others
+			case "roles":
+				if !op.canAssignRole {
+					addErr("没有分配角色的权限，不能修改「角色」列")
+					continue
+				}
+				req.RoleIDs = nil
+				for _, name := range splitImportList(v) {
+					id, ok := lookup.roles[name]
+					if !ok {
+						addErr("角色「%s」不存在或已禁用", name)
+						continue
+					}
+					req.RoleIDs = append(req.RoleIDs, id)
+				}
+			case "manager":
+				managerID := uint(0)
+				if v != "" {
+					var manager models.User
+					if storage.DB.Select("id").Where("username = ? AND is_deleted = 0", v).First(&manager).Error != nil {
+						addErr("直属上级「%s」不存在", v)
+					}
+					managerID = manager.ID
+				}
+				req.ManagerID = &managerID
+			case "valid_from":
+				req.ValidFrom = &v
+			case "valid_until":
+				req.ValidUntil = &v
+			default:
+				if key := strings.TrimPrefix(field, "ext."); key != field {
+					if req.Attributes == nil {
+						req.Attributes = make(map[string]string)
+					}
+					req.Attributes[key] = v
+				}
+			}
+		}
+
+		if user.Source == "dingtalk" {
+			for _, ch := range row.Changes {
+				if ch.Field == "nickname" || ch.Field == "phone" || ch.Field == "email" || ch.Field == "group" || ch.Field == "groups" || ch.Field == "manager" || ch.Field == "job_title" {
+					addErr("钉钉同步用户的「%s」只能通过同步修改", ch.Label)
+				}
+			}
+		}
+		if len(row.Changes) == 0 || len(row.Errors) > 0 {
+			continue
+		}
+		if err := op.scopes.checkUpdate(user, req, op.canAssignRole); err != nil {
+			addErr("%s", err.Error())
+			continue
+		}
+		p, err := prepareUserUpdate(user, req)
+		if err != nil {
+			addErr("%s", err.Error())
+			continue
+		}
+		row.prepped = p
+	}
+}
+
+func statusLabel(status int8) string {
+	if status == 1 {
+		return "启用"
+	}
+	return "禁用"
+}
+
+// applyUpdateRow 执行一行操作，与单个编辑 / 启停 / 删除走相同的处理（同样投递同步任务）
+func applyUpdateRow(row *userUpdateRow, op sheetOperator) error {
+	switch row.Action {
+	case sheetActionDelete:
+		return deleteUserCascade(row.user)
+	case sheetActionDisable:
+		return applyUserStatus(row.user, 0, op.username)
+	case sheetActionEnable:
+		return applyUserStatus(row.user, 1, op.username)
+	}
+	return applyUserUpdate(row.prepped, op.canAssignRole, op.username)
+}
+
+func currentSheetOperator(c *gin.Context) sheetOperator {
+	return sheetOperator{
+		userID:        middleware.GetUserID(c),
+		username:      middleware.GetUsername(c),
+		canAssignRole: middleware.CheckPermission(c, "user:assign_role"),
+		canDelete:     middleware.CheckPermission(c, "user:delete"),
+		scopes:        currentUserScopes(c, "user:update"),
+	}
+}
+
+// updateRowsSummary 批量更新预览统计：待执行、无变化、有错误
+func updateRowsSummary(rows []*userUpdateRow) gin.H {
+	pending, unchanged, invalid := 0, 0, 0
+	for _, row := range rows {
+		switch {
+		case len(row.Errors) > 0:
+			invalid++
+		case row.Action != sheetActionDelete && len(row.Changes) == 0:
+			unchanged++
+		default:
+			pending++
+		}
+	}
+	return gin.H{"total": len(rows), "pending": pending, "unchanged": unchanged, "invalid": invalid}
+}
+
+// ---------- 接口 ----------
+
+// DownloadUserTemplate 下载用户表格模板：type=create 为空白新增导入模板，默认为包含当前用户的批量更新模板
+func DownloadUserTemplate(c *gin.Context) {
+	create := c.Query("type") == "create"
+	defs := storage.ListUserAttributeDefs()
+	cols := userSheetColumns(create, defs)
+
+	f := excelize.NewFile()
+	sheet := "用户"
+	f.SetSheetName("Sheet1", sheet)
+	for i, col := range cols {
+		f.SetCellValue(sheet, cellName(i+1, 1), col.header)
+		colName, _ := excelize.ColumnNumberToName(i + 1)
+		f.SetColWidth(sheet, colName, colName, col.width)
+	}
+	style, _ := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#E0E0E0"}},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+	f.SetCellStyle(sheet, "A1", cellName(len(cols), 1), style)
+	f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+
+	rows := 1
+	if !create {
+		var users []models.User
+		middleware.GetDataScope(c, "user:update").FilterUsers(storage.DB.Model(&models.User{})).
+			Preload("Roles").Where("is_deleted = 0").Order("id asc").Find(&users)
+		lookup := newImportLookup()
+		managerNames := make(map[uint]string, len(users))
+		for _, u := range users {
+			managerNames[u.ID] = u.Username
+		}
+		for i, u := range users {
+			values := userSheetValues(u, lookup, managerNames)
+			for j, col := range cols {
+				if v := values[col.field]; v != "" {
+					f.SetCellStr(sheet, cellName(j+1, i+2), v)
+				}
+			}
+		}
+		rows += len(users)
+
+		// 操作列下拉
+		dv := excelize.NewDataValidation(true)
+		dv.SetSqref(fmt.Sprintf("A2:A%d", rows+1000))
+		dv.SetDropList([]string{"更新", "禁用", "启用", "删除"})
+		f.AddDataValidation(sheet, dv)
+	}
+
+	name := "user_update"
+	if create {
+		name = "user_import_template"
+	}
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_%s.xlsx", name, time.Now().Format("20060102_150405")))
+	if err := f.Write(c.Writer); err != nil {
+		log.Printf("[导出] 用户模板导出失败: %v", err)
+	}
+}
+
+// PreviewUserBulkUpdate 上传修改后的表格，逐行比对当前值并返回差异预览
+func PreviewUserBulkUpdate(c *gin.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "请上传 .xlsx 或 .csv 文件")
+		return
+	}
+	records, err := readSpreadsheet(fh)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(records) < 2 {
+		respondError(c, http.StatusBadRequest, "文件中没有数据行")
+		return
+	}
+	fields, err := mapImportHeader(records[0], storage.ListUserAttributeDefs())
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows := parseUpdateRows(records[1:], fields)
+	if len(rows) == 0 {
+		respondError(c, http.StatusBadRequest, "文件中没有数据行")
+		return
+	}
+	if len(rows) > userImportMaxRows {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("单次最多处理 %d 行", userImportMaxRows))
+		return
+	}
+
+	op := currentSheetOperator(c)
+	validateUpdateRows(rows, op)
+	batch := &userImportBatch{
+		Token:      newImportToken(),
+		Kind:       "update",
+		Operator:   op.username,
+		FileName:   fh.Filename,
+		UpdateRows: rows,
+		ExpiresAt:  time.Now().Add(userImportBatchTTL),
+	}
+	storeUserImportBatch(batch)
+
+	summary := updateRowsSummary(rows)
+	summary["token"] = batch.Token
+	summary["fileName"] = batch.FileName
+	summary["rows"] = rows
+	summary["expiresAt"] = batch.ExpiresAt
+	respondOK(c, summary)
+}
+
+// CommitUserBulkUpdate 确认批量更新：重新校验后逐行执行，有错误或无变化的行跳过，返回每行结果
+func CommitUserBulkUpdate(c *gin.Context) {
+	op := currentSheetOperator(c)
+	batch := takeUserImportBatch(c.Param("token"), op.username, "update")
+	if batch == nil {
+		respondError(c, http.StatusNotFound, "批次不存在或已过期，请重新上传")
+		return
+	}
+
+	// 预览后数据可能已变化，提交前重新比对
+	validateUpdateRows(batch.UpdateRows, op)
+	counts := map[string]int{}
+	for _, row := range batch.UpdateRows {
+		switch {
+		case len(row.Errors) > 0:
+			row.Result, row.Message = "skipped", strings.Join(row.Errors, "；")
+		case row.Action != sheetActionDelete && len(row.Changes) == 0:
+			row.Result, row.Message = "unchanged", "无变化"
+		default:
+			if err := applyUpdateRow(row, op); err != nil {
+				row.Result, row.Message = "failed", err.Error()
+			} else {
+				row.Result = "success"
+			}
+		}
+		counts[row.Result]++
+	}
+
+	middleware.RecordOperationLog(c, "用户管理", "表格批量更新", batch.FileName,
+		fmt.Sprintf("成功 %d，失败 %d，跳过 %d，无变化 %d", counts["success"], counts["failed"], counts["skipped"], counts["unchanged"]))
+	respondOK(c, gin.H{
+		"success":   counts["success"],
+		"failed":    counts["failed"],
+		"skipped":   counts["skipped"],
+		"unchanged": counts["unchanged"],
+		"rows":      batch.UpdateRows,
+	})
+}
@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-syncflow/internal/middleware"
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 通行密钥（WebAuthn） ==========
+
+// 仪式用途
+const (
+	webAuthnPurposeRegister = "register" // 个人中心注册通行密钥
+	webAuthnPurposeLogin    = "login"    // 无密码登录
+	webAuthnPurposeMFA      = "mfa"      // 密码登录后的二次验证
+)
+
+const webAuthnMaxCredentials = 10
+
+// webAuthnCeremony 进行中的注册 / 认证仪式
+type webAuthnCeremony struct {
+	Purpose     string
+	UserID      uint // 无密码登录且未指定用户名时为 0
+	Challenge   []byte
+	RPID        string
+	Origins     []string
+	IP          string
+	Location    string
+	Fingerprint string
+	Risk        services.LoginRiskResult
+	ExpiresAt   time.Time
+}
+
+var (
+	webAuthnCeremonyStore     = make(map[string]*webAuthnCeremony)
+	webAuthnCeremonyStoreLock = &sync.Mutex{}
+)
+
+// webAuthnCredentialJSON 浏览器 PublicKeyCredential 序列化结果（二进制字段为 base64url）
+type webAuthnCredentialJSON struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+		AuthenticatorData string   `json:"authenticatorData"`
+		Signature         string   `json:"signature"`
+		UserHandle        string   `json:"userHandle"`
+	} `json:"response"`
+}
+
+// webAuthnRelyingParty 确定 RP ID 与允许的来源：优先使用配置，否则取页面来源（Origin 头）或访问域名
+func webAuthnRelyingParty(c *gin.Context, cfg services.WebAuthnConfig) (string, []string) {
+	origin := strings.TrimRight(c.GetHeader("Origin"), "/")
+	if origin == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
+			scheme = "https"
+		}
+		host := c.GetHeader("X-Forwarded-Host")
+		if host == "" {
+			host = c.Request.Host
+		}
+		origin = scheme + "://" + host
+	}
+
+	rpID := cfg.RPID
+	if rpID == "" {
+		if u, err := url.Parse(origin); err == nil {
+			rpID = u.Hostname()
+		}
+		if h, _, err := net.SplitHostPort(rpID); err == nil {
+			rpID = h
+		}
+	}
+	origins := cfg.Origins
+	if len(origins) == 0 {
+		origins = []string{origin}
+	}
+	return strings.ToLower(rpID), origins
+}
+
+// webAuthnUserHandle 用户句柄：用户 ID 的 8 字节大端编码
+func webAuthnUserHandle(userID uint) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(userID))
+	return b
+}
+
+// newWebAuthnCeremony 生成挑战并保存仪式状态，返回仪式令牌
+func newWebAuthnCeremony(ceremony *webAuthnCeremony, timeout time.Duration) string {
+	ceremony.Challenge = make([]byte, 32)
+	rand.Read(ceremony.Challenge)
+	ceremony.ExpiresAt = time.Now().Add(timeout)
+
+	token := newImportToken()
+	webAuthnCeremonyStoreLock.Lock()
+	defer webAuthnCeremonyStoreLock.Unlock()
+	now := time.Now()
+	for k, v := range webAuthnCeremonyStore {
+		if now.After(v.ExpiresAt) {
+			delete(webAuthnCeremonyStore, k)
+		}
+	}
+	webAuthnCeremonyStore[token] = ceremony
+	return token
+}
+
+// takeWebAuthnCeremony 取出并删除仪式（一次性使用），用途或 IP 不符、已过期时返回 nil
+func takeWebAuthnCeremony(token, ip string, purposes ...string) *webAuthnCeremony {
+	webAuthnCeremonyStoreLock.Lock()
+	defer webAuthnCeremonyStoreLock.Unlock()
+	ceremony, ok := webAuthnCeremonyStore[token]
+	if !ok {
+		return nil
+	}
+	delete(webAuthnCeremonyStore, token)
+	if time.Now().After(ceremony.ExpiresAt) || ceremony.IP != ip {
+		return nil
+	}
+	for _, p := range purposes {
+		if p == ceremony.Purpose {
+			return ceremony
+		}
+	}
+	return nil
+}
+
+// webAuthnDescriptors 凭据描述列表（excludeCredentials / allowCredentials）
+func webAuthnDescriptors(creds []models.WebAuthnCredential) []gin.H {
+	list := make([]gin.H, 0, len(creds))
+	for _, cred := range creds {
+		item := gin.H{"type": "public-key", "id": cred.CredentialID}
+		if cred.Transports != "" {
+			item["transports"] = strings.Split(cred.Transports, ",")
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// webAuthnRequestOptions 认证仪式参数（PublicKeyCredentialRequestOptions）
+func webAuthnRequestOptions(ceremony *webAuthnCeremony, cfg services.WebAuthnConfig, creds []models.WebAuthnCredential) gin.H {
+	return gin.H{
+		"challenge":        services.EncodeWebAuthnBase64(ceremony.Challenge),
+		"rpId":             ceremony.RPID,
+		"timeout":          cfg.TimeoutSeconds * 1000,
+		"userVerification": cfg.UserVerification,
+		"allowCredentials": webAuthnDescriptors(creds),
+	}
+}
+
+// GetWebAuthnStatus 登录页查询是否启用通行密钥登录（公开）
+func GetWebAuthnStatus(c *gin.Context) {
+	respondOK(c, gin.H{"enabled": services.GetSecurityService().GetWebAuthnConfig().Enabled})
+}
+
+// ---------- 注册 ----------
+
+// WebAuthnRegisterBegin 个人中心 - 开始注册通行密钥
+func WebAuthnRegisterBegin(c *gin.Context) {
+	cfg := services.GetSecurityService().GetWebAuthnConfig()
+	if !cfg.Enabled {
+		respondError(c, http.StatusForbidden, "通行密钥功能未启用")
+		return
+	}
+	var user models.User
+	if err := storage.DB.Where("id = ? AND is_deleted = 0", middleware.GetUserID(c)).First(&user).Error; err != nil {
+		respondError(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+	var creds []models.WebAuthnCredential
+	storage.DB.Where("user_id = ?", user.ID).Find(&creds)
+	if len(creds) >= webAuthnMaxCredentials {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("最多可注册 %d 个通行密钥", webAuthnMaxCredentials))
+		return
+	}
+
+	rpID, origins := webAuthnRelyingParty(c, cfg)
+	ceremony := &webAuthnCeremony{Purpose: webAuthnPurposeRegister, UserID: user.ID, RPID: rpID, Origins: origins, IP: c.ClientIP()}
+	token := newWebAuthnCeremony(ceremony, time.Duration(cfg.TimeoutSeconds)*time.Second)
+
+	displayName := user.Nickname
+	if displayName == "" {
+		displayName = user.Username
+	}
+	respondOK(c, gin.H{
+		"sessionToken": token,
+		"publicKey": gin.H{
+			"challenge": services.EncodeWebAuthnBase64(ceremony.Challenge),
+			"rp":        gin.H{"id": rpID, "name": cfg.RPName},
+			"user": gin.H{
+				"id":          services.EncodeWebAuthnBase64(webAuthnUserHandle(user.ID)),
+				"name":        user.Username,
+				"displayName": displayName,
+			},
+			"pubKeyCredParams": []gin.H{
+				{"type": "public-key", "alg": services.WebAuthnAlgES256},
+				{"type": "public-key", "alg": services.WebAuthnAlgEdDSA},
+				{"type": "public-key", "alg": services.WebAuthnAlgRS256},
+			},
+			"timeout":     cfg.TimeoutSeconds * 1000,
+			"attestation": "none",
+			"authenticatorSelection": gin.H{
+				"residentKey":      "preferred",
+				"userVerification": cfg.UserVerification,
+			},
+			"excludeCredentials": webAuthnDescriptors(creds),
+		},
+	})
+}
+
+// WebAuthnRegisterFinish 个人中心 - 完成注册通行密钥
+func WebAuthnRegisterFinish(c *gin.Context) {
+	var req struct {
+		SessionToken string                 `json:"sessionToken" binding:"required"`
+		Name         string                 `json:"name"`
+		Credential   webAuthnCredentialJSON `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	userID := middleware.GetUserID(c)
+	ceremony := takeWebAuthnCeremony(req.SessionToken, c.ClientIP(), webAuthnPurposeRegister)
+	if ceremony == nil || ceremony.UserID != userID {
+		respondError(c, http.StatusBadRequest, "注册已过期，请重试")
+		return
+	}
+
+	clientData, err1 := services.DecodeWebAuthnBase64(req.Credential.Response.ClientDataJSON)
+	attestation, err2 := services.DecodeWebAuthnBase64(req.Credential.Response.AttestationObject)
+	if err1 != nil || err2 != nil {
+		respondError(c, http.StatusBadRequest, "凭据数据格式错误")
+		return
+	}
+	cfg := services.GetSecurityService().GetWebAuthnConfig()
+	newCred, err := services.VerifyWebAuthnRegistration(ceremony.RPID, ceremony.Origins, ceremony.Challenge,
+		clientData, attestation, cfg.UserVerification == "required")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "通行密钥注册失败: "+err.Error())
+		return
+	}
+
+	var exists int64
+	storage.DB.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", newCred.CredentialID).Count(&exists)
+	if exists > 0 {
+		respondError(c, http.StatusBadRequest, "该通行密钥已注册")
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "通行密钥 " + time.Now().Format("2006-01-02 15:04")
+	}
+	if len([]rune(name)) > 64 {
+		name = string([]rune(name)[:64])
+	}
+	cred := models.WebAuthnCredential{
+		UserID:         userID,
+		Name:           name,
+		CredentialID:   newCred.CredentialID,
+		PublicKey:      newCred.PublicKey,
+		Algorithm:      newCred.Algorithm,
+		SignCount:      newCred.SignCount,
+		AAGUID:         newCred.AAGUID,
+		Transports:     strings.Join(req.Credential.Response.Transports, ","),
+		BackupEligible: newCred.BackupEligible,
+		CreatedAt:      time.Now(),
+	}
+	if err := storage.DB.Create(&cred).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, "保存通行密钥失败")
+		return
+	}
+
+	username := middleware.GetUsername(c)
+	services.GetSecurityService().RecordClientSecurityEvent(models.EventPasskeyRegistered, models.SeverityMedium, c.ClientIP(),
+		c.GetHeader("User-Agent"), clientLocation(c), &userID, username, "passkey", strconv.FormatUint(uint64(cred.ID), 10),
+		"注册通行密钥: "+cred.Name, nil)
+	middleware.RecordOperationLog(c, "个人中心", "注册通行密钥", cred.Name, "")
+	respondOK(c, cred)
+}
+
+// ---------- 凭据管理 ----------
+
+// ListMyWebAuthnCredentials 个人中心 - 我的通行密钥
+func ListMyWebAuthnCredentials(c *gin.Context) {
+	var creds []models.WebAuthnCredential
+	storage.DB.Where("user_id = ?", middleware.GetUserID(c)).Order("id ASC").Find(&creds)
+	respondOK(c, creds)
+}
+
+// RenameMyWebAuthnCredential 个人中心 - 重命名通行密钥
+func RenameMyWebAuthnCredential(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		respondError(c, http.StatusBadRequest, "名称不能为空")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if len([]rune(name)) > 64 {
+		name = string([]rune(name)[:64])
+	}
+	res := storage.DB.Model(&models.WebAuthnCredential{}).Where("id = ? AND user_id = ?", id, middleware.GetUserID(c)).Update("name", name)
+	if res.RowsAffected == 0 {
+		respondError(c, http.StatusNotFound, "通行密钥不存在")
+		return
+	}
+	respondOK(c, nil)
+}
+
+// DeleteMyWebAuthnCredential 个人中心 - 撤销通行密钥
+func DeleteMyWebAuthnCredential(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	revokeWebAuthnCredential(c, middleware.GetUserID(c), uint(id), "个人中心")
+}
+
+// ListUserWebAuthnCredentials 用户管理 - 查看用户的通行密钥
+func ListUserWebAuthnCredentials(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	var creds []models.WebAuthnCredential
+	storage.DB.Where("user_id = ?", id).Order("id ASC").Find(&creds)
+	respondOK(c, creds)
+}
+
+// DeleteUserWebAuthnCredential 用户管理 - 撤销用户的通行密钥
+func DeleteUserWebAuthnCredential(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	credID, _ := strconv.ParseUint(c.Param("credId"), 10, 32)
+	revokeWebAuthnCredential(c, uint(id), uint(credID), "用户管理")
+}
+
+func revokeWebAuthnCredential(c *gin.Context, userID, credID uint, module string) {
+	var cred models.WebAuthnCredential
+	if err := storage.DB.Where("id = ? AND user_id = ?", credID, userID).First(&cred).Error; err != nil {
+		respondError(c, http.StatusNotFound, "通行密钥不存在")
+		return
+	}
+	if err := storage.DB.Delete(&cred).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, "撤销失败")
+		return
+	}
+
+	var user models.User
+	storage.DB.Select("id, username").First(&user, userID)
+	operatorID := middleware.GetUserID(c)
+	services.GetSecurityService().RecordClientSecurityEvent(models.EventPasskeyRevoked, models.SeverityMedium, c.ClientIP(),
+		c.GetHeader("User-Agent"), clientLocation(c), &userID, user.Username, "passkey", strconv.FormatUint(uint64(cred.ID), 10),
+		fmt.Sprintf("撤销通行密钥: %s（操作人: %s）", cred.Name, middleware.GetUsername(c)), map[string]interface{}{"operatorId": operatorID})
+	middleware.RecordOperationLog(c, module, "撤销通行密钥", user.Username, cred.Name)
+	respondOK(c, nil)
+}
+
+// ---------- 登录 ----------
+
+// WebAuthnLoginBegin 通行密钥登录 - 开始（用户名可选，不填时使用可发现凭据）
+func WebAuthnLoginBegin(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	c.ShouldBindJSON(&req)
+
+	cfg := services.GetSecurityService().GetWebAuthnConfig()
+	if !cfg.Enabled {
+		respondError(c, http.StatusForbidden, "通行密钥登录未启用")
+		return
+	}
+
+	// 防枚举：用户不存在或未注册通行密钥时同样返回空的 allowCredentials
+	var creds []models.WebAuthnCredential
+	if username := strings.TrimSpace(req.Username); username != "" {
+		var user models.User
+		if storage.DB.Where("username = ? AND is_deleted = 0", username).First(&user).Error == nil {
+			storage.DB.Where("user_id = ?", user.ID).Find(&creds)
+		}
+	}
+
+	rpID, origins := webAuthnRelyingParty(c, cfg)
+	ceremony := &webAuthnCeremony{Purpose: webAuthnPurposeLogin, RPID: rpID, Origins: origins, IP: c.ClientIP()}
+	token := newWebAuthnCeremony(ceremony, time.Duration(cfg.TimeoutSeconds)*time.Second)
+	respondOK(c, gin.H{
+		"sessionToken": token,
+		"publicKey":    webAuthnRequestOptions(ceremony, cfg, creds),
+	})
+}
+
+// startPasskeySecondFactor 密码校验通过后，已注册通行密钥且开启二次验证的用户需再完成通行密钥验证
+// 已写入响应时返回 true
+func startPasskeySecondFactor(c *gin.Context, user models.User, location, fingerprint string, risk services.LoginRiskResult) bool {
+	cfg := services.GetSecurityService().GetWebAuthnConfig()
+	if !cfg.Enabled || !cfg.SecondFactor {
+		return false
+	}
+	var creds []models.WebAuthnCredential
+	storage.DB.Where("user_id = ?", user.ID).Find(&creds)
+	if len(creds) == 0 {
+		return false
+	}
+
+	rpID, origins := webAuthnRelyingParty(c, cfg)
+	ceremony := &webAuthnCeremony{
+		Purpose:     webAuthnPurposeMFA,
+		UserID:      user.ID,
+		RPID:        rpID,
+		Origins:     origins,
+		IP:          c.ClientIP(),
+		Location:    location,
+		Fingerprint: fingerprint,
+		Risk:        risk,
+	}
+	token := newWebAuthnCeremony(ceremony, time.Duration(cfg.TimeoutSeconds)*time.Second)
+	respondOK(c, gin.H{
+		"mfa":          true,
+		"sessionToken": token,
+		"publicKey":    webAuthnRequestOptions(ceremony, cfg, creds),
+	})
+	return true
+}
+
+// WebAuthnLoginFinish 通行密钥登录 / 二次验证 - 完成
+func WebAuthnLoginFinish(c *gin.Context) {
+	var req struct {
+		SessionToken string                 `json:"sessionToken" binding:"required"`
+		Credential   webAuthnCredentialJSON `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	ss := services.GetSecurityService()
+
+	ceremony := takeWebAuthnCeremony(req.SessionToken, clientIP, webAuthnPurposeLogin, webAuthnPurposeMFA)
+	if ceremony == nil {
+		respondError(c, http.StatusBadRequest, "验证已过期，请重新登录")
+		return
+	}
+
+	rawID, err := services.DecodeWebAuthnBase64(req.Credential.RawID)
+	if err != nil || len(rawID) == 0 {
+		respondError(c, http.StatusBadRequest, "凭据数据格式错误")
+		return
+	}
+	var cred models.WebAuthnCredential
+	if err := storage.DB.Where("credential_id = ?", services.EncodeWebAuthnBase64(rawID)).First(&cred).Error; err != nil ||
+		(ceremony.UserID != 0 && cred.UserID != ceremony.UserID) {
+		ss.RecordClientSecurityEvent(models.EventLoginFailed, models.SeverityMedium, clientIP, userAgent, clientLocation(c),
+			nil, "", "passkey", "", "通行密钥登录失败: 凭据未注册", nil)
+		respondError(c, http.StatusUnauthorized, "通行密钥未注册或已被撤销")
+		return
+	}
+	var user models.User
+	if err := storage.DB.Where("id = ? AND is_deleted = 0", cred.UserID).First(&user).Error; err != nil {
+		respondError(c, http.StatusUnauthorized, "通行密钥未注册或已被撤销")
+		return
+	}
+
+	// 与密码登录相同的前置检查
+	if ceremony.Purpose == webAuthnPurposeLogin && rejectBlockedLogin(c, user.Username) {
+		return
+	}
+	if rejectUnavailableUser(c, user) {
+		return
+	}
+
+	location, fingerprint := ceremony.Location, ceremony.Fingerprint
+	if ceremony.Purpose == webAuthnPurposeLogin {
+		location, fingerprint = clientLocation(c), deviceFingerprint(c)
+	}
+
+	clientData, err1 := services.DecodeWebAuthnBase64(req.Credential.Response.ClientDataJSON)
+	authData, err2 := services.DecodeWebAuthnBase64(req.Credential.Response.AuthenticatorData)
+	signature, err3 := services.DecodeWebAuthnBase64(req.Credential.Response.Signature)
+	userHandle, _ := services.DecodeWebAuthnBase64(req.Credential.Response.UserHandle)
+	if err1 != nil || err2 != nil || err3 != nil {
+		respondError(c, http.StatusBadRequest, "凭据数据格式错误")
+		return
+	}
+	if len(userHandle) > 0 && string(userHandle) != string(webAuthnUserHandle(user.ID)) {
+		respondError(c, http.StatusUnauthorized, "通行密钥与用户不匹配")
+		return
+	}
+
+	// 无密码登录时通行密钥即全部凭据，必须完成用户验证（PIN / 生物识别）
+	cfg := ss.GetWebAuthnConfig()
+	requireUV := ceremony.Purpose == webAuthnPurposeLogin || cfg.UserVerification == "required"
+	signCount, err := services.VerifyWebAuthnAssertion(ceremony.RPID, ceremony.Origins, ceremony.Challenge, &cred,
+		clientData, authData, signature, requireUV)
+	if err != nil {
+		ss.RecordLoginAttemptDetail(user.Username, &user.ID, clientIP, userAgent, location, fingerprint, false, "通行密钥验证失败")
+		ss.RecordClientSecurityEvent(models.EventLoginFailed, models.SeverityMedium, clientIP, userAgent, location,
+			&user.ID, user.Username, "passkey", strconv.FormatUint(uint64(cred.ID), 10), "通行密钥验证失败: "+err.Error(), nil)
+		ss.HandleFailedLogin(user.Username, clientIP)
+		middleware.RecordLoginLog(user.ID, user.Username, clientIP, userAgent, false, "通行密钥验证失败")
+		respondError(c, http.StatusUnauthorized, "通行密钥验证失败")
+		return
+	}
+
+	now := time.Now()
+	storage.DB.Model(&cred).Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"last_used_at": now,
+		"last_used_ip": clientIP,
+	})
+
+	if ceremony.Purpose == webAuthnPurposeMFA {
+		completeLogin(c, user, location, fingerprint, ceremony.Risk, "通行密钥二次验证")
+		return
+	}
+
+	// 通行密钥本身可抵御钓鱼，满足风险登录的二次验证要求，只处理阻止
+	risk := ss.AssessLoginRisk(user, clientIP, location, fingerprint)
+	if risk.Decision == services.LoginRiskBlock {
+		rejectRiskyLogin(c, user, location, fingerprint, risk)
+		return
+	}
+	completeLogin(c, user, location, fingerprint, risk, "通行密钥登录")
+}
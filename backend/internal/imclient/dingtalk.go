@@ -192,6 +192,8 @@ func (c *DingTalkClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, error)
 					Avatar     string  `json:"avatar"`
 					Title      string  `json:"title"`
 					DeptIDList []int64 `json:"dept_id_list"`
+					Leader     bool    `json:"leader"`
+					ManagerID  string  `json:"manager_userid"`
 				} `json:"list"`
 			} `json:"result"`
 		}
@@ -201,17 +203,26 @@ func (c *DingTalkClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, error)
 		if result.ErrCode != 0 {
 			return nil, fmt.Errorf("获取部门用户失败: %s", result.ErrMsg)
 		}
+		var raw struct {
+			Result struct {
+				List []map[string]interface{} `json:"list"`
+			} `json:"result"`
+		}
+		json.Unmarshal(body, &raw)
 
-		for _, u := range result.Result.List {
+		for i, u := range result.Result.List {
 			allUsers = append(allUsers, IMUserInfo{
-				UserID:   u.UserID,
-				Name:     u.Name,
-				Mobile:   u.Mobile,
-				Email:    u.Email,
-				Avatar:   u.Avatar,
-				JobTitle: u.Title,
-				DeptID:   deptID,
-				Active:   true,
+				UserID:     u.UserID,
+				Name:       u.Name,
+				Mobile:     u.Mobile,
+				Email:      u.Email,
+				Avatar:     u.Avatar,
+				JobTitle:   u.Title,
+				DeptID:     deptID,
+				Active:     true,
+				Extra:      rawUserAttrs(raw.Result.List, i),
+				ManagerID:  u.ManagerID,
+				DeptLeader: u.Leader,
 			})
 		}
 
@@ -185,6 +185,7 @@ func (c *FeishuClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, error) {
 						URL string `json:"avatar_240"`
 					} `json:"avatar"`
 					JobTitle  string `json:"job_title"`
+					LeaderID  string `json:"leader_user_id"`
 					Status    struct {
 						IsFrozen    bool `json:"is_frozen"`
 						IsActivated bool `json:"is_activated"`
@@ -196,21 +197,29 @@ func (c *FeishuClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, error) {
 		if result.Code != 0 {
 			return nil, fmt.Errorf("获取飞书用户列表失败: code=%d", result.Code)
 		}
+		var raw struct {
+			Data struct {
+				Items []map[string]interface{} `json:"items"`
+			} `json:"data"`
+		}
+		json.Unmarshal(body, &raw)
 
-		for _, u := range result.Data.Items {
+		for i, u := range result.Data.Items {
 			uid := u.OpenID
 			if u.UserID != "" {
 				uid = u.UserID
 			}
 			allUsers = append(allUsers, IMUserInfo{
-				UserID:   uid,
-				Name:     u.Name,
-				Mobile:   u.Mobile,
-				Email:    u.Email,
-				Avatar:   u.Avatar.URL,
-				JobTitle: u.JobTitle,
-				DeptID:   deptID,
-				Active:   u.Status.IsActivated && !u.Status.IsFrozen,
+				UserID:    uid,
+				Name:      u.Name,
+				Mobile:    u.Mobile,
+				Email:     u.Email,
+				Avatar:    u.Avatar.URL,
+				JobTitle:  u.JobTitle,
+				DeptID:    deptID,
+				Active:    u.Status.IsActivated && !u.Status.IsFrozen,
+				Extra:     rawUserAttrs(raw.Data.Items, i),
+				ManagerID: u.LeaderID,
 			})
 		}
 
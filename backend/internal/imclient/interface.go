@@ -1,7 +1,10 @@
 package imclient
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"go-syncflow/internal/models"
 )
@@ -25,6 +28,84 @@ type IMUserInfo struct {
 	DeptID   string
 	DeptName string
 	Active   bool
+	// 平台返回的原始字段（工号、入职日期、自定义字段等），供扩展属性映射使用
+	Extra map[string]string
+	// 直属上级的平台用户ID（钉钉 manager_userid / 飞书 leader_user_id / 企业微信 direct_leader）
+	ManagerID string
+	// 是否为当前部门负责人（钉钉 leader），缺少直属上级时按部门负责人推断
+	DeptLeader bool
+	// 所属全部部门ID（按部门遍历顺序，首个即 DeptID 主部门），由上游同步汇总填充
+	DeptIDs []string
+}
+
+// rawUserAttrs 将平台返回的第 i 个用户原始 JSON 展开为字符串字段
+// 嵌套对象展开为 "父.子"；字符串形式的 JSON 对象（如钉钉 extension）合并到顶层；
+// 企业微信 extattr.attrs 按 name 展开
+func rawUserAttrs(list []map[string]interface{}, i int) map[string]string {
+	if i >= len(list) {
+		return nil
+	}
+	attrs := make(map[string]string)
+	flattenRawAttrs(attrs, "", list[i], 0)
+	return attrs
+}
+
+func flattenRawAttrs(attrs map[string]string, prefix string, m map[string]interface{}, depth int) {
+	if depth > 3 {
+		return
+	}
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch x := v.(type) {
+		case string:
+			attrs[key] = x
+			if strings.HasPrefix(strings.TrimSpace(x), "{") {
+				var nested map[string]interface{}
+				if json.Unmarshal([]byte(x), &nested) == nil {
+					flattenRawAttrs(attrs, prefix, nested, depth+1)
+				}
+			}
+		case float64:
+			attrs[key] = strconv.FormatFloat(x, 'f', -1, 64)
+		case bool:
+			attrs[key] = strconv.FormatBool(x)
+		case map[string]interface{}:
+			if k == "extattr" {
+				if list, ok := x["attrs"].([]interface{}); ok {
+					for _, item := range list {
+						a, _ := item.(map[string]interface{})
+						name, _ := a["name"].(string)
+						if name == "" {
+							continue
+						}
+						if val, ok := a["value"].(string); ok {
+							attrs[name] = val
+						} else if text, ok := a["text"].(map[string]interface{}); ok {
+							attrs[name], _ = text["value"].(string)
+						}
+					}
+					continue
+				}
+			}
+			flattenRawAttrs(attrs, key, x, depth+1)
+		case []interface{}:
+			parts := make([]string, 0, len(x))
+			for _, item := range x {
+				switch iv := item.(type) {
+				case string:
+					parts = append(parts, iv)
+				case float64:
+					parts = append(parts, strconv.FormatFloat(iv, 'f', -1, 64))
+				}
+			}
+			if len(parts) > 0 {
+				attrs[key] = strings.Join(parts, ",")
+			}
+		}
+	}
 }
 
 // IMClient IM平台统一接口
@@ -142,13 +142,14 @@ func (c *WeChatWorkClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, erro
 	var result struct {
 		ErrCode  int `json:"errcode"`
 		UserList []struct {
-			UserID   string `json:"userid"`
-			Name     string `json:"name"`
-			Mobile   string `json:"mobile"`
-			Email    string `json:"email"`
-			Avatar   string `json:"avatar"`
-			Position string `json:"position"`
-			Status   int    `json:"status"`
+			UserID   string   `json:"userid"`
+			Name     string   `json:"name"`
+			Mobile   string   `json:"mobile"`
+			Email    string   `json:"email"`
+			Avatar   string   `json:"avatar"`
+			Position string   `json:"position"`
+			Status   int      `json:"status"`
+			Leaders  []string `json:"direct_leader"`
 		} `json:"userlist"`
 	}
 	json.Unmarshal(body, &result)
@@ -156,9 +157,14 @@ func (c *WeChatWorkClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, erro
 		return nil, fmt.Errorf("获取用户列表失败: errcode=%d", result.ErrCode)
 	}
 
+	var raw struct {
+		UserList []map[string]interface{} `json:"userlist"`
+	}
+	json.Unmarshal(body, &raw)
+
 	users := make([]IMUserInfo, 0, len(result.UserList))
-	for _, u := range result.UserList {
-		users = append(users, IMUserInfo{
+	for i, u := range result.UserList {
+		info := IMUserInfo{
 			UserID:   u.UserID,
 			Name:     u.Name,
 			Mobile:   u.Mobile,
@@ -167,7 +173,12 @@ func (c *WeChatWorkClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, erro
 			JobTitle: u.Position,
 			DeptID:   deptID,
 			Active:   u.Status == 1,
-		})
+			Extra:    rawUserAttrs(raw.UserList, i),
+		}
+		if len(u.Leaders) > 0 {
+			info.ManagerID = u.Leaders[0]
+		}
+		users = append(users, info)
 	}
 	return users, nil
 }
@@ -149,9 +149,13 @@ func (c *WeLinkClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, error) {
 		} `json:"data"`
 	}
 	json.Unmarshal(body, &result)
+	var raw struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(body, &raw)
 
 	users := make([]IMUserInfo, 0, len(result.Data))
-	for _, u := range result.Data {
+	for i, u := range result.Data {
 		users = append(users, IMUserInfo{
 			UserID:   u.UserID,
 			Name:     u.UserNameCn,
@@ -161,6 +165,7 @@ func (c *WeLinkClient) GetDepartmentUsers(deptID string) ([]IMUserInfo, error) {
 			JobTitle: u.Position,
 			DeptID:   deptID,
 			Active:   u.Status == "0", // 0=在职
+			Extra:    rawUserAttrs(raw.Data, i),
 		})
 	}
 	return users, nil
@@ -101,6 +101,34 @@ func BuildGroupDNMap(baseDN string) *GroupDNMap {
 	}
 }
 
+// AppendUserAttributes 发布 public 可见的扩展属性到用户条目（属性名为 LDAPAttribute 或 Key）
+func AppendUserAttributes(attrs map[string][]string, values map[string]string, defs []models.UserAttributeDef) {
+	for _, d := range defs {
+		if d.Visibility != models.AttrVisibilityPublic {
+			continue
+		}
+		v := values[d.Key]
+		if v == "" {
+			continue
+		}
+		name := d.LDAPAttribute
+		if name == "" {
+			name = d.Key
+		}
+		attrs[name] = []string{v}
+	}
+}
+
+// AppendReportingLine 写入汇报关系：manager 为上级 DN，directReports 为直接下属 DN 列表
+func AppendReportingLine(attrs map[string][]string, managerDN string, reportDNs []string) {
+	if managerDN != "" {
+		attrs["manager"] = []string{managerDN}
+	}
+	if len(reportDNs) > 0 {
+		attrs["directReports"] = reportDNs
+	}
+}
+
 // BuildUserEntry 将用户模型转换为 LDAP 属性映射
 // 用户的 DN 为 uid=username,{groupDN}（如果有群组）或 uid=username,{baseDN}
 func BuildUserEntry(user models.User, baseDN string, adminDN string, groupDNMap *GroupDNMap, roleNames []string, sambaEnabled bool, sambaSID string) (string, map[string][]string) {
@@ -166,6 +194,11 @@ func BuildUserEntry(user models.User, baseDN string, adminDN string, groupDNMap
 		}
 	}
 
+	// shadowExpire -> 账号失效日期（自 1970-01-01 起的天数）
+	if user.ValidUntil != nil {
+		attrs["shadowExpire"] = []string{strconv.FormatInt(user.ValidUntil.Unix()/86400, 10)}
+	}
+
 	// memberOf -> 角色 DN
 	if len(roleNames) > 0 {
 		memberOf := make([]string, 0, len(roleNames))
@@ -188,6 +221,10 @@ func BuildUserEntry(user models.User, baseDN string, adminDN string, groupDNMap
 		} else {
 			attrs["sambaPwdLastSet"] = []string{strconv.FormatInt(time.Now().Unix(), 10)}
 		}
+		// sambaKickoffTime -> 账号失效时间（Unix 时间戳）
+		if user.ValidUntil != nil {
+			attrs["sambaKickoffTime"] = []string{strconv.FormatInt(user.ValidUntil.Unix(), 10)}
+		}
 	}
 
 	// 操作属性
@@ -357,8 +357,11 @@ func (s *LDAPServer) handleSearch(w *gldap.ResponseWriter, r *gldap.Request) {
 				"( 1.3.6.1.1.1.1.1 NAME 'gidNumber' EQUALITY integerMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
 				"( 1.3.6.1.1.1.1.2 NAME 'homeDirectory' EQUALITY caseExactIA5Match SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 SINGLE-VALUE )",
 				"( 1.3.6.1.1.1.1.4 NAME 'loginShell' EQUALITY caseExactIA5Match SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 SINGLE-VALUE )",
+				"( 1.3.6.1.1.1.1.10 NAME 'shadowExpire' EQUALITY integerMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
 				"( 1.3.6.1.1.1.1.12 NAME 'memberUid' EQUALITY caseExactIA5Match SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 )",
 				"( 2.5.4.31 NAME 'member' SUP distinguishedName )",
+				"( 0.9.2342.19200300.100.1.10 NAME 'manager' EQUALITY distinguishedNameMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 )",
+				"( 1.2.840.113556.1.2.436 NAME 'directReports' SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 NO-USER-MODIFICATION )",
 				"( 2.16.840.1.113730.3.1.39 NAME 'preferredLanguage' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
 				"( 1.3.6.1.4.1.250.1.57 NAME 'labeledURI' EQUALITY caseExactMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
 				// Samba 属性
@@ -394,9 +397,9 @@ func (s *LDAPServer) handleSearch(w *gldap.ResponseWriter, r *gldap.Request) {
 				"( 2.5.6.0 NAME 'top' ABSTRACT MUST objectClass )",
 				"( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MUST ( sn $ cn ) MAY ( userPassword $ telephoneNumber $ description ) )",
 				"( 2.5.6.7 NAME 'organizationalPerson' SUP person STRUCTURAL MAY ( title $ ou $ street $ postalAddress $ postalCode $ st $ l ) )",
-				"( 2.16.840.1.113730.3.2.2 NAME 'inetOrgPerson' SUP organizationalPerson STRUCTURAL MAY ( mail $ uid $ displayName $ title $ givenName $ preferredLanguage $ labeledURI ) )",
+				"( 2.16.840.1.113730.3.2.2 NAME 'inetOrgPerson' SUP organizationalPerson STRUCTURAL MAY ( mail $ uid $ displayName $ title $ givenName $ manager $ preferredLanguage $ labeledURI ) )",
 				"( 1.3.6.1.1.1.2.0 NAME 'posixAccount' SUP top AUXILIARY MUST ( cn $ uid $ uidNumber $ gidNumber $ homeDirectory ) MAY ( userPassword $ loginShell $ description ) )",
-				"( 1.3.6.1.1.1.2.1 NAME 'shadowAccount' SUP top AUXILIARY MUST uid )",
+				"( 1.3.6.1.1.1.2.1 NAME 'shadowAccount' SUP top AUXILIARY MUST uid MAY ( userPassword $ shadowExpire $ description ) )",
 				"( 2.5.6.5 NAME 'organizationalUnit' SUP top STRUCTURAL MUST ou MAY description )",
 				"( 2.5.6.9 NAME 'groupOfNames' SUP top STRUCTURAL MUST ( member $ cn ) MAY ( description $ ou ) )",
 				"( 1.3.6.1.1.1.2.2 NAME 'posixGroup' SUP top STRUCTURAL MUST ( cn $ gidNumber ) MAY ( memberUid $ description ) )",
@@ -438,6 +441,9 @@ func (s *LDAPServer) handleSearch(w *gldap.ResponseWriter, r *gldap.Request) {
 	// 3-4. 先加载所有用户，构建群组成员映射，再生成群组和用户条目
 	var users []models.User
 	storage.DB.Where("is_deleted = 0 AND status = 1").Preload("Roles").Find(&users)
+	storage.LoadUserAttributes(users)
+	storage.LoadUserGroupIDs(users)
+	attrDefs := storage.ListUserAttributeDefs()
 
 	// 构建群组 ID -> 成员用户 DN 列表的映射
 	groupMemberDNs := make(map[uint][]string)
@@ -446,6 +452,7 @@ func (s *LDAPServer) handleSearch(w *gldap.ResponseWriter, r *gldap.Request) {
 		attrs map[string][]string
 	}
 	var userEntries []userEntry
+	userDNs := make(map[uint]string, len(users))
 
 	for _, user := range users {
 		var roleNames []string
@@ -457,14 +464,27 @@ func (s *LDAPServer) handleSearch(w *gldap.ResponseWriter, r *gldap.Request) {
 			roleNames = append(roleNames, code)
 		}
 		udn, uattrs := BuildUserEntry(user, cfg.BaseDN, ownerDN, groupDNMap, roleNames, cfg.SambaEnabled, cfg.SambaSID)
+		AppendUserAttributes(uattrs, user.Attributes, attrDefs)
 		userEntries = append(userEntries, userEntry{udn, uattrs})
+		userDNs[user.ID] = udn
 
-		// 记录该用户属于哪个群组
-		if user.GroupID > 0 {
-			groupMemberDNs[user.GroupID] = append(groupMemberDNs[user.GroupID], udn)
+		// 记录该用户属于哪些群组（主部门与兼职部门均为成员；条目 DN 位于主部门下）
+		for _, gid := range user.GroupIDs {
+			groupMemberDNs[gid] = append(groupMemberDNs[gid], udn)
 		}
 	}
 
+	// 汇报关系：manager / directReports（仅包含启用中的用户）
+	reportDNs := make(map[uint][]string)
+	for _, user := range users {
+		if user.ManagerID > 0 {
+			reportDNs[user.ManagerID] = append(reportDNs[user.ManagerID], userDNs[user.ID])
+		}
+	}
+	for i, user := range users {
+		AppendReportingLine(userEntries[i].attrs, userDNs[user.ManagerID], reportDNs[user.ID])
+	}
+
 	// 生成群组条目（带成员列表）
 	for _, group := range groupDNMap.Groups {
 		memberDNs := groupMemberDNs[group.ID]
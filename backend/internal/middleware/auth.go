@@ -4,7 +4,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"net/http"
 	"os"
 	"strings"
@@ -13,20 +13,21 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-
-	"go-syncflow/internal/models"
-	"go-syncflow/internal/storage"
 )
 
 var (
 	jwtSecret     []byte
 	jwtSecretOnce sync.Once
 
-	// 用户密码修改后，该用户的 token 最早签发时间（之前签发的全部作废）
-	tokenMinIssuedAt   = make(map[uint]time.Time)
-	tokenMinIssuedAtMu sync.RWMutex
+	// sessionValidator 校验访问令牌所属会话（终止、超时、IP 绑定），由 handlers 注册
+	sessionValidator func(sessionID string, userID uint, ip string) error
 )
 
+// SetSessionValidator 注册会话校验函数
+func SetSessionValidator(fn func(sessionID string, userID uint, ip string) error) {
+	sessionValidator = fn
+}
+
 // getJWTSecret 获取JWT密钥（优先使用环境变量，否则使用持久化密钥）
 func getJWTSecret() []byte {
 	jwtSecretOnce.Do(func() {
@@ -69,28 +70,29 @@ func GetJWTSecretHex() string {
 }
 
 type Claims struct {
-	UserID   uint   `json:"userId"`
-	Username string `json:"username"`
+	UserID    uint   `json:"userId"`
+	Username  string `json:"username"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uint, username string) (string, error) {
+// GenerateToken 签发访问令牌，绑定会话 ID，会话被撤销后令牌随即失效
+func GenerateToken(userID uint, username, sessionID string, expiresAt time.Time) (string, error) {
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:    userID,
+		Username:  username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(getJWTSecret())
+	return keyring.signToken(&claims)
 }
 
 func ParseToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return getJWTSecret(), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keyring.verifyKey,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}))
 	if err != nil {
 		return nil, err
 	}
@@ -124,56 +126,34 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		claims, err := ParseToken(parts[1])
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Token无效或已过期"})
-			c.Abort()
-			return
-		}
-
-		// 检查 token 是否因密码修改而被撤销
-		if claims.IssuedAt != nil && isTokenRevoked(claims.UserID, claims.IssuedAt.Time) {
-			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "密码已修改，请重新登录"})
+			msg := "Token无效或已过期"
+			if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, ErrSigningKeyUnavailable) {
+				// 前端据此用刷新令牌换取新的访问令牌（签名密钥轮换下线时同样处理）
+				c.Header("X-Token-Expired", "1")
+				msg = "Token已过期"
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": msg})
 			c.Abort()
 			return
 		}
 
-		tokenStr := parts[1]
-
-		// 检查会话是否被管理员终止，并自动追踪会话
-		if storage.DB != nil {
-			tokenHash := HashToken(tokenStr)
-			var session models.Session
-			err := storage.DB.Where("access_token = ?", tokenHash).First(&session).Error
-			if err == nil {
-				// session 存在，检查是否被终止
-				if !session.IsActive {
-					c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "会话已被终止，请重新登录"})
-					c.Abort()
-					return
-				}
-				// 更新最后活动时间（每5分钟更新一次，避免频繁写库）
-				if time.Since(session.LastActivity) > 5*time.Minute {
-					storage.DB.Model(&session).Update("last_activity", time.Now())
-				}
-			} else {
-				// session 不存在，自动创建（兼容之前没有 session 的登录）
-				newSession := models.Session{
-					ID:           generateSessionID(),
-					UserID:       claims.UserID,
-					AccessToken:  tokenHash,
-					RefreshToken: "",
-					IPAddress:    c.ClientIP(),
-					UserAgent:    c.GetHeader("User-Agent"),
-					IsActive:     true,
-					LastActivity: time.Now(),
-					ExpiresAt:    claims.ExpiresAt.Time,
-					CreatedAt:    time.Now(),
-				}
-				storage.DB.Create(&newSession)
+		// 检查会话是否被终止、超时（撤销状态持久化在 sessions 表，重启后依然有效）
+		if sessionValidator != nil {
+			if claims.SessionID == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "登录已过期，请重新登录"})
+				c.Abort()
+				return
+			}
+			if err := sessionValidator(claims.SessionID, claims.UserID, c.ClientIP()); err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": err.Error()})
+				c.Abort()
+				return
 			}
 		}
 
 		c.Set("userId", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("sessionId", claims.SessionID)
 		c.Next()
 	}
 }
@@ -184,30 +164,6 @@ func HashToken(token string) string {
 	return hex.EncodeToString(h[:])
 }
 
-// generateSessionID 生成会话ID
-func generateSessionID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	return fmt.Sprintf("%x", b)
-}
-
-// InvalidateUserTokens 使某用户在此时间点之前签发的所有 token 失效
-func InvalidateUserTokens(userID uint) {
-	tokenMinIssuedAtMu.Lock()
-	defer tokenMinIssuedAtMu.Unlock()
-	tokenMinIssuedAt[userID] = time.Now()
-}
-
-// isTokenRevoked 检查 token 是否已被撤销（签发时间早于密码修改时间）
-func isTokenRevoked(userID uint, issuedAt time.Time) bool {
-	tokenMinIssuedAtMu.RLock()
-	defer tokenMinIssuedAtMu.RUnlock()
-	if minTime, ok := tokenMinIssuedAt[userID]; ok {
-		return issuedAt.Before(minTime)
-	}
-	return false
-}
-
 func GetUserID(c *gin.Context) uint {
 	if v, exists := c.Get("userId"); exists {
 		return v.(uint)
@@ -221,3 +177,8 @@ func GetUsername(c *gin.Context) string {
 	}
 	return ""
 }
+
+// GetSessionID 当前访问令牌所属会话 ID
+func GetSessionID(c *gin.Context) string {
+	return c.GetString("sessionId")
+}
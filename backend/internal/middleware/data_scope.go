@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 委派管理的数据范围 ==========
+
+var (
+	ErrUserOutOfScope  = errors.New("该用户不在你的管理范围内")
+	ErrUserRoleBeyond  = errors.New("该用户拥有你无权授予的角色，不能由你管理")
+	ErrGroupOutOfScope = errors.New("只能调整你管理范围内的分组")
+	ErrRoleNotGrant    = errors.New("包含你无权授予的角色")
+)
+
+// DataScope 当前操作人可管理的数据范围
+// 角色分配未限定范围时不受限；限定了分组的分配只能管理这些分组（含子分组）内的用户，
+// 且只能授予指定角色，拥有其他角色的用户（如超级管理员）不能由其管理
+type DataScope struct {
+	allGroups bool
+	allRoles  bool
+	groupIDs  map[uint]bool
+	roleIDs   map[uint]bool
+}
+
+// ParseIDList 解析 JSON 数组形式的 ID 列表
+func ParseIDList(raw string) []uint {
+	var ids []uint
+	if raw != "" {
+		json.Unmarshal([]byte(raw), &ids)
+	}
+	return ids
+}
+
+// GetDataScope 计算当前请求对指定权限的数据范围（同一请求内缓存）
+// 取拥有任一权限的角色分配的并集；开放 API 以密钥创建者的范围为准，并受密钥自身的分组范围限制
+func GetDataScope(c *gin.Context, codes ...string) *DataScope {
+	key := "dataScope:" + strings.Join(codes, ",")
+	if v, ok := c.Get(key); ok {
+		return v.(*DataScope)
+	}
+	scope := loadDataScope(GetUserID(c), codes)
+	if c.GetString("authType") == "apikey" {
+		var apiKey models.APIKey
+		if storage.DB.Select("id, scope_group_ids").First(&apiKey, c.GetUint("apiKeyId")).Error == nil {
+			scope.narrowGroups(ParseIDList(apiKey.ScopeGroupIDs))
+		}
+	}
+	c.Set(key, scope)
+	return scope
+}
+
+func loadDataScope(userID uint, codes []string) *DataScope {
+	scope := &DataScope{groupIDs: map[uint]bool{}, roleIDs: map[uint]bool{}}
+	if userID == 0 {
+		return scope
+	}
+
+	var userRoles []models.UserRole
+	storage.DB.Where("user_id = ?", userID).Find(&userRoles)
+	var roleIDs []uint
+	for _, ur := range userRoles {
+		roleIDs = append(roleIDs, ur.RoleID)
+	}
+	if len(roleIDs) == 0 {
+		return scope
+	}
+	var permitted []uint
+	storage.DB.Model(&models.RolePermission{}).
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("role_permissions.role_id IN ? AND permissions.code IN ?", roleIDs, codes).
+		Distinct().Pluck("role_permissions.role_id", &permitted)
+	hasPerm := make(map[uint]bool, len(permitted))
+	for _, id := range permitted {
+		hasPerm[id] = true
+	}
+
+	var roots []uint
+	for _, ur := range userRoles {
+		if !hasPerm[ur.RoleID] {
+			continue
+		}
+		groups := ParseIDList(ur.ScopeGroupIDs)
+		if len(groups) == 0 {
+			scope.allGroups, scope.allRoles = true, true
+			return scope
+		}
+		roots = append(roots, groups...)
+		for _, id := range ParseIDList(ur.GrantableRoleIDs) {
+			scope.roleIDs[id] = true
+		}
+	}
+	for _, id := range storage.ExpandGroupIDs(roots) {
+		scope.groupIDs[id] = true
+	}
+	// 普通用户角色是新建用户的默认角色，无角色分配权限时同样会授予，受限管理员视为可授予
+	var defaultRole models.Role
+	if len(roots) > 0 && storage.DB.Select("id").Where("code = ?", "user").First(&defaultRole).Error == nil {
+		scope.roleIDs[defaultRole.ID] = true
+	}
+	return scope
+}
+
+// narrowGroups 进一步限定在指定分组子树内（开放 API 密钥的分组范围）
+func (s *DataScope) narrowGroups(roots []uint) {
+	if len(roots) == 0 {
+		return
+	}
+	narrowed := make(map[uint]bool)
+	for _, id := range storage.ExpandGroupIDs(roots) {
+		if s.allGroups || s.groupIDs[id] {
+			narrowed[id] = true
+		}
+	}
+	s.allGroups = false
+	s.groupIDs = narrowed
+}
+
+// Unrestricted 是否不受范围限制
+func (s *DataScope) Unrestricted() bool {
+	return s.allGroups && s.allRoles
+}
+
+// GroupIDs 可管理的分组（含子分组），不限分组时返回 nil
+func (s *DataScope) GroupIDs() []uint {
+	if s.allGroups {
+		return nil
+	}
+	ids := make([]uint, 0, len(s.groupIDs))
+	for id := range s.groupIDs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// FilterUsers 将用户查询限定在可管理的分组内（按成员关系，含兼职部门）
+func (s *DataScope) FilterUsers(query *gorm.DB) *gorm.DB {
+	if s.allGroups {
+		return query
+	}
+	if len(s.groupIDs) == 0 {
+		return query.Where("1 = 0")
+	}
+	return query.Where("id IN (?)", storage.GroupMemberUserIDs(s.GroupIDs()))
+}
+
+// CheckUserVisible 校验能否查看指定用户：用户须属于可管理的分组（含兼职部门）
+func (s *DataScope) CheckUserVisible(userID uint) error {
+	if s.allGroups {
+		return nil
+	}
+	var count int64
+	if len(s.groupIDs) > 0 {
+		storage.DB.Model(&models.UserGroupMember{}).
+			Where("user_id = ? AND group_id IN ?", userID, s.GroupIDs()).Count(&count)
+	}
+	if count == 0 {
+		return ErrUserOutOfScope
+	}
+	return nil
+}
+
+// CheckUser 校验能否管理指定用户：用户须属于可管理的分组，且不拥有无权授予的角色
+func (s *DataScope) CheckUser(userID uint) error {
+	if err := s.CheckUserVisible(userID); err != nil {
+		return err
+	}
+	if !s.allRoles {
+		var roleIDs []uint
+		storage.DB.Model(&models.UserRole{}).Where("user_id = ?", userID).Pluck("role_id", &roleIDs)
+		for _, id := range roleIDs {
+			if !s.roleIDs[id] {
+				return ErrUserRoleBeyond
+			}
+		}
+	}
+	return nil
+}
+
+// CheckGroupChange 校验分组变更：加入和移出的分组都必须在可管理范围内
+func (s *DataScope) CheckGroupChange(current, desired []uint) error {
+	if s.allGroups {
+		return nil
+	}
+	return checkChange(s.groupIDs, current, desired, ErrGroupOutOfScope)
+}
+
+// CheckRoleChange 校验角色变更：新增和移除的角色都必须可授予
+func (s *DataScope) CheckRoleChange(current, desired []uint) error {
+	if s.allRoles {
+		return nil
+	}
+	return checkChange(s.roleIDs, current, desired, ErrRoleNotGrant)
+}
+
+// checkChange 新增或移除的 ID 不在 allowed 中时返回 err，未变化的不校验
+func checkChange(allowed map[uint]bool, current, desired []uint, err error) error {
+	have := make(map[uint]bool, len(current))
+	for _, id := range current {
+		have[id] = true
+	}
+	want := make(map[uint]bool, len(desired))
+	for _, id := range desired {
+		want[id] = true
+		if !have[id] && !allowed[id] {
+			return err
+		}
+	}
+	for _, id := range current {
+		if !want[id] && !allowed[id] {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserScopeMiddleware 校验路径参数 :id 指向的用户在当前操作人的管理范围内
+// codes 与路由的权限码一致；查询只校验分组，修改还要求用户不拥有无权授予的角色；用户不存在时交由后续处理返回 404
+func UserScopeMiddleware(codes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+		var count int64
+		storage.DB.Model(&models.User{}).Where("id = ?", id).Count(&count)
+		if count > 0 {
+			scope := GetDataScope(c, codes...)
+			check := scope.CheckUser
+			if c.Request.Method == http.MethodGet {
+				check = scope.CheckUserVisible
+			}
+			if err := check(uint(id)); err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"success": false, "message": err.Error()})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,368 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 访问令牌签名密钥（RS256 / ES256 + kid 轮换） ==========
+//
+// 非对称签名时令牌头部携带 kid，其他服务可通过 /.well-known/jwks.json 获取公钥离线验签。
+// 轮换过程：新密钥提前 overlap_hours 以 pending 状态发布到 JWKS，到期后切换为签名密钥，
+// 旧密钥转为 retired 继续验签 overlap_hours 后过期。
+// 切换为非对称签名前签发的 HS256 令牌（无 kid）在过期前仍然有效。
+
+// 签名密钥状态
+const (
+	JWTKeyPending = "pending"
+	JWTKeyActive  = "active"
+	JWTKeyRetired = "retired"
+	JWTKeyExpired = "expired"
+	JWTKeyRevoked = "revoked"
+)
+
+// ErrSigningKeyUnavailable 令牌的签名密钥已轮换下线或被吊销，前端可用刷新令牌换取新令牌
+var ErrSigningKeyUnavailable = errors.New("签名密钥不可用")
+
+// JWTSigningConfig 签名配置（jwt_signing）
+type JWTSigningConfig struct {
+	Algorithm    string `json:"algorithm"` // HS256 | RS256 | ES256
+	RotationDays int    `json:"rotation_days"`
+	OverlapHours int    `json:"overlap_hours"`
+	Issuer       string `json:"issuer"`
+}
+
+type jwtVerifyKey struct {
+	kid       string
+	algorithm string
+	public    crypto.PublicKey
+}
+
+type jwtKeyring struct {
+	mu         sync.RWMutex
+	config     JWTSigningConfig
+	signKid    string
+	signAlg    string
+	signer     crypto.Signer
+	keys       map[string]*jwtVerifyKey
+	publish    []models.JWTSigningKey // JWKS 中发布的密钥（pending / active / retired）
+	hmacCutoff time.Time              // 签发时间早于此时间的 HS256 令牌仍接受；零值表示从未启用非对称签名
+	rotateMu   sync.Mutex
+}
+
+var keyring = &jwtKeyring{keys: make(map[string]*jwtVerifyKey)}
+
+// GetJWTSigningConfig 读取签名配置，未配置的项使用默认值
+func GetJWTSigningConfig() JWTSigningConfig {
+	cfg := JWTSigningConfig{Algorithm: "HS256", RotationDays: 90, OverlapHours: 24, Issuer: "go-syncflow"}
+	if storage.DB == nil {
+		return cfg
+	}
+	if value, err := storage.GetSecurityConfig("jwt_signing"); err == nil {
+		json.Unmarshal([]byte(value), &cfg)
+	}
+	switch cfg.Algorithm {
+	case "RS256", "ES256", "HS256":
+	default:
+		cfg.Algorithm = "HS256"
+	}
+	if cfg.OverlapHours <= 0 {
+		cfg.OverlapHours = 24
+	}
+	return cfg
+}
+
+// RotateJWTKeys 按配置检查并执行密钥轮换，force 为 true 时立即切换到新密钥
+// 由定时任务、配置修改和管理员手动轮换调用
+func RotateJWTKeys(force bool) (bool, error) {
+	keyring.rotateMu.Lock()
+	defer keyring.rotateMu.Unlock()
+
+	cfg := GetJWTSigningConfig()
+	now := time.Now()
+	overlap := time.Duration(cfg.OverlapHours) * time.Hour
+	rotated := false
+
+	// 退役密钥超过保留期后下线，清除私钥
+	storage.DB.Model(&models.JWTSigningKey{}).
+		Where("status = ? AND expires_at < ?", JWTKeyRetired, now).
+		Updates(map[string]interface{}{"status": JWTKeyExpired, "private_key": ""})
+
+	var active, pending *models.JWTSigningKey
+	var keys []models.JWTSigningKey
+	storage.DB.Where("status IN ?", []string{JWTKeyPending, JWTKeyActive}).Order("id").Find(&keys)
+	for i := range keys {
+		switch keys[i].Status {
+		case JWTKeyActive:
+			active = &keys[i]
+		case JWTKeyPending:
+			pending = &keys[i]
+		}
+	}
+
+	if cfg.Algorithm == "HS256" {
+		// 改回 HS256：当前密钥退役，预发布的密钥作废
+		if active != nil {
+			retireJWTKey(active, now, overlap)
+			rotated = true
+		}
+		if pending != nil {
+			storage.DB.Delete(pending)
+		}
+		return rotated, keyring.load(cfg)
+	}
+
+	if pending != nil && pending.Algorithm != cfg.Algorithm {
+		storage.DB.Delete(pending)
+		pending = nil
+	}
+
+	rotation := time.Duration(cfg.RotationDays) * 24 * time.Hour
+	due := force || active == nil || active.Algorithm != cfg.Algorithm ||
+		(cfg.RotationDays > 0 && active.ActivatedAt != nil && now.Sub(*active.ActivatedAt) >= rotation)
+
+	// 距离轮换不足 overlap 时提前发布下一把密钥，让验签方的 JWKS 缓存先拿到新公钥
+	if !due && cfg.RotationDays > 0 && pending == nil && active.ActivatedAt != nil &&
+		now.Sub(*active.ActivatedAt) >= rotation-overlap {
+		key, err := newJWTSigningKey(cfg.Algorithm, JWTKeyPending)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("[令牌签名] 已预发布下一个签名密钥 kid=%s（%s）", key.Kid, key.Algorithm)
+	}
+
+	if due {
+		next := pending
+		if next == nil {
+			key, err := newJWTSigningKey(cfg.Algorithm, JWTKeyPending)
+			if err != nil {
+				return false, err
+			}
+			next = key
+		}
+		storage.DB.Model(next).Updates(map[string]interface{}{"status": JWTKeyActive, "activated_at": now})
+		if active != nil {
+			retireJWTKey(active, now, overlap)
+		}
+		log.Printf("[令牌签名] 签名密钥已切换为 kid=%s（%s）", next.Kid, next.Algorithm)
+		rotated = true
+	}
+
+	return rotated, keyring.load(cfg)
+}
+
+// RevokeJWTKey 吊销签名密钥，由其签名的令牌立即失效（需用刷新令牌重新换取）
+// 吊销当前签名密钥时先切换到新密钥
+func RevokeJWTKey(kid string) error {
+	var key models.JWTSigningKey
+	if err := storage.DB.Where("kid = ?", kid).First(&key).Error; err != nil {
+		return fmt.Errorf("密钥不存在")
+	}
+	if key.Status == JWTKeyExpired || key.Status == JWTKeyRevoked {
+		return fmt.Errorf("密钥已下线")
+	}
+	if key.Status == JWTKeyActive && GetJWTSigningConfig().Algorithm != "HS256" {
+		if _, err := RotateJWTKeys(true); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	storage.DB.Model(&key).Updates(map[string]interface{}{
+		"status": JWTKeyRevoked, "private_key": "", "retired_at": now, "expires_at": now,
+	})
+	log.Printf("[令牌签名] 签名密钥 kid=%s 已吊销", kid)
+	return keyring.load(GetJWTSigningConfig())
+}
+
+// retireJWTKey 签名密钥退役，保留 overlap 时长用于验证其签发的令牌
+func retireJWTKey(key *models.JWTSigningKey, now time.Time, overlap time.Duration) {
+	storage.DB.Model(key).Updates(map[string]interface{}{
+		"status": JWTKeyRetired, "retired_at": now, "expires_at": now.Add(overlap),
+	})
+}
+
+// newJWTSigningKey 生成新的签名密钥并入库
+func newJWTSigningKey(algorithm, status string) (*models.JWTSigningKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case "RS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %s", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(pubDER)
+	key := &models.JWTSigningKey{
+		Kid:        base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+		Status:     status,
+	}
+	if err := storage.DB.Create(key).Error; err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// load 从数据库重新加载签名密钥和验签公钥
+func (k *jwtKeyring) load(cfg JWTSigningConfig) error {
+	var rows []models.JWTSigningKey
+	if err := storage.DB.Where("status IN ?", []string{JWTKeyPending, JWTKeyActive, JWTKeyRetired}).
+		Order("id").Find(&rows).Error; err != nil {
+		return err
+	}
+	var first []models.JWTSigningKey
+	storage.DB.Where("activated_at IS NOT NULL").Order("activated_at").Limit(1).Find(&first)
+	var firstActivated time.Time
+	if len(first) > 0 {
+		firstActivated = *first[0].ActivatedAt
+	}
+
+	keys := make(map[string]*jwtVerifyKey, len(rows))
+	var signer crypto.Signer
+	var signKid, signAlg string
+	now := time.Now()
+	for _, row := range rows {
+		if row.Status == JWTKeyRetired && row.ExpiresAt != nil && now.After(*row.ExpiresAt) {
+			continue
+		}
+		block, _ := pem.Decode([]byte(row.PublicKey))
+		if block == nil {
+			continue
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			log.Printf("[令牌签名] 公钥解析失败 kid=%s: %v", row.Kid, err)
+			continue
+		}
+		keys[row.Kid] = &jwtVerifyKey{kid: row.Kid, algorithm: row.Algorithm, public: pub}
+
+		if row.Status == JWTKeyActive && cfg.Algorithm == row.Algorithm {
+			block, _ := pem.Decode([]byte(row.PrivateKey))
+			if block == nil {
+				continue
+			}
+			priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				log.Printf("[令牌签名] 私钥解析失败 kid=%s: %v", row.Kid, err)
+				continue
+			}
+			if s, ok := priv.(crypto.Signer); ok {
+				signer, signKid, signAlg = s, row.Kid, row.Algorithm
+			}
+		}
+	}
+
+	k.mu.Lock()
+	k.config = cfg
+	k.keys = keys
+	k.publish = rows
+	k.signer, k.signKid, k.signAlg = signer, signKid, signAlg
+	k.hmacCutoff = firstActivated
+	k.mu.Unlock()
+	return nil
+}
+
+// signToken 使用当前签名密钥签发令牌，未启用非对称签名时使用 HS256
+func (k *jwtKeyring) signToken(claims *Claims) (string, error) {
+	k.mu.RLock()
+	signer, kid, alg, issuer := k.signer, k.signKid, k.signAlg, k.config.Issuer
+	k.mu.RUnlock()
+
+	claims.Issuer = issuer
+	if signer == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(getJWTSecret())
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims)
+	token.Header["kid"] = kid
+	return token.SignedString(signer)
+}
+
+// verifyKey 按令牌头部的 alg / kid 选择验签密钥，拒绝算法与密钥不匹配的令牌
+func (k *jwtKeyring) verifyKey(token *jwt.Token) (interface{}, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	alg := token.Method.Alg()
+	if alg == "HS256" {
+		if k.signer == nil || k.hmacCutoff.IsZero() {
+			return getJWTSecret(), nil
+		}
+		// 已启用非对称签名：只接受切换前签发的 HS256 令牌
+		if claims, ok := token.Claims.(*Claims); ok && claims.IssuedAt != nil && claims.IssuedAt.Before(k.hmacCutoff) {
+			return getJWTSecret(), nil
+		}
+		return nil, ErrSigningKeyUnavailable
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok || key.algorithm != alg {
+		return nil, ErrSigningKeyUnavailable
+	}
+	return key.public, nil
+}
+
+// JWKS 公开的验签公钥集合（RFC 7517）
+func JWKS() map[string]interface{} {
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+
+	list := make([]map[string]interface{}, 0, len(keyring.publish))
+	for _, row := range keyring.publish {
+		key, ok := keyring.keys[row.Kid]
+		if !ok {
+			continue
+		}
+		jwk := map[string]interface{}{"kid": row.Kid, "alg": row.Algorithm, "use": "sig"}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk["kty"] = "EC"
+			jwk["crv"] = pub.Curve.Params().Name
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			jwk["y"] = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		default:
+			continue
+		}
+		list = append(list, jwk)
+	}
+	return map[string]interface{}{"keys": list}
+}
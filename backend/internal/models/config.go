@@ -69,3 +69,46 @@ type HTTPSConfig struct {
 	CertExpiry  string `json:"certExpiry"`
 	CertSubject string `json:"certSubject"`
 }
+
+// IdentityConfig 多来源身份关联配置
+type IdentityConfig struct {
+	// 按顺序尝试的匹配规则；为空时沿用连接器的 IMMatchField
+	MatchRules []IdentityMatchRule `json:"matchRules"`
+	// 字段权威来源：本地字段 -> 按优先级排列的来源（连接器ID，0 表示本地编辑）
+	// 排在当前来源之前、且对该用户可用的来源存在时，当前来源不覆盖该字段
+	AttributePrecedence map[string][]uint `json:"attributePrecedence"`
+}
+
+// IdentityMatchRule 身份匹配规则：上游属性值等于本地字段值且唯一命中时关联
+type IdentityMatchRule struct {
+	Source string `json:"source"` // 上游属性：mobile / email / userid / 平台原始字段（如 job_number）
+	Field  string `json:"field"`  // 本地字段：phone / email / username / ext.<扩展属性>
+}
+
+// LifecycleConfig 用户生命周期（入职 / 调岗 / 离职）配置
+type LifecycleConfig struct {
+	Enabled              bool   `json:"enabled"`              // 启用后上游移除用户时走离职流程，代替直接禁用
+	DisabledOU           string `json:"disabledOU"`           // 离职用户移入的 AD OU 名称（位于同步目标容器下），为空则不移动
+	StripGroupsAfterDays int    `json:"stripGroupsAfterDays"` // 离职后 N 天移除角色与分组，0 表示不移除
+	DeleteAfterDays      int    `json:"deleteAfterDays"`      // 离职后 M 天删除用户，0 表示不删除
+	PreCreateDays        int    `json:"preCreateDays"`        // 入职日前 N 天预创建下游账号（禁用状态）
+	ExpiryNotifyDays     int    `json:"expiryNotifyDays"`     // 账号失效前 N 天通知本人及直属上级，0 表示不通知
+}
+
+// AccessRequestConfig 自助权限申请配置
+type AccessRequestConfig struct {
+	ApproverIDs         []uint `json:"approverIds"`         // 固定审批人，所有申请都可由其审批
+	ManagerApprove      bool   `json:"managerApprove"`      // 申请人的直属上级可审批
+	RequestableGroupIDs []uint `json:"requestableGroupIds"` // 允许自助申请加入的分组（含子分组），为空表示不开放分组申请
+	MaxDurationDays     int    `json:"maxDurationDays"`     // 授权时长上限（天），0 表示允许长期授权
+}
+
+// SyncQueueConfig 下游同步队列配置
+type SyncQueueConfig struct {
+	MaxAttempts        int `json:"maxAttempts"`        // 最大尝试次数，超过后进入死信
+	BackoffBaseSeconds int `json:"backoffBaseSeconds"` // 首次重试间隔（秒），之后按指数增长
+	BackoffMaxSeconds  int `json:"backoffMaxSeconds"`  // 最大重试间隔（秒）
+	DefaultConcurrency int `json:"defaultConcurrency"` // 每个连接器的默认并发数
+	RetentionDays      int `json:"retentionDays"`      // 已完成任务保留天数
+	CoalesceSeconds    int `json:"coalesceSeconds"`    // 合并窗口（秒）：窗口内同一用户的多次变更合并为一次写入
+}
@@ -6,7 +6,7 @@ import "time"
 type Connector struct {
 	ID        uint   `gorm:"primaryKey" json:"id"`
 	Name      string `gorm:"size:128;not null" json:"name"`
-	Type      string `gorm:"size:32;not null" json:"type"`      // im_dingtalk / im_wechatwork / im_feishu / im_welink / ldap_ad / db_mysql / db_postgresql / db_oracle / db_sqlserver
+	Type      string `gorm:"size:32;not null" json:"type"`      // im_dingtalk / im_wechatwork / im_feishu / im_welink / ldap_ad / db_mysql / db_postgresql / db_oracle / db_sqlserver / http_rest
 	Direction string `gorm:"size:16;not null;default:downstream" json:"direction"` // upstream / downstream / both
 
 	// === 通用 ===
@@ -14,6 +14,9 @@ type Connector struct {
 	Timeout int    `gorm:"default:5" json:"timeout"`
 	Config  string `gorm:"type:text" json:"config"` // 扩展 JSON 配置
 
+	// === 同步队列 ===
+	MaxConcurrency int `gorm:"default:0" json:"maxConcurrency"` // 下游同步并发数（0=使用队列默认值）
+
 	// === LDAP/AD 字段 ===
 	Host         string `gorm:"size:255" json:"host"`
 	Port         int    `gorm:"default:636" json:"port"`
@@ -82,6 +85,11 @@ func (c *Connector) IsLDAP() bool {
 	return c.Type == "ldap_ad" || c.Type == "ldap_generic"
 }
 
+// IsHTTP 判断是否为通用 HTTP/REST 接口类型
+func (c *Connector) IsHTTP() bool {
+	return c.Type == "http_rest"
+}
+
 // IsUpstream 是否支持上游
 func (c *Connector) IsUpstream() bool {
 	return c.Direction == "upstream" || c.Direction == "both"
@@ -156,6 +164,8 @@ func (c *Connector) ConnectorTypeName() string {
 		return "Oracle"
 	case "db_sqlserver":
 		return "SQL Server"
+	case "http_rest":
+		return "HTTP/REST"
 	default:
 		return c.Type
 	}
@@ -198,6 +208,10 @@ type SyncRule struct {
 	SourceType       string `gorm:"size:32;default:local" json:"sourceType"`
 	TargetContainer  string `gorm:"size:255" json:"targetContainer"` // AD: OU DN
 	PreventPwdChange bool   `gorm:"default:false" json:"preventPwdChange"`
+	DeltaSync        bool   `gorm:"default:true" json:"deltaSync"` // 全量同步时仅推送自上次以来变化的属性
+	// 定期对账间隔(小时)，0 表示仅手动对账
+	ReconcileInterval int        `gorm:"default:0" json:"reconcileInterval"`
+	LastReconcileAt   *time.Time `json:"lastReconcileAt"`
 
 	// === 状态 ===
 	Status          int8       `gorm:"default:1" json:"status"`
@@ -234,6 +248,11 @@ type Synchronizer struct {
 	SyncCount        int        `gorm:"default:0" json:"syncCount"`
 	CreatedAt        time.Time  `json:"createdAt"`
 	UpdatedAt        time.Time  `json:"updatedAt"`
+
+	// 运行时字段：增量同步时仅推送这些目标属性（nil 表示全部）
+	DeltaAttributes map[string]bool `gorm:"-" json:"-"`
+	// 运行时字段：由下游同步规则转换而来（ID 为规则 ID），仅此时记录属性指纹
+	FromRule bool `gorm:"-" json:"-"`
 }
 
 // SyncAttributeMapping 属性映射
@@ -269,6 +288,68 @@ type SyncLog struct {
 	CreatedAt      time.Time `json:"createdAt"`
 }
 
+// SyncJob 下游同步任务（持久化队列 / Outbox）
+// 用户变更时在同一事务内写入，由后台 worker 异步投递，失败按指数退避重试
+type SyncJob struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	SynchronizerID uint       `gorm:"index" json:"synchronizerId"` // 旧同步器
+	SyncRuleID     uint       `gorm:"index" json:"syncRuleId"`     // 下游同步规则
+	ConnectorID    uint       `gorm:"index" json:"connectorId"`
+	Event          string     `gorm:"size:32;not null" json:"event"`
+	UserID         uint       `gorm:"index" json:"userId"`
+	Username       string     `gorm:"size:64;index" json:"username"`
+	Snapshot       string     `gorm:"type:text" json:"-"` // 入队时的用户快照（删除事件使用）
+	Payload        string     `gorm:"type:text" json:"-"` // 加密的原文密码
+	Status         string     `gorm:"size:16;index;default:pending" json:"status"` // pending / running / success / dead / discarded
+	Attempts       int        `gorm:"default:0" json:"attempts"`
+	MaxAttempts    int        `gorm:"default:8" json:"maxAttempts"`
+	NextRunAt      time.Time  `gorm:"index" json:"nextRunAt"`
+	LastError      string     `gorm:"type:text" json:"lastError"`
+	StartedAt      *time.Time `json:"startedAt"`
+	FinishedAt     *time.Time `json:"finishedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// SyncFingerprint 下游规则已推送属性指纹（增量全量同步用）
+// Attributes 为 JSON: {目标属性: 值的 SHA256 前 16 位}，不保存原值
+type SyncFingerprint struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	SyncRuleID  uint      `gorm:"uniqueIndex:idx_fp_rule_user" json:"syncRuleId"`
+	UserID      uint      `gorm:"uniqueIndex:idx_fp_rule_user" json:"userId"`
+	ConnectorID uint      `gorm:"index" json:"connectorId"`
+	Attributes  string    `gorm:"type:text" json:"attributes"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// SyncReconcileReport 下游规则对账报告
+// Items 为 JSON 数组：孤儿/缺失/属性漂移条目及处理结果
+type SyncReconcileReport struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	SyncRuleID  uint      `gorm:"index" json:"syncRuleId"`
+	ConnectorID uint      `gorm:"index" json:"connectorId"`
+	LocalTotal  int       `json:"localTotal"`
+	TargetTotal int       `json:"targetTotal"`
+	Orphans     int       `json:"orphans"`
+	Missing     int       `json:"missing"`
+	Drifted     int       `json:"drifted"`
+	Items       string    `gorm:"type:text" json:"-"`
+	Version     int       `gorm:"default:0" json:"-"` // 乐观锁：处理条目时校验，避免并发处理互相覆盖
+	Duration    int64     `json:"duration"`           // 毫秒
+	CreatedBy   string    `gorm:"size:64" json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// 同步任务状态
+const (
+	SyncJobPending   = "pending"
+	SyncJobRunning   = "running"
+	SyncJobSuccess   = "success"
+	SyncJobDead      = "dead"
+	SyncJobDiscarded = "discarded"
+)
+
 // IMDepartment IM 平台部门缓存（通用化）
 type IMDepartment struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
@@ -296,6 +377,7 @@ type IMUser struct {
 	JobTitle       string    `gorm:"size:64" json:"jobTitle"`
 	DepartmentID   string    `gorm:"size:128" json:"departmentId"`
 	DepartmentName string    `gorm:"size:128" json:"departmentName"`
+	ManagerID      string    `gorm:"size:128" json:"managerId"` // 直属上级的平台用户ID
 	Active         bool      `gorm:"default:true" json:"active"`
 	LocalUserID    uint      `gorm:"index" json:"localUserId"`
 	CreatedAt      time.Time `json:"createdAt"`
@@ -362,7 +444,7 @@ var AllSyncEvents = []struct {
 var ConnectorTypeOptions = []struct {
 	Type      string `json:"type"`
 	Label     string `json:"label"`
-	Category  string `json:"category"`  // im / ldap / database
+	Category  string `json:"category"`  // im / ldap / database / http
 	Upstream  bool   `json:"upstream"`
 	Downstream bool  `json:"downstream"`
 	SSO       bool   `json:"sso"`
@@ -377,4 +459,5 @@ var ConnectorTypeOptions = []struct {
 	{"db_postgresql", "PostgreSQL", "database", true, true, false},
 	{"db_oracle", "Oracle", "database", true, true, false},
 	{"db_sqlserver", "SQL Server", "database", true, true, false},
+	{"http_rest", "HTTP/REST 接口", "http", false, true, false},
 }
@@ -90,8 +90,52 @@ type Session struct {
 	Location     string    `gorm:"size:128" json:"location"`
 	IsActive     bool      `gorm:"default:true;index" json:"isActive"`
 	LastActivity time.Time `json:"lastActivity"`
-	ExpiresAt    time.Time `json:"expiresAt"`
+	ExpiresAt    time.Time `json:"expiresAt"` // 绝对过期时间，刷新令牌无法延长
 	CreatedAt    time.Time `json:"createdAt"`
+
+	RefreshExpiresAt time.Time  `json:"refreshExpiresAt"`
+	RevokedAt        *time.Time `json:"revokedAt"`
+	RevokeReason     string     `gorm:"size:128" json:"revokeReason"`
+}
+
+// JWTSigningKey 访问令牌签名密钥（RS256 / ES256），令牌头部 kid 指向该密钥
+// pending 已发布到 JWKS 但尚未用于签名，active 为当前签名密钥，retired 只用于验签直至 ExpiresAt
+type JWTSigningKey struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Kid         string     `gorm:"size:64;uniqueIndex;not null" json:"kid"`
+	Algorithm   string     `gorm:"size:16;not null" json:"algorithm"`
+	PrivateKey  string     `gorm:"type:text" json:"-"`          // PKCS#8 PEM，吊销或过期后清空
+	PublicKey   string     `gorm:"type:text" json:"publicKey"`  // PKIX PEM
+	Status      string     `gorm:"size:16;index" json:"status"` // pending | active | retired | expired | revoked
+	CreatedAt   time.Time  `json:"createdAt"`
+	ActivatedAt *time.Time `json:"activatedAt"`
+	RetiredAt   *time.Time `json:"retiredAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+// RotatedRefreshToken 已轮换作废的刷新令牌摘要，再次出现即视为令牌被盗用（重放）
+type RotatedRefreshToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SessionID string    `gorm:"size:64;index;not null" json:"sessionId"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	RotatedAt time.Time `gorm:"index" json:"rotatedAt"`
+}
+
+// WebAuthnCredential 用户注册的通行密钥（WebAuthn 凭据）
+type WebAuthnCredential struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         uint       `gorm:"index;not null" json:"userId"`
+	Name           string     `gorm:"size:64" json:"name"`                                // 用户自定义名称
+	CredentialID   string     `gorm:"size:1024;uniqueIndex;not null" json:"credentialId"` // base64url
+	PublicKey      string     `gorm:"type:text;not null" json:"-"`                        // COSE 公钥（base64）
+	Algorithm      int        `json:"algorithm"`                                          // COSE 算法：-7 ES256 / -257 RS256 / -8 EdDSA
+	SignCount      uint32     `json:"signCount"`
+	AAGUID         string     `gorm:"size:36" json:"aaguid"`
+	Transports     string     `gorm:"size:128" json:"transports"` // 逗号分隔：internal,usb,nfc,ble,hybrid
+	BackupEligible bool       `json:"backupEligible"`             // 可同步的通行密钥（如 iCloud 钥匙串）
+	LastUsedAt     *time.Time `json:"lastUsedAt"`
+	LastUsedIP     string     `gorm:"size:45" json:"lastUsedIp"`
+	CreatedAt      time.Time  `json:"createdAt"`
 }
 
 // SecurityConfig 安全配置
@@ -131,6 +175,7 @@ type AlertRule struct {
 	NotifyUserIDs     string     `gorm:"type:text" json:"notifyUserIds"`           // JSON数组 用户ID（管理员告警+user模式）
 	TemplateID        uint       `gorm:"default:0" json:"templateId"`              // 关联消息模板ID，0=使用默认模板
 	CooldownMinutes   int        `gorm:"default:5" json:"cooldownMinutes"`
+	Actions           string     `gorm:"type:text" json:"actions"` // JSON数组 自动响应动作 [{type, durationMinutes}]
 	IsActive          bool       `gorm:"default:true" json:"isActive"`
 	LastTriggeredAt   *time.Time `json:"lastTriggeredAt"`
 	CreatedAt         time.Time  `json:"createdAt"`
@@ -142,6 +187,7 @@ type AlertLog struct {
 	ID           uint       `gorm:"primaryKey" json:"id"`
 	RuleID       *uint      `gorm:"index" json:"ruleId"`
 	EventID      *uint      `gorm:"index" json:"eventId"`
+	IncidentID   *uint      `gorm:"index" json:"incidentId"`
 	ChannelID    *uint      `json:"channelId"`
 	ChannelType  string     `gorm:"size:32;not null" json:"channelType"`
 	Recipient    string     `gorm:"size:255;not null" json:"recipient"`
@@ -152,6 +198,70 @@ type AlertLog struct {
 	CreatedAt    time.Time  `json:"createdAt"`
 }
 
+// AlertIncident 告警事件聚合：同一规则、同一分组在聚合窗口内的多次匹配合并为一条，只通知一次
+type AlertIncident struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	RuleID       uint       `gorm:"index;not null" json:"ruleId"`
+	RuleName     string     `gorm:"size:64" json:"ruleName"`
+	GroupKey     string     `gorm:"size:128;index" json:"groupKey"` // 分组键，如 ip24:10.0.0.0/24、user:zhangsan
+	Severity     string     `gorm:"size:16" json:"severity"`
+	Summary      string     `gorm:"size:512" json:"summary"`
+	EventCount   int        `gorm:"default:0" json:"eventCount"`
+	EventIDs     string     `gorm:"type:text" json:"eventIds"` // JSON数组，最多保留最近 100 个
+	FirstEventID uint       `json:"firstEventId"`
+	LastEventID  uint       `json:"lastEventId"`
+	Status       string     `gorm:"size:16;default:open;index" json:"status"` // open | resolved
+	FirstSeenAt  time.Time  `json:"firstSeenAt"`
+	LastSeenAt   time.Time  `gorm:"index" json:"lastSeenAt"`
+	NotifiedAt   *time.Time `json:"notifiedAt"`
+	ResolvedBy   string     `gorm:"size:64" json:"resolvedBy"`
+	ResolvedAt   *time.Time `json:"resolvedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// AlertActionLog 告警自动响应动作记录（审计 + 一键撤销）
+type AlertActionLog struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	RuleID     uint       `gorm:"index" json:"ruleId"`
+	RuleName   string     `gorm:"size:64" json:"ruleName"`
+	IncidentID *uint      `gorm:"index" json:"incidentId"`
+	EventID    *uint      `json:"eventId"`
+	ActionType string     `gorm:"size:32;index;not null" json:"actionType"` // block_ip | lock_account | terminate_sessions | force_password_change | disable_user
+	Target     string     `gorm:"size:128;index" json:"target"`            // IP 或用户名
+	UserID     *uint      `gorm:"index" json:"userId"`
+	Status     string     `gorm:"size:16;index" json:"status"` // success | failed | skipped | reverted
+	Message    string     `gorm:"size:512" json:"message"`
+	RevertData string     `gorm:"type:text" json:"-"` // JSON 撤销所需的原始状态
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevertedBy string     `gorm:"size:64" json:"revertedBy"`
+	RevertedAt *time.Time `json:"revertedAt"`
+	CreatedAt  time.Time  `gorm:"index" json:"createdAt"`
+}
+
+// 告警自动响应动作类型
+const (
+	AlertActionBlockIP             = "block_ip"
+	AlertActionLockAccount         = "lock_account"
+	AlertActionTerminateSessions   = "terminate_sessions"
+	AlertActionForcePasswordChange = "force_password_change"
+	AlertActionDisableUser         = "disable_user"
+)
+
+// 告警自动响应动作状态
+const (
+	AlertActionSuccess  = "success"
+	AlertActionFailed   = "failed"
+	AlertActionSkipped  = "skipped"
+	AlertActionReverted = "reverted"
+)
+
+// 告警事件聚合状态
+const (
+	IncidentStatusOpen     = "open"
+	IncidentStatusResolved = "resolved"
+)
+
 // MessageTemplate 消息模板
 type MessageTemplate struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
@@ -188,6 +298,7 @@ const (
 	EventLoginSuccess       = "login_success"
 	EventLoginFailed        = "login_failed"
 	EventLoginBlocked       = "login_blocked"
+	EventLoginChallenged    = "login_challenged"
 	EventAccountLocked      = "account_locked"
 	EventAccountUnlocked    = "account_unlocked"
 	EventPasswordChanged    = "password_changed"
@@ -204,6 +315,9 @@ const (
 	EventBruteForceDetected = "brute_force_detected"
 	EventAnomalyDetected    = "anomaly_detected"
 	EventGeoBlocked         = "geo_blocked"
+	EventPasskeyRegistered  = "passkey_registered"
+	EventPasskeyRevoked     = "passkey_revoked"
+	EventRefreshTokenReused = "refresh_token_reused"
 )
 
 // 严重级别常量
@@ -222,22 +336,23 @@ const (
 
 // APIKey 开放接口密钥
 type APIKey struct {
-	ID          uint       `gorm:"primaryKey" json:"id"`
-	AppID       string     `gorm:"size:64;uniqueIndex;not null" json:"appId"`
-	AppKey      string     `gorm:"size:128;not null" json:"-"`            // 存储SHA256哈希
-	AppKeyHint  string     `gorm:"size:16" json:"appKeyHint"`            // 显示后4位
-	Name        string     `gorm:"size:128;not null" json:"name"`        // 名称/备注
-	Description string     `gorm:"size:512" json:"description"`          // 详细描述
-	Permissions string     `gorm:"type:text" json:"permissions"`         // JSON数组：允许的权限范围 ["user:list","user:create"...]，空=全部
-	IPWhitelist string     `gorm:"type:text" json:"ipWhitelist"`         // JSON数组：IP白名单，空=不限制
-	IPBlacklist string     `gorm:"type:text" json:"ipBlacklist"`         // JSON数组：IP黑名单
-	RateLimit   int        `gorm:"default:60" json:"rateLimit"`          // 每分钟请求上限
-	IsActive    bool       `gorm:"default:true;index" json:"isActive"`
-	LastUsedAt  *time.Time `json:"lastUsedAt"`
-	LastUsedIP  string     `gorm:"size:45" json:"lastUsedIp"`
-	UsageCount  int64      `gorm:"default:0" json:"usageCount"`
-	ExpiresAt   *time.Time `json:"expiresAt"`                            // 过期时间，NULL=永不过期
-	CreatedBy   uint       `json:"createdBy"`
-	CreatedAt   time.Time  `json:"createdAt"`
-	UpdatedAt   time.Time  `json:"updatedAt"`
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	AppID         string     `gorm:"size:64;uniqueIndex;not null" json:"appId"`
+	AppKey        string     `gorm:"size:128;not null" json:"-"`     // 存储SHA256哈希
+	AppKeyHint    string     `gorm:"size:16" json:"appKeyHint"`      // 显示后4位
+	Name          string     `gorm:"size:128;not null" json:"name"`  // 名称/备注
+	Description   string     `gorm:"size:512" json:"description"`    // 详细描述
+	Permissions   string     `gorm:"type:text" json:"permissions"`   // JSON数组：允许的权限范围 ["user:list","user:create"...]，空=全部
+	IPWhitelist   string     `gorm:"type:text" json:"ipWhitelist"`   // JSON数组：IP白名单，空=不限制
+	IPBlacklist   string     `gorm:"type:text" json:"ipBlacklist"`   // JSON数组：IP黑名单
+	ScopeGroupIDs string     `gorm:"type:text" json:"scopeGroupIds"` // JSON数组：限定可操作的分组（含子分组），空=与创建者一致
+	RateLimit     int        `gorm:"default:60" json:"rateLimit"`    // 每分钟请求上限
+	IsActive      bool       `gorm:"default:true;index" json:"isActive"`
+	LastUsedAt    *time.Time `json:"lastUsedAt"`
+	LastUsedIP    string     `gorm:"size:45" json:"lastUsedIp"`
+	UsageCount    int64      `gorm:"default:0" json:"usageCount"`
+	ExpiresAt     *time.Time `json:"expiresAt"` // 过期时间，NULL=永不过期
+	CreatedBy     uint       `json:"createdBy"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
 }
@@ -22,10 +22,16 @@ type User struct {
 	DepartmentName      string     `gorm:"size:128" json:"departmentName"`         // 部门名称
 	JobTitle            string     `gorm:"size:64" json:"jobTitle"`                // 职位
 	GroupID             uint       `gorm:"index;default:0" json:"groupId"`         // 本地分组ID
+	ManagerID           uint       `gorm:"index;default:0" json:"managerId"`       // 直属上级用户ID，0 表示无
+	LifecycleState      string     `gorm:"size:16;index" json:"lifecycleState"`    // 生命周期状态：空 / pending_join / pre_created / left
+	ValidFrom           *time.Time `gorm:"index" json:"validFrom"`                 // 账号生效时间，到期自动启用
+	ValidUntil          *time.Time `gorm:"index" json:"validUntil"`                // 账号失效时间，到期自动禁用
+	ExpiryNotifiedAt    *time.Time `json:"-"`                                      // 已发送到期提醒的时间，修改失效时间后重置
 	SambaNTPassword     string     `gorm:"size:64" json:"-"`                       // Samba NT密码哈希
 	// 安全相关字段
 	PasswordChangedAt   *time.Time `json:"passwordChangedAt"`
 	PasswordHistory     string     `gorm:"type:text" json:"-"`
+	PasswordSkeletons   string     `gorm:"type:text" json:"-"` // 历史密码归一化形式的哈希，用于相似度检查
 	FailedAttempts      int        `gorm:"default:0" json:"-"`
 	LockedUntil         *time.Time `json:"lockedUntil"`
 	LockCount           int        `gorm:"default:0" json:"-"`
@@ -34,6 +40,10 @@ type User struct {
 	LastLoginIP         string     `gorm:"size:45" json:"lastLoginIp"`
 	LastLoginAt         *time.Time `json:"lastLoginAt"`
 	ForcePasswordChange bool       `gorm:"default:false" json:"forcePasswordChange"`
+	// 扩展属性（按需加载，不落 users 表）
+	Attributes map[string]string `gorm:"-" json:"attributes,omitempty"`
+	// 所属全部分组ID（主部门在前，按需加载）；GroupID 为主部门
+	GroupIDs []uint `gorm:"-" json:"groupIds,omitempty"`
 }
 
 type Role struct {
@@ -44,6 +54,8 @@ type Role struct {
 	Status       int8         `gorm:"default:1" json:"status"`
 	SidebarMode  string       `gorm:"size:16;default:auto" json:"sidebarMode"`  // auto=自动 | visible=始终显示 | hidden=始终隐藏
 	LandingPage  string       `gorm:"size:255" json:"landingPage"`              // 自定义登录后首页路径，空则自动检测
+	OwnerID      uint         `gorm:"default:0" json:"ownerId"`                 // 角色负责人，审批该角色的自助申请
+	Requestable  bool         `gorm:"default:false" json:"requestable"`         // 是否允许用户在个人中心自助申请
 	CreatedAt    time.Time    `json:"createdAt"`
 	UpdatedAt    time.Time    `json:"updatedAt"`
 	Permissions  []Permission `gorm:"many2many:role_permissions" json:"permissions,omitempty"`
@@ -71,10 +83,171 @@ type UserGroup struct {
 	UpdatedAt      time.Time `json:"updatedAt"`
 }
 
+// UserGroupMember 用户与分组的多对多关系（一个用户可属于多个部门，主部门同时记录在 User.GroupID）
+type UserGroupMember struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_group" json:"userId"`
+	GroupID   uint      `gorm:"not null;uniqueIndex:idx_user_group;index" json:"groupId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserIdentity 用户在外部来源（连接器）中的身份，每个用户在每个连接器下至多一个
+type UserIdentity struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;index;uniqueIndex:idx_identity_user_conn" json:"userId"`
+	ConnectorID uint      `gorm:"not null;uniqueIndex:idx_identity_user_conn;uniqueIndex:idx_identity_external" json:"connectorId"`
+	ExternalID  string    `gorm:"size:128;not null;uniqueIndex:idx_identity_external" json:"externalId"`
+	Source      string    `gorm:"size:32" json:"source"`       // 连接器类型，如 im_dingtalk
+	DisplayName string    `gorm:"size:128" json:"displayName"` // 外部来源中的姓名
+	LinkedBy    string    `gorm:"size:64" json:"linkedBy"`     // created / match:<字段> / legacy / merge / split
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// UserFieldSource 用户字段的本地编辑记录：字段由本地手动编辑（ConnectorID 为 0）或被锁定时记录，
+// 供字段权威来源判定使用；上游按优先级覆盖后 ConnectorID 改为该连接器
+type UserFieldSource struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_user_field" json:"userId"`
+	Field       string    `gorm:"size:64;not null;uniqueIndex:idx_user_field" json:"field"` // 本地字段，如 nickname / ext.<key>
+	ConnectorID uint      `gorm:"default:0" json:"connectorId"`                             // 最近写入来源，0 表示本地编辑
+	Locked      bool      `gorm:"default:false" json:"locked"`                              // 锁定后任何上游都不覆盖
+	UpdatedBy   string    `gorm:"size:64" json:"updatedBy"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// 用户生命周期状态
+const (
+	LifecyclePendingJoin = "pending_join" // 待入职：尚未预创建下游账号，不投递同步事件
+	LifecyclePreCreated  = "pre_created"  // 已预创建：下游账号已创建（禁用），入职日启用
+	LifecycleLeft        = "left"         // 已离职：已禁用，等待移除分组与删除
+)
+
+// LifecycleTask 用户生命周期阶段任务（入职 / 调岗 / 离职），到期由调度器执行
+type LifecycleTask struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index" json:"userId"`
+	Username    string     `gorm:"size:64;index" json:"username"`
+	Flow        string     `gorm:"size:16;index" json:"flow"`                   // join / move / leave
+	Stage       string     `gorm:"size:32" json:"stage"`                        // pre_create / activate / move / disable / strip_groups / delete
+	Status      string     `gorm:"size:16;index;default:pending" json:"status"` // pending / running / done / failed / cancelled
+	ScheduledAt time.Time  `gorm:"index" json:"scheduledAt"`
+	Payload     string     `gorm:"type:text" json:"payload"` // JSON：阶段参数（如调岗的目标分组）
+	Message     string     `gorm:"type:text" json:"message"`
+	CreatedBy   string     `gorm:"size:64" json:"createdBy"`
+	CancelledBy string     `gorm:"size:64" json:"cancelledBy"`
+	ExecutedAt  *time.Time `json:"executedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// 生命周期任务状态
+const (
+	LifecycleTaskPending   = "pending"
+	LifecycleTaskRunning   = "running"
+	LifecycleTaskDone      = "done"
+	LifecycleTaskFailed    = "failed"
+	LifecycleTaskCancelled = "cancelled"
+)
+
+// AccessRequest 用户自助申请角色 / 分组，审批通过后授予，可限定有效期到期自动收回
+type AccessRequest struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"index" json:"userId"`
+	Username     string     `gorm:"size:64;index" json:"username"`
+	TargetType   string     `gorm:"size:16" json:"targetType"` // role / group
+	TargetID     uint       `json:"targetId"`
+	TargetName   string     `gorm:"size:128" json:"targetName"`
+	Reason       string     `gorm:"size:500" json:"reason"`
+	DurationDays int        `gorm:"default:0" json:"durationDays"`               // 授权时长（天），0 表示长期
+	Status       string     `gorm:"size:16;index;default:pending" json:"status"` // pending / approved / denied / cancelled / revoked / expired
+	DecidedBy    string     `gorm:"size:64" json:"decidedBy"`
+	DecidedAt    *time.Time `json:"decidedAt"`
+	Comment      string     `gorm:"size:500" json:"comment"` // 审批意见 / 收回原因
+	ExpiresAt    *time.Time `json:"expiresAt"`               // 授权到期时间，到期由生命周期调度器收回
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// AccessRequestApprover 申请的审批人（提交时按角色负责人、直属上级和配置的审批人确定）
+type AccessRequestApprover struct {
+	ID        uint `gorm:"primaryKey"`
+	RequestID uint `gorm:"not null;uniqueIndex:idx_request_approver"`
+	UserID    uint `gorm:"not null;uniqueIndex:idx_request_approver;index"`
+}
+
+// 权限申请状态
+const (
+	AccessRequestPending   = "pending"
+	AccessRequestApproved  = "approved"
+	AccessRequestDenied    = "denied"
+	AccessRequestCancelled = "cancelled"
+	AccessRequestRevoked   = "revoked"
+	AccessRequestExpired   = "expired"
+)
+
+// AccessReview 权限复核活动：为所选角色 / 分组的每个成员生成复核项，复核人逐项确认或收回
+type AccessReview struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Name         string     `gorm:"size:128;not null" json:"name"`
+	Description  string     `gorm:"size:500" json:"description"`
+	TargetType   string     `gorm:"size:16" json:"targetType"`   // role / group
+	TargetIDs    string     `gorm:"type:text" json:"-"`          // JSON：复核的角色 / 分组
+	ReviewerType string     `gorm:"size:16" json:"reviewerType"` // manager=直属上级 / owner=角色负责人
+	ReviewerIDs  string     `gorm:"type:text" json:"-"`          // JSON：找不到直属上级 / 负责人时的复核人
+	Deadline     time.Time  `gorm:"index" json:"deadline"`
+	AutoRevoke   bool       `gorm:"default:false" json:"autoRevoke"` // 截止时仍未复核的成员关系自动收回
+	RemindDays   int        `gorm:"default:0" json:"remindDays"`     // 截止前 N 天提醒尚未完成的复核人，0 表示不提醒
+	RemindedAt   *time.Time `json:"remindedAt"`
+	Status       string     `gorm:"size:16;index;default:active" json:"status"` // active / completed / cancelled
+	CreatedBy    string     `gorm:"size:64" json:"createdBy"`
+	CompletedAt  *time.Time `json:"completedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// AccessReviewItem 复核项：一个成员对一个角色 / 分组的成员关系
+type AccessReviewItem struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	ReviewID     uint       `gorm:"index" json:"reviewId"`
+	UserID       uint       `gorm:"index" json:"userId"`
+	Username     string     `gorm:"size:64" json:"username"`
+	Nickname     string     `gorm:"size:64" json:"nickname"`
+	TargetType   string     `gorm:"size:16" json:"targetType"`
+	TargetID     uint       `json:"targetId"`
+	TargetName   string     `gorm:"size:128" json:"targetName"`
+	ReviewerID   uint       `gorm:"index" json:"reviewerId"` // 0 表示没有可用的复核人，由管理员处理
+	ReviewerName string     `gorm:"size:64" json:"reviewerName"`
+	Decision     string     `gorm:"size:16;index;default:pending" json:"decision"` // pending / confirmed / revoked / auto_revoked / unreviewed
+	Comment      string     `gorm:"size:500" json:"comment"`
+	DecidedBy    string     `gorm:"size:64" json:"decidedBy"`
+	DecidedAt    *time.Time `json:"decidedAt"`
+}
+
+// 权限复核活动状态
+const (
+	AccessReviewActive    = "active"
+	AccessReviewCompleted = "completed"
+	AccessReviewCancelled = "cancelled"
+)
+
+// 复核结论
+const (
+	ReviewDecisionPending     = "pending"
+	ReviewDecisionConfirmed   = "confirmed"
+	ReviewDecisionRevoked     = "revoked"
+	ReviewDecisionAutoRevoked = "auto_revoked" // 截止时未复核，自动收回
+	ReviewDecisionUnreviewed  = "unreviewed"   // 截止时未复核，保留成员关系
+)
+
 type UserRole struct {
 	ID     uint `gorm:"primaryKey"`
 	UserID uint `gorm:"index"`
 	RoleID uint `gorm:"index"`
+	// 委派管理范围（JSON 数组）：该角色的权限只作用于这些分组及其子分组内的用户，空表示不限
+	ScopeGroupIDs string `gorm:"type:text"`
+	// 受限范围内可授予的角色（JSON 数组），ScopeGroupIDs 为空时不生效
+	GrantableRoleIDs string `gorm:"type:text"`
 }
 
 type RolePermission struct {
@@ -91,3 +264,39 @@ type RoleAutoAssignRule struct {
 	RuleValue string    `gorm:"size:255;not null" json:"ruleValue"` // groupId 或 职位名称
 	CreatedAt time.Time `json:"createdAt"`
 }
+
+// UserAttributeDef 管理员自定义的用户扩展属性（工号、成本中心、入职日期等）
+// 同步映射中以 ext.<Key> 引用
+type UserAttributeDef struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Key           string    `gorm:"size:64;uniqueIndex;not null" json:"key"`
+	Label         string    `gorm:"size:64;not null" json:"label"`
+	Type          string    `gorm:"size:16;default:string" json:"type"` // string / number / date / bool / enum / email / phone
+	Options       string    `gorm:"size:1024" json:"options"`           // enum 可选值，逗号分隔
+	Pattern       string    `gorm:"size:256" json:"pattern"`            // 校验正则（可选）
+	MaxLength     int       `gorm:"default:0" json:"maxLength"`
+	Required      bool      `gorm:"default:false" json:"required"`
+	Unique        bool      `gorm:"default:false" json:"unique"`
+	Masked        bool      `gorm:"default:false" json:"masked"`               // 保存时脱敏（如身份证号只保留首尾）
+	Visibility    string    `gorm:"size:16;default:internal" json:"visibility"` // public / internal / private
+	LDAPAttribute string    `gorm:"size:64" json:"ldapAttribute"`               // 内置 LDAP 中的属性名，空则使用 Key
+	SortOrder     int       `gorm:"default:0" json:"sortOrder"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// 扩展属性可见性
+const (
+	AttrVisibilityPublic   = "public"   // 管理端、开放 API、内置 LDAP
+	AttrVisibilityInternal = "internal" // 管理端、开放 API
+	AttrVisibilityPrivate  = "private"  // 仅管理端和同步映射
+)
+
+// UserAttributeValue 用户扩展属性值
+type UserAttributeValue struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_user_attr;not null" json:"userId"`
+	AttrKey   string    `gorm:"size:64;uniqueIndex:idx_user_attr;index:idx_attr_value;not null" json:"attrKey"`
+	Value     string    `gorm:"size:1024;index:idx_attr_value" json:"value"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go-syncflow/internal/models"
+)
+
+// ========== 告警自动响应动作 ==========
+
+const alertBlockDefaultMinutes = 60
+
+// AlertAction 告警规则触发时执行的自动响应动作（存储于 AlertRule.Actions）
+type AlertAction struct {
+	Type            string `json:"type"`                      // block_ip | lock_account | terminate_sessions | force_password_change | disable_user
+	DurationMinutes int    `json:"durationMinutes,omitempty"` // block_ip 的封禁时长，默认 60 分钟
+}
+
+// AlertActionRunner 执行自动响应动作，由 handlers 注册（禁用用户需要投递下游同步任务）
+type AlertActionRunner func(rule *models.AlertRule, incident *models.AlertIncident, event *models.SecurityEvent, actions []AlertAction)
+
+var alertActionRunner AlertActionRunner
+
+// SetAlertActionRunner 注册自动响应动作执行器
+func SetAlertActionRunner(fn AlertActionRunner) {
+	alertActionRunner = fn
+}
+
+var alertActionTypes = map[string]bool{
+	models.AlertActionBlockIP:             true,
+	models.AlertActionLockAccount:         true,
+	models.AlertActionTerminateSessions:   true,
+	models.AlertActionForcePasswordChange: true,
+	models.AlertActionDisableUser:         true,
+}
+
+// ParseAlertActions 解析并校验自动响应动作，空字符串表示不执行动作
+func ParseAlertActions(raw string) ([]AlertAction, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "[]" || raw == "null" {
+		return nil, nil
+	}
+	var actions []AlertAction
+	if err := json.Unmarshal([]byte(raw), &actions); err != nil {
+		return nil, fmt.Errorf("响应动作格式错误: %v", err)
+	}
+	seen := make(map[string]bool)
+	for i := range actions {
+		a := &actions[i]
+		if !alertActionTypes[a.Type] {
+			return nil, fmt.Errorf("不支持的响应动作: %s", a.Type)
+		}
+		if seen[a.Type] {
+			return nil, fmt.Errorf("响应动作重复: %s", a.Type)
+		}
+		seen[a.Type] = true
+		if a.DurationMinutes < 0 {
+			return nil, fmt.Errorf("封禁时长不能为负数")
+		}
+		if a.Type == models.AlertActionBlockIP && a.DurationMinutes == 0 {
+			a.DurationMinutes = alertBlockDefaultMinutes
+		}
+	}
+	return actions, nil
+}
+
+// runAlertActions 新建告警聚合时执行规则配置的自动响应动作
+func runAlertActions(rule *models.AlertRule, incident *models.AlertIncident, event *models.SecurityEvent) {
+	actions, err := ParseAlertActions(rule.Actions)
+	if err != nil || len(actions) == 0 || alertActionRunner == nil {
+		return
+	}
+	alertActionRunner(rule, incident, event, actions)
+}
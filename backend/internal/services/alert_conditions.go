@@ -0,0 +1,635 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+	"gorm.io/gorm"
+)
+
+// ========== 告警规则条件 ==========
+//
+// AlertRule.Conditions 示例：
+//
+//	同一 /24 网段 5 分钟内 ≥10 次登录失败：
+//	{"threshold": {"count": 10, "windowMinutes": 5, "groupBy": "ip24"}}
+//	同一 IP 锁定 ≥3 个不同账号：
+//	{"threshold": {"count": 3, "windowMinutes": 60, "groupBy": "ip", "distinct": "username"}}
+//	重置密码后从新的国家/地区登录：
+//	{"sequence": {"windowMinutes": 120, "steps": [
+//	  {"eventTypes": ["password_reset"]},
+//	  {"eventTypes": ["login_success"], "newLocation": true}]}}
+
+const (
+	alertWindowMaxEvents   = 5000 // 计数窗口内最多读取的事件数
+	alertIncidentMaxIDs    = 100  // 聚合记录中最多保留的事件ID数
+	alertLocationLookback  = 90   // 判断新登录地点时回看的天数
+	alertMaxWindowMinutes  = 7 * 24 * 60
+	alertMaxSequenceSteps  = 5
+	alertDefaultSeqGroupBy = "user"
+)
+
+// AlertConditions 告警规则条件
+type AlertConditions struct {
+	Filters   []AlertFieldFilter `json:"filters,omitempty"`   // 字段条件，全部满足才算匹配
+	Threshold *AlertThreshold    `json:"threshold,omitempty"` // 计数条件
+	Sequence  *AlertSequence     `json:"sequence,omitempty"`  // 序列关联条件
+}
+
+// AlertFieldFilter 事件字段条件
+type AlertFieldFilter struct {
+	Field  string   `json:"field"` // username | source_ip | user_agent | location | target_id | target_type
+	Op     string   `json:"op"`    // eq | neq | contains | not_contains | prefix | suffix | regex | in | not_in | cidr | not_cidr
+	Value  string   `json:"value"`
+	Values []string `json:"values,omitempty"` // in / not_in / cidr / not_cidr 可填多个
+}
+
+// AlertThreshold 计数条件：窗口内同一分组的匹配事件数（或去重字段数）达到阈值
+type AlertThreshold struct {
+	Count         int    `json:"count"`
+	WindowMinutes int    `json:"windowMinutes"`
+	GroupBy       string `json:"groupBy"`  // ip | ip24 | username | user | user_agent，空表示不分组
+	Distinct      string `json:"distinct"` // 可选，按该字段去重计数，如 username
+}
+
+// AlertSequence 序列关联条件：同一分组在窗口内按顺序发生各步骤事件，当前事件需匹配最后一步
+type AlertSequence struct {
+	Steps         []AlertSequenceStep `json:"steps"`
+	WindowMinutes int                 `json:"windowMinutes"`
+	GroupBy       string              `json:"groupBy"` // 默认 user
+}
+
+// AlertSequenceStep 序列中的一步
+type AlertSequenceStep struct {
+	EventTypes  []string           `json:"eventTypes"`
+	Filters     []AlertFieldFilter `json:"filters,omitempty"`
+	NewLocation bool               `json:"newLocation,omitempty"` // 登录地点在该用户历史成功登录中从未出现
+}
+
+// alertMatch 一次规则匹配的结果
+type alertMatch struct {
+	GroupKey string
+	EventIDs []uint
+	Summary  string
+	Window   time.Duration
+}
+
+var alertFilterFields = map[string]bool{
+	"username": true, "source_ip": true, "ip": true, "user_agent": true,
+	"location": true, "target_id": true, "target_type": true,
+}
+
+var alertFilterOps = map[string]bool{
+	"eq": true, "neq": true, "contains": true, "not_contains": true, "prefix": true, "suffix": true,
+	"regex": true, "in": true, "not_in": true, "cidr": true, "not_cidr": true,
+}
+
+var alertGroupByFields = map[string]bool{
+	"": true, "ip": true, "ip24": true, "username": true, "user": true, "user_agent": true,
+}
+
+// ParseAlertConditions 解析并校验告警规则条件，空字符串表示无附加条件
+func ParseAlertConditions(raw string) (*AlertConditions, error) {
+	cond := &AlertConditions{}
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "{}" || raw == "null" {
+		return cond, nil
+	}
+	if err := json.Unmarshal([]byte(raw), cond); err != nil {
+		return nil, fmt.Errorf("条件格式错误: %v", err)
+	}
+	if err := validateAlertFilters(cond.Filters); err != nil {
+		return nil, err
+	}
+	if cond.Threshold != nil && cond.Sequence != nil {
+		return nil, fmt.Errorf("计数条件与序列条件不能同时配置")
+	}
+	if t := cond.Threshold; t != nil {
+		if t.Count < 1 {
+			return nil, fmt.Errorf("计数阈值必须大于 0")
+		}
+		if t.WindowMinutes < 1 || t.WindowMinutes > alertMaxWindowMinutes {
+			return nil, fmt.Errorf("计数窗口必须在 1~%d 分钟之间", alertMaxWindowMinutes)
+		}
+		if !alertGroupByFields[t.GroupBy] {
+			return nil, fmt.Errorf("不支持的分组字段: %s", t.GroupBy)
+		}
+		if t.Distinct != "" && !alertFilterFields[t.Distinct] {
+			return nil, fmt.Errorf("不支持的去重字段: %s", t.Distinct)
+		}
+	}
+	if seq := cond.Sequence; seq != nil {
+		if len(seq.Steps) < 2 || len(seq.Steps) > alertMaxSequenceSteps {
+			return nil, fmt.Errorf("序列条件需要 2~%d 个步骤", alertMaxSequenceSteps)
+		}
+		if seq.WindowMinutes < 1 || seq.WindowMinutes > alertMaxWindowMinutes {
+			return nil, fmt.Errorf("序列窗口必须在 1~%d 分钟之间", alertMaxWindowMinutes)
+		}
+		if seq.GroupBy == "" {
+			seq.GroupBy = alertDefaultSeqGroupBy
+		}
+		if !alertGroupByFields[seq.GroupBy] {
+			return nil, fmt.Errorf("不支持的分组字段: %s", seq.GroupBy)
+		}
+		for i, step := range seq.Steps {
+			if len(step.EventTypes) == 0 {
+				return nil, fmt.Errorf("序列第 %d 步未指定事件类型", i+1)
+			}
+			if err := validateAlertFilters(step.Filters); err != nil {
+				return nil, fmt.Errorf("序列第 %d 步: %v", i+1, err)
+			}
+		}
+	}
+	return cond, nil
+}
+
+func validateAlertFilters(filters []AlertFieldFilter) error {
+	for _, f := range filters {
+		if !alertFilterFields[f.Field] {
+			return fmt.Errorf("不支持的条件字段: %s", f.Field)
+		}
+		if !alertFilterOps[f.Op] {
+			return fmt.Errorf("不支持的条件运算: %s", f.Op)
+		}
+		values := f.values()
+		if len(values) == 0 {
+			return fmt.Errorf("条件 %s %s 缺少比较值", f.Field, f.Op)
+		}
+		for _, v := range values {
+			switch f.Op {
+			case "regex":
+				if _, err := regexp.Compile(v); err != nil {
+					return fmt.Errorf("正则表达式错误 %s: %v", v, err)
+				}
+			case "cidr", "not_cidr":
+				if _, _, err := net.ParseCIDR(v); err != nil && net.ParseIP(v) == nil {
+					return fmt.Errorf("IP/CIDR 格式错误: %s", v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// values 比较值列表（Value 与 Values 合并）
+func (f AlertFieldFilter) values() []string {
+	var out []string
+	if strings.TrimSpace(f.Value) != "" {
+		out = append(out, strings.TrimSpace(f.Value))
+	}
+	for _, v := range f.Values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// triggerEventTypes 触发规则评估的事件类型：序列条件以最后一步为准
+func (c *AlertConditions) triggerEventTypes(ruleTypes []string) []string {
+	if c.Sequence != nil {
+		return c.Sequence.Steps[len(c.Sequence.Steps)-1].EventTypes
+	}
+	return ruleTypes
+}
+
+// alertEventField 读取事件字段值
+func alertEventField(e *models.SecurityEvent, field string) string {
+	switch field {
+	case "username":
+		return e.Username
+	case "source_ip", "ip":
+		return e.SourceIP
+	case "user_agent":
+		return e.UserAgent
+	case "location":
+		return e.Location
+	case "target_id":
+		return e.TargetID
+	case "target_type":
+		return e.TargetType
+	}
+	return ""
+}
+
+var alertRegexCache sync.Map
+
+func alertRegexp(pattern string) *regexp.Regexp {
+	if re, ok := alertRegexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	alertRegexCache.Store(pattern, re)
+	return re
+}
+
+// alertIPMatch IP 是否命中单个 IP 或 CIDR
+func alertIPMatch(ip net.IP, v string) bool {
+	if _, ipNet, err := net.ParseCIDR(v); err == nil {
+		return ipNet.Contains(ip)
+	}
+	other := net.ParseIP(v)
+	return other != nil && other.Equal(ip)
+}
+
+// matchAlertFilter 判断事件是否满足单个字段条件（字符串比较不区分大小写，正则除外）
+func matchAlertFilter(e *models.SecurityEvent, f AlertFieldFilter) bool {
+	actual := alertEventField(e, f.Field)
+	lower := strings.ToLower(actual)
+	values := f.values()
+	anyOf := func(fn func(v string) bool) bool {
+		for _, v := range values {
+			if fn(v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch f.Op {
+	case "eq", "in":
+		return anyOf(func(v string) bool { return lower == strings.ToLower(v) })
+	case "neq", "not_in":
+		return !anyOf(func(v string) bool { return lower == strings.ToLower(v) })
+	case "contains":
+		return anyOf(func(v string) bool { return strings.Contains(lower, strings.ToLower(v)) })
+	case "not_contains":
+		return !anyOf(func(v string) bool { return strings.Contains(lower, strings.ToLower(v)) })
+	case "prefix":
+		return anyOf(func(v string) bool { return strings.HasPrefix(lower, strings.ToLower(v)) })
+	case "suffix":
+		return anyOf(func(v string) bool { return strings.HasSuffix(lower, strings.ToLower(v)) })
+	case "regex":
+		return anyOf(func(v string) bool {
+			re := alertRegexp(v)
+			return re != nil && re.MatchString(actual)
+		})
+	case "cidr", "not_cidr":
+		ip := net.ParseIP(actual)
+		hit := ip != nil && anyOf(func(v string) bool { return alertIPMatch(ip, v) })
+		if f.Op == "cidr" {
+			return hit
+		}
+		return ip != nil && !hit
+	}
+	return false
+}
+
+func matchAlertFilters(e *models.SecurityEvent, filters []AlertFieldFilter) bool {
+	for _, f := range filters {
+		if !matchAlertFilter(e, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// alertGroupKey 计算事件的分组键，分组字段为空值时返回 false（该事件不参与分组计数）
+func alertGroupKey(e *models.SecurityEvent, groupBy string) (string, bool) {
+	switch groupBy {
+	case "":
+		return "", true
+	case "ip":
+		if e.SourceIP == "" {
+			return "", false
+		}
+		return "ip:" + e.SourceIP, true
+	case "ip24":
+		ip := net.ParseIP(e.SourceIP)
+		if ip == nil {
+			return "", false
+		}
+		if v4 := ip.To4(); v4 != nil {
+			return "net:" + v4.Mask(net.CIDRMask(24, 32)).String() + "/24", true
+		}
+		return "net:" + ip.Mask(net.CIDRMask(64, 128)).String() + "/64", true
+	case "username":
+		if e.Username == "" {
+			return "", false
+		}
+		return "user:" + strings.ToLower(e.Username), true
+	case "user":
+		if e.UserID != nil {
+			return "uid:" + strconv.FormatUint(uint64(*e.UserID), 10), true
+		}
+		if e.Username == "" {
+			return "", false
+		}
+		return "user:" + strings.ToLower(e.Username), true
+	case "user_agent":
+		if e.UserAgent == "" {
+			return "", false
+		}
+		return "ua:" + e.UserAgent, true
+	}
+	return "", false
+}
+
+// narrowAlertGroup 按分组在数据库层面预先缩小查询范围，最终仍以 alertGroupKey 精确比较
+func narrowAlertGroup(q *gorm.DB, groupBy string, e *models.SecurityEvent) *gorm.DB {
+	switch groupBy {
+	case "ip":
+		return q.Where("source_ip = ?", e.SourceIP)
+	case "ip24":
+		if v4 := net.ParseIP(e.SourceIP).To4(); v4 != nil {
+			return q.Where("source_ip LIKE ?", fmt.Sprintf("%d.%d.%d.%%", v4[0], v4[1], v4[2]))
+		}
+	case "username":
+		return q.Where("LOWER(username) = ?", strings.ToLower(e.Username))
+	case "user":
+		if e.UserID != nil {
+			return q.Where("user_id = ?", *e.UserID)
+		}
+		return q.Where("LOWER(username) = ?", strings.ToLower(e.Username))
+	case "user_agent":
+		return q.Where("user_agent = ?", e.UserAgent)
+	}
+	return q
+}
+
+// matchAlertRuleEvent 单个事件是否满足规则的事件类型、严重级别和字段条件
+func matchAlertRuleEvent(e *models.SecurityEvent, eventTypes []string, minSeverity int, filters []AlertFieldFilter) bool {
+	if len(eventTypes) > 0 && !containsString(eventTypes, e.EventType) {
+		return false
+	}
+	if alertSeverityOrder[e.Severity] < minSeverity {
+		return false
+	}
+	return matchAlertFilters(e, filters)
+}
+
+var alertSeverityOrder = map[string]int{"low": 1, "medium": 2, "high": 3, "critical": 4}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateAlertConditions 在滑动窗口内评估规则条件，返回 nil 表示未命中
+// 调用方已确认当前事件满足事件类型、严重级别和字段条件
+func evaluateAlertConditions(rule *models.AlertRule, cond *AlertConditions, eventTypes []string, event *models.SecurityEvent) *alertMatch {
+	cooldown := time.Duration(rule.CooldownMinutes) * time.Minute
+	switch {
+	case cond.Threshold != nil:
+		return evaluateAlertThreshold(rule, cond, eventTypes, event, cooldown)
+	case cond.Sequence != nil:
+		return evaluateAlertSequence(cond.Sequence, event, cooldown)
+	}
+	return &alertMatch{EventIDs: []uint{event.ID}, Summary: event.Description, Window: cooldown}
+}
+
+// evaluateAlertThreshold 计数条件：统计窗口内与当前事件同组的匹配事件
+func evaluateAlertThreshold(rule *models.AlertRule, cond *AlertConditions, eventTypes []string, event *models.SecurityEvent, cooldown time.Duration) *alertMatch {
+	t := cond.Threshold
+	groupKey, ok := alertGroupKey(event, t.GroupBy)
+	if !ok {
+		return nil
+	}
+	window := time.Duration(t.WindowMinutes) * time.Minute
+
+	q := storage.DB.Where("created_at >= ? AND id <= ?", event.CreatedAt.Add(-window), event.ID)
+	if len(eventTypes) > 0 {
+		q = q.Where("event_type IN ?", eventTypes)
+	}
+	var events []models.SecurityEvent
+	narrowAlertGroup(q, t.GroupBy, event).Order("id DESC").Limit(alertWindowMaxEvents).Find(&events)
+
+	minSeverity := alertSeverityOrder[rule.SeverityThreshold]
+	var ids []uint
+	distinct := make(map[string]bool)
+	for i := range events {
+		e := &events[i]
+		if key, ok := alertGroupKey(e, t.GroupBy); !ok || key != groupKey {
+			continue
+		}
+		if !matchAlertRuleEvent(e, eventTypes, minSeverity, cond.Filters) {
+			continue
+		}
+		ids = append(ids, e.ID)
+		if t.Distinct != "" {
+			if v := strings.ToLower(alertEventField(e, t.Distinct)); v != "" {
+				distinct[v] = true
+			}
+		}
+	}
+
+	count := len(ids)
+	desc := fmt.Sprintf("%d 分钟内发生 %d 次匹配事件", t.WindowMinutes, count)
+	if t.Distinct != "" {
+		count = len(distinct)
+		desc = fmt.Sprintf("%d 分钟内涉及 %d 个不同的 %s（共 %d 次事件）", t.WindowMinutes, count, t.Distinct, len(ids))
+	}
+	if count < t.Count {
+		return nil
+	}
+	if groupKey != "" {
+		desc = groupKey + " " + desc
+	}
+	return &alertMatch{GroupKey: groupKey, EventIDs: reverseIDs(ids), Summary: desc, Window: maxDuration(window, cooldown)}
+}
+
+// evaluateAlertSequence 序列条件：从当前事件（最后一步）向前依次查找前序步骤
+func evaluateAlertSequence(seq *AlertSequence, event *models.SecurityEvent, cooldown time.Duration) *alertMatch {
+	groupKey, ok := alertGroupKey(event, seq.GroupBy)
+	if !ok {
+		return nil
+	}
+	last := seq.Steps[len(seq.Steps)-1]
+	if !matchAlertSequenceStep(event, last) {
+		return nil
+	}
+
+	window := time.Duration(seq.WindowMinutes) * time.Minute
+	since := event.CreatedAt.Add(-window)
+	ids := []uint{event.ID}
+	beforeID := event.ID
+	for i := len(seq.Steps) - 2; i >= 0; i-- {
+		step := seq.Steps[i]
+		var candidates []models.SecurityEvent
+		q := storage.DB.Where("created_at >= ? AND id < ? AND event_type IN ?", since, beforeID, step.EventTypes)
+		narrowAlertGroup(q, seq.GroupBy, event).Order("id DESC").Limit(200).Find(&candidates)
+
+		var found *models.SecurityEvent
+		for j := range candidates {
+			c := &candidates[j]
+			if key, ok := alertGroupKey(c, seq.GroupBy); ok && key == groupKey && matchAlertSequenceStep(c, step) {
+				found = c
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		ids = append([]uint{found.ID}, ids...)
+		beforeID = found.ID
+	}
+
+	steps := make([]string, 0, len(seq.Steps))
+	for _, step := range seq.Steps {
+		steps = append(steps, strings.Join(step.EventTypes, "/"))
+	}
+	desc := fmt.Sprintf("%d 分钟内依次发生 %s", seq.WindowMinutes, strings.Join(steps, " → "))
+	if groupKey != "" {
+		desc = groupKey + " " + desc
+	}
+	if event.Location != "" && last.NewLocation {
+		desc += "（新登录地点: " + event.Location + "）"
+	}
+	return &alertMatch{GroupKey: groupKey, EventIDs: ids, Summary: desc, Window: maxDuration(window, cooldown)}
+}
+
+func matchAlertSequenceStep(e *models.SecurityEvent, step AlertSequenceStep) bool {
+	if !containsString(step.EventTypes, e.EventType) || !matchAlertFilters(e, step.Filters) {
+		return false
+	}
+	return !step.NewLocation || isNewLoginLocation(e)
+}
+
+// isNewLoginLocation 事件的登录国家是否未在该用户近期成功登录中出现过
+// 没有任何带地点的历史登录时不视为新地点，避免首次登录即告警
+func isNewLoginLocation(e *models.SecurityEvent) bool {
+	if e.Location == "" {
+		return false
+	}
+	q := storage.DB.Model(&models.SecurityEvent{}).
+		Where("event_type = ? AND id < ? AND created_at >= ? AND location <> ''",
+			models.EventLoginSuccess, e.ID, e.CreatedAt.AddDate(0, 0, -alertLocationLookback))
+	if e.UserID != nil {
+		q = q.Where("user_id = ?", *e.UserID)
+	} else {
+		q = q.Where("LOWER(username) = ?", strings.ToLower(e.Username))
+	}
+
+	var locations []string
+	q.Distinct("location").Limit(500).Pluck("location", &locations)
+	if len(locations) == 0 {
+		return false
+	}
+	country := locationCountry(e.Location)
+	for _, loc := range locations {
+		if locationCountry(loc) == country {
+			return false
+		}
+	}
+	return true
+}
+
+func containsUint(list []uint, v uint) bool {
+	for _, id := range list {
+		if id == v {
+			return true
+		}
+	}
+	return false
+}
+
+func reverseIDs(ids []uint) []uint {
+	out := make([]uint, len(ids))
+	for i, id := range ids {
+		out[len(ids)-1-i] = id
+	}
+	return out
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// ========== 告警事件聚合 ==========
+
+var alertIncidentMu sync.Mutex
+
+// recordAlertIncident 将一次规则匹配合并进聚合记录：
+// 同一规则、同一分组在聚合窗口内仍有未处理的聚合时只追加事件，否则新建聚合并需要发送通知
+func recordAlertIncident(rule *models.AlertRule, event *models.SecurityEvent, match *alertMatch) (*models.AlertIncident, bool, error) {
+	alertIncidentMu.Lock()
+	defer alertIncidentMu.Unlock()
+
+	now := time.Now()
+	var incident models.AlertIncident
+	err := storage.DB.Where("rule_id = ? AND group_key = ? AND status = ? AND last_seen_at >= ?",
+		rule.ID, match.GroupKey, models.IncidentStatusOpen, now.Add(-match.Window)).
+		Order("id DESC").First(&incident).Error
+	if err == nil {
+		// 已有聚合只追加当前事件，窗口内其余事件在首次命中时已计入
+		var ids []uint
+		json.Unmarshal([]byte(incident.EventIDs), &ids)
+		if containsUint(ids, event.ID) {
+			return &incident, false, nil
+		}
+		ids = append(ids, event.ID)
+		if len(ids) > alertIncidentMaxIDs {
+			ids = ids[len(ids)-alertIncidentMaxIDs:]
+		}
+		idsJSON, _ := json.Marshal(ids)
+		updates := map[string]interface{}{
+			"event_count":  incident.EventCount + 1,
+			"event_ids":    string(idsJSON),
+			"last_seen_at": now,
+			"summary":      truncateAlertText(match.Summary, 512),
+		}
+		if event.ID > incident.LastEventID {
+			updates["last_event_id"] = event.ID
+		}
+		if alertSeverityOrder[event.Severity] > alertSeverityOrder[incident.Severity] {
+			updates["severity"] = event.Severity
+		}
+		if err := storage.DB.Model(&incident).Updates(updates).Error; err != nil {
+			return nil, false, err
+		}
+		return &incident, false, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, false, err
+	}
+
+	ids := match.EventIDs
+	if len(ids) > alertIncidentMaxIDs {
+		ids = ids[len(ids)-alertIncidentMaxIDs:]
+	}
+	idsJSON, _ := json.Marshal(ids)
+	incident = models.AlertIncident{
+		RuleID:       rule.ID,
+		RuleName:     rule.Name,
+		GroupKey:     match.GroupKey,
+		Severity:     event.Severity,
+		Summary:      truncateAlertText(match.Summary, 512),
+		EventCount:   len(match.EventIDs),
+		EventIDs:     string(idsJSON),
+		FirstEventID: match.EventIDs[0],
+		LastEventID:  event.ID,
+		Status:       models.IncidentStatusOpen,
+		FirstSeenAt:  now,
+		LastSeenAt:   now,
+	}
+	if err := storage.DB.Create(&incident).Error; err != nil {
+		return nil, false, err
+	}
+	return &incident, true, nil
+}
+
+func truncateAlertText(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
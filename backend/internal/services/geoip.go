@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========== 离线 GeoIP 数据库 ==========
+//
+// 支持 CSV 格式的 IP 段数据库（每行一个 IP 段，起止 IP 可以是点分 / 冒号格式或整数）：
+//   - 7 列自定义格式：start_ip,end_ip,country,region,city,latitude,longitude
+//   - 8 列格式（IP2Location LITE DB5 / DB-IP Lite City）：start_ip,end_ip,代码或大洲,country,region,city,latitude,longitude
+
+// GeoLocation IP 归属地
+type GeoLocation struct {
+	Country   string  `json:"country"`
+	Region    string  `json:"region"`
+	City      string  `json:"city"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// String 归属地展示文本，格式为 国家/省份/城市
+func (g *GeoLocation) String() string {
+	if g == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range []string{g.Country, g.Region, g.City} {
+		if p != "" && p != "-" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// HasCoordinates 是否有经纬度
+func (g *GeoLocation) HasCoordinates() bool {
+	return g != nil && (g.Latitude != 0 || g.Longitude != 0)
+}
+
+type geoRange struct {
+	start, end [16]byte
+	loc        *GeoLocation
+}
+
+type geoIPDatabase struct {
+	mu        sync.RWMutex
+	path      string
+	modTime   time.Time
+	checkedAt time.Time
+	ranges    []geoRange
+}
+
+var geoIPDB = &geoIPDatabase{}
+
+// LookupGeoIP 在离线数据库中查询 IP 归属地，数据库不存在、内网 IP 或未命中时返回 nil
+// 数据库文件更新后（按修改时间判断，每分钟检查一次）自动重新加载
+func LookupGeoIP(path, ip string) *GeoLocation {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if path == "" || parsed == nil || parsed.IsLoopback() || parsed.IsPrivate() || parsed.IsLinkLocalUnicast() {
+		return nil
+	}
+	geoIPDB.refresh(path)
+
+	var key [16]byte
+	copy(key[:], parsed.To16())
+
+	geoIPDB.mu.RLock()
+	defer geoIPDB.mu.RUnlock()
+	ranges := geoIPDB.ranges
+	i := sort.Search(len(ranges), func(i int) bool { return bytes.Compare(ranges[i].start[:], key[:]) > 0 })
+	if i == 0 {
+		return nil
+	}
+	r := ranges[i-1]
+	if bytes.Compare(key[:], r.end[:]) > 0 {
+		return nil
+	}
+	return r.loc
+}
+
+// refresh 路径变化或文件更新时重新加载数据库
+func (db *geoIPDatabase) refresh(path string) {
+	db.mu.RLock()
+	fresh := db.path == path && time.Since(db.checkedAt) < time.Minute
+	db.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.path == path && time.Since(db.checkedAt) < time.Minute {
+		return
+	}
+	db.checkedAt = time.Now()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if db.path != path || db.ranges != nil {
+			log.Printf("[GeoIP] 数据库文件不可用 %s: %v", path, err)
+		}
+		db.path, db.ranges, db.modTime = path, nil, time.Time{}
+		return
+	}
+	if db.path == path && info.ModTime().Equal(db.modTime) {
+		return
+	}
+
+	start := time.Now()
+	ranges, err := loadGeoIPFile(path)
+	if err != nil {
+		log.Printf("[GeoIP] 加载数据库失败 %s: %v", path, err)
+		db.path, db.ranges, db.modTime = path, nil, info.ModTime()
+		return
+	}
+	db.path, db.ranges, db.modTime = path, ranges, info.ModTime()
+	log.Printf("[GeoIP] 已加载 %s，共 %d 个IP段，耗时 %v", path, len(ranges), time.Since(start).Round(time.Millisecond))
+}
+
+// loadGeoIPFile 读取 CSV 数据库并按起始 IP 排序
+func loadGeoIPFile(path string) ([]geoRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(bufio.NewReaderSize(f, 1<<20))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	// 相同归属地共享同一对象，减少内存占用
+	locations := make(map[GeoLocation]*GeoLocation)
+	var ranges []geoRange
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 7 {
+			continue
+		}
+		start, ok1 := parseGeoIPBound(rec[0])
+		end, ok2 := parseGeoIPBound(rec[1])
+		if !ok1 || !ok2 {
+			continue // 表头或无效行
+		}
+
+		fields := rec[2:]
+		if len(rec) >= 8 {
+			fields = rec[3:]
+		}
+		loc := GeoLocation{
+			Country: strings.TrimSpace(fields[0]),
+			Region:  strings.TrimSpace(fields[1]),
+			City:    strings.TrimSpace(fields[2]),
+		}
+		loc.Latitude, _ = strconv.ParseFloat(strings.TrimSpace(fields[3]), 64)
+		loc.Longitude, _ = strconv.ParseFloat(strings.TrimSpace(fields[4]), 64)
+		if loc.Country == "" || loc.Country == "-" {
+			continue
+		}
+		shared, ok := locations[loc]
+		if !ok {
+			shared = &GeoLocation{}
+			*shared = loc
+			locations[loc] = shared
+		}
+		ranges = append(ranges, geoRange{start: start, end: end, loc: shared})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].start[:], ranges[j].start[:]) < 0 })
+	return ranges, nil
+}
+
+// parseGeoIPBound 解析 IP 段边界：点分 / 冒号格式或整数（≤ 2^32-1 视为 IPv4）
+func parseGeoIPBound(s string) ([16]byte, bool) {
+	var out [16]byte
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		copy(out[:], ip.To16())
+		return out, true
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return out, false
+	}
+	if n.BitLen() <= 32 {
+		v := uint32(n.Uint64())
+		copy(out[:], net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To16())
+		return out, true
+	}
+	n.FillBytes(out[:])
+	return out, true
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 登录风险评估 ==========
+
+// 登录风险处置结果
+const (
+	LoginRiskAllow     = "allow"     // 直接放行
+	LoginRiskChallenge = "challenge" // 需要额外验证码
+	LoginRiskBlock     = "block"     // 阻止登录
+)
+
+// LoginRiskConfig 登录风险配置（login_security.risk）
+type LoginRiskConfig struct {
+	Enabled                bool
+	GeoIPPath              string
+	ChallengeScore         int
+	BlockScore             int
+	ChallengeFallback      string // 无可用验证渠道时的处理：allow | block
+	HistoryDays            int
+	MaxTravelSpeedKmh      float64
+	FailureWindowMinutes   int
+	FailureThreshold       int
+	UnusualHourMinLogins   int
+	WeightNewDevice        int
+	WeightNewIPRange       int
+	WeightNewLocation      int
+	WeightImpossibleTravel int
+	WeightUnusualHour      int
+	WeightRecentFailures   int
+}
+
+// LoginRiskFactor 单个风险因素
+type LoginRiskFactor struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Score  int    `json:"score"`
+	Detail string `json:"detail"`
+}
+
+// LoginRiskResult 风险评估结果
+type LoginRiskResult struct {
+	Score    int               `json:"score"`
+	Decision string            `json:"decision"`
+	Factors  []LoginRiskFactor `json:"factors"`
+}
+
+// FactorNames 风险因素名称列表
+func (r LoginRiskResult) FactorNames() []string {
+	names := make([]string, 0, len(r.Factors))
+	for _, f := range r.Factors {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// Details 写入安全事件的风险明细
+func (r LoginRiskResult) Details() map[string]interface{} {
+	return map[string]interface{}{
+		"risk_score":   r.Score,
+		"decision":     r.Decision,
+		"risk_factors": r.Factors,
+	}
+}
+
+// GetLoginRiskConfig 读取登录风险配置，未配置的项使用默认值
+func (s *SecurityService) GetLoginRiskConfig() LoginRiskConfig {
+	config, _ := s.GetConfig("login_security")
+	risk, _ := config["risk"].(map[string]interface{})
+	weights, _ := risk["weights"].(map[string]interface{})
+
+	// 升级前创建的配置没有 risk 节点，保持原有登录行为，需管理员在安全中心开启
+	enabled, _ := risk["enabled"].(bool)
+	geoPath, _ := risk["geoip_path"].(string)
+	if geoPath == "" {
+		geoPath = "./data/geoip.csv"
+	}
+	fallback, _ := risk["challenge_fallback"].(string)
+	if fallback != LoginRiskBlock {
+		fallback = LoginRiskAllow
+	}
+
+	return LoginRiskConfig{
+		Enabled:                enabled,
+		GeoIPPath:              geoPath,
+		ChallengeScore:         int(getFloat64(risk, "challenge_score", 40)),
+		BlockScore:             int(getFloat64(risk, "block_score", 80)),
+		ChallengeFallback:      fallback,
+		HistoryDays:            int(getFloat64(risk, "history_days", 90)),
+		MaxTravelSpeedKmh:      getFloat64(risk, "max_travel_speed_kmh", 900),
+		FailureWindowMinutes:   int(getFloat64(risk, "failure_window_minutes", 30)),
+		FailureThreshold:       int(getFloat64(risk, "failure_threshold", 3)),
+		UnusualHourMinLogins:   int(getFloat64(risk, "unusual_hour_min_logins", 10)),
+		WeightNewDevice:        int(getFloat64(weights, "new_device", 25)),
+		WeightNewIPRange:       int(getFloat64(weights, "new_ip_range", 15)),
+		WeightNewLocation:      int(getFloat64(weights, "new_location", 30)),
+		WeightImpossibleTravel: int(getFloat64(weights, "impossible_travel", 60)),
+		WeightUnusualHour:      int(getFloat64(weights, "unusual_hour", 10)),
+		WeightRecentFailures:   int(getFloat64(weights, "recent_failures", 20)),
+	}
+}
+
+// LookupLocation 通过离线 GeoIP 数据库查询 IP 归属地
+func (s *SecurityService) LookupLocation(ip string) *GeoLocation {
+	return LookupGeoIP(s.GetLoginRiskConfig().GeoIPPath, ip)
+}
+
+// locationCountry 归属地文本中的国家部分（格式 国家/省份/城市）
+func locationCountry(location string) string {
+	if i := strings.Index(location, "/"); i >= 0 {
+		location = location[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(location))
+}
+
+// ipRangeKey IPv4 取 /24、IPv6 取 /48 作为网段标识
+func ipRangeKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// haversineKm 两个经纬度之间的球面距离（公里）
+func haversineKm(a, b *GeoLocation) float64 {
+	const earthRadius = 6371.0
+	rad := math.Pi / 180
+	dLat := (b.Latitude - a.Latitude) * rad
+	dLon := (b.Longitude - a.Longitude) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Latitude*rad)*math.Cos(b.Latitude*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// AssessLoginRisk 评估一次已通过密码校验的登录的风险
+// location 为本次登录归属地（国家/省份/城市），fingerprint 为设备指纹
+func (s *SecurityService) AssessLoginRisk(user models.User, ip, location, fingerprint string) LoginRiskResult {
+	cfg := s.GetLoginRiskConfig()
+	result := LoginRiskResult{Decision: LoginRiskAllow}
+	if !cfg.Enabled {
+		return result
+	}
+	now := time.Now()
+	add := func(code, name string, score int, detail string) {
+		if score <= 0 {
+			return
+		}
+		result.Factors = append(result.Factors, LoginRiskFactor{Code: code, Name: name, Score: score, Detail: detail})
+		result.Score += score
+	}
+
+	var history []models.LoginAttempt
+	storage.DB.Where("user_id = ? AND success = ? AND created_at >= ?", user.ID, true, now.AddDate(0, 0, -cfg.HistoryDays)).
+		Order("id DESC").Limit(500).Find(&history)
+
+	// 没有历史成功登录时无从比较，首次登录只评估失败次数
+	if len(history) > 0 {
+		knownDevice, hasDevice := false, false
+		knownRange := false
+		knownCountry, hasCountry := false, false
+		hourCount := 0
+		rangeKey := ipRangeKey(ip)
+		country := locationCountry(location)
+		for _, h := range history {
+			if h.DeviceFingerprint != "" {
+				hasDevice = true
+				if h.DeviceFingerprint == fingerprint {
+					knownDevice = true
+				}
+			}
+			if rangeKey != "" && ipRangeKey(h.IPAddress) == rangeKey {
+				knownRange = true
+			}
+			if c := locationCountry(h.Location); c != "" {
+				hasCountry = true
+				if c == country {
+					knownCountry = true
+				}
+			}
+			diff := (h.CreatedAt.Hour() - now.Hour() + 24) % 24
+			if diff <= 1 || diff >= 23 {
+				hourCount++
+			}
+		}
+
+		if fingerprint != "" && hasDevice && !knownDevice {
+			add("new_device", "新设备", cfg.WeightNewDevice, "该设备此前未登录过")
+		}
+		if rangeKey != "" && !knownRange {
+			add("new_ip_range", "新网段", cfg.WeightNewIPRange, "首次从网段 "+rangeKey+" 登录")
+		}
+		if country != "" && hasCountry && !knownCountry {
+			add("new_location", "新登录地点", cfg.WeightNewLocation, "首次从 "+location+" 登录")
+		}
+		if len(history) >= cfg.UnusualHourMinLogins && hourCount == 0 {
+			add("unusual_hour", "异常登录时段", cfg.WeightUnusualHour, fmt.Sprintf("历史上从未在 %d 点前后登录", now.Hour()))
+		}
+	}
+
+	// 不可能的旅行：与上次登录地点的距离 / 时间换算出的速度超过阈值
+	if user.LastLoginAt != nil && user.LastLoginIP != "" && user.LastLoginIP != ip {
+		prev := LookupGeoIP(cfg.GeoIPPath, user.LastLoginIP)
+		cur := LookupGeoIP(cfg.GeoIPPath, ip)
+		if prev.HasCoordinates() && cur.HasCoordinates() {
+			km := haversineKm(prev, cur)
+			hours := math.Max(now.Sub(*user.LastLoginAt).Hours(), 1.0/60)
+			if km > 300 && km/hours > cfg.MaxTravelSpeedKmh {
+				add("impossible_travel", "不可能的旅行", cfg.WeightImpossibleTravel,
+					fmt.Sprintf("%.1f 小时内从 %s 到 %s（约 %.0f 公里）", hours, prev.String(), cur.String(), km))
+			}
+		}
+	}
+
+	// 近期失败次数
+	var failures int64
+	storage.DB.Model(&models.LoginAttempt{}).
+		Where("username = ? AND success = ? AND created_at >= ?", user.Username, false,
+			now.Add(-time.Duration(cfg.FailureWindowMinutes)*time.Minute)).
+		Count(&failures)
+	if cfg.FailureThreshold > 0 && int(failures) >= cfg.FailureThreshold {
+		add("recent_failures", "近期多次失败", cfg.WeightRecentFailures,
+			fmt.Sprintf("%d 分钟内失败 %d 次", cfg.FailureWindowMinutes, failures))
+	}
+
+	if result.Score > 100 {
+		result.Score = 100
+	}
+	switch {
+	case cfg.BlockScore > 0 && result.Score >= cfg.BlockScore:
+		result.Decision = LoginRiskBlock
+	case cfg.ChallengeScore > 0 && result.Score >= cfg.ChallengeScore:
+		result.Decision = LoginRiskChallenge
+	}
+	return result
+}
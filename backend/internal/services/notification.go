@@ -592,6 +592,46 @@ func InitDefaultTemplates() {
 			IsBuiltin: true,
 			IsActive:  true,
 		},
+		{
+			Name:      "账号到期提醒",
+			Scene:     "account_expiring",
+			Content:   "【{{app_name}}】账号 {{username}}（{{nickname}}）将于 {{valid_until}} 到期，到期后将被自动禁用。如需延期请联系管理员。",
+			Variables: `[{"key":"username","desc":"到期账号用户名","example":"zhangsan"},{"key":"nickname","desc":"到期账号姓名","example":"张三"},{"key":"name","desc":"收件人姓名","example":"李四"},{"key":"valid_until","desc":"到期时间","example":"2026-06-30 23:59:59"},{"key":"days","desc":"剩余天数","example":"7"},{"key":"time","desc":"当前时间","example":"2026-06-23 09:00:00"},{"key":"app_name","desc":"系统名称","example":"统一身份认证平台"}]`,
+			IsBuiltin: true,
+			IsActive:  true,
+		},
+		{
+			Name:      "权限申请待审批",
+			Scene:     "access_request",
+			Content:   "【{{app_name}}】{{name}}，{{nickname}}（{{username}}）申请{{target}}，授权时长：{{duration}}，申请理由：{{reason}}。请登录个人中心审批。",
+			Variables: `[{"key":"username","desc":"申请人用户名","example":"zhangsan"},{"key":"nickname","desc":"申请人姓名","example":"张三"},{"key":"name","desc":"审批人姓名","example":"李四"},{"key":"target","desc":"申请的角色或分组","example":"角色「财务审批」"},{"key":"duration","desc":"授权时长","example":"30 天"},{"key":"reason","desc":"申请理由","example":"负责月度报销审批"},{"key":"time","desc":"当前时间","example":"2026-06-23 09:00:00"},{"key":"app_name","desc":"系统名称","example":"统一身份认证平台"}]`,
+			IsBuiltin: true,
+			IsActive:  true,
+		},
+		{
+			Name:      "权限申请结果通知",
+			Scene:     "access_request_result",
+			Content:   "【{{app_name}}】您申请的{{target}}{{result}}，处理人：{{approver}}。{{comment}}",
+			Variables: `[{"key":"username","desc":"申请人用户名","example":"zhangsan"},{"key":"nickname","desc":"申请人姓名","example":"张三"},{"key":"name","desc":"申请人姓名","example":"张三"},{"key":"target","desc":"申请的角色或分组","example":"角色「财务审批」"},{"key":"result","desc":"处理结果","example":"已通过（授权时长：30 天）"},{"key":"approver","desc":"处理人","example":"lisi"},{"key":"comment","desc":"审批意见","example":"同意"},{"key":"time","desc":"当前时间","example":"2026-06-23 09:00:00"},{"key":"app_name","desc":"系统名称","example":"统一身份认证平台"}]`,
+			IsBuiltin: true,
+			IsActive:  true,
+		},
+		{
+			Name:      "权限复核提醒",
+			Scene:     "access_review",
+			Content:   "【{{app_name}}】{{name}}，权限复核「{{review_name}}」中有 {{count}} 项成员权限待您确认，请于 {{deadline}} 前登录个人中心完成复核，逾期未复核的权限{{overdue}}。",
+			Variables: `[{"key":"username","desc":"复核人用户名","example":"lisi"},{"key":"name","desc":"复核人姓名","example":"李四"},{"key":"review_name","desc":"复核活动名称","example":"2026年第三季度权限复核"},{"key":"count","desc":"待复核项数","example":"12"},{"key":"deadline","desc":"截止时间","example":"2026-09-30 23:59:59"},{"key":"overdue","desc":"逾期处理方式","example":"将被自动收回"},{"key":"time","desc":"当前时间","example":"2026-06-23 09:00:00"},{"key":"app_name","desc":"系统名称","example":"统一身份认证平台"}]`,
+			IsBuiltin: true,
+			IsActive:  true,
+		},
+		{
+			Name:      "登录验证码",
+			Scene:     "login_verify",
+			Content:   "【{{app_name}}】{{name}}，检测到账号 {{username}} 于 {{time}} 从 {{location}}（IP {{ip}}）登录存在风险，验证码：{{code}}，5分钟内有效。如非本人操作，请立即修改密码。",
+			Variables: `[{"key":"code","desc":"登录验证码","example":"123456"},{"key":"username","desc":"登录账号","example":"zhangsan"},{"key":"name","desc":"用户姓名","example":"张三"},{"key":"ip","desc":"登录IP","example":"203.0.113.10"},{"key":"location","desc":"登录地点","example":"中国/上海/上海"},{"key":"time","desc":"登录时间","example":"2026-06-23 09:00:00"},{"key":"app_name","desc":"系统名称","example":"统一身份认证平台"}]`,
+			IsBuiltin: true,
+			IsActive:  true,
+		},
 		{
 			Name:      "测试消息",
 			Scene:     "test",
@@ -765,8 +805,30 @@ func SendWebhook(cfg WebhookConfig, message string) error {
 		body = strings.ReplaceAll(body, "{{time}}", time.Now().Format("2006-01-02 15:04:05"))
 	}
 
-	targetURL := cfg.URL
-	// Token 放在 URL 参数
+	req, err := http.NewRequest(method, WebhookTargetURL(cfg, cfg.URL), bytes.NewBufferString(body))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SignWebhookRequest(req, cfg, body)
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook返回异常: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// WebhookTargetURL Token 放在 URL 参数时直接追加到地址末尾，其余情况原样返回
+func WebhookTargetURL(cfg WebhookConfig, targetURL string) string {
 	if cfg.SignType == "token" && cfg.TokenPosition == "query" {
 		sep := "?"
 		if strings.Contains(targetURL, "?") {
@@ -774,13 +836,12 @@ func SendWebhook(cfg WebhookConfig, message string) error {
 		}
 		targetURL = targetURL + sep + "token=" + cfg.TokenValue
 	}
+	return targetURL
+}
 
-	req, err := http.NewRequest(method, targetURL, bytes.NewBufferString(body))
-	if err != nil {
-		return fmt.Errorf("创建请求失败: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
+// SignWebhookRequest 按 Webhook 配置为请求附加签名或 Token
+// hmac_sha256: 对 body 计算签名放入 SignHeader；token: 放入 Header（URL 参数见 WebhookTargetURL）
+func SignWebhookRequest(req *http.Request, cfg WebhookConfig, body string) {
 	// HMAC-SHA256 签名
 	if cfg.SignType == "hmac_sha256" && cfg.SignSecret != "" {
 		mac := hmac.New(sha256.New, []byte(cfg.SignSecret))
@@ -801,20 +862,6 @@ func SendWebhook(cfg WebhookConfig, message string) error {
 		}
 		req.Header.Set(header, cfg.TokenValue)
 	}
-
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("Webhook返回异常: %d - %s", resp.StatusCode, string(respBody))
-	}
-
-	return nil
 }
 
 // TestWebhookChannel 测试Webhook通道
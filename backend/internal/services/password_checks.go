@@ -0,0 +1,689 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+	"golang.org/x/crypto/bcrypt"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 密码检查流水线 ==========
+//
+// 密码策略由一组检查器依次执行，每个检查器返回不通过的原因：
+//   - policy     长度与字符类别
+//   - dictionary 内置常见弱密码 + 管理员上传的自定义禁用字典（weak_password_check）
+//   - user_info  包含用户名、姓名（含拼音）、邮箱前缀或手机号（user_info_check）
+//   - keyboard   主要由键盘序列、连续或重复字符组成（keyboard_pattern_check）
+//   - breach     出现在本地导入的 HIBP 泄露密码库中（breach_check，不访问网络）
+//   - similarity 与近期密码仅差字符替换或数字递增（similarity_check）
+//
+// 其他模块可以通过 RegisterPasswordChecker 追加检查器
+
+// PasswordDictionaryPath 管理员上传的自定义禁用字典
+const PasswordDictionaryPath = "./data/password_dictionary.txt"
+
+// PasswordPolicy 密码策略（password_policy）
+type PasswordPolicy struct {
+	MinLength            int
+	MaxLength            int
+	RequireUppercase     bool
+	RequireLowercase     bool
+	RequireNumber        bool
+	RequireSpecial       bool
+	SpecialChars         string
+	HistoryCount         int
+	WeakPasswordCheck    bool
+	UserInfoCheck        bool
+	KeyboardPatternCheck bool
+	KeyboardMinRun       int // 连续 N 个字符以上才算键盘序列
+	BreachCheck          bool
+	BreachDataPath       string
+	BreachMinCount       int // 泄露次数达到该值才拒绝
+	SimilarityCheck      bool
+}
+
+// PasswordCheckInput 待检查的密码及其所属用户
+type PasswordCheckInput struct {
+	Password string       // 明文密码
+	User     *models.User // 新建用户时只需填写用户名、姓名等；为空时跳过个人信息和相似度检查
+}
+
+// PasswordChecker 密码检查器，Check 返回不通过的原因，未启用或通过时返回空
+type PasswordChecker struct {
+	Code  string
+	Name  string
+	Check func(policy PasswordPolicy, in PasswordCheckInput) []string
+}
+
+var (
+	passwordCheckersMu sync.RWMutex
+	passwordCheckers   []PasswordChecker
+)
+
+// RegisterPasswordChecker 注册密码检查器，Code 相同时替换已注册的检查器
+func RegisterPasswordChecker(checker PasswordChecker) {
+	passwordCheckersMu.Lock()
+	defer passwordCheckersMu.Unlock()
+	for i, c := range passwordCheckers {
+		if c.Code == checker.Code {
+			passwordCheckers[i] = checker
+			return
+		}
+	}
+	passwordCheckers = append(passwordCheckers, checker)
+}
+
+// RegisteredPasswordCheckers 已注册的检查器，按执行顺序
+func RegisteredPasswordCheckers() []PasswordChecker {
+	passwordCheckersMu.RLock()
+	defer passwordCheckersMu.RUnlock()
+	return append([]PasswordChecker{}, passwordCheckers...)
+}
+
+func init() {
+	RegisterPasswordChecker(PasswordChecker{Code: "policy", Name: "长度与字符类别", Check: checkPasswordComposition})
+	RegisterPasswordChecker(PasswordChecker{Code: "dictionary", Name: "常见密码与禁用字典", Check: checkPasswordDictionary})
+	RegisterPasswordChecker(PasswordChecker{Code: "user_info", Name: "个人信息", Check: checkPasswordUserInfo})
+	RegisterPasswordChecker(PasswordChecker{Code: "keyboard", Name: "键盘序列", Check: checkPasswordKeyboard})
+	RegisterPasswordChecker(PasswordChecker{Code: "breach", Name: "泄露密码库", Check: checkPasswordBreach})
+	RegisterPasswordChecker(PasswordChecker{Code: "similarity", Name: "近期密码相似度", Check: checkPasswordSimilarity})
+}
+
+// GetPasswordPolicy 读取密码策略，未配置的项使用默认值
+// 升级前创建的配置没有新增的检查项，保持原有行为，需管理员在安全中心开启
+func (s *SecurityService) GetPasswordPolicy() (PasswordPolicy, error) {
+	config, err := s.GetConfig("password_policy")
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	specialChars, _ := config["special_chars"].(string)
+	if specialChars == "" {
+		specialChars = "!@#$%^&*()_+-=[]{}|;:,.<>?"
+	}
+	breachPath, _ := config["breach_data_path"].(string)
+	if breachPath == "" {
+		breachPath = "./data/hibp"
+	}
+	policy := PasswordPolicy{
+		MinLength:      int(getFloat64(config, "min_length", 8)),
+		MaxLength:      int(getFloat64(config, "max_length", 128)),
+		SpecialChars:   specialChars,
+		HistoryCount:   int(getFloat64(config, "history_count", 5)),
+		KeyboardMinRun: int(getFloat64(config, "keyboard_min_run", 4)),
+		BreachDataPath: breachPath,
+		BreachMinCount: int(getFloat64(config, "breach_min_count", 1)),
+	}
+	policy.RequireUppercase, _ = config["require_uppercase"].(bool)
+	policy.RequireLowercase, _ = config["require_lowercase"].(bool)
+	policy.RequireNumber, _ = config["require_number"].(bool)
+	policy.RequireSpecial, _ = config["require_special"].(bool)
+	policy.WeakPasswordCheck, _ = config["weak_password_check"].(bool)
+	policy.UserInfoCheck, _ = config["user_info_check"].(bool)
+	policy.KeyboardPatternCheck, _ = config["keyboard_pattern_check"].(bool)
+	policy.BreachCheck, _ = config["breach_check"].(bool)
+	policy.SimilarityCheck, _ = config["similarity_check"].(bool)
+	if policy.KeyboardMinRun < 3 {
+		policy.KeyboardMinRun = 3
+	}
+	if policy.BreachMinCount < 1 {
+		policy.BreachMinCount = 1
+	}
+	return policy, nil
+}
+
+// ValidatePasswordFor 按密码策略依次执行各检查器
+func (s *SecurityService) ValidatePasswordFor(in PasswordCheckInput) (bool, []string) {
+	policy, err := s.GetPasswordPolicy()
+	if err != nil {
+		return true, nil // 如果获取配置失败，跳过验证
+	}
+
+	var errors []string
+	for _, checker := range RegisteredPasswordCheckers() {
+		errors = append(errors, checker.Check(policy, in)...)
+	}
+	return len(errors) == 0, errors
+}
+
+// ---------- 长度与字符类别 ----------
+
+func checkPasswordComposition(policy PasswordPolicy, in PasswordCheckInput) []string {
+	var errors []string
+	password := in.Password
+	if len(password) < policy.MinLength {
+		errors = append(errors, fmt.Sprintf("密码长度不能少于%d位", policy.MinLength))
+	}
+	if len(password) > policy.MaxLength {
+		errors = append(errors, fmt.Sprintf("密码长度不能超过%d位", policy.MaxLength))
+	}
+	if policy.RequireUppercase && !strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		errors = append(errors, "密码必须包含大写字母")
+	}
+	if policy.RequireLowercase && !strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz") {
+		errors = append(errors, "密码必须包含小写字母")
+	}
+	if policy.RequireNumber && !strings.ContainsAny(password, "0123456789") {
+		errors = append(errors, "密码必须包含数字")
+	}
+	if policy.RequireSpecial && !strings.ContainsAny(password, policy.SpecialChars) {
+		errors = append(errors, "密码必须包含特殊字符")
+	}
+	return errors
+}
+
+// ---------- 归一化 ----------
+
+// 常见的字符替换写法，如 P@ssw0rd
+var leetReplacer = strings.NewReplacer("@", "a", "4", "a", "8", "b", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+// passwordSkeleton 密码的归一化形式：转小写，去掉首尾的数字和符号，再还原常见的字符替换
+// Password2024! 与 P@ssw0rd#2025 得到相同的结果 password
+func passwordSkeleton(password string) string {
+	lower := strings.ToLower(password)
+	trimmed := strings.TrimFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	return leetReplacer.Replace(trimmed)
+}
+
+// ---------- 常见密码与禁用字典 ----------
+
+//go:embed common_passwords.txt
+var commonPasswordsData string
+
+var commonPasswords = parseWordList(strings.NewReader(commonPasswordsData))
+
+// parseWordList 每行一个词，忽略空行和 # 开头的注释，统一转小写
+func parseWordList(r io.Reader) map[string]bool {
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word != "" && !strings.HasPrefix(word, "#") {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+type passwordDictionary struct {
+	mu        sync.RWMutex
+	modTime   time.Time
+	checkedAt time.Time
+	words     map[string]bool
+}
+
+var customPasswordDict = &passwordDictionary{}
+
+// refresh 字典文件更新后（按修改时间判断，每分钟检查一次）重新加载
+func (d *passwordDictionary) refresh() {
+	d.mu.RLock()
+	fresh := time.Since(d.checkedAt) < time.Minute
+	d.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.checkedAt = time.Now()
+	info, err := os.Stat(PasswordDictionaryPath)
+	if err != nil {
+		d.words, d.modTime = nil, time.Time{}
+		return
+	}
+	if info.ModTime().Equal(d.modTime) && d.words != nil {
+		return
+	}
+	f, err := os.Open(PasswordDictionaryPath)
+	if err != nil {
+		log.Printf("[密码策略] 打开自定义字典失败: %v", err)
+		return
+	}
+	defer f.Close()
+	d.words = parseWordList(f)
+	d.modTime = info.ModTime()
+}
+
+func (d *passwordDictionary) contains(word string) bool {
+	d.refresh()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.words[word]
+}
+
+// PasswordDictionaryStatus 自定义禁用字典的词数和更新时间
+func PasswordDictionaryStatus() (int, *time.Time) {
+	customPasswordDict.refresh()
+	customPasswordDict.mu.RLock()
+	defer customPasswordDict.mu.RUnlock()
+	if customPasswordDict.words == nil {
+		return 0, nil
+	}
+	t := customPasswordDict.modTime
+	return len(customPasswordDict.words), &t
+}
+
+// CommonPasswordCount 内置常见弱密码的数量
+func CommonPasswordCount() int {
+	return len(commonPasswords)
+}
+
+// SavePasswordDictionary 用上传的内容替换自定义禁用字典，返回去重后的词数
+func SavePasswordDictionary(r io.Reader) (int, error) {
+	words := parseWordList(r)
+	if len(words) == 0 {
+		return 0, fmt.Errorf("字典中没有有效的词")
+	}
+	list := make([]string, 0, len(words))
+	for w := range words {
+		list = append(list, w)
+	}
+	if err := os.MkdirAll(filepath.Dir(PasswordDictionaryPath), 0755); err != nil {
+		return 0, err
+	}
+	tmp := PasswordDictionaryPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(list, "\n")+"\n"), 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, PasswordDictionaryPath); err != nil {
+		return 0, err
+	}
+	customPasswordDict.mu.Lock()
+	customPasswordDict.checkedAt = time.Time{}
+	customPasswordDict.mu.Unlock()
+	return len(list), nil
+}
+
+// ClearPasswordDictionary 删除自定义禁用字典
+func ClearPasswordDictionary() error {
+	if err := os.Remove(PasswordDictionaryPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	customPasswordDict.mu.Lock()
+	customPasswordDict.checkedAt = time.Time{}
+	customPasswordDict.mu.Unlock()
+	return nil
+}
+
+func checkPasswordDictionary(policy PasswordPolicy, in PasswordCheckInput) []string {
+	if !policy.WeakPasswordCheck {
+		return nil
+	}
+	lower := strings.ToLower(in.Password)
+	candidates := []string{lower}
+	// 末尾追加符号的写法，如 acme2024!
+	if trimmed := strings.TrimRightFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}); trimmed != lower && trimmed != "" {
+		candidates = append(candidates, trimmed)
+	}
+	// 归一化后过短的（如 abc）容易误伤，只比对原文
+	if skeleton := passwordSkeleton(in.Password); len(skeleton) >= 4 {
+		candidates = append(candidates, skeleton)
+	}
+	for _, word := range candidates {
+		if commonPasswords[word] {
+			return []string{"密码过于常见，请使用更复杂的密码"}
+		}
+		if customPasswordDict.contains(word) {
+			return []string{"密码在系统禁用的密码字典中，请更换"}
+		}
+	}
+	return nil
+}
+
+// ---------- 个人信息 ----------
+
+// namePinyin 姓名的全拼和首字母，非汉字部分忽略
+func namePinyin(name string) (full, initials string) {
+	a := pinyin.NewArgs()
+	a.Style = pinyin.Normal
+	var fullParts, initialParts []string
+	for _, p := range pinyin.Pinyin(name, a) {
+		if len(p) > 0 && p[0] != "" {
+			fullParts = append(fullParts, p[0])
+			initialParts = append(initialParts, p[0][:1])
+		}
+	}
+	return strings.Join(fullParts, ""), strings.Join(initialParts, "")
+}
+
+// userInfoTokens 密码中不应出现的个人信息
+func userInfoTokens(user *models.User) (tokens []string, initials string) {
+	add := func(s string) {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len(s) >= 3 {
+			tokens = append(tokens, s)
+		}
+	}
+	add(user.Username)
+	if at := strings.Index(user.Email, "@"); at > 0 {
+		add(user.Email[:at])
+	}
+	if len(user.Phone) >= 6 {
+		add(user.Phone)
+		add(user.Phone[len(user.Phone)-6:])
+	}
+	if user.Nickname != "" {
+		add(user.Nickname)
+		full, abbr := namePinyin(user.Nickname)
+		add(full)
+		// 名字的拼音（去掉姓），如 张三丰 -> sanfeng
+		if given, _ := namePinyin(string([]rune(user.Nickname)[1:])); given != full {
+			add(given)
+		}
+		initials = abbr
+	}
+	return tokens, initials
+}
+
+func checkPasswordUserInfo(policy PasswordPolicy, in PasswordCheckInput) []string {
+	if !policy.UserInfoCheck || in.User == nil {
+		return nil
+	}
+	lower := strings.ToLower(in.Password)
+	normalized := leetReplacer.Replace(lower)
+	skeleton := passwordSkeleton(in.Password)
+	tokens, initials := userInfoTokens(in.User)
+	for _, token := range tokens {
+		if strings.Contains(lower, token) || strings.Contains(normalized, token) {
+			return []string{"密码不能包含用户名、姓名（含拼音）、邮箱或手机号等个人信息"}
+		}
+	}
+	// 姓名首字母加数字，如 zs123456
+	if len(initials) >= 2 && skeleton == initials {
+		return []string{"密码不能包含用户名、姓名（含拼音）、邮箱或手机号等个人信息"}
+	}
+	return nil
+}
+
+// ---------- 键盘序列 ----------
+
+// 键盘行、列、交错走位以及字母数字顺序，正序和倒序都会比对
+var keyboardSequences = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"1qaz", "2wsx", "3edc", "4rfv", "5tgb", "6yhn", "7ujm", "8ik,", "9ol.", "0p;/",
+	"1q2w3e4r5t6y7u8i9o0p", "q1w2e3r4t5y6u7i8o9p0", "qawsedrftgyhujikolp;", "azsxdcfvgbhnjmk,l.;/",
+	"abcdefghijklmnopqrstuvwxyz", "01234567890",
+}
+
+// 上档符号还原为对应的按键
+var keyboardShiftReplacer = strings.NewReplacer(
+	"~", "`", "!", "1", "@", "2", "#", "3", "$", "4", "%", "5", "^", "6", "&", "7", "*", "8", "(", "9", ")", "0", "_", "-", "+", "=",
+	"{", "[", "}", "]", "|", "\\", ":", ";", "\"", "'", "<", ",", ">", ".", "?", "/",
+)
+
+// keyboardCoverage 密码中属于键盘序列或重复字符（连续 minRun 个以上）的字符数
+func keyboardCoverage(password string, minRun int) int {
+	s := keyboardShiftReplacer.Replace(strings.ToLower(password))
+	n := len(s)
+	covered := make([]bool, n)
+	mark := func(from, to int) {
+		for i := from; i < to; i++ {
+			covered[i] = true
+		}
+	}
+
+	var patterns []string
+	for _, seq := range keyboardSequences {
+		runes := []byte(seq)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		patterns = append(patterns, seq, string(runes))
+	}
+
+	for i := 0; i+minRun <= n; i++ {
+		// 重复字符，如 aaaa、1111
+		j := i + 1
+		for j < n && s[j] == s[i] {
+			j++
+		}
+		if j-i >= minRun {
+			mark(i, j)
+		}
+		// 键盘 / 顺序序列，取从 i 开始的最长匹配
+		for l := n - i; l >= minRun; l-- {
+			matched := false
+			for _, p := range patterns {
+				if strings.Contains(p, s[i:i+l]) {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				mark(i, i+l)
+				break
+			}
+		}
+	}
+
+	count := 0
+	for _, c := range covered {
+		if c {
+			count++
+		}
+	}
+	return count
+}
+
+func checkPasswordKeyboard(policy PasswordPolicy, in PasswordCheckInput) []string {
+	if !policy.KeyboardPatternCheck || in.Password == "" {
+		return nil
+	}
+	// 键盘序列占一半以上视为规律密码，如 qwer1234、1qaz2wsx、Aa123456
+	if keyboardCoverage(in.Password, policy.KeyboardMinRun)*2 >= len(in.Password) {
+		return []string{"密码不能主要由键盘序列、连续或重复字符组成"}
+	}
+	return nil
+}
+
+// ---------- 泄露密码库 ----------
+
+// HIBPDataStatus 离线 HIBP 数据是否可用及其说明
+func HIBPDataStatus(path string) (bool, string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, "数据不存在：" + path
+	}
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, "00000.txt")); err != nil {
+			return false, "目录中没有按前缀分片的文件（如 00000.txt）"
+		}
+		return true, "按前缀分片的目录：" + path
+	}
+	return true, fmt.Sprintf("按哈希排序的单文件：%s（%.1f MB）", path, float64(info.Size())/1024/1024)
+}
+
+// HIBPBreachCount 在离线 HIBP 数据中查询密码的泄露次数
+// 采用 k-匿名方式：只用 SHA1 的前 5 位定位范围，再在范围内比对后缀，完整哈希不出现在文件名或日志中
+// path 为目录时读取 <前缀>.txt（PwnedPasswordsDownloader 默认输出，每行 后缀:次数）；
+// 为文件时视为按哈希排序的完整列表（每行 完整哈希:次数），按前缀二分查找
+func HIBPBreachCount(path, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		f, err := os.Open(filepath.Join(path, prefix+".txt"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		defer f.Close()
+		return scanHIBPRange(bufio.NewScanner(f), "", suffix), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	start, err := seekHIBPPrefix(f, info.Size(), prefix)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(f)
+	if start > 0 {
+		scanner.Scan() // 偏移可能落在行中间，跳过不完整的一行（该行前缀必然小于目标前缀）
+	}
+	return scanHIBPRange(scanner, prefix, suffix), nil
+}
+
+// scanHIBPRange 逐行比对范围内的后缀；prefix 非空时各行带完整哈希，离开该前缀即停止
+func scanHIBPRange(scanner *bufio.Scanner, prefix, suffix string) int {
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if prefix != "" {
+			if !strings.HasPrefix(strings.ToUpper(line), prefix) {
+				if len(line) >= 5 && strings.ToUpper(line[:5]) > prefix {
+					return 0
+				}
+				continue
+			}
+			line = line[5:]
+		}
+		hashPart, countPart, _ := strings.Cut(line, ":")
+		if strings.EqualFold(hashPart, suffix) {
+			count, _ := strconv.Atoi(strings.TrimSpace(countPart))
+			if count < 1 {
+				count = 1
+			}
+			return count
+		}
+	}
+	return 0
+}
+
+// seekHIBPPrefix 在按哈希排序的文件中二分查找，返回前缀不小于 prefix 的第一行之前的某个行首偏移
+func seekHIBPPrefix(f *os.File, size int64, prefix string) (int64, error) {
+	lo, hi := int64(0), size
+	buf := make([]byte, 128)
+	for hi-lo > 4096 {
+		mid := (lo + hi) / 2
+		n, err := f.ReadAt(buf, mid)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		// 跳到 mid 之后的下一个行首
+		chunk := string(buf[:n])
+		nl := strings.IndexByte(chunk, '\n')
+		if nl < 0 || len(chunk)-nl-1 < 5 {
+			hi = mid
+			continue
+		}
+		if strings.ToUpper(chunk[nl+1:nl+6]) < prefix {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+func checkPasswordBreach(policy PasswordPolicy, in PasswordCheckInput) []string {
+	if !policy.BreachCheck || in.Password == "" {
+		return nil
+	}
+	count, err := HIBPBreachCount(policy.BreachDataPath, in.Password)
+	if err != nil {
+		// 数据未导入或读取失败时不阻止修改密码
+		log.Printf("[密码策略] 查询泄露密码库失败: %v", err)
+		return nil
+	}
+	if count >= policy.BreachMinCount {
+		return []string{fmt.Sprintf("该密码已在公开泄露的密码库中出现 %d 次，请更换", count)}
+	}
+	return nil
+}
+
+// ---------- 近期密码相似度 ----------
+//
+// 密码历史只保存完整密码的哈希，只能发现完全相同的密码。修改密码时额外保存归一化形式的哈希，
+// 新密码的归一化形式与其中任一相同即视为相似（如 Summer2024! -> Summ3r2025#）
+
+// passwordSkeletonKeep 保留的归一化哈希数量，与密码历史次数一致，未启用历史时保留 5 个
+func passwordSkeletonKeep(policy PasswordPolicy) int {
+	if policy.HistoryCount > 0 {
+		return policy.HistoryCount
+	}
+	return 5
+}
+
+func checkPasswordSimilarity(policy PasswordPolicy, in PasswordCheckInput) []string {
+	if !policy.SimilarityCheck || in.User == nil || in.User.ID == 0 {
+		return nil
+	}
+	skeleton := passwordSkeleton(in.Password)
+	if len(skeleton) < 4 {
+		return nil
+	}
+	var user models.User
+	if err := storage.DB.Select("id, password_skeletons").First(&user, in.User.ID).Error; err != nil || user.PasswordSkeletons == "" {
+		return nil
+	}
+	var hashes []string
+	json.Unmarshal([]byte(user.PasswordSkeletons), &hashes)
+	if keep := passwordSkeletonKeep(policy); len(hashes) > keep {
+		hashes = hashes[len(hashes)-keep:]
+	}
+	for _, h := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(h), []byte(skeleton)) == nil {
+			return []string{"新密码与近期使用过的密码过于相似，仅替换字符或修改数字不算新密码"}
+		}
+	}
+	return nil
+}
+
+// RecordPasswordSkeleton 修改密码成功后记录新密码归一化形式的哈希（需要明文，前端已哈希且无原文时跳过）
+func (s *SecurityService) RecordPasswordSkeleton(userID uint, password string) error {
+	skeleton := passwordSkeleton(password)
+	if len(skeleton) < 4 {
+		return nil
+	}
+	policy, _ := s.GetPasswordPolicy()
+
+	var user models.User
+	if err := storage.DB.Select("id, password_skeletons").First(&user, userID).Error; err != nil {
+		return err
+	}
+	var hashes []string
+	if user.PasswordSkeletons != "" {
+		json.Unmarshal([]byte(user.PasswordSkeletons), &hashes)
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(skeleton), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	hashes = append(hashes, string(hashed))
+	if keep := passwordSkeletonKeep(policy); len(hashes) > keep {
+		hashes = hashes[len(hashes)-keep:]
+	}
+	data, _ := json.Marshal(hashes)
+	return storage.DB.Model(&models.User{}).Where("id = ?", userID).Update("password_skeletons", string(data)).Error
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPasswordSkeleton(t *testing.T) {
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"Password2024!", "password"},
+		{"P@ssw0rd#2025", "password"},
+		{"Summ3r2025#", "summer"},
+		{"2024Winter!!", "winter"},
+		{"$ecure1", "ecure"}, // 首尾非字母先去掉，$ 不再参与还原
+		{"Adm1n+", "admin"},
+		{"123456", ""},
+		{"", ""},
+		{"张三2024", "张三"},
+	}
+	for _, tt := range tests {
+		if got := passwordSkeleton(tt.password); got != tt.want {
+			t.Errorf("passwordSkeleton(%q) = %q, want %q", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestScanHIBPRange(t *testing.T) {
+	const suffix = "1E4C9B93F3F0682250B6CF8331B7EE68FD8"
+	tests := []struct {
+		name   string
+		prefix string
+		data   string
+		want   int
+	}{
+		{"分片文件命中", "", "0018A45C4D1DEF81644B54AB7F969B88D65:3\n" + suffix + ":42\n", 42},
+		{"分片文件后缀不区分大小写", "", strings.ToLower(suffix) + ":7\r\n", 7},
+		{"分片文件未命中", "", "0018A45C4D1DEF81644B54AB7F969B88D65:3\n", 0},
+		{"次数缺失按 1 计", "", suffix + "\n", 1},
+		{"完整哈希命中", "5BAA6", "5BAA5FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\n5BAA6" + suffix + ":9\n", 9},
+		{"离开前缀即停止", "5BAA6", "5BAA7" + suffix + ":9\n5BAA6" + suffix + ":9\n", 0},
+		{"跳过前缀更小的行", "5BAA6", "00000" + suffix + ":1\nABC\n5baa6" + suffix + ":5\n", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(strings.NewReader(tt.data))
+			if got := scanHIBPRange(scanner, tt.prefix, suffix); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeHIBPFile 按哈希排序写入 count 个密码的完整哈希列表；size > 0 时用前导零补齐最后一行的次数，使文件恰好为 size 字节
+func writeHIBPFile(t *testing.T, count int, size int) (string, map[string]int) {
+	t.Helper()
+	type entry struct {
+		hash     string
+		password string
+	}
+	entries := make([]entry, count)
+	for i := range entries {
+		password := fmt.Sprintf("pw-%d", i)
+		sum := sha1.Sum([]byte(password))
+		entries[i] = entry{strings.ToUpper(hex.EncodeToString(sum[:])), password}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].hash < entries[j].hash })
+
+	var b strings.Builder
+	counts := make(map[string]int, count)
+	for i, e := range entries {
+		counts[e.password] = i + 1
+		line := fmt.Sprintf("%s:%d\n", e.hash, i+1)
+		if i == count-1 && size > 0 {
+			pad := size - b.Len() - len(line)
+			if pad < 0 {
+				t.Fatalf("%d 行超过 %d 字节", count, size)
+			}
+			line = fmt.Sprintf("%s:%s%d\n", e.hash, strings.Repeat("0", pad), i+1)
+		}
+		b.WriteString(line)
+	}
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if size > 0 && b.Len() != size {
+		t.Fatalf("文件大小 %d, want %d", b.Len(), size)
+	}
+	return path, counts
+}
+
+func TestHIBPBreachCountSortedFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		size  int
+	}{
+		{"恰好 4096 字节不做二分", 90, 4096},
+		{"4097 字节二分一次", 90, 4097},
+		{"多次二分", 3000, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, counts := writeHIBPFile(t, tt.count, tt.size)
+			for password, want := range counts {
+				got, err := HIBPBreachCount(path, password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Fatalf("HIBPBreachCount(%s) = %d, want %d", password, got, want)
+				}
+			}
+			if got, _ := HIBPBreachCount(path, "not-in-list"); got != 0 {
+				t.Errorf("未泄露密码 count = %d", got)
+			}
+		})
+	}
+}
+
+func TestSeekHIBPPrefix(t *testing.T) {
+	path, _ := writeHIBPFile(t, 3000, 0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, prefix := range []string{"00000", "3FFFF", "80000", "C0FFE", "FFFFF"} {
+		// 第一行前缀不小于 prefix 的行首偏移
+		first := len(data)
+		for offset := 0; offset < len(data); {
+			if string(data[offset:offset+5]) >= prefix {
+				first = offset
+				break
+			}
+			offset += strings.IndexByte(string(data[offset:]), '\n') + 1
+		}
+		start, err := seekHIBPPrefix(f, int64(len(data)), prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 起点不越过目标行，且距离不超过一个二分窗口加一行
+		if start > int64(first) || int64(first)-start > 4096+128 {
+			t.Errorf("seekHIBPPrefix(%s) = %d, first line at %d", prefix, start, first)
+		}
+	}
+}
+
+func TestHIBPBreachCountDirectory(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("password"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	data := "0018A45C4D1DEF81644B54AB7F969B88D65:3\n" + hash[5:] + ":3861493\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := HIBPBreachCount(dir, "password"); err != nil || got != 3861493 {
+		t.Errorf("got %d, %v", got, err)
+	}
+	// 前缀分片不存在视为未泄露
+	if got, err := HIBPBreachCount(dir, "another"); err != nil || got != 0 {
+		t.Errorf("got %d, %v", got, err)
+	}
+}
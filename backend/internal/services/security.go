@@ -1,6 +1,7 @@
 package services
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -76,14 +77,21 @@ func (s *SecurityService) ClearConfigCache() {
 
 // RecordLoginAttempt 记录登录尝试
 func (s *SecurityService) RecordLoginAttempt(username string, userID *uint, ip, userAgent string, success bool, failureReason string) error {
+	return s.RecordLoginAttemptDetail(username, userID, ip, userAgent, "", "", success, failureReason)
+}
+
+// RecordLoginAttemptDetail 记录登录尝试（含归属地和设备指纹，供风险评估使用）
+func (s *SecurityService) RecordLoginAttemptDetail(username string, userID *uint, ip, userAgent, location, fingerprint string, success bool, failureReason string) error {
 	attempt := models.LoginAttempt{
-		Username:      username,
-		UserID:        userID,
-		IPAddress:     ip,
-		UserAgent:     userAgent,
-		Success:       success,
-		FailureReason: failureReason,
-		CreatedAt:     time.Now(),
+		Username:          username,
+		UserID:            userID,
+		IPAddress:         ip,
+		UserAgent:         userAgent,
+		Success:           success,
+		FailureReason:     failureReason,
+		Location:          location,
+		DeviceFingerprint: fingerprint,
+		CreatedAt:         time.Now(),
 	}
 	return storage.DB.Create(&attempt).Error
 }
@@ -137,7 +145,7 @@ func (s *SecurityService) HandleFailedLogin(username, ip string) error {
 			if enabled, _ := accountConfig["enabled"].(bool); enabled {
 				maxAttempts := int(getFloat64(accountConfig, "max_attempts", 5))
 				if user.FailedAttempts >= maxAttempts {
-					s.LockAccount(username, user.FailedAttempts, user.LockCount+1)
+					s.LockAccount(username, ip, "", user.FailedAttempts, user.LockCount+1)
 				}
 			}
 		}
@@ -171,8 +179,12 @@ func (s *SecurityService) HandleSuccessfulLogin(userID uint, ip string) error {
 	}).Error
 }
 
-// LockAccount 锁定账户
-func (s *SecurityService) LockAccount(username string, attemptCount, lockCount int) error {
+// LockAccount 锁定账户，ip 为触发锁定的来源IP（用于告警规则按IP统计），reason 为空表示因连续登录失败锁定
+func (s *SecurityService) LockAccount(username, ip, reason string, attemptCount, lockCount int) error {
+	if reason == "" {
+		reason = "连续登录失败次数过多"
+	}
+
 	config, _ := s.GetConfig("login_security")
 	accountConfig, _ := config["account_lockout"].(map[string]interface{})
 
@@ -200,7 +212,7 @@ func (s *SecurityService) LockAccount(username string, attemptCount, lockCount i
 	lockout := models.Lockout{
 		LockType:     models.LockTypeAccount,
 		Target:       username,
-		Reason:       "连续登录失败次数过多",
+		Reason:       reason,
 		AttemptCount: attemptCount,
 		LockCount:    lockCount,
 		LockedAt:     time.Now(),
@@ -216,8 +228,8 @@ func (s *SecurityService) LockAccount(username string, attemptCount, lockCount i
 	})
 
 	// 记录安全事件
-	s.RecordSecurityEvent(models.EventAccountLocked, models.SeverityHigh, "", nil, username,
-		"account", username, "账户因连续登录失败被锁定", map[string]interface{}{
+	s.RecordSecurityEvent(models.EventAccountLocked, models.SeverityHigh, ip, nil, username,
+		"account", username, "账户被锁定: "+reason, map[string]interface{}{
 			"attempt_count":    attemptCount,
 			"lock_count":       lockCount,
 			"duration_minutes": durationMinutes,
@@ -423,6 +435,13 @@ func (s *SecurityService) RemoveFromBlacklist(id uint, removedBy uint) error {
 // RecordSecurityEvent 记录安全事件
 func (s *SecurityService) RecordSecurityEvent(eventType, severity, sourceIP string, userID *uint, username,
 	targetType, targetID, description string, details map[string]interface{}) error {
+	return s.RecordClientSecurityEvent(eventType, severity, sourceIP, "", "", userID, username,
+		targetType, targetID, description, details)
+}
+
+// RecordClientSecurityEvent 记录带客户端信息（User-Agent、登录地点）的安全事件，供告警条件按字段匹配
+func (s *SecurityService) RecordClientSecurityEvent(eventType, severity, sourceIP, userAgent, location string, userID *uint, username,
+	targetType, targetID, description string, details map[string]interface{}) error {
 
 	detailsJSON := ""
 	if details != nil {
@@ -440,6 +459,8 @@ func (s *SecurityService) RecordSecurityEvent(eventType, severity, sourceIP stri
 		TargetID:    targetID,
 		Description: description,
 		Details:     detailsJSON,
+		Location:    location,
+		UserAgent:   userAgent,
 		CreatedAt:   time.Now(),
 	}
 
@@ -465,51 +486,50 @@ func (s *SecurityService) triggerAlertRules(event *models.SecurityEvent) {
 	var rules []models.AlertRule
 	storage.DB.Where("is_active = ?", true).Find(&rules)
 
-	severityOrder := map[string]int{"low": 1, "medium": 2, "high": 3, "critical": 4}
-
 	for _, rule := range rules {
-		// 1. 检查事件类型是否匹配
-		var eventTypes []string
-		json.Unmarshal([]byte(rule.EventTypes), &eventTypes)
-		if len(eventTypes) > 0 {
-			matched := false
-			for _, et := range eventTypes {
-				if et == event.EventType {
-					matched = true
-					break
-				}
-			}
-			if !matched {
-				continue
-			}
+		cond, err := ParseAlertConditions(rule.Conditions)
+		if err != nil {
+			log.Printf("[告警引擎] 规则 %s 条件无效，跳过: %v", rule.Name, err)
+			continue
 		}
 
-		// 2. 检查严重级别
-		eventLevel := severityOrder[event.Severity]
-		thresholdLevel := severityOrder[rule.SeverityThreshold]
-		if eventLevel < thresholdLevel {
+		// 1. 检查事件类型、严重级别和字段条件
+		var ruleTypes []string
+		json.Unmarshal([]byte(rule.EventTypes), &ruleTypes)
+		eventTypes := cond.triggerEventTypes(ruleTypes)
+		if !matchAlertRuleEvent(event, eventTypes, alertSeverityOrder[rule.SeverityThreshold], cond.Filters) {
 			continue
 		}
 
-		// 3. 检查冷却时间
-		if rule.LastTriggeredAt != nil {
-			cooldown := time.Duration(rule.CooldownMinutes) * time.Minute
-			if time.Since(*rule.LastTriggeredAt) < cooldown {
-				continue
-			}
+		// 2. 在滑动窗口内评估计数 / 序列条件
+		match := evaluateAlertConditions(&rule, cond, eventTypes, event)
+		if match == nil {
+			continue
 		}
 
-		// 4. 匹配成功，发送告警
-		s.sendAlertNotification(&rule, event)
+		// 3. 合并到告警聚合，聚合窗口（冷却时间与条件窗口取大）内的后续匹配不再重复通知
+		incident, isNew, err := recordAlertIncident(&rule, event, match)
+		if err != nil {
+			log.Printf("[告警引擎] 规则 %s 记录告警聚合失败: %v", rule.Name, err)
+			continue
+		}
+		if !isNew {
+			continue
+		}
 
-		// 5. 更新规则的最后触发时间
+		// 4. 新的告警聚合，执行自动响应动作并发送告警
+		runAlertActions(&rule, incident, event)
+		s.sendAlertNotification(&rule, event, incident)
 		now := time.Now()
+		storage.DB.Model(&models.AlertIncident{}).Where("id = ?", incident.ID).Update("notified_at", now)
+
+		// 5. 更新规则的最后触发时间
 		storage.DB.Model(&rule).Update("last_triggered_at", now)
 	}
 }
 
 // sendAlertNotification 通过配置的通道发送告警通知
-func (s *SecurityService) sendAlertNotification(rule *models.AlertRule, event *models.SecurityEvent) {
+func (s *SecurityService) sendAlertNotification(rule *models.AlertRule, event *models.SecurityEvent, incident *models.AlertIncident) {
 	// 获取通知渠道
 	var channelIDs []uint
 	json.Unmarshal([]byte(rule.NotifyChannels), &channelIDs)
@@ -519,7 +539,7 @@ func (s *SecurityService) sendAlertNotification(rule *models.AlertRule, event *m
 	}
 
 	// 获取消息模板内容
-	content := s.buildAlertContent(rule, event)
+	content := s.buildAlertContent(rule, event, incident)
 
 	// 遍历渠道发送
 	for _, chID := range channelIDs {
@@ -563,6 +583,7 @@ func (s *SecurityService) sendAlertNotification(rule *models.AlertRule, event *m
 		alertLog := models.AlertLog{
 			RuleID:       &ruleID,
 			EventID:      &eventID,
+			IncidentID:   &incident.ID,
 			ChannelID:    &channel.ID,
 			ChannelType:  channel.ChannelType,
 			Recipient:    channel.Name,
@@ -576,7 +597,7 @@ func (s *SecurityService) sendAlertNotification(rule *models.AlertRule, event *m
 }
 
 // buildAlertContent 构建告警消息内容
-func (s *SecurityService) buildAlertContent(rule *models.AlertRule, event *models.SecurityEvent) string {
+func (s *SecurityService) buildAlertContent(rule *models.AlertRule, event *models.SecurityEvent, incident *models.AlertIncident) string {
 	// 如果配置了模板，使用模板
 	if rule.TemplateID > 0 {
 		var tpl models.MessageTemplate
@@ -589,6 +610,9 @@ func (s *SecurityService) buildAlertContent(rule *models.AlertRule, event *model
 			content = strings.Replace(content, "{{description}}", event.Description, -1)
 			content = strings.Replace(content, "{{time}}", event.CreatedAt.Format("2006-01-02 15:04:05"), -1)
 			content = strings.Replace(content, "{{rule_name}}", rule.Name, -1)
+			content = strings.Replace(content, "{{event_count}}", fmt.Sprintf("%d", incident.EventCount), -1)
+			content = strings.Replace(content, "{{group_key}}", incident.GroupKey, -1)
+			content = strings.Replace(content, "{{summary}}", incident.Summary, -1)
 			return content
 		}
 	}
@@ -597,10 +621,13 @@ func (s *SecurityService) buildAlertContent(rule *models.AlertRule, event *model
 	severityNames := map[string]string{"low": "低", "medium": "中", "high": "高", "critical": "严重"}
 	eventNames := map[string]string{
 		"login_failed": "登录失败", "login_blocked": "登录阻止", "login_success": "登录成功",
+		"login_challenged": "登录二次验证",
 		"account_locked": "账户锁定", "account_unlocked": "账户解锁",
 		"ip_blocked": "IP封禁", "ip_unblocked": "IP解封",
-		"password_changed": "密码修改", "config_changed": "配置变更",
+		"password_changed": "密码修改", "password_reset": "密码重置", "config_changed": "配置变更",
 		"session_terminated": "会话终止", "suspicious_activity": "可疑活动",
+		"passkey_registered": "通行密钥注册", "passkey_revoked": "通行密钥撤销",
+		"refresh_token_reused": "刷新令牌重放",
 	}
 
 	eventName := eventNames[event.EventType]
@@ -612,10 +639,14 @@ func (s *SecurityService) buildAlertContent(rule *models.AlertRule, event *model
 		severityName = event.Severity
 	}
 
-	return fmt.Sprintf("【安全告警】%s\n规则: %s\n事件: %s\n级别: %s\n用户: %s\nIP: %s\n描述: %s\n时间: %s",
+	content := fmt.Sprintf("【安全告警】%s\n规则: %s\n事件: %s\n级别: %s\n用户: %s\nIP: %s\n描述: %s\n时间: %s",
 		rule.Name, rule.Name, eventName, severityName,
 		event.Username, event.SourceIP, event.Description,
 		event.CreatedAt.Format("2006-01-02 15:04:05"))
+	if incident.EventCount > 1 {
+		content += fmt.Sprintf("\n聚合: %s（%d 个事件）", incident.Summary, incident.EventCount)
+	}
+	return content
 }
 
 // sendEmailAlert 发送邮件告警
@@ -712,66 +743,9 @@ func (s *SecurityService) getTargetUsers(rule *models.AlertRule, event *models.S
 
 // ========== 密码策略 ==========
 
-// ValidatePassword 验证密码是否符合策略
+// ValidatePassword 验证密码是否符合策略（不含与用户相关的检查，见 ValidatePasswordFor）
 func (s *SecurityService) ValidatePassword(password string) (bool, []string) {
-	var errors []string
-
-	config, err := s.GetConfig("password_policy")
-	if err != nil {
-		return true, nil // 如果获取配置失败，跳过验证
-	}
-
-	minLength := int(getFloat64(config, "min_length", 8))
-	maxLength := int(getFloat64(config, "max_length", 128))
-
-	if len(password) < minLength {
-		errors = append(errors, "密码长度不能少于"+string(rune('0'+minLength))+"位")
-	}
-	if len(password) > maxLength {
-		errors = append(errors, "密码长度不能超过"+string(rune('0'+maxLength))+"位")
-	}
-
-	if requireUpper, _ := config["require_uppercase"].(bool); requireUpper {
-		if !strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
-			errors = append(errors, "密码必须包含大写字母")
-		}
-	}
-
-	if requireLower, _ := config["require_lowercase"].(bool); requireLower {
-		if !strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz") {
-			errors = append(errors, "密码必须包含小写字母")
-		}
-	}
-
-	if requireNumber, _ := config["require_number"].(bool); requireNumber {
-		if !strings.ContainsAny(password, "0123456789") {
-			errors = append(errors, "密码必须包含数字")
-		}
-	}
-
-	if requireSpecial, _ := config["require_special"].(bool); requireSpecial {
-		specialChars, _ := config["special_chars"].(string)
-		if specialChars == "" {
-			specialChars = "!@#$%^&*()_+-=[]{}|;:,.<>?"
-		}
-		if !strings.ContainsAny(password, specialChars) {
-			errors = append(errors, "密码必须包含特殊字符")
-		}
-	}
-
-	// 弱密码检查
-	if weakCheck, _ := config["weak_password_check"].(bool); weakCheck {
-		weakPasswords := []string{"password", "123456", "12345678", "admin", "qwerty", "password123", "admin123"}
-		lowerPwd := strings.ToLower(password)
-		for _, weak := range weakPasswords {
-			if lowerPwd == weak {
-				errors = append(errors, "密码过于简单，请使用更复杂的密码")
-				break
-			}
-		}
-	}
-
-	return len(errors) == 0, errors
+	return s.ValidatePasswordFor(PasswordCheckInput{Password: password})
 }
 
 // CheckPasswordHistory 检查密码历史
@@ -845,52 +819,58 @@ func (s *SecurityService) UpdatePasswordHistory(userID uint, passwordHash string
 
 // ========== 会话管理 ==========
 
-// CreateSession 创建会话
-func (s *SecurityService) CreateSession(userID uint, accessToken, refreshToken, ip, userAgent string) (*models.Session, error) {
-	config, _ := s.GetConfig("session")
-
-	accessTTL := int(getFloat64(config, "access_token_ttl_minutes", 60))
-	refreshTTL := int(getFloat64(config, "refresh_token_ttl_days", 7))
-	maxSessions := int(getFloat64(config, "max_concurrent_sessions", 5))
-	singleMode, _ := config["single_session_mode"].(bool)
+// CreateSession 创建会话并生成刷新令牌（明文只返回一次，库中保存摘要）
+// 单会话模式下终止该用户其他会话，否则超出并发会话数时终止最早的会话
+func (s *SecurityService) CreateSession(userID uint, ip, userAgent, location string) (*models.Session, string, error) {
+	cfg := s.GetSessionConfig()
+	now := time.Now()
 
-	// 单会话模式：终止其他会话
-	if singleMode {
-		storage.DB.Model(&models.Session{}).Where("user_id = ? AND is_active = ?", userID, true).
-			Update("is_active", false)
-	} else {
-		// 检查并发会话数
-		var count int64
-		storage.DB.Model(&models.Session{}).Where("user_id = ? AND is_active = ?", userID, true).Count(&count)
-		if int(count) >= maxSessions {
-			// 终止最旧的会话
-			var oldestSession models.Session
-			storage.DB.Where("user_id = ? AND is_active = ?", userID, true).
-				Order("created_at ASC").First(&oldestSession)
-			storage.DB.Model(&oldestSession).Update("is_active", false)
+	// LDAP Bind 记录的会话不占用并发名额
+	active := storage.DB.Model(&models.Session{}).
+		Where("user_id = ? AND is_active = ? AND expires_at > ? AND user_agent <> ?", userID, true, now, "LDAP")
+	if cfg.SingleSession {
+		var ids []string
+		active.Pluck("id", &ids)
+		if len(ids) > 0 {
+			revokeSession(storage.DB, "id IN ?", []interface{}{ids}, SessionRevokeSingle)
+		}
+	} else if cfg.MaxConcurrent > 0 {
+		var ids []string
+		active.Order("last_activity DESC").Pluck("id", &ids)
+		if len(ids) >= cfg.MaxConcurrent {
+			// 保留最近活跃的 MaxConcurrent-1 个，为新会话腾出名额
+			revokeSession(storage.DB, "id IN ?", []interface{}{ids[cfg.MaxConcurrent-1:]}, SessionRevokeConcurrent)
 		}
 	}
 
-	session := models.Session{
-		ID:           generateSessionID(),
-		UserID:       userID,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		IPAddress:    ip,
-		UserAgent:    userAgent,
-		IsActive:     true,
-		LastActivity: time.Now(),
-		ExpiresAt:    time.Now().Add(time.Duration(refreshTTL) * 24 * time.Hour),
-		CreatedAt:    time.Now(),
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
 	}
 
-	_ = accessTTL // 用于token生成
+	expiresAt := now.Add(cfg.RefreshTokenTTL)
+	if cfg.AbsoluteTimeout > 0 && now.Add(cfg.AbsoluteTimeout).Before(expiresAt) {
+		expiresAt = now.Add(cfg.AbsoluteTimeout)
+	}
+	session := models.Session{
+		ID:               generateSessionID(),
+		UserID:           userID,
+		RefreshToken:     hashSessionToken(refreshToken),
+		IPAddress:        ip,
+		UserAgent:        userAgent,
+		Location:         location,
+		IsActive:         true,
+		LastActivity:     now,
+		ExpiresAt:        expiresAt,
+		RefreshExpiresAt: expiresAt,
+		CreatedAt:        now,
+	}
 
 	if err := storage.DB.Create(&session).Error; err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return &session, nil
+	return &session, refreshToken, nil
 }
 
 // TerminateSession 终止会话
@@ -900,8 +880,8 @@ func (s *SecurityService) TerminateSession(sessionID string, terminatedBy *uint)
 		return err
 	}
 
-	if err := storage.DB.Model(&session).Update("is_active", false).Error; err != nil {
-		return err
+	if !s.RevokeSession(sessionID, SessionRevokeTerminated) {
+		return nil
 	}
 
 	s.RecordSecurityEvent(models.EventSessionTerminated, models.SeverityLow, session.IPAddress,
@@ -930,12 +910,13 @@ func generateSessionID() string {
 	return time.Now().Format("20060102150405") + randomString(16)
 }
 
+// randomString 会话 ID 写入访问令牌用于撤销校验，使用 crypto/rand 避免被猜测
 func randomString(n int) string {
 	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, n)
+	rand.Read(b)
 	for i := range b {
-		b[i] = letters[time.Now().UnixNano()%int64(len(letters))]
-		time.Sleep(time.Nanosecond)
+		b[i] = letters[int(b[i])%len(letters)]
 	}
 	return string(b)
 }
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 会话令牌（访问令牌 + 轮换刷新令牌） ==========
+
+// 并发刷新的宽限期：多个标签页同时用同一个刷新令牌换新时，宽限期内的旧令牌只拒绝不视为重放
+const refreshTokenReuseGrace = 30 * time.Second
+
+// 会话撤销原因
+const (
+	SessionRevokeLogout         = "用户登出"
+	SessionRevokeTerminated     = "管理员终止"
+	SessionRevokeConcurrent     = "超过并发会话数"
+	SessionRevokeSingle         = "单会话模式下新登录"
+	SessionRevokeIdle           = "空闲超时"
+	SessionRevokeExpired        = "超过最长有效期"
+	SessionRevokeIPChanged      = "IP 变化"
+	SessionRevokeReuse          = "刷新令牌重放"
+	SessionRevokeCredential     = "密码或账号状态变更"
+	SessionRevokeUserDisabled   = "用户已禁用"
+	SessionRevokeRefreshExpired = "刷新令牌过期"
+)
+
+var (
+	ErrSessionInvalid      = errors.New("会话已失效，请重新登录")
+	ErrRefreshTokenRotated = errors.New("刷新令牌已被使用，请使用最新令牌")
+	ErrRefreshTokenReused  = errors.New("检测到刷新令牌被重复使用，会话已终止，请重新登录")
+)
+
+// SessionConfig 会话配置（session）
+type SessionConfig struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	MaxConcurrent   int
+	SingleSession   bool
+	IPBinding       bool
+	IdleTimeout     time.Duration // 0 表示不限制
+	AbsoluteTimeout time.Duration // 0 表示只受刷新令牌有效期限制
+}
+
+// GetSessionConfig 读取会话配置，未配置的项使用默认值
+func (s *SecurityService) GetSessionConfig() SessionConfig {
+	config, _ := s.GetConfig("session")
+	singleMode, _ := config["single_session_mode"].(bool)
+	ipBinding, _ := config["ip_binding"].(bool)
+
+	cfg := SessionConfig{
+		AccessTokenTTL:  time.Duration(getFloat64(config, "access_token_ttl_minutes", 15)) * time.Minute,
+		RefreshTokenTTL: time.Duration(getFloat64(config, "refresh_token_ttl_days", 7)) * 24 * time.Hour,
+		MaxConcurrent:   int(getFloat64(config, "max_concurrent_sessions", 5)),
+		SingleSession:   singleMode,
+		IPBinding:       ipBinding,
+		IdleTimeout:     time.Duration(getFloat64(config, "idle_timeout_minutes", 30)) * time.Minute,
+		AbsoluteTimeout: time.Duration(getFloat64(config, "absolute_timeout_hours", 24)) * time.Hour,
+	}
+	if cfg.AccessTokenTTL <= 0 {
+		cfg.AccessTokenTTL = 15 * time.Minute
+	}
+	if cfg.RefreshTokenTTL <= 0 {
+		cfg.RefreshTokenTTL = 7 * 24 * time.Hour
+	}
+	return cfg
+}
+
+// AccessTokenExpiry 访问令牌过期时间，不超过会话的绝对过期时间
+func (c SessionConfig) AccessTokenExpiry(session *models.Session) time.Time {
+	expiresAt := time.Now().Add(c.AccessTokenTTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+	return expiresAt
+}
+
+// hashSessionToken 刷新令牌只保存 SHA256 摘要
+func hashSessionToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// newRefreshToken 生成 256 位随机刷新令牌
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// revokeSession 停用会话并记录原因
+func revokeSession(db *gorm.DB, query interface{}, args []interface{}, reason string) int64 {
+	return db.Model(&models.Session{}).Where(query, args...).Where("is_active = ?", true).
+		Updates(map[string]interface{}{
+			"is_active":     false,
+			"revoked_at":    time.Now(),
+			"revoke_reason": reason,
+		}).RowsAffected
+}
+
+// RevokeSession 撤销单个会话
+func (s *SecurityService) RevokeSession(sessionID, reason string) bool {
+	return revokeSession(storage.DB, "id = ?", []interface{}{sessionID}, reason) > 0
+}
+
+// RevokeUserSessions 撤销用户的全部会话（修改密码、禁用用户等场景），撤销记录持久化在库中，重启后依然有效
+// exceptSessionID 非空时保留该会话（用户本人修改密码时保留当前会话）
+func (s *SecurityService) RevokeUserSessions(userID uint, exceptSessionID, reason string) int64 {
+	return revokeSession(storage.DB, "user_id = ? AND id <> ?", []interface{}{userID, exceptSessionID}, reason)
+}
+
+// checkSessionUsable 检查会话状态与超时，不可用时撤销会话并返回原因
+func (s *SecurityService) checkSessionUsable(session *models.Session, cfg SessionConfig, ip string) error {
+	if !session.IsActive {
+		if session.RevokeReason != "" {
+			return fmt.Errorf("会话已失效（%s），请重新登录", session.RevokeReason)
+		}
+		return errors.New("会话已被终止，请重新登录")
+	}
+	now := time.Now()
+	var reason string
+	switch {
+	case now.After(session.ExpiresAt):
+		reason = SessionRevokeExpired
+	case cfg.IdleTimeout > 0 && now.Sub(session.LastActivity) > cfg.IdleTimeout:
+		reason = SessionRevokeIdle
+	case cfg.IPBinding && session.IPAddress != "" && session.IPAddress != ip:
+		reason = SessionRevokeIPChanged
+	}
+	if reason == "" {
+		return nil
+	}
+	s.RevokeSession(session.ID, reason)
+	if reason == SessionRevokeIdle {
+		return errors.New("长时间未操作，请重新登录")
+	}
+	return fmt.Errorf("会话已失效（%s），请重新登录", reason)
+}
+
+// ValidateSession 校验访问令牌所属会话（Auth 中间件每次请求调用）
+// 会话被终止、超时或 IP 变化时返回错误；正常时按分钟粒度刷新最后活动时间
+func (s *SecurityService) ValidateSession(sessionID string, userID uint, ip string) error {
+	var session models.Session
+	if err := storage.DB.First(&session, "id = ?", sessionID).Error; err != nil || session.UserID != userID {
+		return ErrSessionInvalid
+	}
+	if err := s.checkSessionUsable(&session, s.GetSessionConfig(), ip); err != nil {
+		return err
+	}
+	if time.Since(session.LastActivity) > time.Minute {
+		storage.DB.Model(&session).Update("last_activity", time.Now())
+	}
+	return nil
+}
+
+// RefreshSession 用刷新令牌换取新的刷新令牌（一次性使用，每次刷新都轮换）
+// 已轮换的旧令牌再次出现说明令牌泄露，终止整个会话
+func (s *SecurityService) RefreshSession(refreshToken, ip, userAgent string) (*models.Session, string, error) {
+	if refreshToken == "" {
+		return nil, "", ErrSessionInvalid
+	}
+	tokenHash := hashSessionToken(refreshToken)
+	cfg := s.GetSessionConfig()
+
+	var session models.Session
+	if err := storage.DB.Where("refresh_token = ?", tokenHash).First(&session).Error; err != nil {
+		var rotated models.RotatedRefreshToken
+		if storage.DB.Where("token_hash = ?", tokenHash).First(&rotated).Error != nil {
+			return nil, "", ErrSessionInvalid
+		}
+		if time.Since(rotated.RotatedAt) < refreshTokenReuseGrace {
+			return nil, "", ErrRefreshTokenRotated
+		}
+
+		var stolen models.Session
+		storage.DB.First(&stolen, "id = ?", rotated.SessionID)
+		if s.RevokeSession(rotated.SessionID, SessionRevokeReuse) {
+			log.Printf("[会话] 检测到刷新令牌重放, 会话 %s 已终止, 来源IP %s", rotated.SessionID, ip)
+		}
+		var userID *uint
+		var username string
+		if stolen.UserID > 0 {
+			userID = &stolen.UserID
+			storage.DB.Model(&models.User{}).Where("id = ?", stolen.UserID).Pluck("username", &username)
+		}
+		s.RecordClientSecurityEvent(models.EventRefreshTokenReused, models.SeverityHigh, ip, userAgent, "",
+			userID, username, "session", rotated.SessionID, "已轮换的刷新令牌被再次使用，会话已终止", map[string]interface{}{
+				"session_ip":  stolen.IPAddress,
+				"rotated_at":  rotated.RotatedAt,
+				"session_ua":  stolen.UserAgent,
+				"request_ip":  ip,
+				"request_ua":  userAgent,
+				"session_age": time.Since(stolen.CreatedAt).Round(time.Second).String(),
+			})
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	if err := s.checkSessionUsable(&session, cfg, ip); err != nil {
+		return nil, "", err
+	}
+	if time.Now().After(session.RefreshExpiresAt) {
+		s.RevokeSession(session.ID, SessionRevokeRefreshExpired)
+		return nil, "", ErrSessionInvalid
+	}
+
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	refreshExpiresAt := now.Add(cfg.RefreshTokenTTL)
+	if session.ExpiresAt.Before(refreshExpiresAt) {
+		refreshExpiresAt = session.ExpiresAt
+	}
+
+	// 以旧令牌为条件更新，并发刷新时只有一个请求成功
+	res := storage.DB.Model(&models.Session{}).
+		Where("id = ? AND refresh_token = ? AND is_active = ?", session.ID, tokenHash, true).
+		Updates(map[string]interface{}{
+			"refresh_token":      hashSessionToken(newToken),
+			"refresh_expires_at": refreshExpiresAt,
+			"last_activity":      now,
+			"ip_address":         ip,
+		})
+	if res.Error != nil {
+		return nil, "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, "", ErrRefreshTokenRotated
+	}
+	storage.DB.Create(&models.RotatedRefreshToken{SessionID: session.ID, TokenHash: tokenHash, RotatedAt: now})
+
+	session.RefreshToken = hashSessionToken(newToken)
+	session.RefreshExpiresAt = refreshExpiresAt
+	session.LastActivity = now
+	session.IPAddress = ip
+	return &session, newToken, nil
+}
+
+// CleanupSessions 清理过期会话和已轮换的刷新令牌记录
+// 已过期会话保留 30 天用于审计，轮换记录在所属会话过期后即无用
+func (s *SecurityService) CleanupSessions() {
+	now := time.Now()
+	storage.DB.Model(&models.Session{}).Where("is_active = ? AND expires_at < ?", true, now).
+		Updates(map[string]interface{}{"is_active": false, "revoked_at": now, "revoke_reason": SessionRevokeExpired})
+	storage.DB.Where("session_id NOT IN (?)", storage.DB.Model(&models.Session{}).Select("id").Where("is_active = ?", true)).
+		Delete(&models.RotatedRefreshToken{})
+	res := storage.DB.Where("expires_at < ?", now.AddDate(0, 0, -30)).Delete(&models.Session{})
+	if res.RowsAffected > 0 {
+		log.Printf("[会话] 已清理 %d 条过期会话记录", res.RowsAffected)
+	}
+}
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"go-syncflow/internal/models"
+)
+
+// ========== WebAuthn 通行密钥 ==========
+//
+// 服务端实现注册（navigator.credentials.create）与认证（navigator.credentials.get）两个仪式的校验。
+// 注册时请求 attestation: "none"，不校验证明声明与认证器型号，只信任公钥本身。
+
+// WebAuthnConfig 通行密钥配置（security_configs.webauthn）
+type WebAuthnConfig struct {
+	Enabled          bool
+	RPID             string   // 依赖方 ID（域名），为空时取访问域名
+	RPName           string   // 依赖方显示名称
+	Origins          []string // 允许的来源，为空时取访问来源
+	UserVerification string   // required | preferred | discouraged
+	SecondFactor     bool     // 已注册通行密钥的用户密码登录后需再验证通行密钥
+	TimeoutSeconds   int
+}
+
+// WebAuthnCOSE 算法标识
+const (
+	WebAuthnAlgES256 = -7
+	WebAuthnAlgEdDSA = -8
+	WebAuthnAlgRS256 = -257
+)
+
+// 认证器数据标志位
+const (
+	webAuthnFlagUP = 0x01 // 用户在场
+	webAuthnFlagUV = 0x04 // 用户已验证（PIN / 生物识别）
+	webAuthnFlagBE = 0x08 // 可备份（同步通行密钥）
+	webAuthnFlagAT = 0x40 // 包含凭据数据
+)
+
+// WebAuthnNewCredential 注册仪式校验通过后得到的凭据
+type WebAuthnNewCredential struct {
+	CredentialID   string // base64url
+	PublicKey      string // COSE 公钥（base64）
+	Algorithm      int
+	SignCount      uint32
+	AAGUID         string
+	BackupEligible bool
+}
+
+// GetWebAuthnConfig 读取通行密钥配置
+func (s *SecurityService) GetWebAuthnConfig() WebAuthnConfig {
+	config, _ := s.GetConfig("webauthn")
+	cfg := WebAuthnConfig{
+		Enabled:          true,
+		RPName:           "统一身份认证平台",
+		UserVerification: "preferred",
+		TimeoutSeconds:   int(getFloat64(config, "timeout_seconds", 120)),
+	}
+	if v, ok := config["enabled"].(bool); ok {
+		cfg.Enabled = v
+	}
+	if v, _ := config["rp_id"].(string); v != "" {
+		cfg.RPID = strings.ToLower(strings.TrimSpace(v))
+	}
+	if v, _ := config["rp_name"].(string); v != "" {
+		cfg.RPName = v
+	}
+	if v, _ := config["user_verification"].(string); v == "required" || v == "discouraged" {
+		cfg.UserVerification = v
+	}
+	cfg.SecondFactor, _ = config["second_factor"].(bool)
+	if origins, ok := config["origins"].([]interface{}); ok {
+		for _, o := range origins {
+			if str, _ := o.(string); strings.TrimSpace(str) != "" {
+				cfg.Origins = append(cfg.Origins, strings.TrimRight(strings.TrimSpace(str), "/"))
+			}
+		}
+	}
+	return cfg
+}
+
+// DecodeWebAuthnBase64 解码浏览器传来的 base64url（兼容带填充和标准 base64）
+func DecodeWebAuthnBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// EncodeWebAuthnBase64 编码为无填充 base64url
+func EncodeWebAuthnBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyWebAuthnClientData 校验 clientDataJSON 的类型、挑战值与来源
+func verifyWebAuthnClientData(raw []byte, ceremonyType string, challenge []byte, origins []string) error {
+	var cd webAuthnClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("clientDataJSON 格式错误")
+	}
+	if cd.Type != ceremonyType {
+		return fmt.Errorf("仪式类型不匹配: %s", cd.Type)
+	}
+	got, err := DecodeWebAuthnBase64(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return fmt.Errorf("挑战值不匹配")
+	}
+	origin := strings.TrimRight(cd.Origin, "/")
+	for _, o := range origins {
+		if strings.EqualFold(o, origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("来源不被允许: %s", cd.Origin)
+}
+
+type webAuthnAuthData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte // COSE 编码
+}
+
+// parseWebAuthnAuthData 解析认证器数据
+func parseWebAuthnAuthData(b []byte) (*webAuthnAuthData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("认证器数据长度不足")
+	}
+	ad := &webAuthnAuthData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if ad.Flags&webAuthnFlagAT == 0 {
+		return ad, nil
+	}
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("凭据数据长度不足")
+	}
+	ad.AAGUID = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, fmt.Errorf("凭据 ID 长度不足")
+	}
+	ad.CredentialID = rest[:idLen]
+	rest = rest[idLen:]
+	_, after, err := cborDecode(rest, 0)
+	if err != nil {
+		return nil, fmt.Errorf("凭据公钥解析失败: %v", err)
+	}
+	ad.PublicKey = rest[:len(rest)-len(after)]
+	return ad, nil
+}
+
+// checkWebAuthnAuthData 校验 RP ID 摘要与用户在场 / 验证标志
+func checkWebAuthnAuthData(ad *webAuthnAuthData, rpID string, requireUV bool) error {
+	rpHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(ad.RPIDHash, rpHash[:]) {
+		return fmt.Errorf("RP ID 不匹配")
+	}
+	if ad.Flags&webAuthnFlagUP == 0 {
+		return fmt.Errorf("认证器未确认用户在场")
+	}
+	if requireUV && ad.Flags&webAuthnFlagUV == 0 {
+		return fmt.Errorf("认证器未完成用户验证（PIN 或生物识别）")
+	}
+	return nil
+}
+
+// VerifyWebAuthnRegistration 校验注册仪式，返回新凭据
+func VerifyWebAuthnRegistration(rpID string, origins []string, challenge, clientDataJSON, attestationObject []byte, requireUV bool) (*WebAuthnNewCredential, error) {
+	if err := verifyWebAuthnClientData(clientDataJSON, "webauthn.create", challenge, origins); err != nil {
+		return nil, err
+	}
+	obj, _, err := cborDecode(attestationObject, 0)
+	if err != nil {
+		return nil, fmt.Errorf("attestationObject 解析失败: %v", err)
+	}
+	m, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("attestationObject 格式错误")
+	}
+	raw, _ := m["authData"].([]byte)
+	ad, err := parseWebAuthnAuthData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWebAuthnAuthData(ad, rpID, requireUV); err != nil {
+		return nil, err
+	}
+	if ad.Flags&webAuthnFlagAT == 0 || len(ad.CredentialID) == 0 {
+		return nil, fmt.Errorf("认证器未返回凭据")
+	}
+	_, alg, err := parseCOSEKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aaguid := hex.EncodeToString(ad.AAGUID)
+	if len(aaguid) == 32 {
+		aaguid = aaguid[:8] + "-" + aaguid[8:12] + "-" + aaguid[12:16] + "-" + aaguid[16:20] + "-" + aaguid[20:]
+	}
+	return &WebAuthnNewCredential{
+		CredentialID:   EncodeWebAuthnBase64(ad.CredentialID),
+		PublicKey:      base64.StdEncoding.EncodeToString(ad.PublicKey),
+		Algorithm:      alg,
+		SignCount:      ad.SignCount,
+		AAGUID:         aaguid,
+		BackupEligible: ad.Flags&webAuthnFlagBE != 0,
+	}, nil
+}
+
+// VerifyWebAuthnAssertion 校验认证仪式，返回认证器新的签名计数
+func VerifyWebAuthnAssertion(rpID string, origins []string, challenge []byte, cred *models.WebAuthnCredential, clientDataJSON, authData, signature []byte, requireUV bool) (uint32, error) {
+	if err := verifyWebAuthnClientData(clientDataJSON, "webauthn.get", challenge, origins); err != nil {
+		return 0, err
+	}
+	ad, err := parseWebAuthnAuthData(authData)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkWebAuthnAuthData(ad, rpID, requireUV); err != nil {
+		return 0, err
+	}
+
+	coseKey, err := base64.StdEncoding.DecodeString(cred.PublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("凭据公钥损坏")
+	}
+	pub, alg, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return 0, err
+	}
+	clientHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientHash[:]...)
+	if err := verifyWebAuthnSignature(pub, alg, signed, signature); err != nil {
+		return 0, err
+	}
+
+	// 签名计数不增反降说明认证器可能被克隆（同步通行密钥始终为 0，不做比较）
+	if ad.SignCount != 0 || cred.SignCount != 0 {
+		if ad.SignCount <= cred.SignCount {
+			return 0, fmt.Errorf("签名计数异常，认证器可能被克隆")
+		}
+	}
+	return ad.SignCount, nil
+}
+
+// parseCOSEKey 将 COSE_Key 转换为公钥
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int, error) {
+	v, _, err := cborDecode(raw, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("公钥格式错误: %v", err)
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("公钥格式错误")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == WebAuthnAlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("仅支持 P-256 曲线")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, fmt.Errorf("公钥不在曲线上")
+		}
+		return pub, WebAuthnAlgES256, nil
+	case kty == 3 && alg == WebAuthnAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("RSA 公钥无效")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, WebAuthnAlgRS256, nil
+	case kty == 1 && alg == WebAuthnAlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("仅支持 Ed25519 曲线")
+		}
+		return ed25519.PublicKey(x), WebAuthnAlgEdDSA, nil
+	}
+	return nil, 0, fmt.Errorf("不支持的公钥算法: kty=%d alg=%d", kty, alg)
+}
+
+// verifyWebAuthnSignature 按算法校验签名
+func verifyWebAuthnSignature(pub crypto.PublicKey, alg int, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	ok := false
+	switch alg {
+	case WebAuthnAlgES256:
+		ok = ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig)
+	case WebAuthnAlgRS256:
+		ok = rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	case WebAuthnAlgEdDSA:
+		ok = ed25519.Verify(pub.(ed25519.PublicKey), data, sig)
+	}
+	if !ok {
+		return errors.New("签名校验失败")
+	}
+	return nil
+}
+
+// ========== CBOR 解码（仅支持 WebAuthn 使用的定长编码） ==========
+
+const cborMaxDepth = 16
+
+// cborDecode 解码一个 CBOR 数据项，返回值与剩余字节
+// 整数统一为 int64，字节串为 []byte，文本为 string，数组为 []interface{}，映射为 map[interface{}]interface{}
+func cborDecode(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("嵌套层级过深")
+	}
+	if len(b) == 0 {
+		return nil, nil, errors.New("数据不完整")
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(b) < n {
+			return nil, nil, errors.New("数据不完整")
+		}
+		for _, c := range b[:n] {
+			arg = arg<<8 | uint64(c)
+		}
+		b = b[n:]
+	default:
+		return nil, nil, errors.New("不支持不定长编码")
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("整数溢出")
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("整数溢出")
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, errors.New("数据不完整")
+		}
+		data := b[:arg]
+		if major == 3 {
+			return string(data), b[arg:], nil
+		}
+		return data, b[arg:], nil
+	case 4:
+		if arg > uint64(len(b)) {
+			return nil, nil, errors.New("数据不完整")
+		}
+		list := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			var err error
+			if item, b, err = cborDecode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, b, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, nil, errors.New("数据不完整")
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, val interface{}
+			var err error
+			if key, b, err = cborDecode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			if val, b, err = cborDecode(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+				m[key] = val
+			default:
+				return nil, nil, errors.New("不支持的映射键类型")
+			}
+		}
+		return m, b, nil
+	case 6:
+		return cborDecode(b, depth+1)
+	default:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22, 23:
+			return nil, b, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), b, nil
+		case 27:
+			return math.Float64frombits(arg), b, nil
+		}
+		// 半精度浮点等 WebAuthn 不会用到的简单值
+		return nil, b, nil
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"go-syncflow/internal/models"
 )
@@ -51,7 +52,17 @@ func InitDB(dbPath string) error {
 		&models.DingTalkDepartment{},
 		&models.DingTalkUser{},
 		&models.UserGroup{},
+		&models.UserGroupMember{},
+		&models.UserIdentity{},
+		&models.UserFieldSource{},
+		&models.LifecycleTask{},
+		&models.AccessRequest{},
+		&models.AccessRequestApprover{},
+		&models.AccessReview{},
+		&models.AccessReviewItem{},
 		&models.RoleAutoAssignRule{},
+		&models.UserAttributeDef{},
+		&models.UserAttributeValue{},
 		// 安全相关表
 		&models.LoginAttempt{},
 		&models.Lockout{},
@@ -59,10 +70,15 @@ func InitDB(dbPath string) error {
 		&models.IPWhitelist{},
 		&models.SecurityEvent{},
 		&models.Session{},
+		&models.RotatedRefreshToken{},
+		&models.JWTSigningKey{},
+		&models.WebAuthnCredential{},
 		&models.SecurityConfig{},
 		&models.NotifyChannel{},
 		&models.AlertRule{},
 		&models.AlertLog{},
+		&models.AlertIncident{},
+		&models.AlertActionLog{},
 		&models.MessageTemplate{},
 		&models.MessagePolicy{},
 		// API Key
@@ -72,6 +88,9 @@ func InitDB(dbPath string) error {
 		&models.Synchronizer{},
 		&models.SyncAttributeMapping{},
 		&models.SyncLog{},
+		&models.SyncJob{},
+		&models.SyncFingerprint{},
+		&models.SyncReconcileReport{},
 		// === 新增表 ===
 		&models.SyncRule{},
 		&models.IMDepartment{},
@@ -136,6 +155,46 @@ func migrateOldData(db *gorm.DB) {
 
 	// 3. 迁移旧 DingTalk 缓存表到 IM 通用表
 	migrateDingTalkCacheToIM(db)
+
+	// 4. 单部门 → 多部门：为已有主部门补齐成员关系
+	migrateUserGroupMembers(db)
+
+	// 5. IM 缓存表中的关联 → 关联身份表
+	migrateUserIdentities(db)
+}
+
+// migrateUserIdentities 将 im_users 中已关联本地用户的记录写入 user_identities（可重复执行）
+func migrateUserIdentities(db *gorm.DB) {
+	var rows []models.IMUser
+	db.Joins("JOIN users u ON u.id = im_users.local_user_id AND u.is_deleted = 0").
+		Where("im_users.local_user_id > 0").Find(&rows)
+
+	migrated := 0
+	for _, m := range rows {
+		identity := models.UserIdentity{
+			UserID: m.LocalUserID, ConnectorID: m.ConnectorID, ExternalID: m.RemoteUserID,
+			Source: m.PlatformType, DisplayName: m.Name, LinkedBy: "legacy",
+		}
+		// 已存在（同一用户同一连接器，或外部 ID 已被关联）时跳过
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&identity)
+		if res.Error == nil && res.RowsAffected > 0 {
+			migrated++
+		}
+	}
+	if migrated > 0 {
+		log.Printf("[DB迁移] 已迁移 %d 条关联身份", migrated)
+	}
+}
+
+// migrateUserGroupMembers 为 group_id 已设置但缺少成员关系的用户补充记录（可重复执行）
+func migrateUserGroupMembers(db *gorm.DB) {
+	res := db.Exec(`INSERT INTO user_group_members (user_id, group_id, created_at)
+		SELECT u.id, u.group_id, CURRENT_TIMESTAMP FROM users u
+		WHERE u.group_id > 0 AND NOT EXISTS (
+			SELECT 1 FROM user_group_members m WHERE m.user_id = u.id AND m.group_id = u.group_id)`)
+	if res.Error == nil && res.RowsAffected > 0 {
+		log.Printf("[DB迁移] 已为 %d 个用户补充分组成员关系", res.RowsAffected)
+	}
 }
 
 // migrateSynchronizersToSyncRules 迁移同步器到同步规则
@@ -376,6 +435,9 @@ func initPermissions() error {
 		ensurePermissionExists(71, 11, "新增群组", "user:create_group", "button", 7)
 		ensurePermissionExists(72, 11, "分配角色", "user:assign_role", "button", 8)
 
+		// 角色权限复核
+		ensurePermissionExists(26, 20, "权限复核", "role:review", "button", 6)
+
 		// 同步管理权限
 		ensurePermissionExists(80, 0, "同步管理", "sync", "menu", 3)
 		ensurePermissionExists(81, 80, "上游同步", "sync:upstream", "menu", 1)
@@ -418,6 +480,7 @@ func initPermissions() error {
 		{ID: 23, ParentID: 20, Name: "编辑角色", Code: "role:update", Type: "button", Sort: 3},
 		{ID: 24, ParentID: 20, Name: "删除角色", Code: "role:delete", Type: "button", Sort: 4},
 		{ID: 25, ParentID: 20, Name: "分配权限", Code: "role:permission", Type: "button", Sort: 5},
+		{ID: 26, ParentID: 20, Name: "权限复核", Code: "role:review", Type: "button", Sort: 6},
 
 		// 日志管理
 		{ID: 30, ParentID: 0, Name: "日志管理", Code: "log", Type: "menu", Path: "/admin/logs", Icon: "Document", Sort: 5},
@@ -544,6 +607,34 @@ func initConfigs() error {
 			}),
 			Description: "LDAP服务配置（默认启用Samba）",
 		},
+		{
+			Key: "sync_queue",
+			Value: mustJSON(models.SyncQueueConfig{
+				MaxAttempts:        8,
+				BackoffBaseSeconds: 10,
+				BackoffMaxSeconds:  3600,
+				DefaultConcurrency: 2,
+				RetentionDays:      7,
+				CoalesceSeconds:    3,
+			}),
+			Description: "下游同步队列配置",
+		},
+		{
+			Key:         "identity",
+			Value:       mustJSON(models.IdentityConfig{MatchRules: []models.IdentityMatchRule{}, AttributePrecedence: map[string][]uint{}}),
+			Description: "多来源身份关联配置",
+		},
+		{
+			Key: "lifecycle",
+			Value: mustJSON(models.LifecycleConfig{
+				DisabledOU:           "Disabled Users",
+				StripGroupsAfterDays: 30,
+				DeleteAfterDays:      90,
+				PreCreateDays:        3,
+				ExpiryNotifyDays:     7,
+			}),
+			Description: "用户生命周期（入职/调岗/离职）配置",
+		},
 	}
 
 	for _, cfg := range configs {
@@ -609,17 +700,24 @@ func initSecurityConfigs() error {
 		{
 			ConfigKey: "password_policy",
 			ConfigValue: mustJSON(map[string]interface{}{
-				"min_length":          8,
-				"max_length":          128,
-				"require_uppercase":   true,
-				"require_lowercase":   true,
-				"require_number":      true,
-				"require_special":     false,
-				"special_chars":       "!@#$%^&*()_+-=[]{}|;:,.<>?",
-				"history_count":       5,
-				"max_age_days":        0,
-				"min_age_days":        0,
-				"weak_password_check": true,
+				"min_length":             8,
+				"max_length":             128,
+				"require_uppercase":      true,
+				"require_lowercase":      true,
+				"require_number":         true,
+				"require_special":        false,
+				"special_chars":          "!@#$%^&*()_+-=[]{}|;:,.<>?",
+				"history_count":          5,
+				"max_age_days":           0,
+				"min_age_days":           0,
+				"weak_password_check":    true,
+				"user_info_check":        true,
+				"keyboard_pattern_check": true,
+				"keyboard_min_run":       4,
+				"similarity_check":       true,
+				"breach_check":           false,
+				"breach_data_path":       "./data/hibp",
+				"breach_min_count":       1,
 			}),
 			Description: "密码策略配置",
 		},
@@ -644,13 +742,33 @@ func initSecurityConfigs() error {
 					"enabled":                false,
 					"trigger_after_failures": 3,
 				},
+				"risk": map[string]interface{}{
+					"enabled":                 true,
+					"geoip_path":              "./data/geoip.csv",
+					"challenge_score":         40,
+					"block_score":             80,
+					"challenge_fallback":      "allow",
+					"history_days":            90,
+					"max_travel_speed_kmh":    900,
+					"failure_window_minutes":  30,
+					"failure_threshold":       3,
+					"unusual_hour_min_logins": 10,
+					"weights": map[string]interface{}{
+						"new_device":        25,
+						"new_ip_range":      15,
+						"new_location":      30,
+						"impossible_travel": 60,
+						"unusual_hour":      10,
+						"recent_failures":   20,
+					},
+				},
 			}),
 			Description: "登录安全配置",
 		},
 		{
 			ConfigKey: "session",
 			ConfigValue: mustJSON(map[string]interface{}{
-				"access_token_ttl_minutes": 60,
+				"access_token_ttl_minutes": 15,
 				"refresh_token_ttl_days":   7,
 				"max_concurrent_sessions":  5,
 				"single_session_mode":      false,
@@ -660,6 +778,29 @@ func initSecurityConfigs() error {
 			}),
 			Description: "会话管理配置",
 		},
+		{
+			ConfigKey: "jwt_signing",
+			ConfigValue: mustJSON(map[string]interface{}{
+				"algorithm":     "ES256",
+				"rotation_days": 90,
+				"overlap_hours": 24,
+				"issuer":        "go-syncflow",
+			}),
+			Description: "访问令牌签名配置",
+		},
+		{
+			ConfigKey: "webauthn",
+			ConfigValue: mustJSON(map[string]interface{}{
+				"enabled":           true,
+				"rp_id":             "",
+				"rp_name":           "统一身份认证平台",
+				"origins":           []string{},
+				"user_verification": "preferred",
+				"second_factor":     false,
+				"timeout_seconds":   120,
+			}),
+			Description: "通行密钥（WebAuthn）配置",
+		},
 		{
 			ConfigKey: "ip_security",
 			ConfigValue: mustJSON(map[string]interface{}{
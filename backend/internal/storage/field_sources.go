@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"go-syncflow/internal/models"
+)
+
+// ========== 用户字段来源 ==========
+
+// GetUserFieldSources 读取用户的字段来源记录（键为字段名）
+func GetUserFieldSources(userID uint) map[string]models.UserFieldSource {
+	var rows []models.UserFieldSource
+	DB.Where("user_id = ?", userID).Find(&rows)
+	result := make(map[string]models.UserFieldSource, len(rows))
+	for _, r := range rows {
+		result[r.Field] = r
+	}
+	return result
+}
+
+// RecordLocalEdits 记录本地手动编辑的字段；lock 为 true 时同时锁定，否则保留原锁定状态
+func RecordLocalEdits(tx *gorm.DB, userID uint, fields []string, operator string, lock bool) error {
+	cols := []string{"connector_id", "updated_by", "updated_at"}
+	if lock {
+		cols = append(cols, "locked")
+	}
+	for _, field := range fields {
+		row := models.UserFieldSource{UserID: userID, Field: field, Locked: lock, UpdatedBy: operator}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "field"}},
+			DoUpdates: clause.AssignmentColumns(cols),
+		}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnlockUserField 解除字段锁定
+func UnlockUserField(userID uint, field string) error {
+	return DB.Model(&models.UserFieldSource{}).Where("user_id = ? AND field = ?", userID, field).
+		Update("locked", false).Error
+}
+
+// ClaimFieldSources 上游覆盖字段后将来源记录改为该连接器（已锁定的记录不变）
+func ClaimFieldSources(tx *gorm.DB, userID, connectorID uint, fields []string, operator string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return tx.Model(&models.UserFieldSource{}).
+		Where("user_id = ? AND field IN ? AND locked = ?", userID, fields, false).
+		Updates(map[string]interface{}{"connector_id": connectorID, "updated_by": operator}).Error
+}
+
+// DeleteUserFieldSources 删除用户的全部字段来源记录（用户删除时调用）
+func DeleteUserFieldSources(tx *gorm.DB, userID uint) error {
+	return tx.Where("user_id = ?", userID).Delete(&models.UserFieldSource{}).Error
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"go-syncflow/internal/models"
+)
+
+// ========== 关联身份 ==========
+
+// ListUserIdentities 用户的全部关联身份
+func ListUserIdentities(userID uint) []models.UserIdentity {
+	var list []models.UserIdentity
+	DB.Where("user_id = ?", userID).Order("id").Find(&list)
+	return list
+}
+
+// FindIdentityUser 按连接器与外部ID查找已关联的本地用户ID
+func FindIdentityUser(connectorID uint, externalID string) (uint, bool) {
+	if externalID == "" {
+		return 0, false
+	}
+	var identity models.UserIdentity
+	if DB.Where("connector_id = ? AND external_id = ?", connectorID, externalID).First(&identity).Error != nil {
+		return 0, false
+	}
+	return identity.UserID, true
+}
+
+// UserLinkedConnectors 用户已关联的连接器集合
+func UserLinkedConnectors(userID uint) map[uint]bool {
+	var ids []uint
+	DB.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Pluck("connector_id", &ids)
+	linked := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		linked[id] = true
+	}
+	return linked
+}
+
+// LinkIdentity 建立或更新关联身份
+// 同一外部ID已关联其他用户时改为关联到 identity.UserID；该用户在此连接器下已有其他外部ID时报错
+func LinkIdentity(tx *gorm.DB, identity models.UserIdentity) error {
+	if identity.UserID == 0 || identity.ExternalID == "" {
+		return fmt.Errorf("关联身份缺少用户或外部ID")
+	}
+	var other models.UserIdentity
+	if tx.Where("user_id = ? AND connector_id = ? AND external_id <> ?", identity.UserID, identity.ConnectorID, identity.ExternalID).
+		First(&other).Error == nil {
+		return fmt.Errorf("用户在该连接器下已关联身份 %s", other.ExternalID)
+	}
+
+	var existing models.UserIdentity
+	if tx.Where("connector_id = ? AND external_id = ?", identity.ConnectorID, identity.ExternalID).First(&existing).Error == nil {
+		updates := map[string]interface{}{"display_name": identity.DisplayName}
+		if existing.UserID != identity.UserID {
+			updates["user_id"] = identity.UserID
+			updates["linked_by"] = identity.LinkedBy
+		}
+		return tx.Model(&existing).Updates(updates).Error
+	}
+	return tx.Create(&identity).Error
+}
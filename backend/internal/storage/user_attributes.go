@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"go-syncflow/internal/models"
+)
+
+// ========== 用户扩展属性 ==========
+
+// ListUserAttributeDefs 扩展属性定义（按排序）
+func ListUserAttributeDefs() []models.UserAttributeDef {
+	var defs []models.UserAttributeDef
+	DB.Order("sort_order, id").Find(&defs)
+	return defs
+}
+
+// GetUserAttributes 读取单个用户的扩展属性
+func GetUserAttributes(userID uint) map[string]string {
+	var rows []models.UserAttributeValue
+	DB.Where("user_id = ?", userID).Find(&rows)
+	attrs := make(map[string]string, len(rows))
+	for _, r := range rows {
+		attrs[r.AttrKey] = r.Value
+	}
+	return attrs
+}
+
+// LoadUserAttributes 批量填充用户的 Attributes 字段
+func LoadUserAttributes(users []models.User) {
+	if len(users) == 0 {
+		return
+	}
+	index := make(map[uint]int, len(users))
+	ids := make([]uint, 0, len(users))
+	for i := range users {
+		users[i].Attributes = make(map[string]string)
+		index[users[i].ID] = i
+		ids = append(ids, users[i].ID)
+	}
+
+	// 分批查询，避免 IN 参数过多
+	for start := 0; start < len(ids); start += 500 {
+		end := start + 500
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var rows []models.UserAttributeValue
+		DB.Where("user_id IN ?", ids[start:end]).Find(&rows)
+		for _, r := range rows {
+			if i, ok := index[r.UserID]; ok {
+				users[i].Attributes[r.AttrKey] = r.Value
+			}
+		}
+	}
+}
+
+// SaveUserAttributes 写入用户扩展属性；值为空时删除该属性
+func SaveUserAttributes(tx *gorm.DB, userID uint, values map[string]string) error {
+	for key, value := range values {
+		if value == "" {
+			if err := tx.Where("user_id = ? AND attr_key = ?", userID, key).Delete(&models.UserAttributeValue{}).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "attr_key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+		}).Create(&models.UserAttributeValue{UserID: userID, AttrKey: key, Value: value}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUserAttributes 删除用户的全部扩展属性（用户删除时调用）
+func DeleteUserAttributes(tx *gorm.DB, userID uint) error {
+	return tx.Where("user_id = ?", userID).Delete(&models.UserAttributeValue{}).Error
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"go-syncflow/internal/models"
+)
+
+// ========== 用户多部门 ==========
+
+// GetUserGroupIDs 读取用户所属的全部分组（主部门在前）
+func GetUserGroupIDs(userID uint) []uint {
+	var user models.User
+	if DB.Select("id, group_id").First(&user, userID).Error != nil {
+		return nil
+	}
+	users := []models.User{user}
+	LoadUserGroupIDs(users)
+	return users[0].GroupIDs
+}
+
+// LoadUserGroupIDs 批量填充用户的 GroupIDs 字段（主部门在前）
+func LoadUserGroupIDs(users []models.User) {
+	if len(users) == 0 {
+		return
+	}
+	index := make(map[uint]int, len(users))
+	ids := make([]uint, 0, len(users))
+	for i := range users {
+		users[i].GroupIDs = []uint{}
+		if users[i].GroupID > 0 {
+			users[i].GroupIDs = append(users[i].GroupIDs, users[i].GroupID)
+		}
+		index[users[i].ID] = i
+		ids = append(ids, users[i].ID)
+	}
+
+	for start := 0; start < len(ids); start += 500 {
+		end := start + 500
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var rows []models.UserGroupMember
+		DB.Where("user_id IN ?", ids[start:end]).Order("id").Find(&rows)
+		for _, r := range rows {
+			if i, ok := index[r.UserID]; ok && r.GroupID != users[i].GroupID {
+				users[i].GroupIDs = append(users[i].GroupIDs, r.GroupID)
+			}
+		}
+	}
+}
+
+// SetUserGroups 设置用户的全部分组与主部门
+// primaryID 为 0 时取 groupIDs 第一个；主部门总会包含在成员关系中
+func SetUserGroups(tx *gorm.DB, userID uint, primaryID uint, groupIDs []uint) error {
+	if primaryID == 0 && len(groupIDs) > 0 {
+		primaryID = groupIDs[0]
+	}
+	desired := make(map[uint]bool)
+	ordered := make([]uint, 0, len(groupIDs)+1)
+	for _, id := range append([]uint{primaryID}, groupIDs...) {
+		if id > 0 && !desired[id] {
+			desired[id] = true
+			ordered = append(ordered, id)
+		}
+	}
+
+	q := tx.Where("user_id = ?", userID)
+	if len(ordered) > 0 {
+		q = q.Where("group_id NOT IN ?", ordered)
+	}
+	if err := q.Delete(&models.UserGroupMember{}).Error; err != nil {
+		return err
+	}
+	for _, id := range ordered {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.UserGroupMember{UserID: userID, GroupID: id}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.User{}).Where("id = ?", userID).Update("group_id", primaryID).Error
+}
+
+// DeleteUserGroups 删除用户的全部分组关系（用户删除时调用）
+func DeleteUserGroups(tx *gorm.DB, userID uint) error {
+	return tx.Where("user_id = ?", userID).Delete(&models.UserGroupMember{}).Error
+}
+
+// RemoveGroupMembers 分组删除时移除成员关系；以该分组为主部门的用户改用剩余的第一个分组
+func RemoveGroupMembers(tx *gorm.DB, groupID uint) error {
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.UserGroupMember{}).Error; err != nil {
+		return err
+	}
+	var users []models.User
+	tx.Select("id").Where("group_id = ?", groupID).Find(&users)
+	for _, u := range users {
+		var next models.UserGroupMember
+		primary := uint(0)
+		if tx.Where("user_id = ?", u.ID).Order("id").First(&next).Error == nil {
+			primary = next.GroupID
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", u.ID).Update("group_id", primary).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GroupMemberUserIDs 属于任一指定分组的用户ID子查询
+func GroupMemberUserIDs(groupIDs []uint) *gorm.DB {
+	return DB.Model(&models.UserGroupMember{}).Select("user_id").Where("group_id IN ?", groupIDs)
+}
+
+// ExpandGroupIDs 展开分组及其全部子分组
+func ExpandGroupIDs(rootIDs []uint) []uint {
+	if len(rootIDs) == 0 {
+		return nil
+	}
+	var groups []models.UserGroup
+	DB.Select("id, parent_id").Find(&groups)
+	children := make(map[uint][]uint, len(groups))
+	exists := make(map[uint]bool, len(groups))
+	for _, g := range groups {
+		exists[g.ID] = true
+		if g.ParentID != g.ID {
+			children[g.ParentID] = append(children[g.ParentID], g.ID)
+		}
+	}
+
+	seen := make(map[uint]bool)
+	var result []uint
+	queue := append([]uint{}, rootIDs...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] || !exists[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+		queue = append(queue, children[id]...)
+	}
+	return result
+}
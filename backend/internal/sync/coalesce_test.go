@@ -0,0 +1,194 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"go-syncflow/internal/models"
+)
+
+func TestCoalesceSyncJob(t *testing.T) {
+	cfg := models.SyncQueueConfig{CoalesceSeconds: 5}
+	recent := time.Now()
+	stale := time.Now().Add(-time.Minute)
+
+	type pendingJob struct {
+		event    string
+		payload  string
+		attempts int
+		created  time.Time
+		ruleID   uint
+	}
+	tests := []struct {
+		name        string
+		cfg         models.SyncQueueConfig
+		pending     []pendingJob
+		event       string
+		payload     string
+		wantMerged  bool
+		wantEvents  []string // 合并后各待执行任务的事件（按 id）
+		wantPayload string   // 被合并任务的 payload
+		wantStatus  []string
+	}{
+		{
+			name: "无待执行任务", cfg: cfg,
+			event: models.SyncEventUserUpdate, wantMerged: false,
+		},
+		{
+			name: "窗口内更新合并", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserUpdate, created: recent, ruleID: 1}},
+			event:      models.SyncEventUserUpdate,
+			wantMerged: true, wantEvents: []string{models.SyncEventUserUpdate},
+		},
+		{
+			name: "禁用覆盖更新", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserUpdate, created: recent, ruleID: 1}},
+			event:      models.SyncEventUserDisable,
+			wantMerged: true, wantEvents: []string{models.SyncEventUserDisable},
+		},
+		{
+			name: "更新不降级禁用", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserDisable, created: recent, ruleID: 1}},
+			event:      models.SyncEventUserUpdate,
+			wantMerged: true, wantEvents: []string{models.SyncEventUserDisable},
+		},
+		{
+			name: "创建后的更新保留创建", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserCreate, created: recent, ruleID: 1}},
+			event:      models.SyncEventUserEnable,
+			wantMerged: true, wantEvents: []string{models.SyncEventUserCreate},
+		},
+		{
+			name: "密码修改合并为最新密码", cfg: cfg,
+			pending: []pendingJob{{event: models.SyncEventPasswordChange, payload: "old", created: recent, ruleID: 1}},
+			event:   models.SyncEventPasswordChange, payload: "new",
+			wantMerged: true, wantEvents: []string{models.SyncEventPasswordChange}, wantPayload: "new",
+		},
+		{
+			name: "创建待执行时用最新密码创建", cfg: cfg,
+			pending: []pendingJob{{event: models.SyncEventUserCreate, payload: "old", created: recent, ruleID: 1}},
+			event:   models.SyncEventPasswordChange, payload: "new",
+			wantMerged: true, wantEvents: []string{models.SyncEventUserCreate}, wantPayload: "new",
+		},
+		{
+			name: "密码修改不与普通更新合并", cfg: cfg,
+			pending: []pendingJob{{event: models.SyncEventUserUpdate, created: recent, ruleID: 1}},
+			event:   models.SyncEventPasswordChange, payload: "new",
+			wantMerged: false, wantEvents: []string{models.SyncEventUserUpdate},
+		},
+		{
+			name: "普通更新不并入密码修改", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventPasswordChange, payload: "old", created: recent, ruleID: 1}},
+			event:      models.SyncEventUserUpdate,
+			wantMerged: false, wantEvents: []string{models.SyncEventPasswordChange}, wantPayload: "old",
+		},
+		{
+			name: "退避中的任务不参与合并", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserUpdate, attempts: 1, created: recent, ruleID: 1}},
+			event:      models.SyncEventUserUpdate,
+			wantMerged: false,
+		},
+		{
+			name: "超出合并窗口不合并", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserUpdate, created: stale, ruleID: 1}},
+			event:      models.SyncEventUserUpdate,
+			wantMerged: false,
+		},
+		{
+			name: "合并窗口为 0 时不合并", cfg: models.SyncQueueConfig{},
+			pending:    []pendingJob{{event: models.SyncEventUserUpdate, created: recent, ruleID: 1}},
+			event:      models.SyncEventUserUpdate,
+			wantMerged: false,
+		},
+		{
+			name: "不同规则的任务不合并", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserUpdate, created: recent, ruleID: 2}},
+			event:      models.SyncEventUserUpdate,
+			wantMerged: false,
+		},
+		{
+			name: "已有删除待执行时丢弃后续事件", cfg: cfg,
+			pending:    []pendingJob{{event: models.SyncEventUserDelete, created: stale, ruleID: 1}},
+			event:      models.SyncEventUserCreate,
+			wantMerged: true, wantEvents: []string{models.SyncEventUserDelete},
+		},
+		{
+			name: "删除丢弃全部待执行写入（含退避中）", cfg: models.SyncQueueConfig{},
+			pending: []pendingJob{
+				{event: models.SyncEventUserUpdate, attempts: 2, created: stale, ruleID: 1},
+				{event: models.SyncEventPasswordChange, payload: "old", created: recent, ruleID: 1},
+			},
+			event:      models.SyncEventUserDelete,
+			wantMerged: false,
+			wantStatus: []string{models.SyncJobDiscarded, models.SyncJobDiscarded},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &models.SyncJob{})
+			var ids []uint
+			for _, p := range tt.pending {
+				job := models.SyncJob{
+					SyncRuleID: p.ruleID, ConnectorID: 1, UserID: 7, Username: "alice",
+					Event: p.event, Payload: p.payload, Status: models.SyncJobPending,
+				}
+				db.Create(&job)
+				db.Model(&job).Updates(map[string]interface{}{"attempts": p.attempts, "created_at": p.created})
+				ids = append(ids, job.ID)
+			}
+
+			incoming := models.SyncJob{
+				SyncRuleID: 1, ConnectorID: 1, UserID: 7, Username: "alice",
+				Event: tt.event, Payload: tt.payload, Snapshot: `{"username":"alice"}`,
+			}
+			merged, err := coalesceSyncJob(db, incoming, tt.cfg)
+			if err != nil {
+				t.Fatalf("coalesceSyncJob: %v", err)
+			}
+			if merged != tt.wantMerged {
+				t.Fatalf("merged = %v, want %v", merged, tt.wantMerged)
+			}
+
+			for i, id := range ids {
+				var got models.SyncJob
+				db.First(&got, id)
+				if tt.wantStatus != nil {
+					if got.Status != tt.wantStatus[i] {
+						t.Errorf("job %d status = %s, want %s", i, got.Status, tt.wantStatus[i])
+					}
+					if got.Payload != "" {
+						t.Errorf("job %d 丢弃后 payload 未清除", i)
+					}
+					continue
+				}
+				if tt.wantEvents != nil && got.Event != tt.wantEvents[i] {
+					t.Errorf("job %d event = %s, want %s", i, got.Event, tt.wantEvents[i])
+				}
+				if tt.wantPayload != "" && got.Payload != tt.wantPayload {
+					t.Errorf("job %d payload = %q, want %q", i, got.Payload, tt.wantPayload)
+				}
+				if merged && got.Event != models.SyncEventUserDelete && got.Snapshot != incoming.Snapshot {
+					t.Errorf("job %d snapshot 未更新为最新", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncEventRank(t *testing.T) {
+	order := []string{
+		models.SyncEventUserUpdate,
+		models.SyncEventUserEnable,
+		models.SyncEventUserCreate,
+		models.SyncEventUserDelete,
+	}
+	for i := 1; i < len(order); i++ {
+		if syncEventRank(order[i]) <= syncEventRank(order[i-1]) {
+			t.Errorf("rank(%s) 应高于 rank(%s)", order[i], order[i-1])
+		}
+	}
+	if syncEventRank(models.SyncEventUserEnable) != syncEventRank(models.SyncEventUserDisable) {
+		t.Error("启用与禁用应同级")
+	}
+}
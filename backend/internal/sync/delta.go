@@ -0,0 +1,220 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 增量全量同步（属性指纹）==========
+// 每个 (规则, 用户) 保存上次成功推送的属性指纹；全量同步时只推送指纹有变化的用户，
+// 且只推送变化的属性。首次同步或强制全量时推送全部属性并刷新指纹。
+// 指纹以下游规则 ID 为键，旧同步器（Synchronizer）与规则 ID 可能重复，不记录指纹（也不支持增量）。
+// 状态、角色、分组与上级不属于映射属性，但决定目标端的账号状态和组成员关系，同样计入指纹。
+
+// loadUserMappings 加载同步器/规则的用户属性映射，增量模式下按 DeltaAttributes 过滤
+func loadUserMappings(syncr models.Synchronizer) []models.SyncAttributeMapping {
+	var mappings []models.SyncAttributeMapping
+	storage.DB.Where("(synchronizer_id = ? OR sync_rule_id = ?) AND object_type = ? AND is_enabled = ?", syncr.ID, syncr.ID, "user", true).Order("priority").Find(&mappings)
+	if syncr.DeltaAttributes == nil {
+		return mappings
+	}
+	return filterDeltaMappings(mappings, syncr.DeltaAttributes)
+}
+
+// filterDeltaMappings 保留变化的属性；用户名等标识字段始终保留（用于定位目标对象）
+func filterDeltaMappings(mappings []models.SyncAttributeMapping, changed map[string]bool) []models.SyncAttributeMapping {
+	filtered := make([]models.SyncAttributeMapping, 0, len(mappings))
+	for _, m := range mappings {
+		if changed[m.TargetAttribute] || m.SourceAttribute == "username" || m.SourceAttribute == "id" {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// fingerprintAttrs 计算用户各目标属性的指纹（原文密码不参与，全量同步时拿不到）
+func fingerprintAttrs(mappings []models.SyncAttributeMapping, user models.User) map[string]string {
+	fp := make(map[string]string, len(mappings))
+	for _, m := range mappings {
+		if m.SourceAttribute == "password" || m.SourceAttribute == "password_raw" {
+			continue
+		}
+		fp[m.TargetAttribute] = fingerprintValue(resolveSourceValue(m, user, ""))
+	}
+	for key, v := range membershipFingerprint(user) {
+		fp[key] = fingerprintValue(v)
+	}
+	return fp
+}
+
+func fingerprintValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:8])
+}
+
+// membershipFingerprint 映射之外的同步内容：账号状态、角色、分组（主部门在前）、上级与失效时间
+// 以 "#" 开头，不会与目标属性名冲突；变化时用户按增量推送，目标端组成员关系随之对齐
+func membershipFingerprint(user models.User) map[string]string {
+	roleIDs := make([]int, 0, len(user.Roles))
+	for _, r := range user.Roles {
+		roleIDs = append(roleIDs, int(r.ID))
+	}
+	sort.Ints(roleIDs)
+	groupIDs := user.GroupIDs
+	if groupIDs == nil {
+		groupIDs = storage.GetUserGroupIDs(user.ID)
+	}
+	expiry := ""
+	if user.ValidUntil != nil {
+		expiry = user.ValidUntil.UTC().Format(time.RFC3339)
+	}
+	return map[string]string{
+		"#status":  fmt.Sprint(user.Status),
+		"#roles":   fmt.Sprint(roleIDs),
+		"#groups":  fmt.Sprint(groupIDs),
+		"#manager": fmt.Sprint(user.ManagerID),
+		"#expiry":  expiry,
+	}
+}
+
+// loadSyncFingerprints 读取规则下所有用户的指纹
+func loadSyncFingerprints(ruleID uint) map[uint]map[string]string {
+	var rows []models.SyncFingerprint
+	storage.DB.Where("sync_rule_id = ?", ruleID).Find(&rows)
+	result := make(map[uint]map[string]string, len(rows))
+	for _, r := range rows {
+		var attrs map[string]string
+		if json.Unmarshal([]byte(r.Attributes), &attrs) == nil {
+			result[r.UserID] = attrs
+		}
+	}
+	return result
+}
+
+// deltaPushUsers 按指纹差异分组推送：新用户推送全部属性，变化用户只推送变化的属性
+func deltaPushUsers(conn models.Connector, syncr models.Synchronizer, users []models.User, mappings []models.SyncAttributeMapping) SyncResult {
+	if !syncr.FromRule {
+		return pushUsers(conn, syncr, users, mappings)
+	}
+	var result SyncResult
+	stored := loadSyncFingerprints(syncr.ID)
+
+	type deltaGroup struct {
+		changed map[string]bool // nil 表示全部属性
+		users   []models.User
+	}
+	groups := make(map[string]*deltaGroup)
+	var order []string
+
+	for _, user := range users {
+		current := fingerprintAttrs(mappings, user)
+		key := "*"
+		var changed map[string]bool
+		if old, ok := stored[user.ID]; ok {
+			changed = make(map[string]bool)
+			for attr, v := range current {
+				if old[attr] != v {
+					changed[attr] = true
+				}
+			}
+			if len(changed) == 0 {
+				result.Skipped++
+				continue
+			}
+			attrs := make([]string, 0, len(changed))
+			for attr := range changed {
+				attrs = append(attrs, attr)
+			}
+			sort.Strings(attrs)
+			key = strings.Join(attrs, ",")
+		}
+
+		g, ok := groups[key]
+		if !ok {
+			g = &deltaGroup{changed: changed}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.users = append(g.users, user)
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		s := syncr
+		s.DeltaAttributes = g.changed
+		ms := mappings
+		if g.changed != nil {
+			ms = filterDeltaMappings(mappings, g.changed)
+		}
+
+		r := pushUsers(conn, s, g.users, ms)
+		result.Success += r.Success
+		result.Failed += r.Failed
+		result.Errors = append(result.Errors, r.Errors...)
+		saveSyncFingerprints(conn, syncr, g.users, mappings, r)
+	}
+	return result
+}
+
+// saveSyncFingerprints 推送后刷新指纹；错误信息中出现的用户（"[用户名] ..."）视为失败，不刷新
+func saveSyncFingerprints(conn models.Connector, syncr models.Synchronizer, users []models.User, mappings []models.SyncAttributeMapping, result SyncResult) {
+	if !syncr.FromRule || len(users) == 0 || (result.Success == 0 && result.Failed > 0) {
+		return
+	}
+	failed := failedUsernames(result.Errors)
+	for _, user := range users {
+		if failed[user.Username] {
+			continue
+		}
+		saveSyncFingerprint(conn, syncr, user, mappings)
+	}
+}
+
+// saveSyncFingerprint 写入单个用户的指纹
+func saveSyncFingerprint(conn models.Connector, syncr models.Synchronizer, user models.User, mappings []models.SyncAttributeMapping) {
+	if !syncr.FromRule {
+		return
+	}
+	data, _ := json.Marshal(fingerprintAttrs(mappings, user))
+	storage.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "sync_rule_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"attributes", "connector_id", "updated_at"}),
+	}).Create(&models.SyncFingerprint{
+		SyncRuleID:  syncr.ID,
+		UserID:      user.ID,
+		ConnectorID: conn.ID,
+		Attributes:  string(data),
+	})
+}
+
+// deleteSyncFingerprint 目标端用户已删除时清除指纹，下次同步会完整重建
+func deleteSyncFingerprint(syncr models.Synchronizer, userID uint) {
+	if !syncr.FromRule {
+		return
+	}
+	storage.DB.Where("sync_rule_id = ? AND user_id = ?", syncr.ID, userID).Delete(&models.SyncFingerprint{})
+}
+
+// failedUsernames 从错误列表中提取 "[用户名] ..." 格式的失败用户
+func failedUsernames(errs []string) map[string]bool {
+	failed := make(map[string]bool)
+	for _, e := range errs {
+		if !strings.HasPrefix(e, "[") {
+			continue
+		}
+		if end := strings.Index(e, "]"); end > 1 {
+			failed[e[1:end]] = true
+		}
+	}
+	return failed
+}
@@ -0,0 +1,146 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go-syncflow/internal/models"
+)
+
+func TestFingerprintValue(t *testing.T) {
+	a := fingerprintValue("alice@example.com")
+	if len(a) != 16 {
+		t.Fatalf("len = %d, want 16", len(a))
+	}
+	if a != fingerprintValue("alice@example.com") {
+		t.Error("相同值的指纹应相同")
+	}
+	if a == fingerprintValue("Alice@example.com") || fingerprintValue("") == fingerprintValue(" ") {
+		t.Error("不同值的指纹应不同")
+	}
+}
+
+func TestFingerprintAttrs(t *testing.T) {
+	mappings := []models.SyncAttributeMapping{
+		{SourceAttribute: "username", TargetAttribute: "sAMAccountName"},
+		{SourceAttribute: "email", TargetAttribute: "mail"},
+		{SourceAttribute: "nickname", TargetAttribute: "displayName"},
+		{SourceAttribute: "password", TargetAttribute: "unicodePwd"},
+		{SourceAttribute: "password_raw", TargetAttribute: "userPassword"},
+		{TargetAttribute: "company", MappingType: "constant", TransformRule: "ACME"},
+	}
+	base := models.User{
+		ID: 1, Username: "alice", Email: "alice@example.com", Nickname: "Alice", Status: 1,
+		Roles: []models.Role{{ID: 3}, {ID: 1}}, GroupIDs: []uint{5, 2}, ManagerID: 9,
+	}
+	fp := fingerprintAttrs(mappings, base)
+
+	for _, attr := range []string{"unicodePwd", "userPassword"} {
+		if _, ok := fp[attr]; ok {
+			t.Errorf("原文密码属性 %s 不应计入指纹", attr)
+		}
+	}
+	for _, attr := range []string{"sAMAccountName", "mail", "displayName", "company", "#status", "#roles", "#groups", "#manager", "#expiry"} {
+		if _, ok := fp[attr]; !ok {
+			t.Errorf("缺少指纹 %s", attr)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(u *models.User)
+		want   []string // 应变化的指纹键
+	}{
+		{"无变化", func(u *models.User) {}, nil},
+		{"邮箱", func(u *models.User) { u.Email = "a@example.com" }, []string{"mail"}},
+		{"状态", func(u *models.User) { u.Status = 0 }, []string{"#status"}},
+		{"角色顺序不影响", func(u *models.User) { u.Roles = []models.Role{{ID: 1}, {ID: 3}} }, nil},
+		{"角色变化", func(u *models.User) { u.Roles = []models.Role{{ID: 1}} }, []string{"#roles"}},
+		{"主部门变化", func(u *models.User) { u.GroupIDs = []uint{2, 5} }, []string{"#groups"}},
+		{"上级", func(u *models.User) { u.ManagerID = 0 }, []string{"#manager"}},
+		{"失效时间", func(u *models.User) { d := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC); u.ValidUntil = &d }, []string{"#expiry"}},
+		{"密码哈希不影响", func(u *models.User) { u.Password = "changed" }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := base
+			tt.modify(&u)
+			got := fingerprintAttrs(mappings, u)
+			var changed []string
+			for _, key := range []string{"sAMAccountName", "mail", "displayName", "company", "#status", "#roles", "#groups", "#manager", "#expiry"} {
+				if got[key] != fp[key] {
+					changed = append(changed, key)
+				}
+			}
+			if !reflect.DeepEqual(changed, tt.want) {
+				t.Errorf("changed = %v, want %v", changed, tt.want)
+			}
+		})
+	}
+}
+
+func TestMembershipFingerprintLoadsGroups(t *testing.T) {
+	db := setupTestDB(t, &models.User{}, &models.UserGroupMember{})
+	db.Create(&models.User{ID: 1, Username: "alice", Password: "x", GroupID: 4})
+	db.Create(&models.UserGroupMember{UserID: 1, GroupID: 4})
+	db.Create(&models.UserGroupMember{UserID: 1, GroupID: 6})
+
+	// GroupIDs 未加载时从成员关系表读取
+	loaded := membershipFingerprint(models.User{ID: 1, GroupID: 4})
+	if loaded["#groups"] != "[4 6]" {
+		t.Errorf("#groups = %s, want [4 6]", loaded["#groups"])
+	}
+	// 已加载（含空列表）时直接使用
+	empty := membershipFingerprint(models.User{ID: 1, GroupIDs: []uint{}})
+	if empty["#groups"] != "[]" {
+		t.Errorf("#groups = %s, want []", empty["#groups"])
+	}
+}
+
+func TestFilterDeltaMappings(t *testing.T) {
+	mappings := []models.SyncAttributeMapping{
+		{SourceAttribute: "username", TargetAttribute: "uid"},
+		{SourceAttribute: "id", TargetAttribute: "employeeID"},
+		{SourceAttribute: "email", TargetAttribute: "mail"},
+		{SourceAttribute: "nickname", TargetAttribute: "displayName"},
+	}
+	tests := []struct {
+		name    string
+		changed map[string]bool
+		want    []string
+	}{
+		{"标识字段始终保留", map[string]bool{}, []string{"uid", "employeeID"}},
+		{"保留变化属性", map[string]bool{"mail": true}, []string{"uid", "employeeID", "mail"}},
+		{"成员关系变化只推送标识", map[string]bool{"#roles": true}, []string{"uid", "employeeID"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range filterDeltaMappings(mappings, tt.changed) {
+			got = append(got, m.TargetAttribute)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFailedUsernames(t *testing.T) {
+	tests := []struct {
+		name string
+		errs []string
+		want map[string]bool
+	}{
+		{"空", nil, map[string]bool{}},
+		{"提取用户名", []string{"[alice] 更新失败: timeout", "[bob] 创建失败"}, map[string]bool{"alice": true, "bob": true}},
+		{"忽略非用户错误", []string{"连接目标端失败", "alice] 缺少前缀"}, map[string]bool{}},
+		{"空用户名", []string{"[] 失败"}, map[string]bool{}},
+		{"缺少右括号", []string{"[alice 失败"}, map[string]bool{}},
+		{"取第一个右括号", []string{"[carol] 属性 [mail] 无效"}, map[string]bool{"carol": true}},
+	}
+	for _, tt := range tests {
+		if got := failedUsernames(tt.errs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	_ "github.com/lib/pq"
 	_ "github.com/microsoft/go-mssqldb"
 	_ "github.com/sijms/go-ora/v2"
+	"gorm.io/gorm"
 
 	"go-syncflow/internal/models"
 	"go-syncflow/internal/storage"
@@ -221,11 +222,16 @@ type SyncResult struct {
 
 // ExecuteSync 执行同步（单用户 - 事件触发）
 func ExecuteSync(syncr models.Synchronizer, user models.User, event string, rawPassword string) {
+	runEventSync(syncr, user, event, rawPassword)
+}
+
+// runEventSync 执行单用户事件同步并记录日志，返回结果供同步队列判断是否重试
+func runEventSync(syncr models.Synchronizer, user models.User, event string, rawPassword string) SyncResult {
 	start := time.Now()
 	var conn models.Connector
 	if err := storage.DB.First(&conn, syncr.ConnectorID).Error; err != nil {
 		logSync(syncr.ID, "event", event, user.ID, user.Username, "failed", "连接器不存在", 0, time.Since(start).Milliseconds())
-		return
+		return SyncResult{Failed: 1, Errors: []string{"连接器不存在"}}
 	}
 
 	var result SyncResult
@@ -236,9 +242,11 @@ func ExecuteSync(syncr models.Synchronizer, user models.User, event string, rawP
 		result = syncUserToGenericLDAP(conn, syncr, user, event, rawPassword)
 	case conn.Type == "mysql" || conn.IsDatabase():
 		result = syncUserToDB(conn, syncr, user, event, rawPassword)
+	case conn.IsHTTP():
+		result = syncUserToHTTPRest(conn, syncr, user, event, rawPassword)
 	default:
 		logSync(syncr.ID, "event", event, user.ID, user.Username, "failed", "不支持的连接器类型: "+conn.Type, 0, time.Since(start).Milliseconds())
-		return
+		return SyncResult{Failed: 1, Errors: []string{"不支持的连接器类型: " + conn.Type}}
 	}
 
 	status := "success"
@@ -261,10 +269,27 @@ func ExecuteSync(syncr models.Synchronizer, user models.User, event string, rawP
 	}
 
 	logSyncWithDetail(syncr.ID, "event", event, user.ID, user.Username, status, msg, detail, result.Success, time.Since(start).Milliseconds())
+
+	// 事件推送成功后同步刷新指纹，避免下次增量全量同步重复推送
+	if result.Failed == 0 && result.Success > 0 {
+		switch event {
+		case models.SyncEventUserDelete:
+			deleteSyncFingerprint(syncr, user.ID)
+		case models.SyncEventPasswordChange:
+		default:
+			saveSyncFingerprint(conn, syncr, user, loadUserMappings(syncr))
+		}
+	}
+	return result
 }
 
 // ExecuteFullSync 全量同步（定时/手动）
 func ExecuteFullSync(syncr models.Synchronizer, triggerType string) SyncResult {
+	return executeFullSync(syncr, triggerType, false)
+}
+
+// executeFullSync 全量同步；delta=true 时仅推送指纹变化的用户及属性
+func executeFullSync(syncr models.Synchronizer, triggerType string, delta bool) SyncResult {
 	start := time.Now()
 	var conn models.Connector
 	if err := storage.DB.First(&conn, syncr.ConnectorID).Error; err != nil {
@@ -274,28 +299,20 @@ func ExecuteFullSync(syncr models.Synchronizer, triggerType string) SyncResult {
 	// 查询所有活跃用户
 	var users []models.User
 	storage.DB.Where("is_deleted = 0 AND status = 1").Preload("Roles").Find(&users)
+	storage.LoadUserAttributes(users)
+	storage.LoadUserGroupIDs(users)
 
 	// 获取属性映射
-	var mappings []models.SyncAttributeMapping
-	storage.DB.Where("(synchronizer_id = ? OR sync_rule_id = ?) AND object_type = ? AND is_enabled = ?", syncr.ID, syncr.ID, "user", true).Order("priority").Find(&mappings)
+	mappings := loadUserMappings(syncr)
 
 	var result SyncResult
-	result.Total = len(users)
-
-	switch {
-	case conn.Type == "ldap_ad":
-		result = batchSyncUsersToAD(conn, syncr, users, mappings)
-	case conn.Type == "ldap_generic":
-		result = batchSyncUsersToGenericLDAP(conn, syncr, users, mappings)
-	case conn.Type == "mysql" || conn.IsDatabase():
-		for _, user := range users {
-			r := syncUserToDB(conn, syncr, user, "full_sync", "")
-			result.Success += r.Success
-			result.Failed += r.Failed
-			result.Errors = append(result.Errors, r.Errors...)
-		}
+	if delta {
+		result = deltaPushUsers(conn, syncr, users, mappings)
+	} else {
+		result = pushUsers(conn, syncr, users, mappings)
+		saveSyncFingerprints(conn, syncr, users, mappings, result)
 	}
-
+	result.Total = len(users)
 	result.Duration = time.Since(start).Milliseconds()
 
 	status := "success"
@@ -307,6 +324,9 @@ func ExecuteFullSync(syncr models.Synchronizer, triggerType string) SyncResult {
 	}
 
 	msg := fmt.Sprintf("全量同步完成: 总计%d, 成功%d, 失败%d", result.Total, result.Success, result.Failed)
+	if delta {
+		msg = fmt.Sprintf("增量全量同步完成: 总计%d, 推送%d, 失败%d, 无变化跳过%d", result.Total, result.Success, result.Failed, result.Skipped)
+	}
 
 	// 记录所有错误详情（清理 null 字节）
 	detail := ""
@@ -336,6 +356,27 @@ func ExecuteFullSync(syncr models.Synchronizer, triggerType string) SyncResult {
 	return result
 }
 
+// pushUsers 按连接器类型批量推送用户
+func pushUsers(conn models.Connector, syncr models.Synchronizer, users []models.User, mappings []models.SyncAttributeMapping) SyncResult {
+	var result SyncResult
+	switch {
+	case conn.Type == "ldap_ad":
+		result = batchSyncUsersToAD(conn, syncr, users, mappings)
+	case conn.Type == "ldap_generic":
+		result = batchSyncUsersToGenericLDAP(conn, syncr, users, mappings)
+	case conn.Type == "mysql" || conn.IsDatabase():
+		for _, user := range users {
+			r := syncUserToDB(conn, syncr, user, "full_sync", "")
+			result.Success += r.Success
+			result.Failed += r.Failed
+			result.Errors = append(result.Errors, r.Errors...)
+		}
+	case conn.IsHTTP():
+		result = batchSyncUsersToHTTPRest(conn, users, mappings)
+	}
+	return result
+}
+
 // ========== AD 批量同步（共享连接）==========
 
 // AD 中不允许在 Modify 操作中直接修改的属性
@@ -444,6 +485,48 @@ func ensureOUExists(l *ldapv3.Conn, groupID uint, baseDN string) {
 	}
 }
 
+// adDisabledUsersOU 已离职用户应放入的 OU：生命周期配置了离职用户 OU 时返回其 DN 并确保存在，否则返回空
+func adDisabledUsersOU(l *ldapv3.Conn, user models.User, targetContainer string) string {
+	if user.LifecycleState != models.LifecycleLeft {
+		return ""
+	}
+	name := strings.TrimSpace(GetLifecycleConfig().DisabledOU)
+	if name == "" {
+		return ""
+	}
+	ouDN := fmt.Sprintf("OU=%s,%s", ldapv3.EscapeDN(name), targetContainer)
+	sr, err := l.Search(ldapv3.NewSearchRequest(
+		ouDN, ldapv3.ScopeBaseObject, ldapv3.NeverDerefAliases, 1, 5, false,
+		"(objectClass=*)", []string{"dn"}, nil,
+	))
+	if err == nil && len(sr.Entries) > 0 {
+		return ouDN
+	}
+	addReq := ldapv3.NewAddRequest(ouDN, nil)
+	addReq.Attribute("objectClass", []string{"top", "organizationalUnit"})
+	addReq.Attribute("ou", []string{name})
+	addReq.Attribute("description", []string{"离职用户"})
+	if err := l.Add(addReq); err != nil && !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultEntryAlreadyExists) {
+		log.Printf("[同步] 创建离职用户OU失败 %s: %v", ouDN, err)
+		return ""
+	}
+	return ouDN
+}
+
+// adMoveUser 用户当前 DN 与期望 DN 不一致时移动到期望 OU，返回移动后的 DN（失败时返回原 DN）
+func adMoveUser(l *ldapv3.Conn, realDN, userDN, parentDN string, user models.User, result *SyncResult) string {
+	if strings.EqualFold(realDN, userDN) {
+		return realDN
+	}
+	newRDN := fmt.Sprintf("cn=%s", ldapv3.EscapeDN(user.Username))
+	if err := l.ModifyDN(ldapv3.NewModifyDNRequest(realDN, newRDN, true, parentDN)); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("[%s] 移动OU失败: %v", user.Username, err))
+		return realDN
+	}
+	log.Printf("[同步] [%s] 已移动到 %s", user.Username, parentDN)
+	return userDN
+}
+
 // adSyncUserStatus 显式同步用户启用/禁用状态到 AD
 // 根据本地 user.Status 设置 userAccountControl：
 //   status=1 → 66048 (NORMAL_ACCOUNT + DONT_EXPIRE_PASSWORD = 启用)
@@ -465,13 +548,51 @@ func adSyncUserStatus(l *ldapv3.Conn, userDN string, user models.User) {
 	}
 }
 
-// adSyncUserRoles 同步用户角色到 AD 安全组（事件触发时使用）
-// 确保角色安全组存在，并将用户添加为成员
-func adSyncUserRoles(l *ldapv3.Conn, conn models.Connector, userDN string, targetContainer string, user models.User) {
-	if len(user.Roles) == 0 {
+// accountExpiresNever AD 中另一种“永不过期”的取值
+const accountExpiresNever = "9223372036854775807"
+
+// adSyncUserExpiry 同步账号失效时间到 AD accountExpires（FILETIME，0 表示永不过期）
+// 映射中显式配置了 accountExpires 时以映射为准；增量同步中失效时间未变化时跳过
+func adSyncUserExpiry(l *ldapv3.Conn, syncr models.Synchronizer, userDN string, user models.User, mappings []models.SyncAttributeMapping) {
+	if syncr.DeltaAttributes != nil && !syncr.DeltaAttributes["#expiry"] {
 		return
 	}
+	for _, m := range mappings {
+		if strings.EqualFold(m.TargetAttribute, "accountExpires") {
+			return
+		}
+	}
+	value := "0"
+	if user.ValidUntil != nil {
+		value = timeToFiletime(*user.ValidUntil)
+	}
+	// 读取当前值，未变化时不修改（0 与最大值均表示永不过期）
+	searchReq := ldapv3.NewSearchRequest(
+		userDN, ldapv3.ScopeBaseObject, ldapv3.NeverDerefAliases, 1, 10, false,
+		"(objectClass=*)", []string{"accountExpires"}, nil,
+	)
+	if sr, err := l.Search(searchReq); err == nil && len(sr.Entries) > 0 {
+		current := sr.Entries[0].GetEqualFoldAttributeValue("accountExpires")
+		if current == accountExpiresNever {
+			current = "0"
+		}
+		if current == value {
+			return
+		}
+	}
+	modReq := ldapv3.NewModifyRequest(userDN, nil)
+	modReq.Replace("accountExpires", []string{value})
+	if err := l.Modify(modReq); err != nil {
+		log.Printf("[同步] [%s] 同步账号失效时间失败: %v", user.Username, err)
+	}
+}
+
+// adSyncUserRoles 同步用户角色到 AD 安全组（事件触发时使用）
+// 确保角色安全组存在并将用户添加为成员，同时移出已不再拥有的角色组
+func adSyncUserRoles(l *ldapv3.Conn, conn models.Connector, userDN string, targetContainer string, user models.User) {
+	desired := make(map[string]bool, len(user.Roles))
 	for _, role := range user.Roles {
+		desired[strings.ToLower(role.Name)] = true
 		groupDN := fmt.Sprintf("cn=%s,%s", ldapv3.EscapeDN(role.Name), targetContainer)
 		// 确保安全组存在
 		sr, _ := l.Search(ldapv3.NewSearchRequest(
@@ -505,6 +626,157 @@ func adSyncUserRoles(l *ldapv3.Conn, conn models.Connector, userDN string, targe
 			log.Printf("[同步] [%s] 已添加到角色组: %s", user.Username, role.Name)
 		}
 	}
+
+	// 移出已不再拥有的角色组：只处理角色容器下、与本地角色同名的组，不影响其他安全组
+	sr, err := l.Search(ldapv3.NewSearchRequest(
+		userDN, ldapv3.ScopeBaseObject, ldapv3.NeverDerefAliases, 1, 10, false,
+		"(objectClass=*)", []string{"memberOf"}, nil,
+	))
+	if err != nil || len(sr.Entries) == 0 {
+		return
+	}
+	container, err := ldapv3.ParseDN(targetContainer)
+	if err != nil {
+		return
+	}
+	var roleNames []string
+	storage.DB.Model(&models.Role{}).Pluck("name", &roleNames)
+	managed := make(map[string]bool, len(roleNames))
+	for _, name := range roleNames {
+		managed[strings.ToLower(name)] = true
+	}
+	for _, groupDN := range sr.Entries[0].GetEqualFoldAttributeValues("memberOf") {
+		dn, err := ldapv3.ParseDN(groupDN)
+		if err != nil || len(dn.RDNs) < 2 || len(dn.RDNs[0].Attributes) != 1 {
+			continue
+		}
+		if !(&ldapv3.DN{RDNs: dn.RDNs[1:]}).EqualFold(container) {
+			continue
+		}
+		cn := dn.RDNs[0].Attributes[0]
+		name := strings.ToLower(cn.Value)
+		if !strings.EqualFold(cn.Type, "cn") || !managed[name] || desired[name] {
+			continue
+		}
+		modReq := ldapv3.NewModifyRequest(groupDN, nil)
+		modReq.Delete("member", []string{userDN})
+		if err := l.Modify(modReq); err != nil {
+			log.Printf("[同步] [%s] 移出角色组 %s 失败: %v", user.Username, cn.Value, err)
+		} else {
+			log.Printf("[同步] [%s] 已移出角色组: %s", user.Username, cn.Value)
+		}
+	}
+}
+
+// deptGroupAccountPrefix 部门安全组 sAMAccountName 前缀，用于识别由本系统维护的部门组
+const deptGroupAccountPrefix = "dept_"
+
+// adSyncUserDeptGroups 同步用户的部门安全组（规则开启"同步群组"时）
+// 每个部门 OU 下维护一个同名安全组：用户加入所属全部部门（含兼职部门）的安全组，并移出已不再所属的部门组。
+// ouDNs 为已计算的群组 OU（批量同步传入），未命中时按群组层级计算并确保 OU 存在
+func adSyncUserDeptGroups(l *ldapv3.Conn, targetContainer, userDN string, user models.User, ouDNs map[uint]string, ensured map[string]bool) {
+	if ensured == nil {
+		ensured = make(map[string]bool)
+	}
+	desired := make(map[string]bool)
+	for _, gid := range userGroupIDs(user) {
+		var group models.UserGroup
+		if storage.DB.Select("id, name").First(&group, gid).Error != nil {
+			continue
+		}
+		ouDN := ouDNs[gid]
+		if ouDN == "" {
+			if ouDN = buildGroupOUPath(gid, targetContainer); ouDN == "" {
+				continue
+			}
+			ensureOUExists(l, gid, targetContainer)
+		}
+		groupDN := fmt.Sprintf("cn=%s,%s", ldapv3.EscapeDN(group.Name), ouDN)
+		if !ensured[groupDN] {
+			addReq := ldapv3.NewAddRequest(groupDN, nil)
+			addReq.Attribute("objectClass", []string{"top", "group"})
+			addReq.Attribute("cn", []string{group.Name})
+			addReq.Attribute("sAMAccountName", []string{fmt.Sprintf("%s%d", deptGroupAccountPrefix, group.ID)})
+			addReq.Attribute("groupType", []string{"-2147483646"}) // 全局安全组
+			addReq.Attribute("description", []string{fmt.Sprintf("部门: %s", group.Name)})
+			if err := l.Add(addReq); err != nil && !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultEntryAlreadyExists) {
+				log.Printf("[同步] [%s] 创建部门安全组失败 %s: %v", user.Username, group.Name, err)
+				continue
+			}
+			ensured[groupDN] = true
+		}
+		desired[strings.ToLower(groupDN)] = true
+
+		modReq := ldapv3.NewModifyRequest(groupDN, nil)
+		modReq.Add("member", []string{userDN})
+		if err := l.Modify(modReq); err != nil &&
+			!ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultEntryAlreadyExists) &&
+			!strings.Contains(err.Error(), "ENTRY_EXISTS") &&
+			!strings.Contains(err.Error(), "already") {
+			log.Printf("[同步] [%s] 添加到部门组 %s 失败: %v", user.Username, group.Name, err)
+		}
+	}
+
+	// 移出已不再所属的部门组（分页搜索，不受服务端单次返回条数限制）
+	sr, err := l.SearchWithPaging(ldapv3.NewSearchRequest(
+		targetContainer, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=group)(sAMAccountName=%s*)(member=%s))", deptGroupAccountPrefix, ldapv3.EscapeFilter(userDN)),
+		[]string{"dn"}, nil,
+	), 500)
+	if err != nil {
+		return
+	}
+	for _, e := range sr.Entries {
+		if desired[strings.ToLower(e.DN)] {
+			continue
+		}
+		modReq := ldapv3.NewModifyRequest(e.DN, nil)
+		modReq.Delete("member", []string{userDN})
+		if err := l.Modify(modReq); err != nil {
+			log.Printf("[同步] [%s] 移出部门组 %s 失败: %v", user.Username, e.DN, err)
+		}
+	}
+}
+
+// adSyncUserManager 同步直属上级到 AD manager 属性（值为上级在 AD 中的 DN）
+// knownDNs 为本批次已确定的用户 DN，未命中时按用户名搜索；映射中显式配置了 manager 时以映射为准
+func adSyncUserManager(l *ldapv3.Conn, conn models.Connector, userDN string, user models.User, mappings []models.SyncAttributeMapping, knownDNs map[uint]string) {
+	for _, m := range mappings {
+		if strings.EqualFold(m.TargetAttribute, "manager") {
+			return
+		}
+	}
+	var managerDN string
+	if user.ManagerID > 0 {
+		managerDN = knownDNs[user.ManagerID]
+		if managerDN == "" {
+			var manager models.User
+			if storage.DB.Select("id, username").Where("is_deleted = 0").First(&manager, user.ManagerID).Error == nil {
+				managerDN = searchUserDN(l, conn.BaseDN, manager.Username)
+			}
+		}
+	}
+	// 读取当前上级，未变化时不修改（避免每次同步都写入 AD）
+	searchReq := ldapv3.NewSearchRequest(
+		userDN, ldapv3.ScopeBaseObject, ldapv3.NeverDerefAliases, 1, 10, false,
+		"(objectClass=*)", []string{"manager"}, nil,
+	)
+	if sr, err := l.Search(searchReq); err == nil && len(sr.Entries) > 0 {
+		if strings.EqualFold(sr.Entries[0].GetEqualFoldAttributeValue("manager"), managerDN) {
+			return
+		}
+	}
+
+	// Replace 空值即清除属性，属性不存在时也不会报错
+	modReq := ldapv3.NewModifyRequest(userDN, nil)
+	if managerDN != "" {
+		modReq.Replace("manager", []string{managerDN})
+	} else {
+		modReq.Replace("manager", []string{})
+	}
+	if err := l.Modify(modReq); err != nil {
+		log.Printf("[同步] [%s] 同步上级失败: %v", user.Username, err)
+	}
 }
 
 // prevent=true: 添加 DENY ACE（勾选"用户不能更改密码"）
@@ -794,6 +1066,8 @@ func batchSyncUsersToAD(conn models.Connector, syncr models.Synchronizer, users
 	}
 
 	// ===== 第三步：同步用户到对应的 OU =====
+	userDNs := make(map[uint]string, len(users)) // 用户ID -> 实际 DN，供第六步设置上级
+	deptGroupsEnsured := make(map[string]bool)   // 本批次已确认存在的部门安全组
 	for _, user := range users {
 		// 确定用户应该在哪个 OU
 		userParentDN := targetContainer // 默认
@@ -808,19 +1082,8 @@ func batchSyncUsersToAD(conn models.Connector, syncr models.Synchronizer, users
 		realDN := searchUserDN(l, conn.BaseDN, user.Username)
 		if realDN != "" {
 			// 用户已存在
-			// 检查是否需要移动到正确的 OU
-			if !strings.EqualFold(realDN, userDN) {
-				// 需要移动：使用 ModifyDN
-				newRDN := fmt.Sprintf("cn=%s", ldapv3.EscapeDN(user.Username))
-				modDNReq := ldapv3.NewModifyDNRequest(realDN, newRDN, true, userParentDN)
-				if err := l.ModifyDN(modDNReq); err != nil {
-					result.Errors = append(result.Errors, fmt.Sprintf("[%s] 移动OU失败: %v", user.Username, err))
-					// 移动失败不影响后续更新，继续使用 realDN
-				} else {
-					realDN = userDN
-					log.Printf("[同步] [%s] 已移动到 %s", user.Username, userParentDN)
-				}
-			}
+			// 检查是否需要移动到正确的 OU（移动失败不影响后续更新，继续使用 realDN）
+			realDN = adMoveUser(l, realDN, userDN, userParentDN, user, &result)
 
 			// 更新属性
 			modReq := ldapv3.NewModifyRequest(realDN, nil)
@@ -828,9 +1091,8 @@ func batchSyncUsersToAD(conn models.Connector, syncr models.Synchronizer, users
 				if adReadOnlyAttrs[m.TargetAttribute] {
 					continue
 				}
-				val := resolveSourceValue(m, user, "")
-				if val != "" {
-					modReq.Replace(m.TargetAttribute, []string{val})
+				if vals := resolveSourceValues(m, user, ""); len(vals) > 0 {
+					modReq.Replace(m.TargetAttribute, vals)
 				}
 			}
 			if len(modReq.Changes) > 0 {
@@ -896,9 +1158,8 @@ func batchSyncUsersToAD(conn models.Connector, syncr models.Synchronizer, users
 				if m.TargetAttribute == "displayName" || m.TargetAttribute == "sn" || m.TargetAttribute == "givenName" {
 					continue
 				}
-				val := resolveSourceValue(m, user, "")
-				if val != "" {
-					addReq.Attribute(m.TargetAttribute, []string{val})
+				if vals := resolveSourceValues(m, user, ""); len(vals) > 0 {
+					addReq.Attribute(m.TargetAttribute, vals)
 				}
 			}
 
@@ -915,6 +1176,7 @@ func batchSyncUsersToAD(conn models.Connector, syncr models.Synchronizer, users
 		if actualDN == "" {
 			actualDN = userDN
 		}
+		userDNs[user.ID] = actualDN
 		roleIDs := userRoleMap[user.ID]
 		for _, roleID := range roleIDs {
 			groupDN, ok := roleDNMap[roleID]
@@ -935,12 +1197,26 @@ func batchSyncUsersToAD(conn models.Connector, syncr models.Synchronizer, users
 			}
 		}
 
+		// 部门安全组（主部门与兼职部门）
+		if syncr.SyncGroups {
+			adSyncUserDeptGroups(l, targetContainer, actualDN, user, groupDNMap, deptGroupsEnsured)
+		}
+
+		adSyncUserExpiry(l, syncr, actualDN, user, mappings)
+
 		// ===== 第五步：设置/取消"用户不能更改密码" =====
 		if err := adSetCannotChangePassword(l, actualDN, syncr.PreventPwdChange); err != nil {
 			log.Printf("[同步] [%s] 设置'用户不能更改密码'(%v)失败: %v", user.Username, syncr.PreventPwdChange, err)
 		}
 	}
 
+	// ===== 第六步：同步上级（所有用户就位后再设置，上级可能在本批次中新建） =====
+	for _, user := range users {
+		if dn, ok := userDNs[user.ID]; ok {
+			adSyncUserManager(l, conn, dn, user, mappings, userDNs)
+		}
+	}
+
 	return result
 }
 
@@ -977,8 +1253,7 @@ func syncUserToAD(conn models.Connector, syncr models.Synchronizer, user models.
 	}
 
 	// 获取属性映射
-	var mappings []models.SyncAttributeMapping
-	storage.DB.Where("(synchronizer_id = ? OR sync_rule_id = ?) AND object_type = ? AND is_enabled = ?", syncr.ID, syncr.ID, "user", true).Order("priority").Find(&mappings)
+	mappings := loadUserMappings(syncr)
 
 	// 构造用户DN — 根据用户所属群组确定 OU（与批量同步一致）
 	targetContainer := syncr.TargetContainer
@@ -988,7 +1263,10 @@ func syncUserToAD(conn models.Connector, syncr models.Synchronizer, user models.
 
 	// 如果用户有群组，构建对应的 OU DN 层级并确保 OU 存在
 	userParentDN := targetContainer
-	if user.GroupID > 0 {
+	if disabledDN := adDisabledUsersOU(l, user, targetContainer); disabledDN != "" {
+		// 已离职用户放入离职用户 OU
+		userParentDN = disabledDN
+	} else if user.GroupID > 0 {
 		// 构建群组层级路径
 		ouPath := buildGroupOUPath(user.GroupID, targetContainer)
 		if ouPath != "" {
@@ -1072,15 +1350,16 @@ func syncUserToAD(conn models.Connector, syncr models.Synchronizer, user models.
 		// 先搜索用户是否存在
 		realDN := searchUserDN(l, conn.BaseDN, user.Username)
 		if realDN != "" {
-			// 用户存在 -> 更新
+			// 用户存在 -> 所在 OU 与分组（或离职状态）不一致时先移动（调岗 / 离职）
+			realDN = adMoveUser(l, realDN, userDN, userParentDN, user, &result)
+			// 更新属性
 			modReq := ldapv3.NewModifyRequest(realDN, nil)
 			for _, m := range mappings {
 				if adReadOnlyAttrs[m.TargetAttribute] {
 					continue // 跳过 AD 中不允许 Modify 的只读属性（cn, unicodePwd, userAccountControl 等）
 				}
-				val := resolveSourceValue(m, user, rawPassword)
-				if val != "" {
-					modReq.Replace(m.TargetAttribute, []string{val})
+				if vals := resolveSourceValues(m, user, rawPassword); len(vals) > 0 {
+					modReq.Replace(m.TargetAttribute, vals)
 				}
 			}
 			if len(modReq.Changes) > 0 {
@@ -1104,6 +1383,11 @@ func syncUserToAD(conn models.Connector, syncr models.Synchronizer, user models.
 			adSyncUserStatus(l, realDN, user)
 			// 同步角色到 AD 安全组
 			adSyncUserRoles(l, conn, realDN, targetContainer, user)
+			if syncr.SyncGroups {
+				adSyncUserDeptGroups(l, targetContainer, realDN, user, nil, nil)
+			}
+			adSyncUserManager(l, conn, realDN, user, mappings, nil)
+			adSyncUserExpiry(l, syncr, realDN, user, mappings)
 			// 设置/取消"用户不能更改密码"
 			if err := adSetCannotChangePassword(l, realDN, syncr.PreventPwdChange); err != nil {
 				log.Printf("[同步] [%s] 设置'用户不能更改密码'(%v)失败: %v", user.Username, syncr.PreventPwdChange, err)
@@ -1121,9 +1405,8 @@ func syncUserToAD(conn models.Connector, syncr models.Synchronizer, user models.
 				if adCreateSkipAttrs[m.TargetAttribute] {
 					continue
 				}
-				val := resolveSourceValue(m, user, rawPassword)
-				if val != "" {
-					addReq.Attribute(m.TargetAttribute, []string{val})
+				if vals := resolveSourceValues(m, user, rawPassword); len(vals) > 0 {
+					addReq.Attribute(m.TargetAttribute, vals)
 				}
 			}
 			if err := l.Add(addReq); err != nil {
@@ -1143,6 +1426,11 @@ func syncUserToAD(conn models.Connector, syncr models.Synchronizer, user models.
 			adSyncUserStatus(l, userDN, user)
 			// 同步角色到 AD 安全组
 			adSyncUserRoles(l, conn, userDN, targetContainer, user)
+			if syncr.SyncGroups {
+				adSyncUserDeptGroups(l, targetContainer, userDN, user, nil, nil)
+			}
+			adSyncUserManager(l, conn, userDN, user, mappings, nil)
+			adSyncUserExpiry(l, syncr, userDN, user, mappings)
 			// 设置/取消"用户不能更改密码"
 			if err := adSetCannotChangePassword(l, userDN, syncr.PreventPwdChange); err != nil {
 				log.Printf("[同步] [%s] 设置'用户不能更改密码'(%v)失败: %v", user.Username, syncr.PreventPwdChange, err)
@@ -1173,8 +1461,7 @@ func syncUserToGenericLDAP(conn models.Connector, syncr models.Synchronizer, use
 		return result
 	}
 
-	var mappings []models.SyncAttributeMapping
-	storage.DB.Where("(synchronizer_id = ? OR sync_rule_id = ?) AND object_type = ? AND is_enabled = ?", syncr.ID, syncr.ID, "user", true).Order("priority").Find(&mappings)
+	mappings := loadUserMappings(syncr)
 
 	targetContainer := syncr.TargetContainer
 	if targetContainer == "" {
@@ -1225,9 +1512,8 @@ func syncUserToGenericLDAP(conn models.Connector, syncr models.Synchronizer, use
 				if skipAttrs[m.TargetAttribute] {
 					continue
 				}
-				val := resolveSourceValue(m, user, rawPassword)
-				if val != "" {
-					modReq.Replace(m.TargetAttribute, []string{val})
+				if vals := resolveSourceValues(m, user, rawPassword); len(vals) > 0 {
+					modReq.Replace(m.TargetAttribute, vals)
 				}
 			}
 			if len(modReq.Changes) > 0 {
@@ -1254,9 +1540,8 @@ func syncUserToGenericLDAP(conn models.Connector, syncr models.Synchronizer, use
 				if skipAttrs[m.TargetAttribute] {
 					continue
 				}
-				val := resolveSourceValue(m, user, rawPassword)
-				if val != "" {
-					addReq.Attribute(m.TargetAttribute, []string{val})
+				if vals := resolveSourceValues(m, user, rawPassword); len(vals) > 0 {
+					addReq.Attribute(m.TargetAttribute, vals)
 				}
 			}
 
@@ -1340,8 +1625,7 @@ func syncUserToDB(conn models.Connector, syncr models.Synchronizer, user models.
 		return result
 	}
 
-	var mappings []models.SyncAttributeMapping
-	storage.DB.Where("(synchronizer_id = ? OR sync_rule_id = ?) AND object_type = ? AND is_enabled = ?", syncr.ID, syncr.ID, "user", true).Order("priority").Find(&mappings)
+	mappings := loadUserMappings(syncr)
 
 	// 使用 placeholder 函数根据数据库类型生成参数占位符
 	ph := func(idx int) string {
@@ -1570,9 +1854,49 @@ func encodeADPassword(password string) []byte {
 
 // resolveSourceValue 解析源字段值
 func resolveSourceValue(m models.SyncAttributeMapping, user models.User, rawPassword string) string {
+	return transformMappingValue(m, user, sourceBaseValue(m.SourceAttribute, user, rawPassword))
+}
+
+// transformMappingValue 对源字段值应用映射的转换规则
+func transformMappingValue(m models.SyncAttributeMapping, user models.User, baseValue string) string {
+	switch m.MappingType {
+	case "constant":
+		return m.TransformRule
+	case "transform":
+		return applyTransform(baseValue, m.TransformRule, user)
+	case "expression":
+		return applyExpression(m.TransformRule, user, baseValue)
+	default:
+		return baseValue
+	}
+}
+
+// TransformMappingValue 对外部提供的源值应用映射转换（上游映射使用，源值来自上游平台，.value 即该值）
+// 表达式错误会返回而不是只记日志
+func TransformMappingValue(m models.SyncAttributeMapping, user models.User, baseValue string) (string, error) {
+	if m.MappingType == "expression" || (m.MappingType == "transform" && strings.Contains(m.TransformRule, "{{")) {
+		values, err := EvaluateExpression(m.TransformRule, user, baseValue)
+		return strings.Join(values, ","), err
+	}
+	return transformMappingValue(m, user, baseValue), nil
+}
+
+// resolveSourceValues 多值版本：表达式结果为列表时返回多个值（LDAP 多值属性）
+func resolveSourceValues(m models.SyncAttributeMapping, user models.User, rawPassword string) []string {
+	if m.MappingType == "expression" {
+		return evaluateExpressionValues(m.TransformRule, user, sourceBaseValue(m.SourceAttribute, user, rawPassword))
+	}
+	if val := resolveSourceValue(m, user, rawPassword); val != "" {
+		return []string{val}
+	}
+	return nil
+}
+
+// sourceBaseValue 读取本地用户的源字段值
+func sourceBaseValue(attr string, user models.User, rawPassword string) string {
 	var baseValue string
 
-	switch m.SourceAttribute {
+	switch attr {
 	case "username":
 		baseValue = user.Username
 	case "password", "password_raw":
@@ -1609,6 +1933,27 @@ func resolveSourceValue(m models.SyncAttributeMapping, user models.User, rawPass
 		}
 	case "group_id":
 		baseValue = fmt.Sprintf("%d", user.GroupID)
+	case "group_ids", "group_names":
+		ids := userGroupIDs(user)
+		parts := make([]string, 0, len(ids))
+		if attr == "group_ids" {
+			for _, id := range ids {
+				parts = append(parts, fmt.Sprintf("%d", id))
+			}
+		} else if len(ids) > 0 {
+			var groups []models.UserGroup
+			storage.DB.Where("id IN ?", ids).Find(&groups)
+			names := make(map[uint]string, len(groups))
+			for _, g := range groups {
+				names[g.ID] = g.Name
+			}
+			for _, id := range ids {
+				if names[id] != "" {
+					parts = append(parts, names[id])
+				}
+			}
+		}
+		baseValue = strings.Join(parts, ",")
 	case "roles":
 		roleNames := make([]string, 0, len(user.Roles))
 		for _, r := range user.Roles {
@@ -1637,6 +1982,14 @@ func resolveSourceValue(m models.SyncAttributeMapping, user models.User, rawPass
 		if user.PasswordChangedAt != nil {
 			baseValue = user.PasswordChangedAt.Format("2006-01-02 15:04:05")
 		}
+	case "valid_from":
+		if user.ValidFrom != nil {
+			baseValue = user.ValidFrom.Format("2006-01-02 15:04:05")
+		}
+	case "valid_until":
+		if user.ValidUntil != nil {
+			baseValue = user.ValidUntil.Format("2006-01-02 15:04:05")
+		}
 	case "mfa_enabled":
 		if user.MFAEnabled {
 			baseValue = "1"
@@ -1645,19 +1998,54 @@ func resolveSourceValue(m models.SyncAttributeMapping, user models.User, rawPass
 		}
 	case "id":
 		baseValue = fmt.Sprintf("%d", user.ID)
+	case "manager_username", "manager_nickname":
+		if user.ManagerID > 0 {
+			var manager models.User
+			if storage.DB.Select("id, username, nickname").First(&manager, user.ManagerID).Error == nil {
+				baseValue = manager.Username
+				if attr == "manager_nickname" {
+					baseValue = manager.Nickname
+				}
+			}
+		}
+	default:
+		if key := strings.TrimPrefix(attr, "ext."); key != attr {
+			baseValue = userAttribute(user, key)
+		}
 	}
+	return baseValue
+}
 
-	// 应用转换规则
-	switch m.MappingType {
-	case "constant":
-		return m.TransformRule
-	case "transform":
-		return applyTransform(baseValue, m.TransformRule, user)
-	case "expression":
-		return applyExpression(m.TransformRule, user)
-	default:
-		return baseValue
+// userGroupIDs 用户所属全部分组（主部门在前）；未预加载时单独查询
+func userGroupIDs(user models.User) []uint {
+	if user.GroupIDs != nil {
+		return user.GroupIDs
 	}
+	if user.ID == 0 {
+		if user.GroupID > 0 {
+			return []uint{user.GroupID}
+		}
+		return nil
+	}
+	return storage.GetUserGroupIDs(user.ID)
+}
+
+// userAttribute 读取用户扩展属性；未预加载时单独查询
+func userAttribute(user models.User, key string) string {
+	if user.Attributes != nil {
+		return user.Attributes[key]
+	}
+	var v models.UserAttributeValue
+	if user.ID > 0 && storage.DB.Where("user_id = ? AND attr_key = ?", user.ID, key).First(&v).Error == nil {
+		return v.Value
+	}
+	return ""
+}
+
+// timeToFiletime 时间转换为 Windows FILETIME（自 1601-01-01 起的 100 纳秒间隔数）
+func timeToFiletime(t time.Time) string {
+	epoch := time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+	return fmt.Sprintf("%d", (t.Unix()-epoch.Unix())*10000000)
 }
 
 func applyTransform(value, rule string, user models.User) string {
@@ -1701,23 +2089,29 @@ func applyTransform(value, rule string, user models.User) string {
 		if err != nil {
 			return "0"
 		}
-		// Windows FILETIME: 100-nanosecond intervals since 1601-01-01
-		epoch := time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
-		return fmt.Sprintf("%d", (t.Unix()-epoch.Unix())*10000000)
+		return timeToFiletime(t)
+	case strings.Contains(rule, "{{"):
+		// 转换规则也可以写表达式，.value 为源字段值
+		return applyExpression(rule, user, value)
 	}
 	return value
 }
 
-func applyExpression(expr string, user models.User) string {
-	// 简单模板替换
-	result := expr
-	result = strings.ReplaceAll(result, "{{.username}}", user.Username)
-	result = strings.ReplaceAll(result, "{{.nickname}}", user.Nickname)
-	result = strings.ReplaceAll(result, "{{.email}}", user.Email)
-	result = strings.ReplaceAll(result, "{{.phone}}", user.Phone)
-	result = strings.ReplaceAll(result, "{{.jobTitle}}", user.JobTitle)
-	result = strings.ReplaceAll(result, "{{.departmentName}}", user.DepartmentName)
-	return result
+func applyExpression(expr string, user models.User, value string) string {
+	// 多值结果以逗号拼接；LDAP 推送使用 resolveSourceValues 保留多值
+	return strings.Join(evaluateExpressionValues(expr, user, value), ",")
+}
+
+// expressionVars 表达式/模板可用的用户变量（变量名, 值）
+func expressionVars(user models.User) [][2]string {
+	return [][2]string{
+		{"username", user.Username},
+		{"nickname", user.Nickname},
+		{"email", user.Email},
+		{"phone", user.Phone},
+		{"jobTitle", user.JobTitle},
+		{"departmentName", user.DepartmentName},
+	}
 }
 
 // logSync 记录同步日志
@@ -1746,111 +2140,33 @@ func logSyncWithDetail(syncID uint, triggerType, event string, userID uint, user
 
 // ========== 事件分发 ==========
 
-// DispatchSyncEventSync 同步版本的事件分发（阻塞等待完成，用于删除前触发）
-func DispatchSyncEventSync(event string, user models.User, rawPassword string) {
-	// 1. 旧同步器
-	var synchronizers []models.Synchronizer
-	storage.DB.Where("status = 1 AND enable_event = 1").Find(&synchronizers)
-	for _, syncr := range synchronizers {
-		var events []string
-		if json.Unmarshal([]byte(syncr.Events), &events) != nil { continue }
-		for _, e := range events {
-			if e == event {
-				log.Printf("[同步] 触发事件同步(同步): syncer=%s event=%s user=%s", syncr.Name, event, user.Username)
-				ExecuteSync(syncr, user, event, rawPassword)
-				break
-			}
-		}
-	}
-	// 2. 新同步规则
-	var rules []models.SyncRule
-	storage.DB.Where("status = 1 AND enable_event = 1 AND direction = ?", "downstream").Find(&rules)
-	for _, rule := range rules {
-		var events []string
-		if json.Unmarshal([]byte(rule.Events), &events) != nil { continue }
-		for _, e := range events {
-			if e == event {
-				log.Printf("[同步] 触发下游规则同步(同步): rule=%s event=%s user=%s", rule.Name, event, user.Username)
-				ExecuteSyncRule(rule, user, event, rawPassword)
-				break
-			}
-		}
+// EnqueueUserSyncEvent 按用户 ID 加载用户并在 tx 所在事务内写入同步任务
+// 调用方在事务提交后调用 WakeSyncQueue
+func EnqueueUserSyncEvent(tx *gorm.DB, event string, userID uint, rawPassword string) error {
+	var user models.User
+	if err := tx.Preload("Roles").First(&user, userID).Error; err != nil {
+		return fmt.Errorf("用户不存在: %d", userID)
 	}
+	return EnqueueSyncEvent(tx, event, user, rawPassword)
 }
 
-// DispatchSyncEvent 分发同步事件（异步）
-// 同时查询旧的 Synchronizer 表和新的 SyncRule 表
-func DispatchSyncEvent(event string, userID uint, rawPassword string) {
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("[同步] 事件处理panic: %v", r)
-			}
-		}()
-
-		var user models.User
-		if err := storage.DB.Preload("Roles").First(&user, userID).Error; err != nil {
-			log.Printf("[同步] 事件分发失败，用户不存在: %d", userID)
-			return
-		}
-
-		// 1. 旧同步器 (Synchronizer)
-		var synchronizers []models.Synchronizer
-		storage.DB.Where("status = 1 AND enable_event = 1").Find(&synchronizers)
-		for _, syncr := range synchronizers {
-			var events []string
-			if err := json.Unmarshal([]byte(syncr.Events), &events); err != nil {
-				continue
-			}
-			subscribed := false
-			for _, e := range events {
-				if e == event {
-					subscribed = true
-					break
-				}
-			}
-			if !subscribed {
-				continue
-			}
-			log.Printf("[同步] 触发事件同步: syncer=%s event=%s user=%s", syncr.Name, event, user.Username)
-			ExecuteSync(syncr, user, event, rawPassword)
-		}
-
-		// 2. 新同步规则 (SyncRule) - 仅下游
-		var rules []models.SyncRule
-		storage.DB.Where("status = 1 AND enable_event = 1 AND direction = ?", "downstream").Find(&rules)
-		for _, rule := range rules {
-			var events []string
-			if err := json.Unmarshal([]byte(rule.Events), &events); err != nil {
-				continue
-			}
-			subscribed := false
-			for _, e := range events {
-				if e == event {
-					subscribed = true
-					break
-				}
-			}
-			if !subscribed {
-				continue
-			}
-			log.Printf("[同步] 触发下游规则同步: rule=%s event=%s user=%s", rule.Name, event, user.Username)
-			ExecuteSyncRule(rule, user, event, rawPassword)
+// subscribedEvent 判断 JSON 事件列表是否订阅了指定事件
+func subscribedEvent(eventsJSON, event string) bool {
+	var events []string
+	if err := json.Unmarshal([]byte(eventsJSON), &events); err != nil {
+		return false
+	}
+	for _, e := range events {
+		if e == event {
+			return true
 		}
-	}()
-}
-
-// ExecuteSyncRule 执行同步规则（单用户事件触发 - 下游）
-func ExecuteSyncRule(rule models.SyncRule, user models.User, event string, rawPassword string) {
-	start := time.Now()
-	var conn models.Connector
-	if err := storage.DB.First(&conn, rule.ConnectorID).Error; err != nil {
-		logSyncRule(rule.ID, conn.ID, "downstream", "event", event, user.ID, user.Username, "failed", "连接器不存在", 0, time.Since(start).Milliseconds())
-		return
 	}
+	return false
+}
 
-	// 构造兼容的 Synchronizer 以复用现有下游逻辑
-	syncr := models.Synchronizer{
+// ruleToSynchronizer 构造兼容的 Synchronizer 以复用现有下游逻辑
+func ruleToSynchronizer(rule models.SyncRule) models.Synchronizer {
+	return models.Synchronizer{
 		ID:               rule.ID,
 		Name:             rule.Name,
 		ConnectorID:      rule.ConnectorID,
@@ -1867,32 +2183,30 @@ func ExecuteSyncRule(rule models.SyncRule, user models.User, event string, rawPa
 		SyncRoles:        rule.SyncRoles,
 		PreventPwdChange: rule.PreventPwdChange,
 		Status:           rule.Status,
+		FromRule:         true,
+	}
+}
+
+// ExecuteSyncRule 执行同步规则（单用户事件触发 - 下游）
+func ExecuteSyncRule(rule models.SyncRule, user models.User, event string, rawPassword string) {
+	start := time.Now()
+	var conn models.Connector
+	if err := storage.DB.First(&conn, rule.ConnectorID).Error; err != nil {
+		logSyncRule(rule.ID, conn.ID, "downstream", "event", event, user.ID, user.Username, "failed", "连接器不存在", 0, time.Since(start).Milliseconds())
+		return
 	}
 
-	ExecuteSync(syncr, user, event, rawPassword)
+	ExecuteSync(ruleToSynchronizer(rule), user, event, rawPassword)
 }
 
-// ExecuteFullSyncRule 执行同步规则全量同步（下游）
+// ExecuteFullSyncRule 执行同步规则全量同步（下游），规则启用增量时仅推送变化
 func ExecuteFullSyncRule(rule models.SyncRule, triggerType string) SyncResult {
-	syncr := models.Synchronizer{
-		ID:               rule.ID,
-		Name:             rule.Name,
-		ConnectorID:      rule.ConnectorID,
-		Direction:        "push",
-		SourceType:       rule.SourceType,
-		TargetContainer:  rule.TargetContainer,
-		EnableSchedule:   rule.EnableSchedule,
-		ScheduleTime:     rule.ScheduleTime,
-		CronExpr:         rule.CronExpr,
-		EnableEvent:      rule.EnableEvent,
-		Events:           rule.Events,
-		SyncUsers:        rule.SyncUsers,
-		SyncGroups:       rule.SyncGroups,
-		SyncRoles:        rule.SyncRoles,
-		PreventPwdChange: rule.PreventPwdChange,
-		Status:           rule.Status,
-	}
-	return ExecuteFullSync(syncr, triggerType)
+	return executeFullSync(ruleToSynchronizer(rule), triggerType, rule.DeltaSync)
+}
+
+// ExecuteForcedFullSyncRule 强制全量推送（忽略指纹），用于修复目标端被手动改动的情况
+func ExecuteForcedFullSyncRule(rule models.SyncRule, triggerType string) SyncResult {
+	return executeFullSync(ruleToSynchronizer(rule), triggerType, false)
 }
 
 // logSyncRule 记录同步规则日志
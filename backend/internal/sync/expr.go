@@ -0,0 +1,1214 @@
+package sync
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 属性映射表达式 ==========
+// 表达式映射的 TransformRule 为模板：普通文本 + {{ 表达式 }} 片段，兼容旧的 {{.username}} 写法。
+// 表达式语言是受限的：只能读取用户字段、调用白名单函数，不能访问密码、文件或网络；
+// 求值步数、正则长度、输出长度均有上限。
+//
+//   字段:   .username .nickname .jobTitle .departmentName .groupName .groupPath .groupNames .roles .roleNames .value ...
+//   字面量: "文本" '文本' 123 true false null [a, b]
+//   运算:   == != < > <= >= && || ! + (字符串拼接)  a ?? b (a 为空时取 b)
+//   管道:   .nickname | pinyin | lower   等价于 lower(pinyin(.nickname))
+//   函数:   if(cond, a, b) substr(s, start, len) regexReplace(s, re, repl) split(s, sep) date(v, "YYYY-MM-DD") ...
+//           attr("employeeNo") 读取扩展属性
+//
+// 结果为列表且模板只有一个表达式时，作为多值属性输出（如 LDAP 的 memberOf、mail 别名）。
+
+const (
+	exprMaxSteps     = 10000
+	exprMaxDepth     = 64
+	exprMaxOutputLen = 4096
+	exprMaxRegexLen  = 256
+
+	// 编译缓存容量：试算/校验接口可提交任意表达式，缓存需有上限
+	exprMaxCachedTemplates = 512
+	exprMaxCachedRegexps   = 256
+)
+
+// exprValue 表达式值：nil / string / bool / []string
+type exprValue interface{}
+
+type exprNode interface {
+	eval(env *exprEnv) (exprValue, error)
+}
+
+// exprEnv 单次求值环境
+type exprEnv struct {
+	user  models.User
+	value string // 映射源字段的值（.value）
+	steps int
+	cache map[string]exprValue
+}
+
+func (env *exprEnv) step() error {
+	env.steps++
+	if env.steps > exprMaxSteps {
+		return fmt.Errorf("表达式计算步数超过上限")
+	}
+	return nil
+}
+
+// exprTemplate 编译后的模板
+type exprTemplate struct {
+	parts []exprPart
+}
+
+type exprPart struct {
+	text string
+	node exprNode // 非 nil 时为表达式片段
+}
+
+// exprLRU 固定容量的 LRU 缓存
+type exprLRU struct {
+	mu    gosync.Mutex
+	size  int
+	order *list.List // 元素为 *exprLRUEntry，队首最近使用
+	items map[string]*list.Element
+}
+
+type exprLRUEntry struct {
+	key   string
+	value interface{}
+}
+
+func newExprLRU(size int) *exprLRU {
+	return &exprLRU{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *exprLRU) Load(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*exprLRUEntry).value, true
+}
+
+func (c *exprLRU) Store(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*exprLRUEntry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&exprLRUEntry{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*exprLRUEntry).key)
+	}
+}
+
+var exprCache = newExprLRU(exprMaxCachedTemplates) // rule -> *exprTemplate
+
+// compileExpression 编译表达式模板（带缓存）
+func compileExpression(rule string) (*exprTemplate, error) {
+	if cached, ok := exprCache.Load(rule); ok {
+		return cached.(*exprTemplate), nil
+	}
+
+	tpl := &exprTemplate{}
+	rest := rule
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			if rest != "" {
+				tpl.parts = append(tpl.parts, exprPart{text: rest})
+			}
+			break
+		}
+		if start > 0 {
+			tpl.parts = append(tpl.parts, exprPart{text: rest[:start]})
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("缺少 }}")
+		}
+		src := rest[start+2 : start+end]
+		node, err := parseExpression(src)
+		if err != nil {
+			return nil, fmt.Errorf("{{%s}}: %v", src, err)
+		}
+		tpl.parts = append(tpl.parts, exprPart{node: node})
+		rest = rest[start+end+2:]
+	}
+
+	exprCache.Store(rule, tpl)
+	return tpl, nil
+}
+
+// execute 渲染模板；单个表达式且结果为列表时返回多值
+func (t *exprTemplate) execute(env *exprEnv) ([]string, error) {
+	if len(t.parts) == 1 && t.parts[0].node != nil {
+		v, err := t.parts[0].node.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if values, ok := v.([]string); ok {
+			if len(strings.Join(values, ",")) > exprMaxOutputLen {
+				return nil, fmt.Errorf("表达式输出超过 %d 字节", exprMaxOutputLen)
+			}
+			return values, nil
+		}
+		s := exprString(v)
+		if len(s) > exprMaxOutputLen {
+			return nil, fmt.Errorf("表达式输出超过 %d 字节", exprMaxOutputLen)
+		}
+		if s == "" {
+			return nil, nil
+		}
+		return []string{s}, nil
+	}
+
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.node == nil {
+			b.WriteString(p.text)
+			continue
+		}
+		v, err := p.node.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(exprString(v))
+		if b.Len() > exprMaxOutputLen {
+			return nil, fmt.Errorf("表达式输出超过 %d 字节", exprMaxOutputLen)
+		}
+	}
+	if b.Len() == 0 {
+		return nil, nil
+	}
+	return []string{b.String()}, nil
+}
+
+// EvaluateExpression 对用户求值表达式模板（映射测试接口使用）
+func EvaluateExpression(rule string, user models.User, value string) ([]string, error) {
+	tpl, err := compileExpression(rule)
+	if err != nil {
+		return nil, err
+	}
+	return tpl.execute(&exprEnv{user: user, value: value})
+}
+
+// evaluateExpressionValues 同步时求值，出错记录日志并返回空
+func evaluateExpressionValues(rule string, user models.User, value string) []string {
+	values, err := EvaluateExpression(rule, user, value)
+	if err != nil {
+		log.Printf("[同步] [%s] 表达式求值失败: %v (规则: %s)", user.Username, err, rule)
+		return nil
+	}
+	return values
+}
+
+// PreviewMappingValues 试算映射对用户的输出值（不含密码原文）；表达式错误会返回而不是只记日志
+func PreviewMappingValues(m models.SyncAttributeMapping, user models.User) ([]string, error) {
+	rule := m.TransformRule
+	if m.MappingType == "expression" || (m.MappingType == "transform" && strings.Contains(rule, "{{")) {
+		return EvaluateExpression(rule, user, sourceBaseValue(m.SourceAttribute, user, ""))
+	}
+	return resolveSourceValues(m, user, ""), nil
+}
+
+// ValidateMappingRule 校验映射的转换规则（保存映射时调用）
+func ValidateMappingRule(m models.SyncAttributeMapping) error {
+	switch m.MappingType {
+	case "expression":
+		if strings.TrimSpace(m.TransformRule) == "" {
+			return fmt.Errorf("表达式不能为空")
+		}
+		_, err := compileExpression(m.TransformRule)
+		return err
+	case "transform":
+		if strings.Contains(m.TransformRule, "{{") {
+			_, err := compileExpression(m.TransformRule)
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------- 值转换 ----------
+
+func exprString(v exprValue) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	case []string:
+		return strings.Join(x, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+func exprList(v exprValue) []string {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case []string:
+		return x
+	}
+	if s := exprString(v); s != "" {
+		return []string{s}
+	}
+	return nil
+}
+
+func exprTruthy(v exprValue) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case string:
+		return x != "" && x != "0" && x != "false"
+	case []string:
+		return len(x) > 0
+	}
+	return true
+}
+
+func exprEmpty(v exprValue) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(x) == ""
+	case []string:
+		return len(x) == 0
+	}
+	return false
+}
+
+// exprMap 字符串函数作用于列表时逐项处理
+func exprMap(v exprValue, f func(string) (string, error)) (exprValue, error) {
+	if list, ok := v.([]string); ok {
+		out := make([]string, 0, len(list))
+		for _, s := range list {
+			r, err := f(s)
+			if err != nil {
+				return nil, err
+			}
+			if r != "" {
+				out = append(out, r)
+			}
+		}
+		return out, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return f(exprString(v))
+}
+
+// ---------- 词法分析 ----------
+
+type exprToken struct {
+	kind string // str / num / ident / field / op / eof
+	text string
+	pos  int
+}
+
+func lexExpression(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			quote := r
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != quote; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+					switch runes[j] {
+					case 'n':
+						b.WriteRune('\n')
+					case 't':
+						b.WriteRune('\t')
+					default:
+						b.WriteRune(runes[j])
+					}
+					continue
+				}
+				b.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("字符串未闭合")
+			}
+			tokens = append(tokens, exprToken{"str", b.String(), i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]) && exprExpectOperand(tokens)):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{"num", string(runes[i:j]), i})
+			i = j
+		case r == '.' && i+1 < len(runes) && exprIdentRune(runes[i+1]):
+			j := i + 1
+			for j < len(runes) && exprIdentRune(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, exprToken{"field", string(runes[i+1 : j]), i})
+			i = j
+		case exprIdentRune(r):
+			j := i
+			for j < len(runes) && exprIdentRune(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, exprToken{"ident", string(runes[i:j]), i})
+			i = j
+		default:
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case "==", "!=", "<=", ">=", "&&", "||", "??":
+				tokens = append(tokens, exprToken{"op", two, i})
+				i += 2
+				continue
+			}
+			if strings.ContainsRune("()[],|!<>+", r) {
+				tokens = append(tokens, exprToken{"op", string(r), i})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("无法识别的字符 %q", r)
+		}
+	}
+	return append(tokens, exprToken{kind: "eof", pos: len(runes)}), nil
+}
+
+func exprIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// exprExpectOperand 负号是否应解析为数字符号（前一个 token 不是操作数）
+func exprExpectOperand(tokens []exprToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == "op" && last.text != ")" && last.text != "]"
+}
+
+// ---------- 语法分析 ----------
+// expr    := or ('??' or)*
+// or      := and ('||' and)*
+// and     := cmp ('&&' cmp)*
+// cmp     := add (('=='|'!='|'<'|'>'|'<='|'>=') add)?
+// add     := unary ('+' unary)*
+// unary   := '!' unary | pipe
+// pipe    := primary ('|' ident ('(' args ')')?)*
+// primary := str | num | field | ident '(' args ')' | true | false | null | '(' expr ')' | '[' args ']'
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	depth  int
+}
+
+func parseExpression(src string) (exprNode, error) {
+	tokens, err := lexExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseDefault()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != "eof" {
+		return nil, fmt.Errorf("位置 %d 处多余的内容 %q", tok.pos, tok.text)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != "eof" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) acceptOp(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expectOp(op string) error {
+	if _, ok := p.acceptOp(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("位置 %d 处缺少 %q", tok.pos, op)
+	}
+	return nil
+}
+
+func (p *exprParser) binary(sub func() (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseDefault() (exprNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > exprMaxDepth {
+		return nil, fmt.Errorf("表达式嵌套过深")
+	}
+	return p.binary(p.parseOr, "??")
+}
+
+func (p *exprParser) parseOr() (exprNode, error)  { return p.binary(p.parseAnd, "||") }
+func (p *exprParser) parseAnd() (exprNode, error) { return p.binary(p.parseCmp, "&&") }
+func (p *exprParser) parseAdd() (exprNode, error) { return p.binary(p.parseUnary, "+") }
+
+func (p *exprParser) parseCmp() (exprNode, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.acceptOp("==", "!=", "<=", ">=", "<", ">"); ok {
+		right, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return &exprBinary{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if _, ok := p.acceptOp("!"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNot{x: x}, nil
+	}
+	return p.parsePipe()
+}
+
+func (p *exprParser) parsePipe() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("|"); !ok {
+			return node, nil
+		}
+		tok := p.next()
+		if tok.kind != "ident" {
+			return nil, fmt.Errorf("位置 %d 处管道后应为函数名", tok.pos)
+		}
+		args := []exprNode{node}
+		if _, ok := p.acceptOp("("); ok {
+			rest, err := p.parseArgs(")")
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, rest...)
+		}
+		if node, err = newExprCall(tok.text, args); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parseArgs(closing string) ([]exprNode, error) {
+	var args []exprNode
+	if _, ok := p.acceptOp(closing); ok {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseDefault()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.acceptOp(closing); ok {
+			return args, nil
+		}
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case "str", "num":
+		return &exprLiteral{v: tok.text}, nil
+	case "field":
+		if !exprKnownField(tok.text) {
+			return nil, fmt.Errorf("未知字段 .%s", tok.text)
+		}
+		return &exprField{name: exprNormalize(tok.text)}, nil
+	case "ident":
+		switch tok.text {
+		case "true":
+			return &exprLiteral{v: true}, nil
+		case "false":
+			return &exprLiteral{v: false}, nil
+		case "null", "nil":
+			return &exprLiteral{v: nil}, nil
+		}
+		if err := p.expectOp("("); err != nil {
+			return nil, fmt.Errorf("函数 %s %v", tok.text, err)
+		}
+		args, err := p.parseArgs(")")
+		if err != nil {
+			return nil, err
+		}
+		return newExprCall(tok.text, args)
+	case "op":
+		switch tok.text {
+		case "(":
+			node, err := p.parseDefault()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expectOp(")")
+		case "[":
+			items, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return &exprListNode{items: items}, nil
+		}
+	case "eof":
+		return nil, fmt.Errorf("表达式不完整")
+	}
+	return nil, fmt.Errorf("位置 %d 处意外的 %q", tok.pos, tok.text)
+}
+
+// ---------- 语法树节点 ----------
+
+type exprLiteral struct{ v exprValue }
+
+func (n *exprLiteral) eval(env *exprEnv) (exprValue, error) { return n.v, env.step() }
+
+type exprListNode struct{ items []exprNode }
+
+func (n *exprListNode) eval(env *exprEnv) (exprValue, error) {
+	var out []string
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, exprList(v)...)
+	}
+	return out, env.step()
+}
+
+type exprNot struct{ x exprNode }
+
+func (n *exprNot) eval(env *exprEnv) (exprValue, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !exprTruthy(v), env.step()
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *exprBinary) eval(env *exprEnv) (exprValue, error) {
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// 短路运算
+	switch n.op {
+	case "??":
+		if !exprEmpty(l) {
+			return l, nil
+		}
+		return n.right.eval(env)
+	case "&&":
+		if !exprTruthy(l) {
+			return false, nil
+		}
+		r, err := n.right.eval(env)
+		return exprTruthy(r), err
+	case "||":
+		if exprTruthy(l) {
+			return true, nil
+		}
+		r, err := n.right.eval(env)
+		return exprTruthy(r), err
+	}
+
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		s := exprString(l) + exprString(r)
+		if len(s) > exprMaxOutputLen {
+			return nil, fmt.Errorf("字符串超过 %d 字节", exprMaxOutputLen)
+		}
+		return s, nil
+	case "==":
+		return exprString(l) == exprString(r), nil
+	case "!=":
+		return exprString(l) != exprString(r), nil
+	}
+
+	ls, rs := exprString(l), exprString(r)
+	cmp := strings.Compare(ls, rs)
+	if lf, err1 := strconv.ParseFloat(ls, 64); err1 == nil {
+		if rf, err2 := strconv.ParseFloat(rs, 64); err2 == nil {
+			switch {
+			case lf < rf:
+				cmp = -1
+			case lf > rf:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case ">":
+		return cmp > 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("不支持的运算符 %s", n.op)
+}
+
+// ---------- 字段 ----------
+
+// exprFieldSources 字段名（小写去下划线）-> 映射源属性
+var exprFieldSources = map[string]string{
+	"username": "username", "nickname": "nickname", "phone": "phone", "email": "email",
+	"avatar": "avatar", "status": "status", "source": "source", "jobtitle": "job_title",
+	"departmentname": "department_name", "groupname": "group_name", "groupid": "group_id",
+	"dingtalkuid": "dingtalk_uid", "createdat": "created_at", "updatedat": "updated_at",
+	"lastloginip": "last_login_ip", "lastloginat": "last_login_at",
+	"passwordchangedat": "password_changed_at", "mfaenabled": "mfa_enabled", "id": "id",
+	"managerusername": "manager_username", "managernickname": "manager_nickname",
+	"groupids": "group_ids", "validfrom": "valid_from", "validuntil": "valid_until",
+}
+
+// 列表/计算字段
+var exprComputedFields = map[string]bool{
+	"value": true, "roles": true, "rolecodes": true, "rolenames": true, "grouppath": true,
+	"groupnames": true,
+}
+
+func exprNormalize(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+func exprKnownField(name string) bool {
+	n := exprNormalize(name)
+	_, ok := exprFieldSources[n]
+	return ok || exprComputedFields[n]
+}
+
+type exprField struct{ name string }
+
+func (n *exprField) eval(env *exprEnv) (exprValue, error) {
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	if env.cache == nil {
+		env.cache = make(map[string]exprValue)
+	}
+	if v, ok := env.cache[n.name]; ok {
+		return v, nil
+	}
+
+	var v exprValue
+	switch n.name {
+	case "value":
+		v = env.value
+	case "roles", "rolecodes":
+		codes := make([]string, 0, len(env.user.Roles))
+		for _, r := range env.user.Roles {
+			codes = append(codes, r.Code)
+		}
+		v = codes
+	case "rolenames":
+		names := make([]string, 0, len(env.user.Roles))
+		for _, r := range env.user.Roles {
+			names = append(names, r.Name)
+		}
+		v = names
+	case "grouppath":
+		v = exprGroupPath(env.user.GroupID)
+	case "groupnames":
+		names := []string{}
+		if s := sourceBaseValue("group_names", env.user, ""); s != "" {
+			names = strings.Split(s, ",")
+		}
+		v = names
+	default:
+		v = sourceBaseValue(exprFieldSources[n.name], env.user, "")
+	}
+	env.cache[n.name] = v
+	return v, nil
+}
+
+// exprGroupPath 从根到用户所在分组的名称列表
+func exprGroupPath(groupID uint) []string {
+	var path []string
+	for id, depth := groupID, 0; id > 0 && depth < 32; depth++ {
+		var group models.UserGroup
+		if storage.DB.Select("id, name, parent_id").First(&group, id).Error != nil {
+			break
+		}
+		path = append([]string{group.Name}, path...)
+		id = group.ParentID
+	}
+	return path
+}
+
+// ---------- 函数 ----------
+
+type exprFuncDef struct {
+	min, max int // 参数个数范围，max<0 表示不限
+	fn       func(env *exprEnv, args []exprValue) (exprValue, error)
+}
+
+type exprCall struct {
+	name string
+	def  *exprFuncDef
+	args []exprNode
+}
+
+func newExprCall(name string, args []exprNode) (exprNode, error) {
+	if name == "if" {
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("if 需要 2~3 个参数")
+		}
+		return &exprCall{name: name, args: args}, nil
+	}
+	def, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("未知函数 %s", name)
+	}
+	if len(args) < def.min || (def.max >= 0 && len(args) > def.max) {
+		if def.min == def.max {
+			return nil, fmt.Errorf("%s 需要 %d 个参数", name, def.min)
+		}
+		return nil, fmt.Errorf("%s 参数个数不正确", name)
+	}
+	// 常量正则在编译期校验
+	if name == "regexReplace" || name == "regexMatch" || name == "regexFind" {
+		if lit, ok := args[1].(*exprLiteral); ok {
+			if _, err := exprRegexp(exprString(lit.v)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &exprCall{name: name, def: &def, args: args}, nil
+}
+
+func (n *exprCall) eval(env *exprEnv) (exprValue, error) {
+	if err := env.step(); err != nil {
+		return nil, err
+	}
+	if n.name == "if" {
+		cond, err := n.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if exprTruthy(cond) {
+			return n.args[1].eval(env)
+		}
+		if len(n.args) == 3 {
+			return n.args[2].eval(env)
+		}
+		return nil, nil
+	}
+
+	args := make([]exprValue, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.def.fn(env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return v, nil
+}
+
+func exprInt(v exprValue) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(exprString(v)))
+	if err != nil {
+		return 0, fmt.Errorf("参数 %q 不是整数", exprString(v))
+	}
+	return n, nil
+}
+
+var exprRegexCache = newExprLRU(exprMaxCachedRegexps) // pattern -> *regexp.Regexp
+
+func exprRegexp(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > exprMaxRegexLen {
+		return nil, fmt.Errorf("正则表达式过长")
+	}
+	if re, ok := exprRegexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("正则表达式错误: %v", err)
+	}
+	exprRegexCache.Store(pattern, re)
+	return re, nil
+}
+
+// exprStrFunc 单字符串参数函数（对列表逐项处理）
+func exprStrFunc(f func(string) string) exprFuncDef {
+	return exprFuncDef{1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		return exprMap(args[0], func(s string) (string, error) { return f(s), nil })
+	}}
+}
+
+var exprFuncs = map[string]exprFuncDef{
+	"upper":    exprStrFunc(strings.ToUpper),
+	"lower":    exprStrFunc(strings.ToLower),
+	"trim":     exprStrFunc(strings.TrimSpace),
+	"pinyin":   exprStrFunc(func(s string) string { return exprPinyin(s, false) }),
+	"initials": exprStrFunc(func(s string) string { return exprPinyin(s, true) }),
+	"len": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		if list, ok := args[0].([]string); ok {
+			return strconv.Itoa(len(list)), nil
+		}
+		return strconv.Itoa(len([]rune(exprString(args[0])))), nil
+	}},
+	"substr": {2, 3, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		start, err := exprInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		length := -1
+		if len(args) == 3 {
+			if length, err = exprInt(args[2]); err != nil {
+				return nil, err
+			}
+		}
+		return exprMap(args[0], func(s string) (string, error) {
+			runes := []rune(s)
+			from := start
+			if from < 0 {
+				from += len(runes)
+			}
+			if from < 0 {
+				from = 0
+			}
+			if from > len(runes) {
+				return "", nil
+			}
+			to := len(runes)
+			if length >= 0 && from+length < to {
+				to = from + length
+			}
+			return string(runes[from:to]), nil
+		})
+	}},
+	"replace": {3, 3, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		old, repl := exprString(args[1]), exprString(args[2])
+		return exprMap(args[0], func(s string) (string, error) { return strings.ReplaceAll(s, old, repl), nil })
+	}},
+	"regexReplace": {3, 3, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		re, err := exprRegexp(exprString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		repl := exprString(args[2])
+		return exprMap(args[0], func(s string) (string, error) { return re.ReplaceAllString(s, repl), nil })
+	}},
+	"regexMatch": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		re, err := exprRegexp(exprString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(exprString(args[0])), nil
+	}},
+	"regexFind": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		re, err := exprRegexp(exprString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return exprMap(args[0], func(s string) (string, error) {
+			m := re.FindStringSubmatch(s)
+			switch {
+			case len(m) > 1:
+				return m[1], nil // 有分组时取第一个分组
+			case len(m) == 1:
+				return m[0], nil
+			}
+			return "", nil
+		})
+	}},
+	"split": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		sep := exprString(args[1])
+		var out []string
+		for _, s := range exprList(args[0]) {
+			for _, part := range strings.Split(s, sep) {
+				if part = strings.TrimSpace(part); part != "" {
+					out = append(out, part)
+				}
+			}
+		}
+		return out, nil
+	}},
+	"join": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		return strings.Join(exprList(args[0]), exprString(args[1])), nil
+	}},
+	"first": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		if list := exprList(args[0]); len(list) > 0 {
+			return list[0], nil
+		}
+		return nil, nil
+	}},
+	"last": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		if list := exprList(args[0]); len(list) > 0 {
+			return list[len(list)-1], nil
+		}
+		return nil, nil
+	}},
+	"at": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		list := exprList(args[0])
+		i, err := exprInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			i += len(list)
+		}
+		if i < 0 || i >= len(list) {
+			return nil, nil
+		}
+		return list[i], nil
+	}},
+	"unique": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		return uniqueStrings(exprList(args[0])), nil
+	}},
+	"prefix": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		p := exprString(args[1])
+		return exprMap(args[0], func(s string) (string, error) { return p + s, nil })
+	}},
+	"suffix": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		p := exprString(args[1])
+		return exprMap(args[0], func(s string) (string, error) { return s + p, nil })
+	}},
+	"concat": {1, -1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(exprString(a))
+		}
+		return b.String(), nil
+	}},
+	"contains": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		needle := exprString(args[1])
+		if list, ok := args[0].([]string); ok {
+			for _, s := range list {
+				if s == needle {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return strings.Contains(exprString(args[0]), needle), nil
+	}},
+	"startsWith": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		return strings.HasPrefix(exprString(args[0]), exprString(args[1])), nil
+	}},
+	"endsWith": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		return strings.HasSuffix(exprString(args[0]), exprString(args[1])), nil
+	}},
+	"default": {2, 2, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		if exprEmpty(args[0]) {
+			return args[1], nil
+		}
+		return args[0], nil
+	}},
+	"coalesce": {1, -1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		for _, a := range args {
+			if !exprEmpty(a) {
+				return a, nil
+			}
+		}
+		return nil, nil
+	}},
+	"isEmpty": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		return exprEmpty(args[0]), nil
+	}},
+	"date": {2, 3, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		s := strings.TrimSpace(exprString(args[0]))
+		if s == "" {
+			return nil, nil
+		}
+		var t time.Time
+		if s == "now" {
+			t = time.Now()
+		} else {
+			var err error
+			if len(args) == 3 {
+				t, err = time.ParseInLocation(exprDateLayout(exprString(args[2])), s, time.Local)
+			} else {
+				t, err = exprParseTime(s)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("无法解析时间 %q", s)
+			}
+		}
+		return t.Format(exprDateLayout(exprString(args[1]))), nil
+	}},
+	"attr": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		return userAttribute(env.user, exprString(args[0])), nil
+	}},
+	"groupName": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		id, err := exprInt(args[0])
+		if err != nil || id <= 0 {
+			return nil, nil
+		}
+		var group models.UserGroup
+		if storage.DB.Select("id, name").First(&group, id).Error != nil {
+			return nil, nil
+		}
+		return group.Name, nil
+	}},
+	"roleName": {1, 1, func(env *exprEnv, args []exprValue) (exprValue, error) {
+		codes := exprList(args[0])
+		if len(codes) == 0 {
+			return nil, nil
+		}
+		var roles []models.Role
+		storage.DB.Select("code, name").Where("code IN ?", codes).Find(&roles)
+		names := make(map[string]string, len(roles))
+		for _, r := range roles {
+			names[r.Code] = r.Name
+		}
+		return exprMap(args[0], func(code string) (string, error) { return names[code], nil })
+	}},
+}
+
+// exprPinyin 汉字转拼音（小写无声调），非汉字原样保留；initials=true 时只取首字母
+func exprPinyin(s string, initials bool) string {
+	a := pinyin.NewArgs()
+	a.Style = pinyin.Normal
+	if initials {
+		a.Style = pinyin.FirstLetter
+	}
+	a.Fallback = func(r rune, a pinyin.Args) []string {
+		return []string{string(r)}
+	}
+	var b strings.Builder
+	for _, p := range pinyin.Pinyin(s, a) {
+		if len(p) > 0 {
+			b.WriteString(p[0])
+		}
+	}
+	return strings.ToLower(b.String())
+}
+
+// exprDateLayout 将 YYYY-MM-DD HH:mm:ss 风格转换为 Go 时间格式；已是 Go 格式的原样返回
+func exprDateLayout(layout string) string {
+	if !strings.Contains(layout, "YYYY") && !strings.Contains(layout, "YY") &&
+		!strings.Contains(layout, "DD") && !strings.Contains(layout, "HH") {
+		return layout
+	}
+	r := strings.NewReplacer(
+		"YYYY", "2006", "YY", "06", "MM", "01", "DD", "02",
+		"HH", "15", "mm", "04", "ss", "05", "SSS", "000",
+	)
+	return r.Replace(layout)
+}
+
+// exprParseTime 解析常见时间格式
+func exprParseTime(s string) (time.Time, error) {
+	layouts := []string{
+		"2006-01-02 15:04:05", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02", "2006/01/02", "20060102",
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	// Unix 时间戳（秒/毫秒）
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间")
+}
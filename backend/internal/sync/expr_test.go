@@ -0,0 +1,161 @@
+package sync
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-syncflow/internal/models"
+)
+
+func exprTestUser() models.User {
+	return models.User{
+		ID: 1, Username: "zhangsan", Nickname: "张三", Email: "ZhangSan@Example.com",
+		JobTitle: "  工程师 ", DepartmentName: "研发部",
+		Roles: []models.Role{{Code: "admin", Name: "管理员"}, {Code: "user", Name: "普通用户"}},
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		value string
+		want  []string
+	}{
+		{"纯文本", "CN=Users", "", []string{"CN=Users"}},
+		{"旧写法", "{{.username}}@corp.com", "", []string{"zhangsan@corp.com"}},
+		{"字段名不区分大小写和下划线", "{{.Job_Title | trim}}", "", []string{"工程师"}},
+		{"管道", "{{.email | lower}}", "", []string{"zhangsan@example.com"}},
+		{"拼音", "{{.nickname | pinyin}}", "", []string{"zhangsan"}},
+		{"首字母", "{{.nickname | initials | upper}}", "", []string{"ZS"}},
+		{"源字段值", "{{.value | upper}}", "abc", []string{"ABC"}},
+		{"字符串拼接", `{{.username + "." + "x"}}`, "", []string{"zhangsan.x"}},
+		{"空值回退", `{{.phone ?? "无"}}`, "", []string{"无"}},
+		{"非空不回退", `{{.username ?? "无"}}`, "", []string{"zhangsan"}},
+		{"条件", `{{if(.departmentName == "研发部", "R&D", "Other")}}`, "", []string{"R&D"}},
+		{"条件缺省分支", `{{if(.departmentName == "x", "R&D")}}`, "", nil},
+		{"逻辑运算", `{{if(!isEmpty(.email) && .username != "", "y", "n")}}`, "", []string{"y"}},
+		{"substr", `{{substr(.username, 0, 5)}}`, "", []string{"zhang"}},
+		{"substr 负起点", `{{substr(.username, -3)}}`, "", []string{"san"}},
+		{"substr 起点越界", `{{substr(.username, 100)}}`, "", nil},
+		{"substr 按字符", `{{substr(.nickname, 1, 1)}}`, "", []string{"三"}},
+		{"正则替换", `{{regexReplace(.email, "@.*$", "")}}`, "", []string{"ZhangSan"}},
+		{"正则提取分组", `{{regexFind(.email, "@(\\w+)\\.")}}`, "", []string{"Example"}},
+		{"列表字段多值输出", "{{.roles}}", "", []string{"admin", "user"}},
+		{"列表逐项处理", "{{.roles | upper}}", "", []string{"ADMIN", "USER"}},
+		{"模板中的列表按逗号拼接", "roles={{.roles}}", "", []string{"roles=admin,user"}},
+		{"split 去空项", `{{split("a, ,b,", ",")}}`, "", []string{"a", "b"}},
+		{"join", `{{join(.roleNames, "/")}}`, "", []string{"管理员/普通用户"}},
+		{"at 负下标", `{{at(.roles, -1)}}`, "", []string{"user"}},
+		{"at 越界", `{{at(.roles, 5)}}`, "", nil},
+		{"列表字面量", `{{["a", .username]}}`, "", []string{"a", "zhangsan"}},
+		{"空结果", "{{.phone}}", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvaluateExpression(tt.rule, exprTestUser(), tt.value)
+			if err != nil {
+				t.Fatalf("EvaluateExpression(%q): %v", tt.rule, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EvaluateExpression(%q) = %q, want %q", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr string
+	}{
+		{"缺少结束符", "{{.username", "缺少 }}"},
+		{"未知字段", "{{.password}}", "未知字段"},
+		{"未知函数", "{{exec(.username)}}", "未知函数"},
+		{"参数个数", "{{substr(.username)}}", "参数个数"},
+		{"if 参数个数", "{{if(true)}}", "if 需要"},
+		{"表达式不完整", "{{.username +}}", "表达式不完整"},
+		{"常量正则编译期校验", `{{regexMatch(.username, "(")}}`, "正则表达式错误"},
+		{"正则过长", fmt.Sprintf("{{regexMatch(.username, %q)}}", strings.Repeat("a", exprMaxRegexLen+1)), "正则表达式过长"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileExpression(tt.rule)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("compileExpression(%q) err = %v, want containing %q", tt.rule, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpressionOutputLimit(t *testing.T) {
+	long := strings.Repeat("x", exprMaxOutputLen)
+	tests := []struct {
+		name    string
+		rule    string
+		value   string
+		wantErr bool
+	}{
+		{"单值恰好上限", "{{.value}}", long, false},
+		{"单值超限", "{{.value + \"y\"}}", long, true},
+		{"模板超限", "y{{.value}}", long, true},
+		{"列表拼接后恰好上限", `{{split(.value, ",")}}`, long[:2047] + "," + long[:2048], false},
+		{"列表拼接后超限", `{{split(.value, ",")}}`, long[:2048] + "," + long[:2048], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EvaluateExpression(tt.rule, models.User{}, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpressionStepLimit(t *testing.T) {
+	// 每个列表项逐个求值并计步，足够长的列表字面量会触发步数上限
+	items := make([]string, exprMaxSteps)
+	for i := range items {
+		items[i] = `"a"`
+	}
+	_, err := EvaluateExpression("{{["+strings.Join(items, ",")+"] | len}}", models.User{}, "")
+	if err == nil || !strings.Contains(err.Error(), "步数") {
+		t.Errorf("err = %v, want step limit", err)
+	}
+}
+
+func TestExprLRU(t *testing.T) {
+	c := newExprLRU(2)
+	c.Store("a", 1)
+	c.Store("b", 2)
+	if v, ok := c.Load("a"); !ok || v != 1 { // a 变为最近使用
+		t.Fatalf("Load(a) = %v, %v", v, ok)
+	}
+	c.Store("c", 3) // 淘汰最久未使用的 b
+	if _, ok := c.Load("b"); ok {
+		t.Error("b 应被淘汰")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.Load(key); !ok || v != want {
+			t.Errorf("Load(%s) = %v, %v", key, v, ok)
+		}
+	}
+	c.Store("a", 10) // 覆盖已有键不增加容量
+	if v, _ := c.Load("a"); v != 10 || c.order.Len() != 2 || len(c.items) != 2 {
+		t.Errorf("覆盖后 a=%v len=%d/%d", v, c.order.Len(), len(c.items))
+	}
+}
+
+func TestCompileExpressionCacheBounded(t *testing.T) {
+	for i := 0; i < exprMaxCachedTemplates+10; i++ {
+		if _, err := compileExpression(fmt.Sprintf("{{.username}}-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := exprCache.order.Len(); n > exprMaxCachedTemplates {
+		t.Errorf("缓存条目 %d 超过上限 %d", n, exprMaxCachedTemplates)
+	}
+}
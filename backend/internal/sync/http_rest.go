@@ -0,0 +1,365 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/services"
+)
+
+// ========== 通用 HTTP/REST 下游连接器 ==========
+
+// HTTPRestConfig HTTP/REST 连接器配置（存储于 Connector.Config）
+type HTTPRestConfig struct {
+	BaseURL            string                     `json:"baseUrl"`            // 基础地址，请求 URL 以 / 开头时自动拼接
+	Headers            map[string]string          `json:"headers"`            // 所有请求附加的公共 Header
+	InsecureSkipVerify bool                       `json:"insecureSkipVerify"` // 跳过 HTTPS 证书校验
+	Signing            services.WebhookConfig     `json:"signing"`            // 复用 Webhook 的签名选项（signType/signSecret/token...）
+	Events             map[string]HTTPRestRequest `json:"events"`             // 按事件配置请求: user_create / user_update / user_disable / user_delete / password_change
+	Lookup             *HTTPRestRequest           `json:"lookup"`             // 查询目标用户是否存在（全量同步对账用）
+}
+
+// HTTPRestRequest 单个请求模板
+type HTTPRestRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`     // 支持 {{.username}} 等变量
+	Headers map[string]string `json:"headers"` // 支持变量
+	Body    string            `json:"body"`    // JSON 模板，为空时发送映射后的属性
+	Success HTTPRestSuccess   `json:"success"`
+}
+
+// HTTPRestSuccess 成功判定条件
+type HTTPRestSuccess struct {
+	StatusCodes string `json:"statusCodes"` // 如 "200-299,409"，为空表示 2xx
+	JSONField   string `json:"jsonField"`   // 响应 JSON 字段路径，如 "code" / "data.errcode"
+	Equals      string `json:"equals"`      // 字段期望值，为空时要求字段存在且非空
+}
+
+// httpRestEventFallback 未单独配置的事件回退到的请求
+var httpRestEventFallback = map[string]string{
+	models.SyncEventUserEnable:  models.SyncEventUserUpdate,
+	models.SyncEventUserDisable: models.SyncEventUserUpdate,
+	models.SyncEventRoleChange:  models.SyncEventUserUpdate,
+	models.SyncEventGroupChange: models.SyncEventUserUpdate,
+}
+
+// ParseHTTPRestConfig 解析连接器扩展配置
+func ParseHTTPRestConfig(conn models.Connector) (HTTPRestConfig, error) {
+	var cfg HTTPRestConfig
+	if strings.TrimSpace(conn.Config) == "" {
+		return cfg, fmt.Errorf("未配置 HTTP 请求")
+	}
+	if err := json.Unmarshal([]byte(conn.Config), &cfg); err != nil {
+		return cfg, fmt.Errorf("配置解析失败: %v", err)
+	}
+	return cfg, nil
+}
+
+// TestHTTPRestConnection 测试 HTTP/REST 连接（请求 baseUrl，能收到响应即视为可达）
+func TestHTTPRestConnection(conn models.Connector) (string, error) {
+	cfg, err := ParseHTTPRestConfig(conn)
+	if err != nil {
+		return "", err
+	}
+	if cfg.BaseURL == "" {
+		return "", fmt.Errorf("未配置 baseUrl")
+	}
+
+	status, _, err := doHTTPRestRequest(conn, cfg, HTTPRestRequest{Method: http.MethodGet, URL: cfg.BaseURL}, nil)
+	if err != nil {
+		return "", fmt.Errorf("连接失败: %v", err)
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return "", fmt.Errorf("认证失败: HTTP %d", status)
+	}
+
+	events := make([]string, 0, len(cfg.Events))
+	for e := range cfg.Events {
+		events = append(events, e)
+	}
+	return fmt.Sprintf("连接成功 (HTTP %d)，已配置事件: %s", status, strings.Join(events, ",")), nil
+}
+
+// syncUserToHTTPRest 单用户事件同步
+func syncUserToHTTPRest(conn models.Connector, syncr models.Synchronizer, user models.User, event string, rawPassword string) SyncResult {
+	cfg, err := ParseHTTPRestConfig(conn)
+	if err != nil {
+		return SyncResult{Failed: 1, Errors: []string{fmt.Sprintf("[%s] %v", user.Username, err)}}
+	}
+
+	mappings := loadUserMappings(syncr)
+
+	return pushUserToHTTPRest(conn, cfg, mappings, user, event, rawPassword)
+}
+
+// batchSyncUsersToHTTPRest 全量同步：有 lookup 时先查询目标是否存在，再决定创建或更新
+func batchSyncUsersToHTTPRest(conn models.Connector, users []models.User, mappings []models.SyncAttributeMapping) SyncResult {
+	result := SyncResult{Total: len(users)}
+	cfg, err := ParseHTTPRestConfig(conn)
+	if err != nil {
+		result.Failed = len(users)
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	for _, user := range users {
+		r := pushUserToHTTPRest(conn, cfg, mappings, user, "full_sync", "")
+		result.Success += r.Success
+		result.Failed += r.Failed
+		result.Skipped += r.Skipped
+		result.Errors = append(result.Errors, r.Errors...)
+	}
+	return result
+}
+
+// pushUserToHTTPRest 按事件选择请求模板并发送
+func pushUserToHTTPRest(conn models.Connector, cfg HTTPRestConfig, mappings []models.SyncAttributeMapping, user models.User, event string, rawPassword string) SyncResult {
+	result := SyncResult{}
+
+	// 创建/更新类事件：配置了 lookup 时按目标端实际情况做 upsert
+	action := event
+	if event == "full_sync" || event == models.SyncEventUserCreate || event == models.SyncEventUserUpdate || event == models.SyncEventUserEnable {
+		if cfg.Lookup != nil && cfg.Lookup.URL != "" {
+			exists, err := lookupHTTPRestUser(conn, cfg, mappings, user)
+			if err != nil {
+				result.Failed++
+				result.Errors = append(result.Errors, fmt.Sprintf("[%s] 查询目标用户失败: %v", user.Username, err))
+				return result
+			}
+			if exists {
+				action = models.SyncEventUserUpdate
+			} else {
+				action = models.SyncEventUserCreate
+			}
+		} else if event == "full_sync" {
+			action = models.SyncEventUserUpdate
+			if _, ok := cfg.Events[action]; !ok {
+				action = models.SyncEventUserCreate
+			}
+		}
+	}
+
+	reqTpl, ok := cfg.Events[action]
+	if !ok {
+		if fb, has := httpRestEventFallback[action]; has {
+			reqTpl, ok = cfg.Events[fb]
+		}
+	}
+	if !ok || reqTpl.URL == "" {
+		result.Skipped++
+		return result
+	}
+
+	vars := httpRestVars(user, mappings, event, rawPassword)
+	status, body, err := doHTTPRestRequest(conn, cfg, reqTpl, vars)
+	if err != nil {
+		result.Failed++
+		result.Errors = append(result.Errors, fmt.Sprintf("[%s] %s 请求失败: %v", user.Username, action, err))
+		return result
+	}
+	if !matchHTTPRestSuccess(reqTpl.Success, status, body) {
+		result.Failed++
+		result.Errors = append(result.Errors, fmt.Sprintf("[%s] %s 返回异常: HTTP %d - %s", user.Username, action, status, truncateHTTPBody(body)))
+		return result
+	}
+	result.Success++
+	return result
+}
+
+// lookupHTTPRestUser 调用 lookup 请求判断目标用户是否存在（404 或不满足成功条件视为不存在）
+func lookupHTTPRestUser(conn models.Connector, cfg HTTPRestConfig, mappings []models.SyncAttributeMapping, user models.User) (bool, error) {
+	vars := httpRestVars(user, mappings, "lookup", "")
+	tpl := *cfg.Lookup
+	if tpl.Method == "" {
+		tpl.Method = http.MethodGet
+	}
+	status, body, err := doHTTPRestRequest(conn, cfg, tpl, vars)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if status >= 500 {
+		return false, fmt.Errorf("HTTP %d - %s", status, truncateHTTPBody(body))
+	}
+	return matchHTTPRestSuccess(tpl.Success, status, body), nil
+}
+
+// httpRestVars 构造模板变量：与 applyExpression 相同的用户变量，外加 id/status/password/event/attributes
+func httpRestVars(user models.User, mappings []models.SyncAttributeMapping, event string, rawPassword string) [][2]string {
+	attrs := make(map[string]string)
+	for _, m := range mappings {
+		if val := resolveSourceValue(m, user, rawPassword); val != "" {
+			attrs[m.TargetAttribute] = val
+		}
+	}
+	attrsJSON, _ := json.Marshal(attrs)
+
+	vars := expressionVars(user)
+	vars = append(vars,
+		[2]string{"id", strconv.FormatUint(uint64(user.ID), 10)},
+		[2]string{"status", strconv.Itoa(int(user.Status))},
+		[2]string{"password", rawPassword},
+		[2]string{"event", event},
+		[2]string{"attributes", string(attrsJSON)},
+	)
+	return vars
+}
+
+// renderHTTPTemplate 替换模板变量，escape 用于按位置转义（URL / JSON）
+func renderHTTPTemplate(tpl string, vars [][2]string, escape func(string) string) string {
+	result := tpl
+	for _, v := range vars {
+		placeholder := "{{." + v[0] + "}}"
+		if !strings.Contains(result, placeholder) {
+			continue
+		}
+		val := v[1]
+		// attributes 本身即 JSON 对象，不做转义
+		if escape != nil && v[0] != "attributes" {
+			val = escape(val)
+		}
+		result = strings.ReplaceAll(result, placeholder, val)
+	}
+	return result
+}
+
+// jsonEscape 转义为 JSON 字符串内容（不含两侧引号）
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
+
+// doHTTPRestRequest 渲染模板、签名并发送请求，返回状态码与响应体
+func doHTTPRestRequest(conn models.Connector, cfg HTTPRestConfig, tpl HTTPRestRequest, vars [][2]string) (int, []byte, error) {
+	method := strings.ToUpper(tpl.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	targetURL := renderHTTPTemplate(tpl.URL, vars, url.PathEscape)
+	if strings.HasPrefix(targetURL, "/") && cfg.BaseURL != "" {
+		targetURL = strings.TrimRight(cfg.BaseURL, "/") + targetURL
+	}
+
+	body := ""
+	if tpl.Body != "" {
+		body = renderHTTPTemplate(tpl.Body, vars, jsonEscape)
+	} else if method != http.MethodGet && method != http.MethodDelete {
+		body = renderHTTPTemplate("{{.attributes}}", vars, nil)
+	}
+
+	req, err := http.NewRequest(method, services.WebhookTargetURL(cfg.Signing, targetURL), bytes.NewBufferString(body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, renderHTTPTemplate(v, vars, nil))
+	}
+	for k, v := range tpl.Headers {
+		req.Header.Set(k, renderHTTPTemplate(v, vars, nil))
+	}
+	services.SignWebhookRequest(req, cfg.Signing, body)
+
+	timeout := time.Duration(conn.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	if cfg.InsecureSkipVerify {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, respBody, nil
+}
+
+// matchHTTPRestSuccess 按成功条件判定响应
+func matchHTTPRestSuccess(s HTTPRestSuccess, status int, body []byte) bool {
+	if !matchStatusCodes(s.StatusCodes, status) {
+		return false
+	}
+	if s.JSONField == "" {
+		return true
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return false
+	}
+	for _, key := range strings.Split(s.JSONField, ".") {
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if data, ok = obj[key]; !ok {
+			return false
+		}
+	}
+
+	var actual string
+	switch v := data.(type) {
+	case nil:
+		actual = ""
+	case string:
+		actual = v
+	case float64:
+		actual = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		raw, _ := json.Marshal(v)
+		actual = string(raw)
+	}
+	if s.Equals == "" {
+		return actual != ""
+	}
+	return actual == s.Equals
+}
+
+// matchStatusCodes 解析 "200-299,409" 格式的状态码列表
+func matchStatusCodes(spec string, status int) bool {
+	if strings.TrimSpace(spec) == "" {
+		return status >= 200 && status < 300
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if lo, hi, isRange := strings.Cut(part, "-"); isRange {
+			l, err1 := strconv.Atoi(strings.TrimSpace(lo))
+			h, err2 := strconv.Atoi(strings.TrimSpace(hi))
+			if err1 == nil && err2 == nil && status >= l && status <= h {
+				return true
+			}
+			continue
+		}
+		if code, err := strconv.Atoi(part); err == nil && code == status {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateHTTPBody(body []byte) string {
+	s := string(body)
+	if len([]rune(s)) > 200 {
+		return string([]rune(s)[:200]) + "..."
+	}
+	return s
+}
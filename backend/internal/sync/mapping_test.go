@@ -0,0 +1,37 @@
+package sync
+
+import (
+	"testing"
+
+	"go-syncflow/internal/models"
+)
+
+func TestTransformMappingValue(t *testing.T) {
+	user := models.User{Username: "zhangsan", Nickname: "张三"}
+	tests := []struct {
+		name    string
+		mapping models.SyncAttributeMapping
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"直接映射", models.SyncAttributeMapping{MappingType: "mapping"}, "E1001", "E1001", false},
+		{"常量", models.SyncAttributeMapping{MappingType: "constant", TransformRule: "ACME"}, "E1001", "ACME", false},
+		{"内置转换", models.SyncAttributeMapping{MappingType: "transform", TransformRule: "prepend:NO-"}, "1001", "NO-1001", false},
+		{"转换模板", models.SyncAttributeMapping{MappingType: "transform", TransformRule: "{{.value | lower}}"}, "ABC", "abc", false},
+		{"表达式读取本地用户", models.SyncAttributeMapping{MappingType: "expression", TransformRule: `{{.username + ":" + .value}}`}, "1001", "zhangsan:1001", false},
+		{"表达式多值以逗号拼接", models.SyncAttributeMapping{MappingType: "expression", TransformRule: `{{split(.value, ";")}}`}, "a;b", "a,b", false},
+		{"表达式错误返回", models.SyncAttributeMapping{MappingType: "expression", TransformRule: "{{.value"}, "x", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformMappingValue(tt.mapping, user, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
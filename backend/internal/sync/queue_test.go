@@ -0,0 +1,169 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// setupTestDB 使用内存 SQLite 替换 storage.DB，测试结束后恢复
+func setupTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	prev := storage.DB
+	storage.DB = db
+	t.Cleanup(func() {
+		storage.DB = prev
+		sqlDB.Close()
+	})
+	return db
+}
+
+// useTestQueueKey 使用固定密钥，避免测试写入 ./data/sync_queue_key
+func useTestQueueKey() {
+	queueKeyOnce.Do(func() {
+		queueKey = []byte("0123456789abcdef0123456789abcdef")
+	})
+}
+
+func TestSyncJobBackoff(t *testing.T) {
+	cfg := models.SyncQueueConfig{BackoffBaseSeconds: 10, BackoffMaxSeconds: 100}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 80 * time.Second},
+		{5, 100 * time.Second}, // 160s 截断到上限
+		{50, 100 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := syncJobBackoff(tt.attempts, cfg); got != tt.want {
+			t.Errorf("syncJobBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+
+	// 基础间隔大于上限时取上限
+	if got := syncJobBackoff(1, models.SyncQueueConfig{BackoffBaseSeconds: 300, BackoffMaxSeconds: 60}); got != time.Minute {
+		t.Errorf("base > max: got %v, want 1m", got)
+	}
+}
+
+func TestFailSyncJob(t *testing.T) {
+	cfg := models.SyncQueueConfig{MaxAttempts: 3, BackoffBaseSeconds: 10, BackoffMaxSeconds: 100}
+	tests := []struct {
+		name        string
+		attempts    int
+		maxAttempts int
+		wantStatus  string
+	}{
+		{"首次失败重新排队", 1, 3, models.SyncJobPending},
+		{"未达上限重新排队", 2, 3, models.SyncJobPending},
+		{"达到上限进入死信", 3, 3, models.SyncJobDead},
+		{"任务未设置上限时使用全局配置", 3, 0, models.SyncJobDead},
+		{"任务上限优先于全局配置", 3, 5, models.SyncJobPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t, &models.SyncJob{})
+			job := models.SyncJob{
+				Event: models.SyncEventPasswordChange, Username: "alice", Payload: "sealed",
+				Status: models.SyncJobRunning, Attempts: tt.attempts, MaxAttempts: tt.maxAttempts,
+			}
+			db.Create(&job)
+			if tt.maxAttempts == 0 {
+				// gorm 对零值使用字段默认值，需显式写回 0
+				db.Model(&job).Update("max_attempts", 0)
+				job.MaxAttempts = 0
+			}
+
+			before := time.Now()
+			failSyncJob(job, cfg, "连接超时")
+
+			var got models.SyncJob
+			db.First(&got, job.ID)
+			if got.Status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if got.LastError != "连接超时" {
+				t.Errorf("last_error = %q", got.LastError)
+			}
+			// 死信保留加密密码，便于人工重试
+			if got.Payload != "sealed" {
+				t.Errorf("payload = %q, want retained", got.Payload)
+			}
+			if tt.wantStatus == models.SyncJobPending {
+				wantAt := before.Add(syncJobBackoff(tt.attempts, cfg))
+				if got.NextRunAt.Before(wantAt.Add(-time.Second)) {
+					t.Errorf("next_run_at = %v, want >= %v", got.NextRunAt, wantAt)
+				}
+			} else if got.FinishedAt == nil {
+				t.Error("死信任务未记录 finished_at")
+			}
+		})
+	}
+}
+
+func TestFinishSyncJobClearsPayload(t *testing.T) {
+	for _, status := range []string{models.SyncJobSuccess, models.SyncJobDiscarded} {
+		db := setupTestDB(t, &models.SyncJob{})
+		job := models.SyncJob{Event: models.SyncEventPasswordChange, Payload: "sealed", Status: models.SyncJobRunning}
+		db.Create(&job)
+		finishSyncJob(job, status, "")
+
+		var got models.SyncJob
+		db.First(&got, job.ID)
+		if got.Status != status || got.Payload != "" {
+			t.Errorf("%s: status=%s payload=%q, want payload cleared", status, got.Status, got.Payload)
+		}
+	}
+}
+
+func TestQueuePayloadRoundTrip(t *testing.T) {
+	useTestQueueKey()
+	for _, plain := range []string{"", "P@ssw0rd", "中文密码", string(make([]byte, 1024))} {
+		sealed, err := sealQueuePayload(plain)
+		if err != nil {
+			t.Fatalf("seal: %v", err)
+		}
+		if plain != "" && sealed == plain {
+			t.Fatal("payload 未加密")
+		}
+		got, err := openQueuePayload(sealed)
+		if err != nil || got != plain {
+			t.Errorf("open(seal(%q)) = %q, %v", plain, got, err)
+		}
+	}
+
+	// 每次加密使用随机 nonce
+	a, _ := sealQueuePayload("same")
+	b, _ := sealQueuePayload("same")
+	if a == b {
+		t.Error("相同明文的密文不应相同")
+	}
+
+	for _, bad := range []string{"not-base64!", "c2hvcnQ=", a[:len(a)-4] + "AAAA"} {
+		if _, err := openQueuePayload(bad); err == nil {
+			t.Errorf("openQueuePayload(%q) 应返回错误", bad)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	"go-syncflow/internal/models"
+	"go-syncflow/internal/storage"
+)
+
+// ========== 下游对账报告 ==========
+// 读取目标端（AD/LDAP 的 TargetContainer、数据库的 UserTable）全部用户对象，
+// 按属性映射与本地用户比对：孤儿（仅目标端存在）、缺失（仅本地存在）、属性漂移。
+
+// ReconcileItem 对账条目
+type ReconcileItem struct {
+	Kind        string            `json:"kind"` // orphan / missing / drift
+	Key         string            `json:"key"`  // 匹配键（用户名）
+	DN          string            `json:"dn,omitempty"`
+	UserID      uint              `json:"userId,omitempty"`
+	Nickname    string            `json:"nickname,omitempty"`
+	Drifts      []AttrDrift       `json:"drifts,omitempty"`
+	TargetAttrs map[string]string `json:"targetAttrs,omitempty"` // 目标端属性（孤儿认领时使用）
+	Resolved    string            `json:"resolved,omitempty"`    // 已执行的处理动作
+}
+
+// AttrDrift 属性漂移
+type AttrDrift struct {
+	Attribute string `json:"attribute"`
+	Source    string `json:"source"` // 本地源字段
+	Local     string `json:"local"`
+	Target    string `json:"target"`
+}
+
+// ReconcileReport 对账报告
+type ReconcileReport struct {
+	RuleID      uint            `json:"ruleId"`
+	LocalTotal  int             `json:"localTotal"`
+	TargetTotal int             `json:"targetTotal"`
+	Orphans     int             `json:"orphans"`
+	Missing     int             `json:"missing"`
+	Drifted     int             `json:"drifted"`
+	Items       []ReconcileItem `json:"items"`
+	Duration    int64           `json:"duration"`
+}
+
+// targetObject 目标端用户对象
+type targetObject struct {
+	DN    string
+	Attrs map[string]string
+}
+
+// 不参与漂移比对的映射：密码类、状态转换类（本地值与目标值表示不同）
+var reconcileSkipSources = map[string]bool{
+	"password": true, "password_raw": true, "password_hash": true, "samba_nt_password": true,
+	"last_login_at": true, "last_login_ip": true, "updated_at": true,
+}
+var reconcileSkipTargets = map[string]bool{
+	"unicodePwd": true, "userPassword": true, "userAccountControl": true, "sambaNTPassword": true,
+}
+var reconcileSkipTransforms = map[string]bool{
+	"status_to_uac": true, "status_to_delete": true, "password_to_unicode": true, "time_to_filetime": true,
+}
+
+// comparableMappings 可比对的映射
+func comparableMappings(mappings []models.SyncAttributeMapping) []models.SyncAttributeMapping {
+	result := make([]models.SyncAttributeMapping, 0, len(mappings))
+	for _, m := range mappings {
+		if reconcileSkipSources[m.SourceAttribute] || reconcileSkipTargets[m.TargetAttribute] {
+			continue
+		}
+		if m.MappingType == "transform" && reconcileSkipTransforms[m.TransformRule] {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// reconcileKeyAttr 目标端匹配键属性（用户名映射的目标字段）
+func reconcileKeyAttr(conn models.Connector, mappings []models.SyncAttributeMapping) string {
+	for _, m := range mappings {
+		if m.SourceAttribute == "username" && (m.MappingType == "" || m.MappingType == "mapping") {
+			return m.TargetAttribute
+		}
+	}
+	switch conn.Type {
+	case "ldap_ad":
+		return "sAMAccountName"
+	case "ldap_generic":
+		return "uid"
+	}
+	return "username"
+}
+
+// BuildReconcileReport 生成下游规则对账报告
+func BuildReconcileReport(rule models.SyncRule) (*ReconcileReport, error) {
+	start := time.Now()
+	var conn models.Connector
+	if err := storage.DB.First(&conn, rule.ConnectorID).Error; err != nil {
+		return nil, fmt.Errorf("连接器不存在")
+	}
+
+	syncr := ruleToSynchronizer(rule)
+	mappings := loadUserMappings(syncr)
+	compare := comparableMappings(mappings)
+	keyAttr := reconcileKeyAttr(conn, mappings)
+
+	attrs := []string{keyAttr}
+	for _, m := range compare {
+		attrs = append(attrs, m.TargetAttribute)
+	}
+
+	var objects []targetObject
+	var err error
+	switch {
+	case conn.IsLDAP():
+		objects, err = readLDAPTargetUsers(conn, rule, attrs)
+	case conn.IsDatabase():
+		objects, err = readDBTargetUsers(conn, attrs)
+	default:
+		return nil, fmt.Errorf("%s 连接器暂不支持对账", conn.ConnectorTypeName())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	storage.DB.Where("is_deleted = 0").Preload("Roles").Find(&users)
+	storage.LoadUserAttributes(users)
+	storage.LoadUserGroupIDs(users)
+
+	report := &ReconcileReport{RuleID: rule.ID, TargetTotal: len(objects)}
+	targets := make(map[string]targetObject, len(objects))
+	for _, obj := range objects {
+		key := strings.ToLower(obj.Attrs[keyAttr])
+		if key != "" {
+			targets[key] = obj
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, user := range users {
+		key := strings.ToLower(user.Username)
+		obj, ok := targets[key]
+		if !ok {
+			// 只有启用的用户才会被推送到目标端
+			if user.Status == 1 {
+				report.LocalTotal++
+				report.Items = append(report.Items, ReconcileItem{Kind: "missing", Key: user.Username, UserID: user.ID, Nickname: user.Nickname})
+				report.Missing++
+			}
+			continue
+		}
+		report.LocalTotal++
+		seen[key] = true
+
+		var drifts []AttrDrift
+		for _, m := range compare {
+			local := strings.TrimSpace(resolveSourceValue(m, user, ""))
+			target := strings.TrimSpace(obj.Attrs[m.TargetAttribute])
+			if local != target {
+				drifts = append(drifts, AttrDrift{Attribute: m.TargetAttribute, Source: m.SourceAttribute, Local: local, Target: target})
+			}
+		}
+		if len(drifts) > 0 {
+			report.Items = append(report.Items, ReconcileItem{Kind: "drift", Key: user.Username, DN: obj.DN, UserID: user.ID, Nickname: user.Nickname, Drifts: drifts})
+			report.Drifted++
+		}
+	}
+
+	for key, obj := range targets {
+		if seen[key] {
+			continue
+		}
+		report.Items = append(report.Items, ReconcileItem{Kind: "orphan", Key: obj.Attrs[keyAttr], DN: obj.DN, TargetAttrs: obj.Attrs})
+		report.Orphans++
+	}
+
+	kindOrder := map[string]int{"orphan": 0, "missing": 1, "drift": 2}
+	sort.SliceStable(report.Items, func(i, j int) bool {
+		a, b := report.Items[i], report.Items[j]
+		if a.Kind != b.Kind {
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		return a.Key < b.Key
+	})
+	report.Duration = time.Since(start).Milliseconds()
+	return report, nil
+}
+
+// readLDAPTargetUsers 分页读取 TargetContainer 下的用户对象
+func readLDAPTargetUsers(conn models.Connector, rule models.SyncRule, attrs []string) ([]targetObject, error) {
+	l, err := dialLDAP(conn)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP连接失败: %v", err)
+	}
+	defer l.Close()
+	if err := l.Bind(conn.BindDN, conn.BindPassword); err != nil {
+		return nil, fmt.Errorf("LDAP认证失败: %v", err)
+	}
+
+	baseDN := rule.TargetContainer
+	if baseDN == "" {
+		baseDN = conn.BaseDN
+	}
+	filter := conn.UserFilter
+	if filter == "" {
+		if conn.Type == "ldap_ad" {
+			// 排除 Administrator/krbtgt 等系统内置账户
+			filter = "(&(objectClass=user)(objectCategory=person)(!(isCriticalSystemObject=TRUE)))"
+		} else {
+			filter = "(objectClass=inetOrgPerson)"
+		}
+	}
+
+	sr, err := l.SearchWithPaging(ldapv3.NewSearchRequest(
+		baseDN, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
+		filter, attrs, nil,
+	), 500)
+	if err != nil {
+		return nil, fmt.Errorf("搜索目标用户失败: %v", err)
+	}
+
+	objects := make([]targetObject, 0, len(sr.Entries))
+	for _, entry := range sr.Entries {
+		obj := targetObject{DN: entry.DN, Attrs: make(map[string]string, len(attrs))}
+		for _, a := range attrs {
+			obj.Attrs[a] = strings.Join(entry.GetEqualFoldAttributeValues(a), ",")
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// readDBTargetUsers 读取用户表中的映射字段
+func readDBTargetUsers(conn models.Connector, attrs []string) ([]targetObject, error) {
+	if conn.UserTable == "" {
+		return nil, fmt.Errorf("未配置用户表名")
+	}
+	db, err := dialDB(conn)
+	if err != nil {
+		return nil, fmt.Errorf("数据库连接失败: %v", err)
+	}
+	defer db.Close()
+
+	dbType := conn.EffectiveDBType()
+	cols := uniqueStrings(attrs)
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = quoteIdentifier(dbType, col)
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), quoteIdentifier(dbType, conn.UserTable)))
+	if err != nil {
+		return nil, fmt.Errorf("查询用户表失败: %v", err)
+	}
+	defer rows.Close()
+
+	var objects []targetObject
+	for rows.Next() {
+		vals := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("读取用户表失败: %v", err)
+		}
+		obj := targetObject{Attrs: make(map[string]string, len(cols))}
+		for i, col := range cols {
+			obj.Attrs[col] = vals[i].String
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// PushUserForRule 按规则将单个本地用户完整推送到目标端（对账"覆盖"动作）
+func PushUserForRule(rule models.SyncRule, user models.User) error {
+	var conn models.Connector
+	if err := storage.DB.First(&conn, rule.ConnectorID).Error; err != nil {
+		return fmt.Errorf("连接器不存在")
+	}
+	syncr := ruleToSynchronizer(rule)
+	mappings := loadUserMappings(syncr)
+	result := pushUsers(conn, syncr, []models.User{user}, mappings)
+	if result.Failed > 0 {
+		return fmt.Errorf("%s", strings.Join(result.Errors, "; "))
+	}
+	saveSyncFingerprint(conn, syncr, user, mappings)
+	return nil
+}
+
+// DeleteTargetObject 删除目标端孤儿对象（对账"删除"动作）
+func DeleteTargetObject(rule models.SyncRule, item ReconcileItem) error {
+	var conn models.Connector
+	if err := storage.DB.First(&conn, rule.ConnectorID).Error; err != nil {
+		return fmt.Errorf("连接器不存在")
+	}
+
+	switch {
+	case conn.IsLDAP():
+		if item.DN == "" {
+			return fmt.Errorf("缺少目标 DN")
+		}
+		l, err := dialLDAP(conn)
+		if err != nil {
+			return fmt.Errorf("LDAP连接失败: %v", err)
+		}
+		defer l.Close()
+		if err := l.Bind(conn.BindDN, conn.BindPassword); err != nil {
+			return fmt.Errorf("LDAP认证失败: %v", err)
+		}
+		if err := l.Del(ldapv3.NewDelRequest(item.DN, nil)); err != nil && !ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultNoSuchObject) {
+			return fmt.Errorf("删除失败: %v", err)
+		}
+		return nil
+	case conn.IsDatabase():
+		db, err := dialDB(conn)
+		if err != nil {
+			return fmt.Errorf("数据库连接失败: %v", err)
+		}
+		defer db.Close()
+		dbType := conn.EffectiveDBType()
+		keyAttr := reconcileKeyAttr(conn, loadUserMappings(ruleToSynchronizer(rule)))
+		ph := "?"
+		switch dbType {
+		case "postgresql":
+			ph = "$1"
+		case "oracle":
+			ph = ":1"
+		}
+		q := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", quoteIdentifier(dbType, conn.UserTable), quoteIdentifier(dbType, keyAttr), ph)
+		if _, err := db.Exec(q, item.Key); err != nil {
+			return fmt.Errorf("删除失败: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s 连接器暂不支持删除", conn.ConnectorTypeName())
+}
+
+// ReverseMappedFields 目标属性 -> 本地用户字段（仅直接映射的可回写字段，用于"认领"）
+func ReverseMappedFields(rule models.SyncRule) map[string]string {
+	writable := map[string]string{
+		"nickname": "nickname", "phone": "phone", "email": "email",
+		"job_title": "job_title", "department_name": "department_name", "avatar": "avatar",
+	}
+	result := make(map[string]string)
+	for _, m := range loadUserMappings(ruleToSynchronizer(rule)) {
+		if m.MappingType != "" && m.MappingType != "mapping" {
+			continue
+		}
+		if col, ok := writable[m.SourceAttribute]; ok {
+			if _, exists := result[m.TargetAttribute]; !exists {
+				result[m.TargetAttribute] = col
+			}
+		}
+	}
+	return result
+}
+
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	result := make([]string, 0, len(list))
+	for _, s := range list {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -52,9 +52,24 @@ func main() {
 	handlers.StartUpstreamSchedulers()
 	handlers.StartDownstreamSchedulers()
 
+	// 启动下游同步队列
+	handlers.StartSyncQueue()
+
+	// 启动下游定期对账调度器
+	handlers.StartReconcileScheduler()
+
 	// 启动日志清理调度器
 	handlers.StartLogCleanupScheduler()
 
+	// 启动用户生命周期调度器
+	handlers.StartLifecycleScheduler()
+
+	// 启动会话清理调度器
+	handlers.StartSessionCleanupScheduler()
+
+	// 加载访问令牌签名密钥并启动轮换调度器
+	handlers.StartJWTKeyRotationScheduler()
+
 	// 启动 LDAP 服务器
 	ldapSrv := ldapserver.NewLDAPServer()
 	handlers.SetLDAPServer(ldapSrv)
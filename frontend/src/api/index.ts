@@ -141,21 +141,67 @@ async function rsaEncryptPassword(plaintext: string): Promise<string> {
   }
 }
 
+// 设备标识：首次访问时生成并持久化，用于登录风险评估识别新设备
+const getDeviceId = () => {
+  let id = localStorage.getItem("deviceId");
+  if (!id) {
+    const bytes = new Uint8Array(16);
+    crypto.getRandomValues(bytes);
+    id = Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+    localStorage.setItem("deviceId", id);
+  }
+  return id;
+};
+
 api.interceptors.request.use((config) => {
   const token = localStorage.getItem("token");
   if (token) {
     config.headers.Authorization = `Bearer ${token}`;
   }
+  config.headers["X-Device-Id"] = getDeviceId();
   return config;
 });
 
+// 刷新访问令牌：同一时刻只发起一次，并发请求共享结果
+let refreshing: Promise<boolean> | null = null;
+const refreshAccessToken = () => {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const usedToken = localStorage.getItem("refreshToken");
+      if (!usedToken) return false;
+      try {
+        const res = await axios.post("/api/auth/refresh", { refreshToken: usedToken });
+        if (!res.data?.success) return false;
+        localStorage.setItem("token", res.data.data.token);
+        localStorage.setItem("refreshToken", res.data.data.refreshToken);
+        return true;
+      } catch (e: any) {
+        // 409：其他标签页已用同一刷新令牌换新，改用其写入的最新令牌
+        return e.response?.status === 409 && localStorage.getItem("refreshToken") !== usedToken;
+      }
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
+
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config;
+    if (error.response?.status === 401 && error.response.headers?.["x-token-expired"] && original && !original._retried) {
+      original._retried = true;
+      if (await refreshAccessToken()) {
+        original.headers.Authorization = `Bearer ${localStorage.getItem("token")}`;
+        return api(original);
+      }
+    }
     if (error.response?.status === 401) {
       localStorage.removeItem("token");
+      localStorage.removeItem("refreshToken");
       router.push("/login");
-      ElMessage.error("登录已过期，请重新登录");
+      ElMessage.error(error.response?.data?.message || "登录已过期，请重新登录");
     } else if (error.response?.status === 403) {
       ElMessage.error("无权限访问");
     } else {
@@ -181,6 +227,9 @@ export const authApi = {
       _csrf: csrfToken
     });
   },
+  // 风险登录二次验证
+  verifyLogin: (challengeToken: string, code: string) =>
+    api.post("/auth/login/verify", { challengeToken, code }),
   dingtalkLogin: (authCode: string) =>
     api.post("/auth/dingtalk", { authCode }),
   logout: () => api.post("/auth/logout"),
@@ -234,6 +283,101 @@ export const profileApi = {
   sendVerifyCode: (method: string) => api.post("/profile/verify-code", { method })
 };
 
+// ========== 通行密钥（WebAuthn） ==========
+// 服务端以 base64url 传递二进制字段，这里与浏览器 API 所需的 ArrayBuffer 互相转换
+
+const b64urlToBuffer = (value: string): ArrayBuffer => {
+  const b64 = value.replace(/-/g, "+").replace(/_/g, "/").padEnd(Math.ceil(value.length / 4) * 4, "=");
+  const bin = atob(b64);
+  const bytes = new Uint8Array(bin.length);
+  for (let i = 0; i < bin.length; i++) bytes[i] = bin.charCodeAt(i);
+  return bytes.buffer;
+};
+
+const bufferToB64url = (buffer: ArrayBuffer | null): string => {
+  if (!buffer) return "";
+  let bin = "";
+  new Uint8Array(buffer).forEach((b) => (bin += String.fromCharCode(b)));
+  return btoa(bin).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+};
+
+const toDescriptors = (list: any[] = []) =>
+  list.map((c) => ({ ...c, id: b64urlToBuffer(c.id) }));
+
+// 调用浏览器创建通行密钥，返回可提交给服务端的凭据
+const createPasskey = async (options: any) => {
+  const cred = (await navigator.credentials.create({
+    publicKey: {
+      ...options,
+      challenge: b64urlToBuffer(options.challenge),
+      user: { ...options.user, id: b64urlToBuffer(options.user.id) },
+      excludeCredentials: toDescriptors(options.excludeCredentials),
+    },
+  })) as PublicKeyCredential;
+  const response = cred.response as AuthenticatorAttestationResponse;
+  return {
+    id: cred.id,
+    rawId: bufferToB64url(cred.rawId),
+    type: cred.type,
+    response: {
+      clientDataJSON: bufferToB64url(response.clientDataJSON),
+      attestationObject: bufferToB64url(response.attestationObject),
+      transports: response.getTransports ? response.getTransports() : [],
+    },
+  };
+};
+
+// 调用浏览器使用通行密钥签名，返回可提交给服务端的断言
+const getPasskey = async (options: any) => {
+  const cred = (await navigator.credentials.get({
+    publicKey: {
+      ...options,
+      challenge: b64urlToBuffer(options.challenge),
+      allowCredentials: toDescriptors(options.allowCredentials),
+    },
+  })) as PublicKeyCredential;
+  const response = cred.response as AuthenticatorAssertionResponse;
+  return {
+    id: cred.id,
+    rawId: bufferToB64url(cred.rawId),
+    type: cred.type,
+    response: {
+      clientDataJSON: bufferToB64url(response.clientDataJSON),
+      authenticatorData: bufferToB64url(response.authenticatorData),
+      signature: bufferToB64url(response.signature),
+      userHandle: bufferToB64url(response.userHandle),
+    },
+  };
+};
+
+export const isPasskeySupported = () =>
+  typeof window !== "undefined" && !!window.PublicKeyCredential && !!navigator.credentials;
+
+export const webauthnApi = {
+  status: () => api.get("/auth/webauthn/status"),
+  // 无密码登录：用户名可选，不填时由浏览器列出可用的通行密钥
+  login: async (username?: string) => {
+    const begin = await api.post("/auth/webauthn/login/begin", { username: username || "" });
+    const { sessionToken, publicKey } = begin.data.data;
+    const credential = await getPasskey(publicKey);
+    return api.post("/auth/webauthn/login/finish", { sessionToken, credential });
+  },
+  // 密码登录后的通行密钥二次验证
+  verifySecondFactor: async (sessionToken: string, publicKey: any) => {
+    const credential = await getPasskey(publicKey);
+    return api.post("/auth/webauthn/login/finish", { sessionToken, credential });
+  },
+  register: async (name: string) => {
+    const begin = await api.post("/profile/webauthn/register/begin");
+    const { sessionToken, publicKey } = begin.data.data;
+    const credential = await createPasskey(publicKey);
+    return api.post("/profile/webauthn/register/finish", { sessionToken, name, credential });
+  },
+  credentials: () => api.get("/profile/webauthn/credentials"),
+  rename: (id: number, name: string) => api.put(`/profile/webauthn/credentials/${id}`, { name }),
+  remove: (id: number) => api.delete(`/profile/webauthn/credentials/${id}`),
+};
+
 // 用户管理接口
 export const userApi = {
   list: (params: any) => api.get("/users", { params }),
@@ -247,7 +391,85 @@ export const userApi = {
     api.put(`/users/${id}/reset-password`, { notifyChannels: notifyChannels || [] }),
   batchResetPassword: (userIds: number[], notifyChannels: string[]) =>
     api.post("/users/batch-reset-password", { userIds, notifyChannels }),
-  exportAll: () => api.get("/users/export")
+  exportAll: () => api.get("/users/export"),
+  orgChart: (params?: { rootId?: number; depth?: number }) => api.get("/users/org-chart", { params }),
+  reportingLine: (id: number) => api.get(`/users/${id}/reporting-line`),
+  identities: (id: number) => api.get(`/users/${id}/identities`),
+  webauthnCredentials: (id: number) => api.get(`/users/${id}/webauthn`),
+  revokeWebauthnCredential: (id: number, credId: number) => api.delete(`/users/${id}/webauthn/${credId}`),
+  merge: (id: number, sourceUserId: number) => api.post(`/users/${id}/merge`, { sourceUserId }),
+  splitIdentity: (id: number, identityId: number) =>
+    api.post(`/users/${id}/identities/${identityId}/split`),
+  fieldSources: (id: number) => api.get(`/users/${id}/field-sources`),
+  lockFields: (id: number, fields: string[]) => api.put(`/users/${id}/field-locks`, { fields }),
+  unlockField: (id: number, field: string) => api.delete(`/users/${id}/field-locks/${field}`),
+  roleScopes: (id: number) => api.get(`/users/${id}/role-scopes`),
+  updateRoleScopes: (id: number, scopes: any[]) => api.put(`/users/${id}/role-scopes`, { scopes }),
+  importPreview: (formData: FormData) => api.post("/users/import/preview", formData, {
+    headers: { "Content-Type": "multipart/form-data" }
+  }),
+  importCommit: (token: string) => api.post(`/users/import/${token}/commit`),
+  downloadTemplate: (type?: "create") =>
+    api.get("/users/import/template", { params: { type }, responseType: "blob" }),
+  bulkUpdatePreview: (formData: FormData) => api.post("/users/bulk-update/preview", formData, {
+    headers: { "Content-Type": "multipart/form-data" }
+  }),
+  bulkUpdateCommit: (token: string) => api.post(`/users/bulk-update/${token}/commit`)
+};
+
+// 用户生命周期接口
+export const lifecycleApi = {
+  leave: (userId: number, data: { effectiveDate?: string; reason?: string }) =>
+    api.post(`/users/${userId}/lifecycle/leave`, data),
+  move: (userId: number, data: any) => api.post(`/users/${userId}/lifecycle/move`, data),
+  tasks: (params: any) => api.get("/lifecycle/tasks", { params }),
+  cancel: (id: number) => api.post(`/lifecycle/tasks/${id}/cancel`),
+  getConfig: () => api.get("/lifecycle/config"),
+  updateConfig: (data: any) => api.put("/lifecycle/config", data)
+};
+
+// 自助权限申请接口
+export const accessRequestApi = {
+  options: () => api.get("/profile/access-requests/options"),
+  mine: (params: any) => api.get("/profile/access-requests", { params }),
+  create: (data: { targetType: string; targetId: number; reason: string; durationDays: number }) =>
+    api.post("/profile/access-requests", data),
+  cancel: (id: number) => api.post(`/profile/access-requests/${id}/cancel`),
+  approvals: (params: any) => api.get("/access-requests", { params }),
+  approve: (id: number, data: { comment?: string; durationDays?: number }) =>
+    api.post(`/access-requests/${id}/approve`, data),
+  deny: (id: number, comment: string) => api.post(`/access-requests/${id}/deny`, { comment }),
+  revoke: (id: number, comment: string) => api.post(`/access-requests/${id}/revoke`, { comment }),
+  getConfig: () => api.get("/access-requests/config"),
+  updateConfig: (data: any) => api.put("/access-requests/config", data)
+};
+
+// 权限复核接口
+export const accessReviewApi = {
+  list: (params: any) => api.get("/access-reviews", { params }),
+  create: (data: any) => api.post("/access-reviews", data),
+  options: () => api.get("/access-reviews/options"),
+  reviewers: (params: { keyword?: string; ids?: string }) => api.get("/access-reviews/reviewers", { params }),
+  items: (id: number, params: any) => api.get(`/access-reviews/${id}/items`, { params }),
+  close: (id: number) => api.post(`/access-reviews/${id}/close`),
+  cancel: (id: number) => api.post(`/access-reviews/${id}/cancel`),
+  mine: (params?: any) => api.get("/profile/access-reviews", { params }),
+  decide: (data: { itemIds: number[]; decision: string; comment?: string }) =>
+    api.post("/access-reviews/items/decide", data)
+};
+
+// 身份关联配置接口
+export const identityApi = {
+  getConfig: () => api.get("/identity/config"),
+  updateConfig: (data: any) => api.put("/identity/config", data)
+};
+
+// 用户扩展属性接口
+export const userAttributeApi = {
+  list: () => api.get("/user-attributes"),
+  create: (data: any) => api.post("/user-attributes", data),
+  update: (id: number, data: any) => api.put(`/user-attributes/${id}`, data),
+  delete: (id: number) => api.delete(`/user-attributes/${id}`)
 };
 
 // 用户分组接口
@@ -352,9 +574,18 @@ export const securityApi = {
   mySessions: () => api.get("/security/sessions/my"),
   terminateSession: (id: string) => api.delete(`/security/sessions/${id}`),
   terminateUserSessions: (userId: number) => api.delete(`/security/sessions/user/${userId}`),
+  jwtKeys: () => api.get("/security/jwt-keys"),
+  rotateJwtKey: () => api.post("/security/jwt-keys/rotate"),
+  revokeJwtKey: (kid: string) => api.delete(`/security/jwt-keys/${kid}`),
   configs: () => api.get("/security/config"),
   getConfig: (key: string) => api.get(`/security/config/${key}`),
   updateConfig: (key: string, data: any) => api.put(`/security/config/${key}`, data),
+  passwordChecks: () => api.get("/security/password-checks"),
+  testPassword: (data: { password: string; username?: string }) => api.post("/security/password-checks/test", data),
+  uploadPasswordDictionary: (formData: FormData) => api.post("/security/password-dictionary", formData, {
+    headers: { "Content-Type": "multipart/form-data" }
+  }),
+  deletePasswordDictionary: () => api.delete("/security/password-dictionary"),
   notifyChannels: () => api.get("/security/alerts/channels"),
   createNotifyChannel: (data: any) => api.post("/security/alerts/channels", data),
   updateNotifyChannel: (id: number, data: any) => api.put(`/security/alerts/channels/${id}`, data),
@@ -366,6 +597,11 @@ export const securityApi = {
   updateAlertRule: (id: number, data: any) => api.put(`/security/alerts/rules/${id}`, data),
   deleteAlertRule: (id: number) => api.delete(`/security/alerts/rules/${id}`),
   alertLogs: (params: any) => api.get("/security/alerts/logs", { params }),
+  alertIncidents: (params: any) => api.get("/security/alerts/incidents", { params }),
+  alertIncidentEvents: (id: number) => api.get(`/security/alerts/incidents/${id}/events`),
+  resolveAlertIncident: (id: number) => api.post(`/security/alerts/incidents/${id}/resolve`),
+  alertActionLogs: (params: any) => api.get("/security/alerts/actions", { params }),
+  revertAlertAction: (id: number) => api.post(`/security/alerts/actions/${id}/revert`),
   // 消息模板
   getTemplates: () => api.get("/notify/templates"),
   getTemplate: (id: number) => api.get(`/notify/templates/${id}`),
@@ -428,9 +664,24 @@ export const syncApi = {
   getDownstreamRule: (id: number) => api.get(`/sync/downstream/rules/${id}`),
   updateDownstreamRule: (id: number, data: any) => api.put(`/sync/downstream/rules/${id}`, data),
   deleteDownstreamRule: (id: number) => api.delete(`/sync/downstream/rules/${id}`),
-  triggerDownstreamRule: (id: number) => api.post(`/sync/downstream/rules/${id}/trigger`, {}, { timeout: 300000 }),
+  triggerDownstreamRule: (id: number, mode?: "full") =>
+    api.post(`/sync/downstream/rules/${id}/trigger`, {}, { params: mode ? { mode } : undefined, timeout: 300000 }),
   downstreamRuleMappings: (id: number) => api.get(`/sync/downstream/rules/${id}/mappings`),
   updateDownstreamRuleMappings: (id: number, mappings: any[]) => api.put(`/sync/downstream/rules/${id}/mappings`, { mappings }),
+  testMappingRule: (data: { userId: number; sourceAttribute: string; mappingType: string; transformRule: string }) =>
+    api.post("/sync/mappings/test", data),
+  // 下游同步队列
+  queueJobs: (params?: any) => api.get("/sync/queue/jobs", { params }),
+  queueStats: () => api.get("/sync/queue/stats"),
+  retryQueueJob: (id: number) => api.post(`/sync/queue/jobs/${id}/retry`),
+  discardQueueJob: (id: number) => api.post(`/sync/queue/jobs/${id}/discard`),
+  batchQueueJobs: (ids: number[], action: "retry" | "discard") => api.post("/sync/queue/jobs/batch", { ids, action }),
+  getQueueConfig: () => api.get("/sync/queue/config"),
+  updateQueueConfig: (data: any) => api.put("/sync/queue/config", data),
+  runReconcile: (ruleId: number) => api.post(`/sync/downstream/rules/${ruleId}/reconcile`),
+  getReconcile: (ruleId: number) => api.get(`/sync/downstream/rules/${ruleId}/reconcile`),
+  resolveReconcileItem: (ruleId: number, reportId: number, index: number, action: string) =>
+    api.post(`/sync/downstream/rules/${ruleId}/reconcile/${reportId}/items/${index}`, { action }),
   // SSO providers
   ssoProviders: () => api.get("/auth/sso-providers"),
   ssoLogin: (data: { connectorId: number; platform: string; authCode: string }) => api.post("/auth/sso/login", data),
@@ -56,6 +56,12 @@ const routes = [
         component: () => import("../views/admin/Roles.vue"),
         meta: { permission: "role:list" }
       },
+      {
+        path: "access-reviews",
+        name: "AccessReviews",
+        component: () => import("../views/admin/AccessReviews.vue"),
+        meta: { permission: "role:review" }
+      },
       // 日志管理
       {
         path: "logs/system",
@@ -185,6 +191,7 @@ function getFirstAccessibleRoute(userStore: ReturnType<typeof useUserStore>): st
     { path: '/admin/sync/upstream', permission: 'settings:system' },
     { path: '/admin/sync/downstream', permission: 'settings:system' },
     { path: '/admin/roles', permission: 'role:list' },
+    { path: '/admin/access-reviews', permission: 'role:review' },
     { path: '/admin/logs/system', permission: 'log:login' },
     { path: '/admin/logs/api', permission: 'settings:system' },
     { path: '/admin/notify/channels', permission: 'settings:system' },
@@ -1,6 +1,6 @@
 import { defineStore } from "pinia";
 import { ref } from "vue";
-import { authApi } from "../api";
+import { authApi, webauthnApi } from "../api";
 
 export const useUserStore = defineStore("user", () => {
   const token = ref(localStorage.getItem("token") || "");
@@ -8,13 +8,41 @@ export const useUserStore = defineStore("user", () => {
   const permissions = ref<string[]>([]);
   const layoutConfig = ref<{ sidebarMode: string; landingPage: string }>({ sidebarMode: 'auto', landingPage: '' });
 
+  const applyLogin = async (data: any) => {
+    setToken(data.token, data.refreshToken);
+    user.value = data.user;
+    await fetchUserInfo();
+  };
+
   const login = async (username: string, password: string) => {
     const res = await authApi.login({ username, password });
+    // 风险登录需输入验证码或需通行密钥二次验证时不返回 token，由页面继续引导
+    if (res.data.success && !res.data.data?.challenge && !res.data.data?.mfa) {
+      await applyLogin(res.data.data);
+    }
+    return res.data;
+  };
+
+  const loginWithPasskey = async (username?: string) => {
+    const res = await webauthnApi.login(username);
     if (res.data.success) {
-      token.value = res.data.data.token;
-      user.value = res.data.data.user;
-      localStorage.setItem("token", token.value);
-      await fetchUserInfo();
+      await applyLogin(res.data.data);
+    }
+    return res.data;
+  };
+
+  const verifyPasskeySecondFactor = async (sessionToken: string, publicKey: any) => {
+    const res = await webauthnApi.verifySecondFactor(sessionToken, publicKey);
+    if (res.data.success) {
+      await applyLogin(res.data.data);
+    }
+    return res.data;
+  };
+
+  const verifyLogin = async (challengeToken: string, code: string) => {
+    const res = await authApi.verifyLogin(challengeToken, code);
+    if (res.data.success) {
+      await applyLogin(res.data.data);
     }
     return res.data;
   };
@@ -34,6 +62,7 @@ export const useUserStore = defineStore("user", () => {
     permissions.value = [];
     layoutConfig.value = { sidebarMode: 'auto', landingPage: '' };
     localStorage.removeItem("token");
+    localStorage.removeItem("refreshToken");
   };
 
   const fetchUserInfo = async () => {
@@ -56,9 +85,12 @@ export const useUserStore = defineStore("user", () => {
     return permissions.value.includes(code);
   };
 
-  const setToken = (newToken: string) => {
+  const setToken = (newToken: string, refreshToken?: string) => {
     token.value = newToken;
     localStorage.setItem("token", newToken);
+    if (refreshToken) {
+      localStorage.setItem("refreshToken", refreshToken);
+    }
   };
 
   const setUser = (newUser: any) => {
@@ -71,6 +103,9 @@ export const useUserStore = defineStore("user", () => {
     permissions,
     layoutConfig,
     login,
+    verifyLogin,
+    loginWithPasskey,
+    verifyPasskeySecondFactor,
     logout,
     clearAuth,
     fetchUserInfo,